@@ -0,0 +1,146 @@
+package emigrate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveMigrations returns a slice of migrations that can run against the
+// files found at the root of the .zip, .tar.gz or .tgz archive at name, so
+// that migrations can ship as a single release artifact. The files are named
+// as for MigrationsFromDir, and Recursive finds them in the directories of
+// the archive. The archive is read in full before returning, so Lazy has no
+// effect.
+func ArchiveMigrations(name string, opts ...DirOption) ([]Migration, error) {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		r, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return archiveMigrations(FSSource(r, ".", opts...).(*fileSource))
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		mf, err := tarFinder(f)
+		if err != nil {
+			return nil, err
+		}
+		return archiveMigrations(mf.source(".", opts...))
+	}
+	return nil, fmt.Errorf("emigrate: Unknown archive format of %q.", name)
+}
+
+// archiveMigrations returns the migrations of s, which are always read in
+// full, as the archive is closed once they are returned
+func archiveMigrations(s *fileSource) ([]Migration, error) {
+	s.lazy = false
+	return fileMigrations(s)
+}
+
+// tarFinder reads the files of the gzipped tar archive r into memory,
+// returning a migrationFinder for them.
+func tarFinder(r io.Reader) (migrationFinder, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return migrationFinder{}, err
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return migrationFinder{}, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return migrationFinder{}, err
+		}
+		files[path.Clean(strings.TrimPrefix(hdr.Name, "/"))] = contents
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	readFile := func(name string) ([]byte, error) {
+		contents, ok := files[path.Clean(name)]
+		if !ok {
+			return nil, fmt.Errorf("emigrate: File %q not found in archive.", name)
+		}
+		return contents, nil
+	}
+	return migrationFinder{listFiles(names), readFile}, nil
+}
+
+// listFiles returns a function that lists the directories of a tree holding
+// the files with the given slash-separated names. Directories are implied by
+// the names of their files.
+func listFiles(names []string) func(dir string) ([]os.FileInfo, error) {
+	return func(dir string) ([]os.FileInfo, error) {
+		var entries []os.FileInfo
+		seen := make(map[string]bool)
+		for _, name := range names {
+			rel := name
+			if dir != "." {
+				if !strings.HasPrefix(name, dir+"/") {
+					continue
+				}
+				rel = strings.TrimPrefix(name, dir+"/")
+			}
+			if i := strings.Index(rel, "/"); i >= 0 {
+				// the file is in a subdirectory, which is listed once
+				sub := rel[:i]
+				if !seen[sub] {
+					seen[sub] = true
+					entries = append(entries, memFileInfo{sub, true})
+				}
+				continue
+			}
+			entries = append(entries, memFileInfo{rel, false})
+		}
+		if entries == nil && dir != "." {
+			return nil, fmt.Errorf("emigrate: Directory %q not found.", dir)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		return entries, nil
+	}
+}
+
+// memFileInfo describes a file or directory held in memory
+type memFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (f memFileInfo) Name() string       { return f.name }
+func (f memFileInfo) Size() int64        { return 0 }
+func (f memFileInfo) ModTime() time.Time { return time.Time{} }
+func (f memFileInfo) IsDir() bool        { return f.dir }
+func (f memFileInfo) Sys() interface{}   { return nil }
+
+func (f memFileInfo) Mode() os.FileMode {
+	if f.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
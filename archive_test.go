@@ -0,0 +1,101 @@
+package emigrate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testArchiveFiles = map[string]string{
+	"001_create_invoice.up.sql":   TestQueryCreateInvoiceTable,
+	"001_create_invoice.down.sql": TestQueryDropInvoiceTable,
+	"2024/002_up.sql":             TestQueryInsertInvoices,
+}
+
+func writeZip(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for file, contents := range files {
+		w, err := zw.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for file, contents := range files {
+		hdr := &tar.Header{Name: "./" + file, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveMigrations(t *testing.T) {
+	dir := t.TempDir()
+	zipName := filepath.Join(dir, "migrations.zip")
+	tarName := filepath.Join(dir, "migrations.tar.gz")
+	writeZip(t, zipName, testArchiveFiles)
+	writeTarGz(t, tarName, testArchiveFiles)
+
+	for _, name := range []string{zipName, tarName} {
+		ms, err := ArchiveMigrations(name)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %#v", name, err)
+		}
+		if len(ms) != 1 {
+			t.Errorf("Expected subdirectories of %s to be skipped, got %d migrations", name, len(ms))
+		}
+
+		ms, err = ArchiveMigrations(name, Recursive())
+		if err != nil {
+			t.Fatalf("Got unexpected error for %s: %#v", name, err)
+		}
+		if len(ms) != 2 {
+			t.Fatalf("Expected %d migrations in %s, got %d", 2, name, len(ms))
+		}
+		m := ms[0].(stringMigration)
+		if m.up != TestQueryCreateInvoiceTable || m.down != TestQueryDropInvoiceTable || m.name != "create_invoice" {
+			t.Errorf("Unexpected migration %#v in %s", m, name)
+		}
+		if m := ms[1].(stringMigration); m.version != 2 || m.up != TestQueryInsertInvoices {
+			t.Errorf("Unexpected migration %#v in %s", m, name)
+		}
+	}
+}
+
+func TestArchiveMigrationsUnknownFormat(t *testing.T) {
+	if _, err := ArchiveMigrations("migrations.rar"); err == nil {
+		t.Errorf("Expected an error for an unknown archive format")
+	}
+}
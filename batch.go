@@ -0,0 +1,85 @@
+package emigrate
+
+import (
+	"sort"
+	"time"
+)
+
+// newBatch returns the identifier of a new run of migrations. Batches are
+// identified by the time the run started, in nanoseconds since the epoch, so
+// later batches have larger identifiers.
+func newBatch() int64 {
+	return time.Now().UnixNano()
+}
+
+// batches returns the batch in which each applied migration was applied,
+// taken from the latest successful or skipped upgrade in the history.
+func (m *Migrator) batches() (map[int64]int64, error) {
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.History()
+	if err != nil {
+		return nil, err
+	}
+
+	// migrations applied before the history was kept have a batch of 0
+	recorded := latestUpgrades(entries, true)
+	batches := make(map[int64]int64)
+	for version := range applied {
+		batches[version] = recorded[version].Batch
+	}
+	return batches, nil
+}
+
+// LastBatch returns the batch of the most recent upgrade whose migrations are
+// still applied, or 0 if there is none.
+func (m *Migrator) LastBatch() (int64, error) {
+	batches, err := m.batches()
+	if err != nil {
+		return 0, err
+	}
+	var last int64
+	for _, batch := range batches {
+		if batch > last {
+			last = batch
+		}
+	}
+	return last, nil
+}
+
+// DowngradeBatch reverts every migration applied in the given batch, such as
+// the one returned by LastBatch, to roll back a deploy. The migrations of the
+// batch must be the newest applied, otherwise BatchNotLatest is returned and
+// nothing is reverted.
+func (m *Migrator) DowngradeBatch(batch int64) (*Result, error) {
+	batches, err := m.batches()
+	if err != nil {
+		return &Result{}, err
+	}
+	var versions []int64
+	for version := range batches {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(int64Slice(versions)))
+
+	// the target is the newest version from another batch, which must be
+	// older than every version in the batch
+	var target int64
+	found := false
+	for _, version := range versions {
+		if batches[version] == batch {
+			if target != 0 {
+				return &Result{}, BatchNotLatest
+			}
+			found = true
+		} else if target == 0 {
+			target = version
+		}
+	}
+	if !found {
+		return &Result{}, nil
+	}
+	return m.DowngradeToVersion(target)
+}
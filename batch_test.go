@@ -0,0 +1,86 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUpgradeResultBatch(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1)
+	expectSetVersions(0, mock, 1)
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Unexpected error during upgrade: %s", err)
+	} else if result.Batch == 0 {
+		t.Errorf("Expected the result to identify the batch")
+	}
+	mock.CloseTest(t)
+}
+
+func expectBatches(mock *sqlmock.MockDB) {
+	expectAppliedQuery(mock, 1, 2, 3)
+	expectHistoryQuery(mock,
+		HistoryEntry{Version: 1, Direction: "up", ToVersion: 1, Batch: 10, Success: true},
+		HistoryEntry{Version: 2, Direction: "up", FromVersion: 1, ToVersion: 2, Batch: 20, Success: true},
+		HistoryEntry{Version: 3, Direction: "up", FromVersion: 2, ToVersion: 3, Batch: 20, Success: true},
+	)
+}
+
+func TestLastBatch(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+	expectBatches(mock)
+
+	if batch, err := m.LastBatch(); err != nil || batch != 20 {
+		t.Errorf("Expected batch %d, got %d (%v)", 20, batch, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that downgrading a batch reverts all of its migrations.
+func TestDowngradeBatch(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: downgradeRange(1, 2, 3)}
+	expectBatches(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("3"))
+	expectAppliedQuery(mock, 1, 2, 3)
+	expectRevert(mock, 3, 3, 2)
+	expectRevert(mock, 2, 2, 1)
+
+	result, err := m.DowngradeBatch(20)
+	if err != nil {
+		t.Fatalf("Unexpected error during downgrade: %s", err)
+	} else if len(result.Migrations) != 2 {
+		t.Errorf("Expected 2 migrations reverted, got %v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
+
+func TestDowngradeBatchNotLatest(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: downgradeRange(1, 2, 3)}
+	expectBatches(mock)
+
+	if _, err := m.DowngradeBatch(10); err != BatchNotLatest {
+		t.Errorf("Expected %v, got %v", BatchNotLatest, err)
+	}
+	mock.CloseTest(t)
+}
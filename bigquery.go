@@ -0,0 +1,76 @@
+package emigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BigQueryDialect is the Dialect for Google BigQuery, which cannot run DDL
+// in a transaction, and whose database/sql drivers cannot begin one, so it
+// is Transactionless: migrations are run one statement at a time, with their
+// progress recorded after each, as described by NoTransactions. The tracking
+// tables are kept in the dataset named by the Schema of the Migrator, which
+// may be qualified by its project, such as "my-project.analytics". As
+// BigQuery has no way to choose the dataset of a session, TargetSchema is not
+// supported. It uses BigQueryQueries.
+type BigQueryDialect struct{}
+
+func (BigQueryDialect) QuoteIdentifier(name string) string {
+	return quoteGoogleSQL(name)
+}
+
+func (BigQueryDialect) CurrentUser() string {
+	return "SESSION_USER()"
+}
+
+func (BigQueryDialect) Placeholder(n int) string {
+	return QuestionPlaceholder(n)
+}
+
+func (BigQueryDialect) TransactionalDDL() bool {
+	return false
+}
+
+func (BigQueryDialect) IsMissingTable(err error) bool {
+	return strings.Contains(err.Error(), "Not found: Table")
+}
+
+// NoTransactions reports that migrations on BigQuery run without
+// transactions
+func (BigQueryDialect) NoTransactions() bool {
+	return true
+}
+
+// BigQueryQueries returns the queries for Google BigQuery, which uses the
+// types of GoogleSQL, and whose DELETE statements must have a WHERE clause.
+// The current version is read without FOR UPDATE, as BigQuery cannot lock
+// rows.
+func BigQueryQueries() *QuerySet {
+	q := DefaultQueries()
+	q.LockCurrentVersion = q.GetCurrentVersion
+	q.CreateTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INT64)`, table)
+	}
+	q.CreateAppliedTable = q.CreateTable
+	q.CreateLedgerTable = q.CreateTable
+	q.CreateHistoryTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INT64, name STRING, label STRING, checksum STRING, direction STRING, from_version INT64, to_version INT64, applied_by STRING, db_user STRING, application STRING, hostname STRING, deploy_id STRING, batch INT64, sql_text STRING, applied_at TIMESTAMP, duration_ms INT64, success BOOL)`, table)
+	}
+	q.InsertHistory = func(table, currentUser string) string {
+		return fmt.Sprintf(`INSERT INTO %s (version, name, label, checksum, direction, from_version, to_version, applied_by, db_user, application, hostname, deploy_id, batch, sql_text, applied_at, duration_ms, success) VALUES (?, ?, ?, ?, ?, ?, ?, ?, %s, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP(), ?, ?)`, table, currentUser)
+	}
+	q.CreateLockTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INT64, locked_by STRING, locked_at TIMESTAMP)`, table)
+	}
+	q.CreateDirtyTable = q.CreateTable
+	q.ClearDirty = func(table string) string {
+		return fmt.Sprintf(`DELETE FROM %s WHERE true`, table)
+	}
+	q.CreateRepeatableTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (name STRING, checksum STRING)`, table)
+	}
+	q.CreateCheckpointTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INT64, checkpoint STRING)`, table)
+	}
+	return q
+}
@@ -0,0 +1,54 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that the tracking tables on BigQuery are kept in a dataset qualified
+// by its project.
+func TestBigQueryDatasetTables(t *testing.T) {
+	m := Migrator{Schema: "my-project.analytics", Dialect: BigQueryDialect{}}
+	if result, expected := m.versionTable(), "`my-project.analytics`.emigrate"; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+	if c, expected := m.Capabilities(), (Capabilities{false, false, false}); c != expected {
+		t.Errorf("Expected %+v, got %+v", expected, c)
+	}
+}
+
+// Verify that migrations on BigQuery run one statement at a time without a
+// transaction, although NoTransactions is not set.
+func TestBigQueryUpgrade(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, Dialect: BigQueryDialect{}}
+	m.migrations = []Migration{NewStringMigration(1, "CREATE TABLE events (id INT64);\nCREATE TABLE users (id INT64)", "")}
+
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE events (id INT64)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatementsDone(mock, "up:1")
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE users (id INT64)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatementsDone(mock, "up:2")
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,20 @@
+package emigrate
+
+import "path"
+
+// BindataSource returns a MigrationSource that reads migrations from assets
+// embedded by go-bindata or a similar tool, given its AssetNames and Asset
+// functions, so that projects can adopt emigrate without changing how they
+// embed migrations. The migrations are found among the assets in dir, such
+// as "migrations", and named as for MigrationsFromDir.
+func BindataSource(assetNames func() []string, asset func(name string) ([]byte, error), dir string, opts ...DirOption) MigrationSource {
+	var names []string
+	for _, name := range assetNames() {
+		names = append(names, path.Clean(name))
+	}
+	mf := migrationFinder{
+		readDir:  listFiles(names),
+		readFile: asset,
+	}
+	return mf.source(path.Clean(dir), opts...)
+}
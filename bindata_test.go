@@ -0,0 +1,49 @@
+package emigrate
+
+import (
+	"fmt"
+	"testing"
+)
+
+// testAssets stands in for the assets generated by go-bindata
+var testAssets = map[string]string{
+	"migrations/001_create_invoice_up.sql":   TestQueryCreateInvoiceTable,
+	"migrations/001_create_invoice_down.sql": TestQueryDropInvoiceTable,
+	"migrations/002_up.sql":                  TestQueryInsertInvoices,
+	"templates/index.html":                   "<html></html>",
+}
+
+func testAssetNames() []string {
+	names := make([]string, 0, len(testAssets))
+	for name := range testAssets {
+		names = append(names, name)
+	}
+	return names
+}
+
+func testAsset(name string) ([]byte, error) {
+	contents, ok := testAssets[name]
+	if !ok {
+		return nil, fmt.Errorf("Asset %s not found", name)
+	}
+	return []byte(contents), nil
+}
+
+func TestBindataSource(t *testing.T) {
+	ms, err := SourceMigrations(BindataSource(testAssetNames, testAsset, "migrations"))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	m := ms[0].(stringMigration)
+	if m.up != TestQueryCreateInvoiceTable || m.down != TestQueryDropInvoiceTable || m.name != "create_invoice" {
+		t.Errorf("Unexpected migration %#v", m)
+	}
+
+	_, err = SourceMigrations(BindataSource(testAssetNames, testAsset, "db/migrations"))
+	if err == nil {
+		t.Errorf("Expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,114 @@
+package emigrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Checksummed is implemented by migrations that can provide a checksum of
+// their content. The checksum is recorded in the history when the migration
+// is applied, and later runs verify that the migration hasn't changed since.
+// An empty checksum disables verification for the migration.
+type Checksummed interface {
+	Checksum() string
+}
+
+// DirectionChecksummed is implemented by migrations that can provide a
+// checksum of the content they run in each direction, "up" or "down". The
+// checksum of a direction is recorded in the history when the migration is
+// run in that direction. The checksum of the upgrade is the one verified.
+type DirectionChecksummed interface {
+	Checksummed
+	DirectionChecksum(direction string) string
+}
+
+// ChecksumMismatchError indicates that a migration has been changed since it
+// was applied
+type ChecksumMismatchError struct {
+	version  int64  // the version of the changed migration
+	recorded string // the checksum recorded when the migration was applied
+	actual   string // the checksum of the migration as loaded
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("emigrate: Migration %d has changed since it was applied (checksum %s, was %s)", e.version, e.actual, e.recorded)
+}
+
+// checksumString returns the hex encoded SHA-256 checksum of s
+func checksumString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// checksum returns the checksum of a migration, or "" if it has none
+func checksum(migration Migration) string {
+	if c, ok := migration.(Checksummed); ok {
+		return c.Checksum()
+	}
+	return ""
+}
+
+// checksumReader is implemented by migrations whose checksum is computed from
+// content read when it is needed, which may fail, such as lazy migrations
+type checksumReader interface {
+	readChecksum() (string, error)
+}
+
+// verifiedChecksum returns the checksum of a migration to be verified, or an
+// error if its content cannot be read
+func verifiedChecksum(migration Migration) (string, error) {
+	if c, ok := migration.(checksumReader); ok {
+		return c.readChecksum()
+	}
+	return checksum(migration), nil
+}
+
+// directionChecksum returns the checksum of a migration in the given
+// direction, or its checksum if it has none for directions
+func directionChecksum(migration Migration, direction string) string {
+	if c, ok := migration.(DirectionChecksummed); ok {
+		return c.DirectionChecksum(direction)
+	}
+	return checksum(migration)
+}
+
+// verifyChecksums compares the checksums of the migrations at or below the
+// current version with those recorded when they were applied, returning an
+// error for each migration that has changed. The history is only queried if
+// at least one of those migrations has a checksum. An error reading the
+// content of a migration to checksum it is returned.
+func (m *Migrator) verifyChecksums(migrations []Migration, current int64) ([]error, error) {
+	actual := make(map[int64]string)
+	for _, migration := range migrations {
+		if migration.Version() > current {
+			continue
+		}
+		sum, err := verifiedChecksum(migration)
+		if err != nil {
+			return nil, err
+		} else if sum != "" {
+			actual[migration.Version()] = sum
+		}
+	}
+	if len(actual) == 0 {
+		return nil, nil
+	}
+
+	entries, err := m.History()
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := latestUpgrades(entries, false)
+	var errs []error
+	for _, migration := range migrations {
+		version := migration.Version()
+		sum, ok := actual[version]
+		if !ok || recorded[version].Checksum == "" || recorded[version].Checksum == sum {
+			continue
+		}
+		errs = append(errs, ChecksumMismatchError{version, recorded[version].Checksum, sum})
+	}
+	return errs, nil
+}
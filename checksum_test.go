@@ -0,0 +1,88 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestChecksumMismatch(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 2)
+	m.migrations = []Migration{
+		stringMigration{version: 1, up: "CREATE TABLE a (id INTEGER)", down: ""},
+		stringMigration{version: 2, up: "CREATE TABLE b (id INTEGER)", down: ""},
+		stringMigration{version: 3, up: "CREATE TABLE c (id INTEGER)", down: ""},
+	}
+	expectHistoryQuery(mock,
+		HistoryEntry{Version: 1, Checksum: checksumString("CREATE TABLE a (id INTEGER)"), Direction: "up", Success: true},
+		HistoryEntry{Version: 2, Checksum: checksumString("CREATE TABLE b (id BIGINT)"), Direction: "up", Success: true},
+	)
+
+	expected := ChecksumMismatchError{
+		2,
+		checksumString("CREATE TABLE b (id BIGINT)"),
+		checksumString("CREATE TABLE b (id INTEGER)"),
+	}
+	if _, result := m.Upgrade(); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	mock.CloseTest(t)
+}
+
+func TestChecksumVerified(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = []Migration{stringMigration{version: 1, up: "CREATE TABLE a (id INTEGER)", down: ""}}
+
+	// failed attempts and migrations recorded without a checksum are ignored
+	expectHistoryQuery(mock,
+		HistoryEntry{Version: 1, Checksum: checksumString("CREATE TABLE a (id BIGINT)"), Direction: "up", Success: false},
+		HistoryEntry{Version: 1, Checksum: checksumString("CREATE TABLE a (id INTEGER)"), Direction: "up", Success: true},
+	)
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Expected %v, got %v", nil, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("1"))
+	expectHistoryQuery(mock, HistoryEntry{Version: 1, Direction: "up", Success: true})
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Expected %v, got %v", nil, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that migrations loaded from files have a checksum for each
+// direction, whether they are read eagerly or lazily.
+func TestDirectionChecksum(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/001_down.sql": {Data: []byte(TestQueryDropInvoiceTable)},
+		"migrations/002_up.sql":   {Data: []byte(TestQueryInsertInvoices)},
+	}
+
+	for _, opts := range [][]DirOption{nil, {Lazy()}} {
+		ms, err := FSMigrations(fsys, "migrations", opts...)
+		if err != nil {
+			t.Fatalf("Got unexpected error %#v", err)
+		}
+		if sum := directionChecksum(ms[0], "up"); sum != checksumString(TestQueryCreateInvoiceTable) || sum != checksum(ms[0]) {
+			t.Errorf("Expected the checksum of the upgrade, got %q", sum)
+		}
+		if sum := directionChecksum(ms[0], "down"); sum != checksumString(TestQueryDropInvoiceTable) {
+			t.Errorf("Expected the checksum of the downgrade, got %q", sum)
+		}
+		if sum := directionChecksum(ms[1], "down"); sum != "" {
+			t.Errorf("Expected no checksum without a downgrade, got %q", sum)
+		}
+	}
+
+	// migrations without checksums for directions use their checksum
+	m := NewFunctionMigration(1, nil, nil, WithChecksum("declared"))
+	if sum := directionChecksum(m, "down"); sum != "declared" {
+		t.Errorf("Expected %q, got %q", "declared", sum)
+	}
+}
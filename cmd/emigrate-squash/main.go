@@ -0,0 +1,69 @@
+// Command emigrate-squash squashes the migrations in a directory up to a
+// version into a single baseline migration, written to the same directory:
+//
+//	emigrate-squash -dir migrations -version 120
+//
+// The files of the squashed migrations are listed, to be removed once the
+// baseline has been checked with emigrate.VerifyBaseline, and the tracking
+// tables of existing databases must be rewritten with Migrator.Rebaseline.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jnwhiteh/emigrate"
+)
+
+func main() {
+	dir := flag.String("dir", "migrations", "the directory of the migrations")
+	version := flag.Int64("version", 0, "the version to squash up to, inclusive")
+	name := flag.String("name", "baseline", "the name of the baseline migration")
+	flag.Parse()
+
+	if err := squash(*dir, *version, *name); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func squash(dir string, version int64, name string) error {
+	if version <= 0 {
+		return fmt.Errorf("emigrate-squash: -version is required")
+	}
+	ms, err := emigrate.MigrationsFromDir(dir)
+	if err != nil {
+		return err
+	}
+	baseline, err := emigrate.Squash(ms, version, emigrate.WithName(name))
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var squashed []string
+	for _, entry := range entries {
+		fn, err := emigrate.ParseFileName(entry.Name())
+		if err == nil && fn != nil && fn.Version <= version {
+			squashed = append(squashed, entry.Name())
+		}
+	}
+
+	up, down, err := emigrate.WriteBaseline(dir, baseline)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Wrote", up)
+	if down != "" {
+		fmt.Println("Wrote", down)
+	}
+	fmt.Println("Squashed, to be removed:")
+	for _, name := range squashed {
+		fmt.Println("\t" + name)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package emigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultTxRetries is how many times a migration is run again after its
+// transaction is aborted to be retried, as described by TxRetrier, if the
+// Migrator does not set more Retries.
+const DefaultTxRetries = 5
+
+// TxRetrier is implemented by dialects whose transactions may be aborted by
+// the database to be retried, such as CockroachDB under contention. A
+// migration running in a transaction that fails with an error recognized by
+// RetryTx is run again, whether or not it is Retryable, as nothing it did was
+// committed. Go migrations must not have effects outside the database.
+type TxRetrier interface {
+	RetryTx(err error) bool
+}
+
+// CockroachDialect is the Dialect for CockroachDB, which speaks the protocol
+// of PostgreSQL but runs every transaction at the serializable isolation
+// level, aborting some under contention to be retried. It is a TxRetrier,
+// and uses CockroachQueries. It takes no advisory lock, as CockroachDB does
+// not support them, relying on the lock of the current version instead.
+type CockroachDialect struct{}
+
+func (CockroachDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, `"`)
+}
+
+func (CockroachDialect) CurrentUser() string {
+	return "current_user"
+}
+
+func (CockroachDialect) Placeholder(n int) string {
+	return PostgresDialect{}.Placeholder(n)
+}
+
+func (CockroachDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (CockroachDialect) IsMissingTable(err error) bool {
+	return PostgresDialect{}.IsMissingTable(err)
+}
+
+func (d CockroachDialect) UseSchema(name string) string {
+	return "SET search_path TO " + d.QuoteIdentifier(name)
+}
+
+// RetryTx recognizes the serialization failures that CockroachDB asks to be
+// retried, which have the SQLSTATE 40001 and mention "restart transaction"
+func (CockroachDialect) RetryTx(err error) bool {
+	return sqlState(err) == "40001" || strings.Contains(err.Error(), "restart transaction")
+}
+
+// CockroachQueries returns the queries for CockroachDB, which are those for
+// PostgreSQL except that the history table has a primary key of its own,
+// rather than the hidden row ID CockroachDB would otherwise add.
+func CockroachQueries() *QuerySet {
+	q := PostgresQueries()
+	q.CreateHistoryTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), version BIGINT, name TEXT, label TEXT, checksum TEXT, direction TEXT, from_version BIGINT, to_version BIGINT, applied_by TEXT, db_user TEXT, application TEXT, hostname TEXT, deploy_id TEXT, batch BIGINT, sql_text TEXT, applied_at TIMESTAMPTZ, duration_ms BIGINT, success BOOLEAN)`, table)
+	}
+	return q
+}
+
+// retriesTx reports whether a migration that failed with err is run again
+// as its transaction was aborted to be retried, as described by TxRetrier
+func (m *Migrator) retriesTx(migration Migration, err error) bool {
+	r, ok := m.dialect().(TxRetrier)
+	return ok && transactional(migration) && r.RetryTx(err)
+}
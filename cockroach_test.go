@@ -0,0 +1,69 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that a migration whose transaction CockroachDB aborts is run again,
+// although it is not declared Retryable.
+func TestCockroachRetryTx(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, Dialect: CockroachDialect{}}
+	m.migrations = []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnError(errors.New("restart transaction: TransactionRetryWithProtoRefreshError"))
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE emigrate SET version = $1 WHERE version = $2")).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO " + testAppliedTable)).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestCockroachShouldRetry(t *testing.T) {
+	m := &Migrator{Dialect: CockroachDialect{}}
+	migration := NewStringMigration(1, TestQueryCreateInvoiceTable, "")
+	if !m.shouldRetry(migration, sqlStateError("40001"), DefaultTxRetries-1) {
+		t.Errorf("Expected a retry before reaching %d retries", DefaultTxRetries)
+	}
+	if m.shouldRetry(migration, sqlStateError("40001"), DefaultTxRetries) {
+		t.Errorf("Expected no retry after %d retries", DefaultTxRetries)
+	}
+	if m.shouldRetry(NewNoTxStringMigration(2, TestQueryCreateInvoiceTable, ""), sqlStateError("40001"), 0) {
+		t.Errorf("Expected no retry outside a transaction")
+	}
+	if m.shouldRetry(migration, sqlStateError("23505"), 0) {
+		t.Errorf("Expected no retry for a unique violation")
+	}
+}
+
+func TestCockroachQueries(t *testing.T) {
+	m := &Migrator{Dialect: CockroachDialect{}}
+	if query := m.query(m.queries().CreateHistoryTable, m.historyTable()); !strings.Contains(query, "id UUID PRIMARY KEY") {
+		t.Errorf("Expected the history table to have a primary key, got %q", query)
+	}
+}
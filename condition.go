@@ -0,0 +1,143 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Conditional is implemented by migrations that only apply under some
+// runtime condition, such as the dialect of the database, a row in a table of
+// feature flags or the presence of legacy objects. ShouldApply is called in
+// the transaction of the migration once the version has been locked, or in
+// that of the first chunk of a chunked migration. If it returns false the
+// migration is skipped: nothing is run, but its version is recorded as
+// applied, so it is not considered again, and it is reported as skipped in
+// the result and recorded with a direction of "skip" in the history.
+type Conditional interface {
+	ShouldApply(tx *sql.Tx) (bool, error)
+}
+
+// shouldApply reports whether a migration should be applied, which it should
+// unless it is Conditional and its condition does not hold
+func shouldApply(tx Tx, m Migration) (bool, error) {
+	c, ok := m.(Conditional)
+	if !ok {
+		return true, nil
+	}
+	stx, err := databaseSQLTx(tx)
+	if err != nil {
+		return false, err
+	}
+	return c.ShouldApply(stx)
+}
+
+// PreChecker is implemented by risky migrations that check the database
+// before they run, such as that a column to be dropped is unused or that a
+// table holds the expected number of rows. PreCheck is called in the
+// transaction of the migration once the version has been locked and its
+// condition, if any, holds, or in that of the first chunk of a chunked
+// migration. If it fails, the migration fails with a PreCheckError before
+// anything is run.
+type PreChecker interface {
+	PreCheck(tx *sql.Tx) error
+}
+
+// PreCheckError indicates that the PreCheck of a migration failed
+type PreCheckError struct {
+	version int64 // the version of the migration
+	err     error // the error returned by PreCheck
+}
+
+func (e PreCheckError) Error() string {
+	return fmt.Sprintf("emigrate: Pre-check of migration %d failed: %s", e.version, e.err)
+}
+
+// Unwrap returns the error returned by PreCheck
+func (e PreCheckError) Unwrap() error {
+	return e.err
+}
+
+// preCheck runs the PreCheck of a migration, if it has one
+func preCheck(tx Tx, m Migration) error {
+	p, ok := m.(PreChecker)
+	if !ok {
+		return nil
+	}
+	stx, err := databaseSQLTx(tx)
+	if err != nil {
+		return err
+	} else if err := p.PreCheck(stx); err != nil {
+		return PreCheckError{m.Version(), err}
+	}
+	return nil
+}
+
+// PostChecker is implemented by migrations that assert invariants once they
+// have run, such as that rows were preserved or a constraint holds.
+// PostCheck is called in the transaction of the migration after it has run
+// and before it commits, or in that of the last chunk of a chunked migration,
+// and if it fails the transaction is rolled back and the migration fails with
+// a PostCheckError. Migrations run outside of a transaction are not
+// post-checked, as their changes cannot be rolled back. It is distinct from
+// Verifier, which checks a dirty database before a failed migration is run
+// again.
+type PostChecker interface {
+	PostCheck(tx *sql.Tx) error
+}
+
+// PostCheckError indicates that the PostCheck of a migration failed
+type PostCheckError struct {
+	version int64 // the version of the migration
+	err     error // the error returned by PostCheck
+}
+
+func (e PostCheckError) Error() string {
+	return fmt.Sprintf("emigrate: Post-check of migration %d failed: %s", e.version, e.err)
+}
+
+// Unwrap returns the error returned by PostCheck
+func (e PostCheckError) Unwrap() error {
+	return e.err
+}
+
+// postCheck runs the PostCheck of a migration, if it has one
+func postCheck(tx Tx, m Migration) error {
+	p, ok := m.(PostChecker)
+	if !ok {
+		return nil
+	}
+	stx, err := databaseSQLTx(tx)
+	if err != nil {
+		return err
+	} else if err := p.PostCheck(stx); err != nil {
+		return PostCheckError{m.Version(), err}
+	}
+	return nil
+}
+
+// skippedVersions returns the applied versions whose latest upgrade was
+// skipped by its condition, querying the history only if one of migrations
+// is Conditional
+func (m *Migrator) skippedVersions(migrations []Migration) (map[int64]bool, error) {
+	conditional := false
+	for _, migration := range migrations {
+		if _, ok := migration.(Conditional); ok {
+			conditional = true
+			break
+		}
+	}
+	if !conditional {
+		return nil, nil
+	}
+
+	entries, err := m.History()
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := make(map[int64]bool)
+	for version, entry := range latestUpgrades(entries, true) {
+		skipped[version] = entry.Direction == "skip"
+	}
+	return skipped, nil
+}
@@ -0,0 +1,200 @@
+package emigrate
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type conditionalMigration struct {
+	downgradeMigration
+	apply bool  // the result of ShouldApply
+	err   error // an error to be returned by ShouldApply (or nil)
+}
+
+func (cm *conditionalMigration) ShouldApply(tx *sql.Tx) (bool, error) {
+	return cm.apply, cm.err
+}
+
+// Verify that a migration whose condition does not hold is not run, but is
+// recorded as applied, and as skipped in the result and history.
+func TestConditionalMigrationSkipped(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	migration := &conditionalMigration{downgradeMigration: downgradeMigration{mockMigration: mockMigration{version: 1}}}
+	m.migrations = []Migration{migration}
+	m.AppliedBy = "deploy"
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectHistoryEntry(mock, HistoryEntry{
+		Version: 1, Direction: "skip", ToVersion: 1, AppliedBy: "deploy", Hostname: hostname(), Success: true,
+	}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if migration.called {
+		t.Errorf("Expected the skipped migration not to be run")
+	}
+	if len(result.Migrations) != 1 || result.Migrations[0].Status != StatusSkipped {
+		t.Errorf("Expected the migration to be skipped, got %v", result.Migrations)
+	}
+	if applied := result.Applied(); len(applied) != 0 {
+		t.Errorf("Expected nothing applied, got %v", applied)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration whose condition holds is applied as usual.
+func TestConditionalMigrationApplied(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	migration := &conditionalMigration{downgradeMigration: downgradeMigration{mockMigration: mockMigration{version: 1}}, apply: true}
+	m.migrations = []Migration{migration}
+	expectSetVersions(0, mock, 1)
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if !migration.called || result.Migrations[0].Status != StatusApplied {
+		t.Errorf("Expected the migration to be applied, got %v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a failing condition fails the migration without running it.
+func TestConditionalMigrationFails(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	expected := errors.New("no such table")
+	migration := &conditionalMigration{downgradeMigration: downgradeMigration{mockMigration: mockMigration{version: 1}}, err: expected}
+	m.migrations = []Migration{migration}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	if _, err := m.Upgrade(); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	if migration.called {
+		t.Errorf("Expected the migration not to be run")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that downgrading a skipped migration only updates the tracking
+// tables, without running its downgrade.
+func TestDowngradeSkippedMigration(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 2)
+	skipped := &conditionalMigration{downgradeMigration: downgradeMigration{mockMigration: mockMigration{version: 2}}}
+	m.migrations = []Migration{downgradeRange(1)[0], skipped}
+	expectAppliedQuery(mock, 1, 2)
+	expectHistoryQuery(mock,
+		HistoryEntry{Version: 1, Direction: "up", Success: true},
+		HistoryEntry{Version: 2, Direction: "skip", Success: true},
+	)
+	expectRevert(mock, 2, 2, 1)
+
+	if _, err := m.DowngradeToVersion(1); err != nil {
+		t.Fatalf("Unexpected error during downgrade: %s", err)
+	}
+	if skipped.reverted {
+		t.Errorf("Expected the downgrade of the skipped migration not to be run")
+	}
+	mock.CloseTest(t)
+}
+
+type preCheckedMigration struct {
+	downgradeMigration
+	err error // an error to be returned by PreCheck (or nil)
+}
+
+func (pm *preCheckedMigration) PreCheck(tx *sql.Tx) error {
+	return pm.err
+}
+
+// Verify that a failed pre-check aborts the migration before it is run.
+func TestPreCheckFails(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	expected := errors.New("column still in use")
+	migration := &preCheckedMigration{downgradeMigration{mockMigration: mockMigration{version: 1}}, expected}
+	m.migrations = []Migration{migration}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	_, err := m.Upgrade()
+	if _, ok := err.(PreCheckError); !ok || !errors.Is(err, expected) {
+		t.Errorf("Expected a pre-check error, got %v", err)
+	}
+	if migration.called {
+		t.Errorf("Expected the migration not to be run")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration whose pre-check passes is applied as usual.
+func TestPreCheckPasses(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	migration := &preCheckedMigration{downgradeMigration: downgradeMigration{mockMigration: mockMigration{version: 1}}}
+	m.migrations = []Migration{migration}
+	expectSetVersions(0, mock, 1)
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if !migration.called {
+		t.Errorf("Expected the migration to be run")
+	}
+	mock.CloseTest(t)
+}
+
+type postCheckedMigration struct {
+	downgradeMigration
+	err error // an error to be returned by PostCheck (or nil)
+}
+
+func (pm *postCheckedMigration) PostCheck(tx *sql.Tx) error {
+	return pm.err
+}
+
+// Verify that a failed post-check rolls back the migration.
+func TestPostCheckFails(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	expected := errors.New("rows lost")
+	migration := &postCheckedMigration{downgradeMigration{mockMigration: mockMigration{version: 1}}, expected}
+	m.migrations = []Migration{migration}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	_, err := m.Upgrade()
+	if _, ok := err.(PostCheckError); !ok || !errors.Is(err, expected) {
+		t.Errorf("Expected a post-check error, got %v", err)
+	}
+	if !migration.called {
+		t.Errorf("Expected the migration to be run before the post-check")
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,223 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Chunk describes the outcome of processing one chunk of a data migration. A
+// chunk that is not Done must return a Checkpoint, or the migration fails, as
+// the next chunk would start from the beginning again.
+type Chunk struct {
+	Checkpoint string // where the next chunk starts, such as the last id processed
+	Rows       int64  // the number of rows processed
+	Done       bool   // true if there is nothing left to process
+}
+
+// ChunkFunc processes the chunk of a data migration that starts after
+// checkpoint, which is "" for the first chunk, such as by updating the next
+// thousand rows with an id greater than the checkpoint.
+type ChunkFunc func(tx *sql.Tx, checkpoint string) (Chunk, error)
+
+// Chunked is implemented by migrations that process data in chunks, each in a
+// transaction of its own, so that backfilling millions of rows does not hold
+// one long transaction. The checkpoint returned by each chunk is recorded in
+// the transaction of the chunk, so an interrupted migration resumes from the
+// last chunk committed when it is next applied. The migration is recorded as
+// applied in the transaction of its final chunk.
+//
+// As the chunks before the last are committed, a chunked migration cannot
+// be rolled back as a whole, so each chunk should leave the data valid for
+// both the old and the new schema.
+type Chunked interface {
+	Migration
+	RunChunk(tx *sql.Tx, checkpoint string) (Chunk, error)
+}
+
+// NewDataMigration returns a chunked migration that calls chunk until it is
+// done, as described by Chunked.
+func NewDataMigration(version int64, chunk ChunkFunc, opts ...MigrationOption) Migration {
+	m := &dataMigration{version: version, chunk: chunk}
+	m.set(opts)
+	return m
+}
+
+// dataMigration is an implementation of Chunked that calls a Go function for
+// each chunk
+type dataMigration struct {
+	version int64
+	chunk   ChunkFunc
+	migrationOptions
+}
+
+func (m *dataMigration) Version() int64 {
+	return m.version
+}
+
+// Checksum returns the declared checksum of the migration
+func (m *dataMigration) Checksum() string {
+	return m.checksum
+}
+
+func (m *dataMigration) RunChunk(tx *sql.Tx, checkpoint string) (Chunk, error) {
+	return m.chunk(tx, checkpoint)
+}
+
+// Upgrade processes every chunk in tx, for when the migration is not run by a
+// Migrator, which would process each chunk in its own transaction
+func (m *dataMigration) Upgrade(tx *sql.Tx) error {
+	var checkpoint string
+	for {
+		chunk, err := m.chunk(tx, checkpoint)
+		if err != nil {
+			return err
+		} else if chunk.Done {
+			return nil
+		} else if err := checkChunk(m.version, chunk); err != nil {
+			return err
+		}
+		checkpoint = chunk.Checkpoint
+	}
+}
+
+// checkpointTable returns the name of the table holding the checkpoints of
+// chunked migrations, and of migrations run without transactions, in progress
+func (m *Migrator) checkpointTable() string {
+	return m.table("_checkpoint")
+}
+
+// initCheckpoint creates the table of checkpoints, if any chunked migrations
+// are loaded or the Migrator runs without transactions, when it records the
+// statements run by each migration
+func (m *Migrator) initCheckpoint() error {
+	needed := m.noTransactions()
+	for _, migration := range m.migrations {
+		if _, ok := migration.(Chunked); ok {
+			needed = true
+		}
+	}
+	if !needed {
+		return nil
+	}
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().CreateCheckpointTable, m.checkpointTable()))
+	return err
+}
+
+// applyChunked applies a chunked migration one chunk at a time, each in its
+// own transaction, returning the total number of rows processed and whether
+// the migration was skipped as its condition did not hold
+func (m *Migrator) applyChunked(migration Chunked, expected int64) (int64, bool, error) {
+	start := time.Now()
+	ctx, cancel := m.migrationContext(migration)
+	defer cancel()
+
+	var rows int64
+	for {
+		tx, err := m.begin(ctx, migration)
+		if err != nil {
+			return rows, false, err
+		}
+		current, err := m.checkApply(tx, migration, expected)
+		if err != nil {
+			tx.Rollback()
+			return rows, false, err
+		}
+
+		var checkpoint string
+		err = tx.QueryRowContext(ctx, m.query(m.queries().GetCheckpoint, m.checkpointTable()), migration.Version()).Scan(&checkpoint)
+		if err != nil && err != sql.ErrNoRows {
+			tx.Rollback()
+			return rows, false, err
+		}
+
+		if checkpoint == "" {
+			run, err := shouldApply(tx, migration)
+			if err == nil && run {
+				err = preCheck(tx, migration)
+			}
+			if err != nil {
+				tx.Rollback()
+				entry := m.historyEntry(migration, "up", current, current)
+				entry.Duration = time.Since(start)
+				m.recordFailure(entry)
+				return rows, false, err
+			} else if !run {
+				return rows, true, m.commitApplied(tx, migration, true, current, expected, start)
+			}
+		}
+
+		chunk, err := runChunk(tx, migration, checkpoint)
+		if err == nil {
+			err = checkChunk(migration.Version(), chunk)
+		}
+		if err == nil && chunk.Done {
+			err = postCheck(tx, migration)
+		}
+		if err == nil {
+			err = m.reportChunk(tx, migration, rows+chunk.Rows, chunk)
+		}
+		if err != nil {
+			tx.Rollback()
+			entry := m.historyEntry(migration, "up", current, current)
+			entry.Duration = time.Since(start)
+			m.recordFailure(entry)
+			return rows, false, err
+		}
+		rows += chunk.Rows
+
+		_, err = tx.ExecContext(ctx, m.query(m.queries().DeleteCheckpoint, m.checkpointTable()), migration.Version())
+		if err != nil {
+			tx.Rollback()
+			return rows, false, err
+		}
+		if chunk.Done {
+			return rows, false, m.commitApplied(tx, migration, false, current, expected, start)
+		}
+
+		_, err = tx.ExecContext(ctx, m.query(m.queries().InsertCheckpoint, m.checkpointTable()), migration.Version(), chunk.Checkpoint)
+		if err != nil {
+			tx.Rollback()
+			return rows, false, err
+		}
+		if err = tx.Commit(); err != nil {
+			return rows, false, err
+		}
+	}
+}
+
+// runChunk processes the chunk of a chunked migration that starts after
+// checkpoint in tx
+func runChunk(tx Tx, migration Chunked, checkpoint string) (Chunk, error) {
+	stx, err := databaseSQLTx(tx)
+	if err != nil {
+		return Chunk{}, err
+	}
+	return migration.RunChunk(stx, checkpoint)
+}
+
+// checkChunk returns an error if a chunk of the migration of version is not
+// done but has no checkpoint to resume from, which would otherwise run the
+// first chunk again forever
+func checkChunk(version int64, chunk Chunk) error {
+	if !chunk.Done && chunk.Checkpoint == "" {
+		return fmt.Errorf("emigrate: Chunk of migration %d is not done but has no checkpoint.", version)
+	}
+	return nil
+}
+
+// reportChunk reports the progress of a chunked migration to OnProgress once
+// a chunk has been processed in tx, with done rows processed by the run so
+// far, and refreshes the lock, so that it does not go stale during a long
+// backfill
+func (m *Migrator) reportChunk(tx Tx, migration Chunked, done int64, chunk Chunk) error {
+	if m.OnProgress != nil {
+		message := "done"
+		if !chunk.Done {
+			message = "processed up to " + chunk.Checkpoint
+		}
+		m.OnProgress(Progress{Version: migration.Version(), Direction: "up", Done: done, Message: message})
+	}
+	return m.heartbeat(tx)
+}
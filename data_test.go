@@ -0,0 +1,201 @@
+package emigrate
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testCheckpointTable = "emigrate_checkpoint"
+
+// countingChunks returns a ChunkFunc that processes ids up to max, ten at a
+// time, recording the checkpoints it was given
+func countingChunks(max int, checkpoints *[]string) ChunkFunc {
+	return func(tx *sql.Tx, checkpoint string) (Chunk, error) {
+		*checkpoints = append(*checkpoints, checkpoint)
+		last, _ := strconv.Atoi(checkpoint)
+		next := last + 10
+		if next >= max {
+			return Chunk{Rows: int64(max - last), Done: true}, nil
+		}
+		return Chunk{Checkpoint: strconv.Itoa(next), Rows: 10}, nil
+	}
+}
+
+// Sets up the database mock to expect a chunk to start from checkpoint
+func expectChunk(mock *sqlmock.MockDB, checkpoint string) {
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	rows := sqlmock.NewRows([]string{"checkpoint"})
+	if checkpoint != "" {
+		rows.AddRow(checkpoint)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnRows(rows)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// Sets up the database mock to expect a chunk to start from checkpoint and
+// refresh the lock once it has run
+func expectChunkRefreshingLock(mock *sqlmock.MockDB, checkpoint string) {
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	rows := sqlmock.NewRows([]string{"checkpoint"})
+	if checkpoint != "" {
+		rows.AddRow(checkpoint)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnRows(rows)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RefreshLock(testLockTable))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// Sets up the database mock to expect the checkpoint of a chunk to be saved
+func expectCheckpoint(mock *sqlmock.MockDB, checkpoint string) {
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1), checkpoint).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// Verify that a data migration processes each chunk in its own transaction,
+// recording its checkpoint, and is recorded as applied with the last chunk.
+func TestDataMigration(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	var checkpoints []string
+	m.migrations = []Migration{NewDataMigration(1, countingChunks(25, &checkpoints))}
+
+	expectChunk(mock, "")
+	expectCheckpoint(mock, "10")
+	expectChunk(mock, "10")
+	expectCheckpoint(mock, "20")
+	expectChunk(mock, "20")
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	result, err := m.UpgradeToVersion(1)
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if rows := result.Migrations[0].RowsAffected; rows != 25 {
+		t.Errorf("Expected %d rows affected, got %d", 25, rows)
+	}
+	if len(checkpoints) != 3 || checkpoints[0] != "" || checkpoints[2] != "20" {
+		t.Errorf("Expected chunks from %q, got %q", []string{"", "10", "20"}, checkpoints)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that an interrupted data migration resumes from its checkpoint.
+func TestDataMigrationResume(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	var checkpoints []string
+	m.migrations = []Migration{NewDataMigration(1, countingChunks(25, &checkpoints))}
+
+	expectChunk(mock, "20")
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0] != "20" {
+		t.Errorf("Expected a chunk from %q, got %q", "20", checkpoints)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the progress of a data migration is reported after each chunk,
+// refreshing the lock within the transaction of the chunk once it is due.
+func TestDataMigrationProgress(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	// refresh the lock after every chunk
+	m := Migrator{db: db, LockTable: true, LockExpiry: time.Nanosecond}
+	var checkpoints []string
+	m.migrations = []Migration{NewDataMigration(1, countingChunks(15, &checkpoints))}
+	var reported []Progress
+	m.OnProgress = func(p Progress) {
+		reported = append(reported, p)
+	}
+
+	expectAcquireLock(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	expectChunkRefreshingLock(mock, "")
+	expectCheckpoint(mock, "10")
+	expectChunkRefreshingLock(mock, "10")
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	expectReleaseLock(mock)
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("Expected %d reports, got %d", 2, len(reported))
+	}
+	if p := reported[0]; p.Version != 1 || p.Done != 10 || p.Message != "processed up to 10" {
+		t.Errorf("Unexpected progress %#v", p)
+	}
+	if p := reported[1]; p.Done != 15 || p.Message != "done" {
+		t.Errorf("Unexpected progress %#v", p)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a data migration run outside a Migrator processes every chunk.
+func TestDataMigrationUpgrade(t *testing.T) {
+	var checkpoints []string
+	m := NewDataMigration(1, countingChunks(25, &checkpoints))
+	if err := m.Upgrade(nil); err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(checkpoints) != 3 {
+		t.Errorf("Expected %d chunks, got %d", 3, len(checkpoints))
+	}
+}
+
+// stuckChunks is a ChunkFunc that is never done but returns no checkpoint
+func stuckChunks(tx *sql.Tx, checkpoint string) (Chunk, error) {
+	return Chunk{Rows: 10}, nil
+}
+
+// Verify that a data migration fails, rather than starting again forever,
+// if a chunk that is not done returns no checkpoint.
+func TestDataMigrationNoCheckpoint(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{NewDataMigration(1, stuckChunks)}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}))
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	if _, err := m.UpgradeToVersion(1); err == nil {
+		t.Errorf("Expected an error for a chunk with no checkpoint")
+	}
+	if err := NewDataMigration(1, stuckChunks).Upgrade(nil); err == nil {
+		t.Errorf("Expected an error for a chunk with no checkpoint")
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,336 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DB is the database on which a Migrator runs. It is implemented by *sql.DB
+// and *sql.Conn, by adapters with the same methods as them, such as those
+// instrumenting queries or fakes in tests, and by NativeDBs, which reach the
+// database through a driver with an API of its own, such as pgx, rather than
+// through database/sql. A DB that is neither is refused when it is used.
+//
+// If the DB has a Conn method, as *sql.DB does, or a Session method, as a
+// NativeDB for a pool of connections may, statements that must share a
+// session, such as those of migrations run outside a transaction or a session
+// lock, are run on a connection taken from it. Otherwise the DB is taken to
+// be a single session.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// NativeDB is a DB reached through a driver with an API of its own, rather
+// than through database/sql, as emigratepgx adapts pgx. The Migrator runs the
+// SQL of migrations, and reads and writes the tracking tables, through it
+// directly. Migrations and hooks that are given a *sql.Tx, such as those
+// created by NewFunctionMigration or implementing Conditional, cannot run on
+// it, and fail with SQLTxUnavailable.
+//
+// If it has a Dialect method returning a Dialect, that dialect is used unless
+// the Migrator has one configured. If it has a Session method with the
+// signature
+//
+//	Session(ctx context.Context) (NativeDB, func() error, error)
+//
+// statements that must share a session are run on the NativeDB it returns,
+// which is released by calling the function returned with it.
+type NativeDB interface {
+	DB
+	QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+}
+
+// Tx is a transaction begun on a NativeDB
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) Row
+	Commit() error
+	Rollback() error
+}
+
+// Rows are the rows returned by a query of a NativeDB, as for *sql.Rows
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Close() error
+	Err() error
+}
+
+// Row is the row returned by a query of a NativeDB, as for *sql.Row. Scan
+// must return sql.ErrNoRows if the query returned no rows.
+type Row interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlDB is implemented by *sql.DB, *sql.Conn and the adapters with the same
+// methods
+type sqlDB interface {
+	DB
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// nativeDB returns db as a NativeDB, adapting a database reached through
+// database/sql
+func nativeDB(db DB) NativeDB {
+	switch db := db.(type) {
+	case NativeDB:
+		return db
+	case sqlDB:
+		return databaseSQL{db}
+	}
+	return unsupportedDB{db}
+}
+
+// databaseSQL is a NativeDB running on a database reached through
+// database/sql
+type databaseSQL struct {
+	db sqlDB
+}
+
+func (d databaseSQL) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.db.ExecContext(ctx, query, args...)
+}
+
+func (d databaseSQL) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (d databaseSQL) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	return d.db.QueryRowContext(ctx, query, args...)
+}
+
+func (d databaseSQL) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := d.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{tx}, nil
+}
+
+// sqlTx is a Tx of a database reached through database/sql, whose *sql.Tx
+// migrations and hooks can be given
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (t sqlTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t sqlTx) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	rows, err := t.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (t sqlTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
+
+func (t sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// sqlTxer is implemented by the transactions that have a *sql.Tx
+type sqlTxer interface {
+	sqlTx() (*sql.Tx, bool)
+}
+
+func (t sqlTx) sqlTx() (*sql.Tx, bool) {
+	return t.tx, true
+}
+
+// databaseSQLTx returns the *sql.Tx of tx, for the migrations and hooks that
+// are given one, or SQLTxUnavailable if tx was begun on a NativeDB
+func databaseSQLTx(tx Tx) (*sql.Tx, error) {
+	if t, ok := tx.(sqlTxer); ok {
+		if stx, ok := t.sqlTx(); ok {
+			return stx, nil
+		}
+	}
+	return nil, SQLTxUnavailable
+}
+
+// unsupportedDB is a NativeDB for a DB that is neither reached through
+// database/sql nor a NativeDB, on which everything fails
+type unsupportedDB struct {
+	db DB
+}
+
+func (d unsupportedDB) err() error {
+	return fmt.Errorf("emigrate: Database %T is not supported, as it has neither the methods of *sql.DB nor those of NativeDB.", d.db)
+}
+
+func (d unsupportedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, d.err()
+}
+
+func (d unsupportedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return nil, d.err()
+}
+
+func (d unsupportedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	return errRow{d.err()}
+}
+
+func (d unsupportedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	return nil, d.err()
+}
+
+// errRow is a Row whose query failed with err
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+// conner is implemented by databases that can hand out a single connection,
+// such as *sql.DB
+type conner interface {
+	Conn(ctx context.Context) (*sql.Conn, error)
+}
+
+// sessioner is implemented by NativeDBs that can hand out a single session,
+// such as one for a pool of connections
+type sessioner interface {
+	Session(ctx context.Context) (NativeDB, func() error, error)
+}
+
+// session returns a single session of db, and a function to release it
+func session(ctx context.Context, db DB) (NativeDB, func() error, error) {
+	switch c := db.(type) {
+	case conner:
+		conn, err := c.Conn(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nativeDB(conn), conn.Close, nil
+	case sessioner:
+		return c.Session(ctx)
+	}
+	return nativeDB(db), func() error { return nil }, nil
+}
+
+// txDB is a DB running everything within a transaction held by the caller,
+// for UpgradeWithTx. Each transaction begun on it is a savepoint of the
+// transaction, released on commit and rolled back to on rollback.
+type txDB struct {
+	tx    Tx
+	depth int       // the number of savepoints open
+	m     *Migrator // whose queries create and end the savepoints
+}
+
+func (d *txDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if _, err := d.tx.ExecContext(ctx, d.m.query(d.m.queries().Savepoint, d.savepoint(d.depth+1))); err != nil {
+		return nil, err
+	}
+	d.depth++
+	return savepointTx{d}, nil
+}
+
+func (d *txDB) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return d.tx.QueryContext(ctx, query, args...)
+}
+
+func (d *txDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	return d.tx.QueryRowContext(ctx, query, args...)
+}
+
+func (d *txDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.tx.ExecContext(ctx, query, args...)
+}
+
+// savepoint returns the name of the savepoint at depth
+func (d *txDB) savepoint(depth int) string {
+	return fmt.Sprintf("emigrate_%d", depth)
+}
+
+// end releases the innermost savepoint, first rolling back to it if
+// rollback is set
+func (d *txDB) end(rollback bool) error {
+	if d.depth == 0 {
+		return sql.ErrTxDone
+	}
+	name := d.savepoint(d.depth)
+	d.depth--
+	ctx := context.Background()
+	if rollback {
+		if _, err := d.tx.ExecContext(ctx, d.m.query(d.m.queries().RollbackToSavepoint, name)); err != nil {
+			return err
+		}
+	}
+	_, err := d.tx.ExecContext(ctx, d.m.query(d.m.queries().ReleaseSavepoint, name))
+	return err
+}
+
+// savepointTx is a transaction begun on a txDB, which is the innermost
+// savepoint of the transaction of the caller
+type savepointTx struct {
+	*txDB
+}
+
+func (t savepointTx) Commit() error {
+	return t.end(false)
+}
+
+func (t savepointTx) Rollback() error {
+	return t.end(true)
+}
+
+func (t savepointTx) sqlTx() (*sql.Tx, bool) {
+	if s, ok := t.tx.(sqlTxer); ok {
+		return s.sqlTx()
+	}
+	return nil, false
+}
+
+// WithConn returns a copy of the Migrator that runs on conn, a connection
+// held by the caller, so that migrations see the settings of its session.
+// The tracking tables are also read and written on conn, unless a TrackingDB
+// is configured. As the dialect cannot be detected from a connection, the
+// copy uses the dialect of the Migrator. The connection is not closed by the
+// Migrator.
+func (m *Migrator) WithConn(conn *sql.Conn) *Migrator {
+	m.dialect()
+	c := *m
+	c.db = conn
+	return &c
+}
+
+// UpgradeWithTx applies all pending migrations within tx, a transaction held
+// by the caller, which is left for the caller to commit or roll back, such as
+// to undo the migrations at the end of a test. The tracking tables are read
+// and written within tx, even if a TrackingDB is configured. Each migration
+// runs in a savepoint of its own, which is rolled back if the migration
+// fails, leaving tx usable. Migrations that cannot run in a transaction
+// fail, and as the dialect cannot be detected from a transaction, it should
+// be configured. Databases without transactional DDL, such as MySQL, are
+// refused, as their schema changes would commit tx implicitly, along with
+// the savepoints of the migrations.
+func (m *Migrator) UpgradeWithTx(tx *sql.Tx) (*Result, error) {
+	if !m.dialect().TransactionalDDL() {
+		return &Result{}, fmt.Errorf("emigrate: Cannot upgrade within a transaction, as schema changes commit it on %T.", m.dialect())
+	}
+	c := *m
+	c.db = &txDB{tx: sqlTx{tx}, m: m}
+	c.TrackingDB = nil
+	return c.Upgrade()
+}
@@ -0,0 +1,345 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// countingDB is a DB counting the queries run on it, as an instrumented
+// driver would. It has no Conn method, so it is taken to be a single
+// session.
+type countingDB struct {
+	db      *sql.DB
+	queries []string
+}
+
+func (d *countingDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return d.db.BeginTx(ctx, opts)
+}
+
+func (d *countingDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	d.queries = append(d.queries, query)
+	return d.db.QueryContext(ctx, query, args...)
+}
+
+func (d *countingDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	d.queries = append(d.queries, query)
+	return d.db.QueryRowContext(ctx, query, args...)
+}
+
+func (d *countingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	d.queries = append(d.queries, query)
+	return d.db.ExecContext(ctx, query, args...)
+}
+
+// nativeMock is a NativeDB running on a mock database, whose transactions do
+// not give up their *sql.Tx, as those of a driver with an API of its own
+// cannot
+type nativeMock struct {
+	db *sql.DB
+}
+
+func (d nativeMock) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.db.ExecContext(ctx, query, args...)
+}
+
+func (d nativeMock) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return d.db.QueryContext(ctx, query, args...)
+}
+
+func (d nativeMock) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	return d.db.QueryRowContext(ctx, query, args...)
+}
+
+func (d nativeMock) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := d.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return nativeMockTx{tx}, nil
+}
+
+type nativeMockTx struct {
+	tx *sql.Tx
+}
+
+func (t nativeMockTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t nativeMockTx) QueryContext(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return t.tx.QueryContext(ctx, query, args...)
+}
+
+func (t nativeMockTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
+
+func (t nativeMockTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t nativeMockTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// Verify that a Migrator runs the SQL of migrations on a NativeDB, and that
+// migrations that must be given a *sql.Tx fail with SQLTxUnavailable.
+func TestMigratorNativeDB(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	called := false
+	m := NewMigrator(nativeMock{db}, []Migration{
+		NewStringMigration(1, TestQueryCreateInvoiceTable, ""),
+		NewFunctionMigration(2, func(tx *sql.Tx) error { called = true; return nil }, nil),
+	})
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).
+		WithArgs(1, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 1)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	result, err := m.Upgrade()
+	if !errors.Is(err, SQLTxUnavailable) {
+		t.Errorf("Expected SQLTxUnavailable, got %v", err)
+	}
+	if called {
+		t.Errorf("Expected the function migration not to be called")
+	}
+	if len(result.Migrations) != 2 || result.Migrations[0].Status != StatusApplied {
+		t.Errorf("Expected version 1 to be applied, got %#v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a DB that is neither reached through database/sql nor a
+// NativeDB is refused.
+func TestMigratorUnsupportedDB(t *testing.T) {
+	m := NewMigrator(struct{ DB }{}, migrationRange(1))
+	if _, err := m.Upgrade(); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected an unsupported database error, got %v", err)
+	}
+}
+
+// Verify that a Migrator runs on a DB other than *sql.DB, including
+// migrations run outside a transaction, which need a single session.
+func TestMigratorDB(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	cdb := &countingDB{db: db}
+	m := NewMigrator(cdb, []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "", func(o *migrationOptions) { o.noTransaction = true })})
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSetVersions(0, mock, 1)
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error upgrading: %s", err)
+	}
+	if len(cdb.queries) != 2 {
+		t.Errorf("Expected the version query and migration to run on the DB, got %q", cdb.queries)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that Resume verifies a dirty migration on the DB of the Migrator,
+// which need not be a *sql.DB.
+func TestResumeDB(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	cdb := &countingDB{db: db}
+	verifyErr := errors.New("column half added")
+	vm := &verifiedMigration{mockMigration: mockMigration{version: 1}, err: verifyErr}
+	m := Migrator{db: cdb, migrations: []Migration{vm}, Dialect: MySQLDialect{}}
+	expectDirtyQuery(mock, 1)
+
+	if _, err := m.Resume(false); err != verifyErr {
+		t.Errorf("Expected %v, got %v", verifyErr, err)
+	}
+	if vm.db != cdb {
+		t.Errorf("Expected the migration to be verified on the DB of the Migrator, got %T", vm.db)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that Import reads the tables of the other tool from the DB of the
+// Migrator, which need not be a *sql.DB.
+func TestImportDB(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	cdb := &countingDB{db: db}
+	m := NewMigrator(cdb, migrationRange(1, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	expectAppliedQuery(mock)
+	mock.ExpectQuery(testQueries.GetGolangMigrateVersion("schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+	expectImport(mock, 2, 1, 2)
+
+	if err := m.Import(GolangMigrateImporter{}); err != nil {
+		t.Errorf("Unexpected error during import: %s", err)
+	}
+	if len(cdb.queries) != 3 {
+		t.Errorf("Expected the tables to be read on the DB, got %q", cdb.queries)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that UpgradeWithTx runs each migration in a savepoint of the
+// transaction of the caller, rolling back to it when a migration fails, and
+// leaves the transaction to the caller.
+func TestUpgradeWithTx(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := NewMigrator(db, []Migration{
+		NewStringMigration(1, TestQueryCreateInvoiceTable, ""),
+		NewStringMigration(2, "ALTER TABLE invoice ADD total int", ""),
+	})
+	failure := errors.New("column total already exists")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec("SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectExec("RELEASE SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersionQuery(mock, 1)
+	mock.ExpectExec("ALTER TABLE invoice").WillReturnError(failure)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error beginning transaction: %s", err)
+	}
+	if _, err := m.UpgradeWithTx(tx); !errors.Is(err, failure) {
+		t.Errorf("Expected migration 2 to fail, got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Unexpected error committing: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration that cannot run in a transaction is refused by
+// UpgradeWithTx.
+func TestUpgradeWithTxNoTransaction(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := NewMigrator(db, []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "", func(o *migrationOptions) { o.noTransaction = true })})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec("SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT emigrate_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectInsertHistory(mock)
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error beginning transaction: %s", err)
+	}
+	if _, err := m.UpgradeWithTx(tx); err == nil {
+		t.Errorf("Expected the migration to be refused")
+	}
+	tx.Rollback()
+	mock.CloseTest(t)
+}
+
+// Verify that UpgradeWithTx refuses databases without transactional DDL,
+// whose schema changes would commit the transaction of the caller.
+func TestUpgradeWithTxNoTransactionalDDL(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := NewMigrator(db, []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")})
+	m.Dialect = MySQLDialect{}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error beginning transaction: %s", err)
+	}
+	if _, err := m.UpgradeWithTx(tx); err == nil || !strings.Contains(err.Error(), "schema changes commit it") {
+		t.Errorf("Expected the database to be refused, got %v", err)
+	}
+	tx.Rollback()
+	mock.CloseTest(t)
+}
+
+func TestWithConn(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error taking connection: %s", err)
+	}
+	defer conn.Close()
+	m := NewMigrator(db, nil).WithConn(conn)
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("3"))
+
+	if m.db != conn {
+		t.Errorf("Expected the Migrator to run on the connection")
+	}
+	if m.detected == nil {
+		t.Errorf("Expected the dialect to be detected from the database before switching to the connection")
+	}
+	if version, err := m.CurrentVersion(); err != nil || version != 3 {
+		t.Errorf("Expected version 3, got %d, %v", version, err)
+	}
+	mock.CloseTest(t)
+}
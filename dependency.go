@@ -0,0 +1,201 @@
+package emigrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dependent is implemented by migrations that depend on migrations other than
+// the one before them, so that migrations written independently, such as by
+// different teams, need not be numbered in the order they must run. An
+// upgrade runs each migration after those it depends on, and otherwise in
+// order of version, so a migration may be applied after a newer one, and is
+// then recorded as applied out of order. A downgrade reverts each migration
+// before those it depends on. Dependencies must not form a cycle.
+type Dependent interface {
+	DependsOn() []int64
+}
+
+// dependencies returns the versions a migration depends on, if any
+func dependencies(m Migration) []int64 {
+	if d, ok := m.(Dependent); ok {
+		return d.DependsOn()
+	}
+	return nil
+}
+
+// hasDependencies reports whether any of migrations has dependencies
+func hasDependencies(migrations []Migration) bool {
+	for _, migration := range migrations {
+		if len(dependencies(migration)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// DependencyError indicates that a migration depends on a migration that is
+// not available when it is run
+type DependencyError struct {
+	version    int64  // the version of the dependent migration
+	dependency int64  // the version it depends on
+	reason     string // why the dependency is not available
+}
+
+func (e DependencyError) Error() string {
+	return fmt.Sprintf("emigrate: Migration %d depends on migration %d, %s", e.version, e.dependency, e.reason)
+}
+
+// DependencyCycleError indicates that the dependencies of migrations form a
+// cycle, so they cannot be ordered
+type DependencyCycleError struct {
+	versions []int64 // the versions of the migrations in or after the cycle
+}
+
+func (e DependencyCycleError) Error() string {
+	versions := make([]string, len(e.versions))
+	for idx, version := range e.versions {
+		versions[idx] = fmt.Sprint(version)
+	}
+	return fmt.Sprintf("emigrate: Cycle in the dependencies of migrations %s", strings.Join(versions, ", "))
+}
+
+// orderByDependencies returns migrations, which must be sorted by version,
+// ordered so that each follows the migrations it depends on among them, and
+// otherwise in order of version. Dependencies on versions outside of
+// migrations are ignored.
+func orderByDependencies(migrations []Migration) ([]Migration, error) {
+	included := make(map[int64]bool, len(migrations))
+	for _, migration := range migrations {
+		included[migration.Version()] = true
+	}
+
+	ordered := make([]Migration, 0, len(migrations))
+	done := make(map[int64]bool, len(migrations))
+	for len(ordered) < len(migrations) {
+		// take the oldest migration whose dependencies are done
+		progress := false
+		for _, migration := range migrations {
+			if done[migration.Version()] || !dependenciesDone(migration, included, done) {
+				continue
+			}
+			ordered = append(ordered, migration)
+			done[migration.Version()] = true
+			progress = true
+			break
+		}
+		if !progress {
+			var cycle []int64
+			for _, migration := range migrations {
+				if !done[migration.Version()] {
+					cycle = append(cycle, migration.Version())
+				}
+			}
+			return nil, DependencyCycleError{cycle}
+		}
+	}
+	return ordered, nil
+}
+
+// dependenciesDone reports whether every dependency of a migration that is
+// included is done
+func dependenciesDone(m Migration, included, done map[int64]bool) bool {
+	for _, dependency := range dependencies(m) {
+		if included[dependency] && !done[dependency] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDependencies returns an error for each migration depending on a
+// version that is not loaded, and for a cycle in the dependencies
+func checkDependencies(migrations []Migration) []error {
+	if !hasDependencies(migrations) {
+		return nil
+	}
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Sort(byVersion(sorted))
+
+	var errs []error
+	for _, migration := range sorted {
+		for _, dependency := range dependencies(migration) {
+			if _, ok := byVersion(sorted).Search(dependency); !ok {
+				errs = append(errs, DependencyError{migration.Version(), dependency, "which is not loaded"})
+			}
+		}
+	}
+	if _, err := orderByDependencies(sorted); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// failedDependency returns a version that a migration depends on among the
+// failed migrations, if there is one
+func failedDependency(m Migration, failed []MigrationResult) (int64, bool) {
+	for _, dependency := range dependencies(m) {
+		for _, mr := range failed {
+			if mr.Version == dependency {
+				return dependency, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// planUpgrade orders the pending migrations, which must be sorted by
+// version, by their dependencies. Every dependency must be pending or no
+// newer than the current version.
+func planUpgrade(pending []Migration, current int64) ([]Migration, error) {
+	if !hasDependencies(pending) {
+		return pending, nil
+	}
+	for _, migration := range pending {
+		for _, dependency := range dependencies(migration) {
+			if _, ok := byVersion(pending).Search(dependency); !ok && dependency > current {
+				return nil, DependencyError{migration.Version(), dependency, "which is not to be applied"}
+			}
+		}
+	}
+	return orderByDependencies(pending)
+}
+
+// planDowngrade orders the migrations to be reverted, which must be sorted
+// newest first, so that each is reverted before the migrations it depends
+// on. No migration of migrations that remains applied may depend on one that
+// is reverted.
+func planDowngrade(plan, migrations []Migration, applied map[int64]bool) ([]Migration, error) {
+	if !hasDependencies(migrations) {
+		return plan, nil
+	}
+	reverted := make(map[int64]bool, len(plan))
+	for _, migration := range plan {
+		reverted[migration.Version()] = true
+	}
+	for _, migration := range migrations {
+		if !applied[migration.Version()] || reverted[migration.Version()] {
+			continue
+		}
+		for _, dependency := range dependencies(migration) {
+			if reverted[dependency] {
+				return nil, DependencyError{migration.Version(), dependency, "which would be reverted"}
+			}
+		}
+	}
+
+	ascending := make([]Migration, len(plan))
+	for idx, migration := range plan {
+		ascending[len(plan)-1-idx] = migration
+	}
+	ordered, err := orderByDependencies(ascending)
+	if err != nil {
+		return nil, err
+	}
+	for idx, migration := range ordered {
+		plan[len(ordered)-1-idx] = migration
+	}
+	return plan, nil
+}
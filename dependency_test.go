@@ -0,0 +1,141 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type dependentMigration struct {
+	downgradeMigration
+	dependsOn []int64 // the versions the migration depends on
+}
+
+func (dm *dependentMigration) DependsOn() []int64 {
+	return dm.dependsOn
+}
+
+// Returns a downgradable migration at version depending on other versions
+func dependsOn(version int64, versions ...int64) *dependentMigration {
+	return &dependentMigration{
+		downgradeMigration: downgradeMigration{mockMigration: mockMigration{version: version}},
+		dependsOn:          versions,
+	}
+}
+
+// Verify that a migration is applied after a newer migration it depends on,
+// out of order.
+func TestUpgradeDependencies(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{dependsOn(1), dependsOn(2, 3), dependsOn(3)}
+	expectSetVersions(0, mock, 1, 3)
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.CountAppliedVersion(testAppliedTable))).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).FromCSVString("0"))
+	expectInsertApplied(mock, 2)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Unexpected error during migration: %s", err)
+	}
+	if applied := result.Applied(); len(applied) != 3 || applied[0] != 1 || applied[1] != 3 || applied[2] != 2 {
+		t.Errorf("Expected [1 3 2] applied, got %v", applied)
+	}
+	mock.CloseTest(t)
+}
+
+func TestUpgradeDependencyCycle(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{dependsOn(1), dependsOn(2, 3), dependsOn(3, 2)}
+
+	_, err := m.Upgrade()
+	var cycle DependencyCycleError
+	if !errors.As(err, &cycle) || len(cycle.versions) != 2 {
+		t.Errorf("Expected a cycle between 2 and 3, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration is not applied without the migrations it depends
+// on.
+func TestUpgradeMissingDependency(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{dependsOn(1, 2), dependsOn(2)}
+
+	_, err := m.UpgradeToVersion(1)
+	var dep DependencyError
+	if !errors.As(err, &dep) || dep.version != 1 || dep.dependency != 2 {
+		t.Errorf("Expected a DependencyError for 1 on 2, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a downgrade reverts a migration before one it depends on, and
+// that the version only changes once the newest applied migration is
+// reverted.
+func TestDowngradeDependencies(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 3)
+	m.migrations = []Migration{dependsOn(1), dependsOn(2, 3), dependsOn(3)}
+	expectAppliedQuery(mock, 1, 2, 3)
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 3)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteAppliedVersion(testAppliedTable))).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	expectRevert(mock, 3, 3, 1)
+
+	result, err := m.DowngradeToVersion(1)
+	if err != nil {
+		t.Fatalf("Unexpected error during downgrade: %s", err)
+	}
+	if len(result.Migrations) != 2 || result.Migrations[0].Version != 2 || result.Migrations[1].Version != 3 {
+		t.Errorf("Expected 2 then 3 reverted, got %v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
+
+func TestDowngradeDependencyKept(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 3)
+	m.migrations = []Migration{dependsOn(1), dependsOn(2, 3), dependsOn(3)}
+	expectAppliedQuery(mock, 1, 2, 3)
+
+	if _, err := m.DowngradeToVersion(2); !errors.As(err, &DependencyError{}) {
+		t.Errorf("Expected a DependencyError, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestValidateDependencies(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{dependsOn(1, 4), dependsOn(2, 3), dependsOn(3, 2)}
+
+	var verr ValidationError
+	if err := m.Validate(); !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Errorf("Expected a missing dependency and a cycle, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestWithDependencies(t *testing.T) {
+	m := NewStringMigration(2, TestQueryInsertInvoices, "", WithDependencies(1))
+	if deps := dependencies(m); len(deps) != 1 || deps[0] != 1 {
+		t.Errorf("Expected a dependency on 1, got %v", deps)
+	}
+	if hasDependencies([]Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")}) {
+		t.Errorf("Expected a migration without dependencies")
+	}
+}
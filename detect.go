@@ -0,0 +1,52 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// dialecter is implemented by NativeDBs that know their dialect
+type dialecter interface {
+	Dialect() Dialect
+}
+
+// DetectDialect returns the Dialect for the engine behind db, recognized from
+// the package of its driver: pq and pgx for PostgreSQL, mysql for MySQL,
+// sqlite3 or sqlite for SQLite and spannerdriver for Cloud Spanner. As
+// CockroachDB and Redshift use the drivers of PostgreSQL, a PostgreSQL
+// database is told apart from them by querying its version. It returns nil
+// if the driver is not recognized.
+func DetectDialect(db *sql.DB) Dialect {
+	dialect := driverDialect(fmt.Sprintf("%T", db.Driver()))
+	if _, ok := dialect.(PostgresDialect); ok {
+		var version string
+		if err := db.QueryRow(`SELECT version()`).Scan(&version); err == nil && strings.Contains(version, "CockroachDB") {
+			return CockroachDialect{}
+		} else if err == nil && strings.Contains(version, "Redshift") {
+			return RedshiftDialect{}
+		}
+	}
+	return dialect
+}
+
+// driverDialect returns the Dialect for the package of the type of a driver,
+// such as *pq.Driver, or nil if it is not recognized
+func driverDialect(driverType string) Dialect {
+	pkg := strings.TrimPrefix(driverType, "*")
+	if idx := strings.Index(pkg, "."); idx >= 0 {
+		pkg = pkg[:idx]
+	}
+
+	switch pkg {
+	case "pq", "stdlib", "pgx":
+		return PostgresDialect{}
+	case "mysql":
+		return MySQLDialect{}
+	case "sqlite3", "sqlite":
+		return SQLiteDialect{}
+	case "spannerdriver":
+		return SpannerDialect{}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package emigrate
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDriverDialect(t *testing.T) {
+	tests := []struct {
+		driverType string
+		expected   Dialect
+	}{
+		{"*pq.Driver", PostgresDialect{}},
+		{"*stdlib.Driver", PostgresDialect{}},
+		{"*mysql.MySQLDriver", MySQLDialect{}},
+		{"*sqlite3.SQLiteDriver", SQLiteDialect{}},
+		{"*sqlite.Driver", SQLiteDialect{}},
+		{"*spannerdriver.Driver", SpannerDialect{}},
+		{"*sqlmock.mockDriver", nil},
+	}
+	for _, test := range tests {
+		if result := driverDialect(test.driverType); result != test.expected {
+			t.Errorf("%s: expected %T, got %T", test.driverType, test.expected, result)
+		}
+	}
+}
+
+// Verify that a Migrator without a Dialect whose driver is not recognized
+// follows the SQL standard, without probing the database.
+func TestDetectDialectUnknown(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db}
+
+	if dialect := DetectDialect(db); dialect != nil {
+		t.Errorf("Expected no dialect, got %T", dialect)
+	}
+	if _, ok := m.dialect().(ansiDialect); !ok {
+		t.Errorf("Expected the standard dialect, got %T", m.dialect())
+	}
+	if result, expected := m.query(m.queries().GetCurrentVersion, m.versionTable()), testQueries.GetCurrentVersion(testTable); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,313 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes how emigrate needs to adapt its own queries to a
+// particular database engine.
+type Dialect interface {
+	// QuoteIdentifier quotes a table or schema name for use in a query
+	QuoteIdentifier(name string) string
+
+	// IsMissingTable reports whether err was caused by querying a table that
+	// does not exist
+	IsMissingTable(err error) bool
+
+	// CurrentUser returns an SQL expression for the name of the database
+	// user, which is recorded in the migration history
+	CurrentUser() string
+
+	// TransactionalDDL reports whether schema changes are rolled back with
+	// the transaction they were made in. If not, a failed migration marks
+	// the database dirty.
+	TransactionalDDL() bool
+
+	// Placeholder returns the placeholder for the nth argument of a query,
+	// counting from 1, in the style of the driver, such as
+	// QuestionPlaceholder or DollarPlaceholder. Every query run by emigrate
+	// is written with ? placeholders, which are replaced with these, and
+	// its arguments are passed by position.
+	Placeholder(n int) string
+}
+
+// SchemaScoper is implemented by dialects that can set the schema in which the
+// unqualified names of a session are found, as needed by the TargetSchema of
+// a Migrator.
+type SchemaScoper interface {
+	// UseSchema returns the statement making the schema name that of the
+	// session
+	UseSchema(name string) string
+}
+
+// QuestionPlaceholder returns ? for every argument, as used by MySQL, SQLite
+// and ODBC drivers.
+func QuestionPlaceholder(n int) string {
+	return "?"
+}
+
+// DollarPlaceholder returns $1, $2 and so on, as used by PostgreSQL drivers.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// ColonPlaceholder returns :arg1, :arg2 and so on, as used by Oracle drivers,
+// which bind named placeholders by position.
+func ColonPlaceholder(n int) string {
+	return ":arg" + strconv.Itoa(n)
+}
+
+// AtPlaceholder returns @p1, @p2 and so on, as used by SQL Server drivers.
+func AtPlaceholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+// plainIdentifierRegexp matches identifiers that never need to be quoted, as
+// they are folded to the same name by every supported database, unless they
+// are reserved words.
+var plainIdentifierRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// reservedWords are the keywords reserved by the SQL standard or any
+// supported database, which must be quoted to be used as identifiers. Quoting
+// a word that is only reserved by another database is harmless.
+var reservedWords = wordSet(`
+	all alter analyse analyze and any array as asc asymmetric authorization
+	between binary both by call case cast check collate collation column
+	concurrently condition constraint create cross current_catalog
+	current_date current_role current_schema current_time
+	current_timestamp current_user database databases default deferrable
+	delete desc describe distinct do drop else end except exists explain
+	false fetch for force foreign freeze from full function glob grant
+	group having ignore ilike in index inner insert intersect interval
+	into is isnull join key keys lateral leading left like limit
+	localtime localtimestamp match natural not notnull null offset on
+	only or order outer over overlaps partition placing primary range
+	references regexp rename replace returning revoke right row rows
+	schema select session_user set similar some symmetric table then to
+	trailing transaction trigger true union unique update usage use user
+		using values variadic verbose view when where window with
+	assert_rows_modified at contains cube current define enum escape exclude
+	extract following grouping groups hash if lookup merge new no nulls of
+	preceding proto recursive respect rollup struct tablesample treat
+	unbounded unnest within`)
+
+// wordSet returns the set of the words in s, separated by white space
+func wordSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(s) {
+		set[word] = true
+	}
+	return set
+}
+
+// quoteIdentifier quotes name using quote, doubling any quotes within it,
+// unless name is a plain identifier that doesn't need quoting.
+func quoteIdentifier(name, quote string) string {
+	if plainIdentifierRegexp.MatchString(name) && !reservedWords[name] {
+		return name
+	}
+	return quote + strings.Replace(name, quote, quote+quote, -1) + quote
+}
+
+// sqlState returns the SQLSTATE code of err, if the driver provides one
+func sqlState(err error) string {
+	var s interface {
+		SQLState() string
+	}
+	if errors.As(err, &s) {
+		return s.SQLState()
+	}
+	return ""
+}
+
+// PostgresDialect is the Dialect for PostgreSQL. It is a TxLocker, taking a
+// transaction-level advisory lock for each migration, and a
+// TransactionChecker.
+type PostgresDialect struct{}
+
+func (PostgresDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, `"`)
+}
+
+func (PostgresDialect) CurrentUser() string {
+	return "CURRENT_USER"
+}
+
+func (PostgresDialect) Placeholder(n int) string {
+	return DollarPlaceholder(n)
+}
+
+func (PostgresDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (d PostgresDialect) UseSchema(name string) string {
+	return "SET search_path TO " + d.QuoteIdentifier(name)
+}
+
+func (PostgresDialect) IsMissingTable(err error) bool {
+	if state := sqlState(err); state != "" {
+		return state == "42P01" // undefined_table
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "relation") && strings.Contains(msg, "does not exist")
+}
+
+// MySQLDialect is the Dialect for MySQL and MariaDB. It is a SessionLocker,
+// serializing migrators with GET_LOCK, and a DDLRewriter.
+type MySQLDialect struct {
+	// OnlineDDL selects how the ALTER TABLE statements of migrations
+	// declared OnlineDDL are rewritten to alter large tables without
+	// locking them.
+	OnlineDDL OnlineDDLStrategy
+}
+
+func (MySQLDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, "`")
+}
+
+func (MySQLDialect) CurrentUser() string {
+	return "CURRENT_USER()"
+}
+
+func (MySQLDialect) Placeholder(n int) string {
+	return QuestionPlaceholder(n)
+}
+
+// TransactionalDDL returns false, as MySQL commits implicitly on most schema
+// changes
+func (MySQLDialect) TransactionalDDL() bool {
+	return false
+}
+
+// UseSchema returns a USE statement, as MySQL schemas are databases
+func (d MySQLDialect) UseSchema(name string) string {
+	return "USE " + d.QuoteIdentifier(name)
+}
+
+func (MySQLDialect) IsMissingTable(err error) bool {
+	// the MySQL driver doesn't provide the error number other than through
+	// its own error type, so check the message instead
+	return strings.Contains(err.Error(), "Error 1146")
+}
+
+// SQLiteDialect is the Dialect for SQLite, which allows a single writer at a
+// time. It is a TxLocker, writing to the version table at the start of each
+// migration so that the transaction takes the write lock at once, as BEGIN
+// IMMEDIATE would, and a concurrent migrator fails with "database is locked",
+// which IsTransientError recognizes, rather than deadlocking once it writes.
+// Drivers that cannot run several statements at once need SplitStatements
+// set on the Migrator.
+type SQLiteDialect struct {
+	// DeferForeignKeys defers the checking of foreign keys to the end of
+	// each migration, so that migrations may rebuild tables referenced by
+	// others, as schema changes in SQLite often require. PRAGMA foreign_keys
+	// cannot be changed within a transaction, so PRAGMA defer_foreign_keys is
+	// set instead, and the migration fails to commit if a foreign key is
+	// violated once it has run.
+	DeferForeignKeys bool
+}
+
+func (SQLiteDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, `"`)
+}
+
+// CurrentUser returns an empty string, as SQLite has no users
+func (SQLiteDialect) CurrentUser() string {
+	return "''"
+}
+
+func (SQLiteDialect) Placeholder(n int) string {
+	return QuestionPlaceholder(n)
+}
+
+func (SQLiteDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (SQLiteDialect) IsMissingTable(err error) bool {
+	return strings.Contains(err.Error(), "no such table")
+}
+
+// LockTx returns an update of the version table that changes nothing, which
+// takes the write lock of the database for the rest of the transaction
+func (SQLiteDialect) LockTx(key int64, table string) string {
+	return "UPDATE " + table + " SET version = version WHERE 1 = 0"
+}
+
+func (d SQLiteDialect) setupTx() []string {
+	if d.DeferForeignKeys {
+		return []string{"PRAGMA defer_foreign_keys = ON"}
+	}
+	return nil
+}
+
+// ansiDialect is used when no Dialect is configured, and follows the SQL
+// standard.
+type ansiDialect struct{}
+
+func (ansiDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, `"`)
+}
+
+func (ansiDialect) CurrentUser() string {
+	return "CURRENT_USER"
+}
+
+func (ansiDialect) Placeholder(n int) string {
+	return QuestionPlaceholder(n)
+}
+
+func (ansiDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (d ansiDialect) UseSchema(name string) string {
+	return "SET SCHEMA " + d.QuoteIdentifier(name)
+}
+
+// IsMissingTable recognizes the errors of any of the supported databases
+func (ansiDialect) IsMissingTable(err error) bool {
+	return PostgresDialect{}.IsMissingTable(err) ||
+		MySQLDialect{}.IsMissingTable(err) ||
+		SQLiteDialect{}.IsMissingTable(err)
+}
+
+// rebind replaces the ? placeholders of query with those of the dialect,
+// leaving any within string literals, quoted identifiers and comments
+// untouched
+func rebind(d Dialect, query string) string {
+	var b strings.Builder
+	n := 0
+	var end string // the end of the literal, identifier or comment we are in
+	for idx := 0; idx < len(query); idx++ {
+		c := query[idx]
+		switch {
+		case end != "":
+			if strings.HasPrefix(query[idx:], end) {
+				b.WriteString(end)
+				idx += len(end) - 1
+				end = ""
+				continue
+			}
+		case c == '\'' || c == '"' || c == '`':
+			end = string(c)
+		case strings.HasPrefix(query[idx:], "--"):
+			end = "\n"
+		case strings.HasPrefix(query[idx:], "/*"):
+			b.WriteString("/*")
+			idx++
+			end = "*/"
+			continue
+		case c == '?':
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
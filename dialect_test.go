@@ -0,0 +1,256 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		dialect  Dialect
+		name     string
+		expected string
+	}{
+		{PostgresDialect{}, "emigrate", `emigrate`},
+		{PostgresDialect{}, "Emigrate", `"Emigrate"`},
+		{PostgresDialect{}, `odd"name`, `"odd""name"`},
+		{MySQLDialect{}, "emigrate", "emigrate"},
+		{MySQLDialect{}, "migration-history", "`migration-history`"},
+		{MySQLDialect{}, "odd`name", "`odd``name`"},
+		{SQLiteDialect{}, "migration history", `"migration history"`},
+		{PostgresDialect{}, "order", `"order"`},
+		{MySQLDialect{}, "user", "`user`"},
+		{SQLiteDialect{}, "group", `"group"`},
+		{ansiDialect{}, "table", `"table"`},
+	}
+	for _, test := range tests {
+		if result := test.dialect.QuoteIdentifier(test.name); result != test.expected {
+			t.Errorf("%T: expected %s, got %s", test.dialect, test.expected, result)
+		}
+	}
+}
+
+func TestSchemaQualifiedTables(t *testing.T) {
+	m := Migrator{Schema: "ops", Table: "migration-history", Dialect: MySQLDialect{}}
+
+	if result, expected := m.versionTable(), "ops.`migration-history`"; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+	if result, expected := m.appliedTable(), "ops.`migration-history_applied`"; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+
+	m = Migrator{}
+	if result, expected := m.historyTable(), "emigrate_history"; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+
+	m = Migrator{TargetSchema: "user", Table: "order", Dialect: PostgresDialect{}}
+	if result, expected := m.versionTable(), `"user"."order"`; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "pq: error " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsMissingTable(t *testing.T) {
+	tests := []struct {
+		dialect  Dialect
+		err      error
+		expected bool
+	}{
+		{PostgresDialect{}, sqlStateError("42P01"), true},
+		{PostgresDialect{}, sqlStateError("42501"), false},
+		{PostgresDialect{}, errors.New(`pq: relation "emigrate" does not exist`), true},
+		{MySQLDialect{}, errors.New("Error 1146: Table 'app.emigrate' doesn't exist"), true},
+		{MySQLDialect{}, errors.New("Error 1045: Access denied"), false},
+		{SQLiteDialect{}, errors.New("no such table: emigrate"), true},
+		{ansiDialect{}, errors.New("no such table: emigrate"), true},
+		{ansiDialect{}, errors.New("connection refused"), false},
+	}
+	for _, test := range tests {
+		if result := test.dialect.IsMissingTable(test.err); result != test.expected {
+			t.Errorf("%T %q: expected %v, got %v", test.dialect, test.err, test.expected, result)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `UPDATE emigrate_history SET name = ? WHERE version = ? AND direction = 'up?'`
+	tests := []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{PostgresDialect{}, `UPDATE emigrate_history SET name = $1 WHERE version = $2 AND direction = 'up?'`},
+		{MySQLDialect{}, query},
+		{SQLiteDialect{}, query},
+		{ansiDialect{}, query},
+	}
+	for _, test := range tests {
+		if result := rebind(test.dialect, query); result != test.expected {
+			t.Errorf("%T: expected %s, got %s", test.dialect, test.expected, result)
+		}
+	}
+}
+
+// placeholderDialect is a Dialect with the placeholders of another driver
+type placeholderDialect struct {
+	ansiDialect
+	placeholder func(n int) string
+}
+
+func (d placeholderDialect) Placeholder(n int) string {
+	return d.placeholder(n)
+}
+
+func TestRebindPlaceholders(t *testing.T) {
+	query := "UPDATE \"what?\" SET name = ? /* why? */ WHERE version = ? -- how?\nAND `who?` = 'it''s?' AND id = ?"
+	tests := []struct {
+		placeholder func(n int) string
+		expected    string
+	}{
+		{QuestionPlaceholder, query},
+		{DollarPlaceholder, "UPDATE \"what?\" SET name = $1 /* why? */ WHERE version = $2 -- how?\nAND `who?` = 'it''s?' AND id = $3"},
+		{ColonPlaceholder, "UPDATE \"what?\" SET name = :arg1 /* why? */ WHERE version = :arg2 -- how?\nAND `who?` = 'it''s?' AND id = :arg3"},
+		{AtPlaceholder, "UPDATE \"what?\" SET name = @p1 /* why? */ WHERE version = @p2 -- how?\nAND `who?` = 'it''s?' AND id = @p3"},
+	}
+	for _, test := range tests {
+		if result := rebind(placeholderDialect{placeholder: test.placeholder}, query); result != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, result)
+		}
+	}
+}
+
+// Verify that the tracking queries of a migration are run with the
+// placeholders of the dialect.
+func TestDialectPlaceholders(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, Dialect: placeholderDialect{placeholder: AtPlaceholder}}
+	m.migrations = []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE emigrate SET version = @p1 WHERE version = @p2")).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO " + testAppliedTable + " (version) VALUES (@p1)")).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO "+testHistoryTable) + ".*@p15\\)$").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error upgrading: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestUseSchema(t *testing.T) {
+	tests := []struct {
+		dialect  SchemaScoper
+		expected string
+	}{
+		{PostgresDialect{}, `SET search_path TO "Tenant-1"`},
+		{MySQLDialect{}, "USE `Tenant-1`"},
+		{ansiDialect{}, `SET SCHEMA "Tenant-1"`},
+	}
+	for _, test := range tests {
+		if result := test.dialect.UseSchema("Tenant-1"); result != test.expected {
+			t.Errorf("%T: expected %s, got %s", test.dialect, test.expected, result)
+		}
+	}
+}
+
+// Verify that every migration transaction uses the TargetSchema, which also
+// holds the tracking tables.
+func TestTargetSchema(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), TargetSchema: "tenant_1"}
+	table := "tenant_1." + testTable
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(table))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SET SCHEMA tenant_1")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.LockCurrentVersion(table))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(table))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertAppliedVersion("tenant_1." + testAppliedTable))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tenant_1." + testHistoryTable)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a TargetSchema is rejected for dialects that cannot use it.
+func TestTargetSchemaUnsupported(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), Dialect: SQLiteDialect{}, TargetSchema: "tenant_1"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("tenant_1." + testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE tenant_1.emigrate SET version = version WHERE 1 = 0")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := m.UpgradeToVersion(1); err == nil {
+		t.Errorf("Expected an error for a dialect without SchemaScoper")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that SQLite takes the write lock at the start of each migration and
+// defers foreign keys if configured.
+func TestSQLiteTxSetup(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), Dialect: SQLiteDialect{DeferForeignKeys: true}}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE emigrate SET version = version WHERE 1 = 0")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("PRAGMA defer_foreign_keys = ON")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,181 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TableSchema describes a table of a database, as compared by DiffSchemas
+type TableSchema struct {
+	Name    string
+	Columns []Column
+}
+
+// InspectFunc describes the tables of a database
+type InspectFunc func(db *sql.DB) ([]TableSchema, error)
+
+// InspectInformationSchema is an InspectFunc that reads the tables and
+// columns of a database, with their type, nullability and default, from the
+// standard information_schema, which is available in PostgreSQL and MySQL
+// among others. Primary keys and indexes are not described, and types are
+// as information_schema names them, such as "character varying" without its
+// length, so migrations generated from it need review.
+func InspectInformationSchema(db *sql.DB) ([]TableSchema, error) {
+	rows, err := db.Query(`SELECT table_name, column_name, data_type, is_nullable, column_default FROM information_schema.columns ` +
+		`WHERE table_schema NOT IN ('information_schema', 'pg_catalog', 'mysql', 'performance_schema', 'sys') ` +
+		`ORDER BY table_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableSchema
+	for rows.Next() {
+		var table, nullable string
+		var c Column
+		var def sql.NullString
+		if err := rows.Scan(&table, &c.Name, &c.Type, &nullable, &def); err != nil {
+			return nil, err
+		}
+		c.NotNull = nullable == "NO"
+		c.Default = def.String
+		if len(tables) == 0 || tables[len(tables)-1].Name != table {
+			tables = append(tables, TableSchema{Name: table})
+		}
+		tables[len(tables)-1].Columns = append(tables[len(tables)-1].Columns, c)
+	}
+	return tables, rows.Err()
+}
+
+// DiffSchemas returns a Schema changing the tables of current into those of
+// target, generating SQL for dialect. Tables and columns only in target are
+// created, those only in current are dropped, and columns whose type,
+// nullability or default differ are changed, so renames appear as a drop and
+// a create. The tracking tables of emigrate with their default names, which
+// start with DefaultTable, are left out.
+func DiffSchemas(current, target []TableSchema, dialect Dialect) *Schema {
+	s := NewSchema(dialect)
+	currentTables := tablesByName(current)
+	targetTables := tablesByName(target)
+
+	for _, table := range sortedTableNames(targetTables) {
+		t := targetTables[table]
+		c, ok := currentTables[table]
+		if !ok {
+			s.CreateTable(table, t.Columns...)
+			continue
+		}
+		diffColumns(s, table, c.Columns, t.Columns)
+	}
+	for _, table := range sortedTableNames(currentTables) {
+		if _, ok := targetTables[table]; !ok {
+			s.DropTable(table, currentTables[table].Columns...)
+		}
+	}
+	return s
+}
+
+// diffColumns adds to s the changes making the columns of current those of
+// target
+func diffColumns(s *Schema, table string, current, target []Column) {
+	currentColumns := make(map[string]Column, len(current))
+	for _, c := range current {
+		currentColumns[c.Name] = c
+	}
+	targetColumns := make(map[string]bool, len(target))
+	for _, t := range target {
+		targetColumns[t.Name] = true
+		c, ok := currentColumns[t.Name]
+		if !ok {
+			s.AddColumn(table, t)
+		} else if c.Type != t.Type || c.NotNull != t.NotNull || c.Default != t.Default {
+			s.ChangeColumn(table, c, t)
+		}
+	}
+	for _, c := range current {
+		if !targetColumns[c.Name] {
+			s.DropColumn(table, c)
+		}
+	}
+}
+
+// tablesByName indexes tables by name, leaving out the tracking tables
+func tablesByName(tables []TableSchema) map[string]TableSchema {
+	byName := make(map[string]TableSchema, len(tables))
+	for _, t := range tables {
+		if t.Name == DefaultTable || strings.HasPrefix(t.Name, DefaultTable+"_") {
+			continue
+		}
+		byName[t.Name] = t
+	}
+	return byName
+}
+
+// sortedTableNames returns the names of tables in order
+func sortedTableNames(tables map[string]TableSchema) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DiffDatabases returns a Schema changing the schema of the database current
+// into that of target, as described by inspect, or by
+// InspectInformationSchema if inspect is nil. The Schema is a candidate for
+// a migration, to be reviewed before it is written with WriteSchemaMigration.
+func DiffDatabases(current, target *sql.DB, dialect Dialect, inspect InspectFunc) (*Schema, error) {
+	if inspect == nil {
+		inspect = InspectInformationSchema
+	}
+	currentTables, err := inspect(current)
+	if err != nil {
+		return nil, err
+	}
+	targetTables, err := inspect(target)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSchemas(currentTables, targetTables, dialect), nil
+}
+
+// DiffDDL returns a Schema changing the schema of the database current into
+// that created by the DDL script ddl, such as a schema dump, which is run on
+// the empty database scratch to be compared as by DiffDatabases
+func DiffDDL(current, scratch *sql.DB, ddl string, dialect Dialect, inspect InspectFunc) (*Schema, error) {
+	if _, err := scratch.Exec(ddl); err != nil {
+		return nil, fmt.Errorf("emigrate: Failed to run the DDL: %s", err)
+	}
+	return DiffDatabases(current, scratch, dialect, inspect)
+}
+
+// WriteSchemaMigration writes the upgrade and downgrade SQL of s to files in
+// dir for a new migration described by slug and versioned by the current
+// time, as CreateMigration does, returning the paths of the files. It fails
+// rather than overwrite existing files.
+func WriteSchemaMigration(dir, slug string, s *Schema) (up, down string, err error) {
+	return writeSchemaMigration(dir, slug, s, time.Now())
+}
+
+func writeSchemaMigration(dir, slug string, s *Schema, t time.Time) (up, down string, err error) {
+	if !slugRegexp.MatchString(slug) {
+		return "", "", fmt.Errorf("emigrate: Invalid migration name %q.", slug)
+	}
+
+	prefix := filepath.Join(dir, fmt.Sprintf("%d_%s", TimestampVersion(t), slug))
+	up, down = prefix+"_up.sql", prefix+"_down.sql"
+	if err := writeNewFile(up, s.Up()); err != nil {
+		return "", "", err
+	}
+	if err := writeNewFile(down, s.Down()); err != nil {
+		os.Remove(up)
+		return "", "", err
+	}
+	return up, down, nil
+}
@@ -0,0 +1,91 @@
+package emigrate
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	id := Column{Name: "id", Type: "bigint", NotNull: true}
+	current := []TableSchema{
+		{DefaultTable, []Column{{Name: "version", Type: "bigint"}}},
+		{"invoice", []Column{id, {Name: "total", Type: "integer"}, {Name: "notes", Type: "text"}}},
+		{"legacy", []Column{id}},
+	}
+	target := []TableSchema{
+		{"customer", []Column{id}},
+		{"invoice", []Column{id, {Name: "total", Type: "numeric", NotNull: true}, {Name: "customer_id", Type: "bigint"}}},
+	}
+
+	s := DiffSchemas(current, target, PostgresDialect{})
+	up := "CREATE TABLE customer (\n\tid bigint NOT NULL\n);\n\n" +
+		"ALTER TABLE invoice ALTER COLUMN total TYPE numeric;\nALTER TABLE invoice ALTER COLUMN total SET NOT NULL;\n\n" +
+		"ALTER TABLE invoice ADD COLUMN customer_id bigint;\n\n" +
+		"ALTER TABLE invoice DROP COLUMN notes;\n\n" +
+		"DROP TABLE legacy;\n\n"
+	if s.Up() != up {
+		t.Errorf("Expected %q, got %q", up, s.Up())
+	}
+	down := "CREATE TABLE legacy (\n\tid bigint NOT NULL\n);\n\n" +
+		"ALTER TABLE invoice ADD COLUMN notes text;\n\n" +
+		"ALTER TABLE invoice DROP COLUMN customer_id;\n\n" +
+		"ALTER TABLE invoice ALTER COLUMN total TYPE integer;\nALTER TABLE invoice ALTER COLUMN total DROP NOT NULL;\n\n" +
+		"DROP TABLE customer;\n\n"
+	if s.Down() != down {
+		t.Errorf("Expected %q, got %q", down, s.Down())
+	}
+}
+
+// Verify that a DDL script is run on the scratch database and compared with
+// the current one.
+func TestDiffDDL(t *testing.T) {
+	currentMock, current, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	scratchMock, scratch, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	columns := []string{"table_name", "column_name", "data_type", "is_nullable", "column_default"}
+	scratchMock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	currentMock.ExpectQuery("information_schema.columns").
+		WillReturnRows(sqlmock.NewRows(columns))
+	scratchMock.ExpectQuery("information_schema.columns").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("invoice", "id", "integer", "NO", nil))
+
+	s, err := DiffDDL(current, scratch, TestQueryCreateInvoiceTable, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "DROP TABLE invoice;\n\n"; s.Down() != expected {
+		t.Errorf("Expected %q, got %q", expected, s.Down())
+	}
+	currentMock.CloseTest(t)
+	scratchMock.CloseTest(t)
+}
+
+func TestWriteSchemaMigration(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 8, 15, 12, 30, 45, 0, time.UTC)
+	s := NewSchema(nil).AddColumn("invoice", Column{Name: "total", Type: "INTEGER"})
+
+	up, down, err := writeSchemaMigration(dir, "add_total", s, at)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	} else if up != filepath.Join(dir, "20240815123045_add_total_up.sql") {
+		t.Errorf("Unexpected path %s", up)
+	}
+	if contents, _ := os.ReadFile(down); string(contents) != s.Down() {
+		t.Errorf("Expected %q, got %q", s.Down(), contents)
+	}
+	if _, _, err := writeSchemaMigration(dir, "add_total", s, at); err == nil {
+		t.Errorf("Expected an error overwriting the migration")
+	}
+}
@@ -1,26 +1,244 @@
 package emigrate
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"text/template"
 )
 
-// DirMigrations returns a slice of migrations that can run against the files
-// found in dir. An error is returned if the files cannot be read or if the
-// files are erroneously named (such as no "up" migration existing or an
+// MigrationsFromDir returns a slice of migrations that can run against the
+// files found in dir. An error is returned if the files cannot be read or if
+// the files are erroneously named (such as no "up" migration existing or an
 // unknown file extension).
-func MigrationsFromDir(dir string) ([]Migration, error) {
+func MigrationsFromDir(dir string, opts ...DirOption) ([]Migration, error) {
+	return fileMigrations(DirSource(dir, opts...).(*fileSource))
+}
+
+// FSMigrations returns a slice of migrations that can run against the files
+// found in the root directory of fsys, such as an embed.FS, so that
+// migrations can be built into the binary. Errors are returned as for
+// MigrationsFromDir.
+func FSMigrations(fsys fs.FS, root string, opts ...DirOption) ([]Migration, error) {
+	return fileMigrations(FSSource(fsys, root, opts...).(*fileSource))
+}
+
+// fileMigrations returns the migrations of s selected by its filters, sorted
+// by version
+func fileMigrations(s *fileSource) ([]Migration, error) {
+	versions, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		if repeatable, err := s.declaredRepeatable(version); err != nil {
+			return nil, err
+		} else if repeatable {
+			continue
+		}
+		m, err := s.migration(version)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	sort.Sort(byVersion(ms))
+
+	repeatables, err := s.repeatableMigrations()
+	if err != nil {
+		return nil, err
+	}
+	ms = append(ms, repeatables...)
+
+	if len(s.filters) == 0 {
+		return ms, nil
+	}
+	return FilterMigrations(ms, s.filters...), nil
+}
+
+// migration returns the migration for a listed version of s, which runs an
+// executable if its files are executables, or reads its files when it is run
+// if s is lazy
+func (s *fileSource) migration(version int64) (Migration, error) {
+	if s.files[version]["up"].exec {
+		return s.execMigration(version)
+	}
+	if s.lazy {
+		return s.lazyMigration(version), nil
+	}
+
+	up, err := s.Read(version, "up")
+	if err != nil {
+		return nil, err
+	}
+	down, err := s.Read(version, "down")
+	if err != nil {
+		return nil, err
+	}
+	m := stringMigration{version: version, up: up, down: down}
+	m.name = s.Name(version)
+	if err := s.parseHeader(version, &m.migrationOptions); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseHeader sets the options declared by the header of the upgrade file of
+// version
+func (s *fileSource) parseHeader(version int64, o *migrationOptions) error {
+	header, err := s.readHeader(version)
+	if err != nil {
+		return err
+	}
+	return o.parseHeader(s.files[version]["up"].path(), header)
+}
+
+// declaredRepeatable reports whether the header of the upgrade file of
+// version declares its migration repeatable, in which case the file is moved
+// to the repeatable migrations of s, named by its slug. Headers are not read
+// when s is lazy, so lazy migrations cannot be declared repeatable.
+func (s *fileSource) declaredRepeatable(version int64) (bool, error) {
+	info := s.files[version]["up"]
+	if info.exec || s.lazy {
+		return false, nil
+	}
+	var o migrationOptions
+	if err := s.parseHeader(version, &o); err != nil || !o.repeatable {
+		return false, err
+	}
+
+	if info.slug == "" {
+		return false, fmt.Errorf("emigrate: Repeatable migration %q has no name.", info.path())
+	} else if down := s.files[version]["down"]; down != nil && down != info {
+		return false, fmt.Errorf("emigrate: Repeatable migration %q cannot be downgraded by %q.", info.path(), down.path())
+	} else if first := s.repeatables[info.slug]; first != nil {
+		return false, DuplicateRepeatableError{info.slug, first.path(), info.path()}
+	}
+	s.repeatables[info.slug] = info
+	return true, nil
+}
+
+// DirSource returns a MigrationSource that reads migrations from the files
+// in dir, named as for MigrationsFromDir.
+func DirSource(dir string, opts ...DirOption) MigrationSource {
+	s := FSSource(os.DirFS(dir), ".", opts...).(*fileSource)
+	s.osDir = dir
+	return s
+}
+
+// FSSource returns a MigrationSource that reads migrations from the files in
+// the root directory of fsys, named as for MigrationsFromDir.
+func FSSource(fsys fs.FS, root string, opts ...DirOption) MigrationSource {
 	mf := migrationFinder{
-		readDir:  ioutil.ReadDir,
-		readFile: ioutil.ReadFile,
+		readDir: func(dir string) ([]os.FileInfo, error) {
+			entries, err := fs.ReadDir(fsys, dir)
+			if err != nil {
+				return nil, err
+			}
+			infos := make([]os.FileInfo, 0, len(entries))
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					return nil, err
+				}
+				infos = append(infos, info)
+			}
+			return infos, nil
+		},
+		readFile: func(name string) ([]byte, error) {
+			return fs.ReadFile(fsys, name)
+		},
+	}
+	return mf.source(root, opts...)
+}
+
+// dirOptions holds the optional settings of the sources that read migrations
+// from files
+type dirOptions struct {
+	recursive  bool                        // whether subdirectories are scanned
+	pattern    *regexp.Regexp              // the file name pattern, nameRegexp if nil
+	lazy       bool                        // whether files are read when run
+	vars       map[string]interface{}      // the data for templates, if rendered
+	filters    []Filter                    // select the migrations to load
+	manifest   string                      // the manifest file, if any
+	exec       bool                        // whether executables are migrations
+	execEnv    []string                    // added to the environment of executables
+	extensions map[string]ExtensionHandler // extensions other than sql, by lower case
+}
+
+// ExtensionHandler converts the contents of a migration file into the SQL to
+// run, such as by expanding the meta-commands of a psql script.
+type ExtensionHandler func(contents string) (string, error)
+
+// extension reports whether files with the extension ext are migrations, and
+// the handler for their contents, which is nil if they are plain SQL. Files
+// recognized by a pattern without an "ext" group have no extension.
+func (o dirOptions) extension(ext string) (ExtensionHandler, bool) {
+	ext = strings.ToLower(ext)
+	if ext == "" || ext == "sql" || o.exec && execExtensions[ext] {
+		return nil, true
+	}
+	handler, ok := o.extensions[ext]
+	return handler, ok
+}
+
+// WithPattern recognizes migration files by a custom file name pattern, such
+// as that of another migration tool, rather than the default pattern. The
+// pattern must have a "version" group matching the version number, and may
+// have "way" (matching "up" or "down"), "name" and "ext" groups. Files whose
+// pattern has no "way" hold both directions, split by directives.
+func WithPattern(pattern *regexp.Regexp) DirOption {
+	return func(o *dirOptions) {
+		o.pattern = pattern
+	}
+}
+
+// WithExtension recognizes files with the extension ext, such as "psql",
+// "pgsql" or "ddl", as migrations, in any case. Their contents are converted
+// by handler or, if it is nil, run as they are.
+func WithExtension(ext string, handler ExtensionHandler) DirOption {
+	return func(o *dirOptions) {
+		if o.extensions == nil {
+			o.extensions = make(map[string]ExtensionHandler)
+		}
+		o.extensions[strings.ToLower(strings.TrimPrefix(ext, "."))] = handler
+	}
+}
+
+// WithTemplate renders migration files as text/template templates with vars
+// as their data, such as {{.Schema}} or {{.Tablespace}}, so that one set of
+// migrations can target slightly different environments. Files are rendered
+// when they are read, which is when they are run if Lazy. Using a variable
+// that is not in vars is an error.
+func WithTemplate(vars map[string]interface{}) DirOption {
+	return func(o *dirOptions) {
+		o.vars = vars
+	}
+}
+
+// DirOption configures an optional setting of MigrationsFromDir, FSMigrations,
+// ArchiveMigrations, DirSource or FSSource
+type DirOption func(*dirOptions)
+
+// Recursive scans subdirectories for migration files too, such as
+// migrations/2023/001_up.sql. Versions must still be unique across the whole
+// tree, and migrations are ordered by version regardless of the directory
+// they are in.
+func Recursive() DirOption {
+	return func(o *dirOptions) {
+		o.recursive = true
 	}
-	return mf.getMigrations(dir)
 }
 
 type migrationFinder struct {
@@ -29,67 +247,281 @@ type migrationFinder struct {
 }
 
 // Used to enable testing, we can mock the ReadDir function and supply
-func (mf migrationFinder) getMigrations(dir string) ([]Migration, error) {
-	nameInfos, err := mf.groupByVersion(dir)
+func (mf migrationFinder) getMigrations(dir string, opts ...DirOption) ([]Migration, error) {
+	return fileMigrations(mf.source(dir, opts...))
+}
+
+// source returns a MigrationSource for the files in dir
+func (mf migrationFinder) source(dir string, opts ...DirOption) *fileSource {
+	s := &fileSource{finder: mf, dir: dir}
+	for _, opt := range opts {
+		opt(&s.dirOptions)
+	}
+	return s
+}
+
+// fileSource is a MigrationSource that reads each migration from files named
+// after its version and direction. The files are found when the versions are
+// listed.
+type fileSource struct {
+	finder migrationFinder
+	dir    string
+	files  map[int64]map[string]*nameInfo // by version and direction
+	osDir  string                         // the directory on disk, if any
+
+	// the files of repeatable migrations by name, which are not listed
+	repeatables map[string]*nameInfo
+	dirOptions
+}
+
+func (s *fileSource) List() ([]int64, error) {
+	nameInfos := make(map[int64][]*nameInfo)
+	s.repeatables = make(map[string]*nameInfo)
+	err := s.finder.groupByVersion(nameInfos, s.repeatables, s.dir, s.dirOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	// build a new Migration for each version
-	ms := make([]Migration, 0, len(nameInfos))
-	for _, names := range nameInfos {
-		m, err := mf.getFileMigration(names)
+	s.files = make(map[int64]map[string]*nameInfo, len(nameInfos))
+	versions := make([]int64, 0, len(nameInfos))
+	for version, names := range nameInfos {
+		files, err := checkFileMigration(names)
 		if err != nil {
 			return nil, err
 		}
-		ms = append(ms, m)
+		s.files[version] = files
+		versions = append(versions, version)
 	}
+	if s.manifest != "" {
+		if err := s.checkManifest(); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
 
-	// sort the migrations
-	sort.Sort(byVersion(ms))
-	return ms, nil
+// Location returns the path of the upgrade file of version
+func (s *fileSource) Location(version int64) string {
+	if info := s.files[version]["up"]; info != nil {
+		return info.path()
+	}
+	return ""
+}
+
+// Name returns the slug of the files of version
+func (s *fileSource) Name(version int64) string {
+	if info := s.files[version]["up"]; info != nil {
+		return info.slug
+	}
+	return ""
+}
+
+func (s *fileSource) Read(version int64, direction string) (string, error) {
+	info := s.files[version][direction]
+	if info == nil {
+		return "", nil
+	}
+	if info.exec {
+		return "", fmt.Errorf("emigrate: Executable migration %q has no SQL, so must be loaded by MigrationsFromDir.", info.path())
+	}
+	contents, err := s.contents(info)
+	if err != nil {
+		return "", err
+	}
+	if info.way == "" {
+		up, down, err := splitDirectives(info.name, contents)
+		if direction == "down" {
+			return down, err
+		}
+		return up, err
+	}
+	return contents, nil
 }
 
-// nameRegexp defines the file name pattern to recognize migration files
-var nameRegexp = regexp.MustCompile(`^(\d+)[-_](up|down)\.([Ss][Qq][Ll])$`)
+// contents returns the contents of a file, decompressed, decoded as text,
+// converted by the handler of its extension and rendered as a template as
+// configured
+func (s *fileSource) contents(info *nameInfo) (string, error) {
+	bytes, err := s.finder.readFile(info.path())
+	if err != nil {
+		return "", err
+	}
+	if info.gzip {
+		bytes, err = gunzip(info.name, bytes)
+		if err != nil {
+			return "", err
+		}
+	}
+	contents, err := decodeText(info.path(), bytes)
+	if err != nil {
+		return "", err
+	}
+	if handler, _ := s.extension(info.ext); handler != nil {
+		contents, err = handler(contents)
+		if err != nil {
+			return "", err
+		}
+	}
+	if s.vars != nil {
+		contents, err = renderTemplate(info.name, contents, s.vars)
+		if err != nil {
+			return "", err
+		}
+	}
+	return contents, nil
+}
 
-// nameInfo defines the information captured from parsing a file according to nameRegexp
+// gunzip decompresses the contents of the gzip compressed file name
+func gunzip(name string, compressed []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("emigrate: Cannot decompress %q: %w", name, err)
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("emigrate: Cannot decompress %q: %w", name, err)
+	}
+	return contents, nil
+}
+
+// renderTemplate renders the contents of the file name as a template with the
+// data vars
+func renderTemplate(name, contents string, vars map[string]interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(contents)
+	if err != nil {
+		return "", fmt.Errorf("emigrate: Invalid template in %q: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("emigrate: Cannot render template in %q: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// directiveRegexp matches the lines that start the sections of a single-file
+// migration, "-- +emigrate Up" and "-- +emigrate Down"
+var directiveRegexp = regexp.MustCompile(`^--\s*\+emigrate\s+(Up|Down)\s*$`)
+
+// splitDirectives splits the contents of a single-file migration into its up
+// and down sections. Only comments may come before the first section, and the
+// up section is required.
+func splitDirectives(name, contents string) (up, down string, err error) {
+	var sections = make(map[string]*strings.Builder)
+	var section *strings.Builder
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		if match := directiveRegexp.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			way := strings.ToLower(match[1])
+			if sections[way] != nil {
+				return "", "", fmt.Errorf("emigrate: Duplicate \"-- +emigrate %s\" directive in %q.", match[1], name)
+			}
+			section = new(strings.Builder)
+			sections[way] = section
+			continue
+		}
+		if section == nil {
+			trimmed := strings.TrimSpace(line)
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return "", "", fmt.Errorf("emigrate: Statements before the first directive in %q.", name)
+			}
+			continue
+		}
+		section.WriteString(line)
+	}
+
+	if sections["up"] == nil {
+		return "", "", fmt.Errorf("emigrate: Missing \"-- +emigrate Up\" directive in %q.", name)
+	}
+	up = strings.TrimSpace(sections["up"].String())
+	if sections["down"] != nil {
+		down = strings.TrimSpace(sections["down"].String())
+	}
+	return up, down, nil
+}
+
+// nameRegexp defines the file name pattern to recognize migration files, which
+// may describe the migration with a slug, as in 001_create_users.up.sql or
+// 001_create_users_up.sql. Files without a direction, as in
+// 001_create_users.sql, hold both directions, split by directives. Files
+// with an extension other than sql or one added by WithExtension are ignored.
+var nameRegexp = regexp.MustCompile(`^(?P<version>\d+)(?:[-_](?P<name>[A-Za-z0-9_-]+?))??(?:[-_.](?P<way>up|down))?\.(?P<ext>[A-Za-z0-9]+)$`)
+
+// nameInfo defines the information captured from parsing a file according to
+// the file name pattern
 type nameInfo struct {
 	dir     string // file path
 	name    string // file name
 	version int64  // migration version
-	way     string // "up" or "down"
+	slug    string // describes the migration, may be empty
+	way     string // "up", "down" or "" for both
 	ext     string // file extension
+	gzip    bool   // whether the file is gzip compressed
+	exec    bool   // whether the file is an executable to run
+}
+
+// path returns the path of the file
+func (info *nameInfo) path() string {
+	// fs.FS paths are always separated by slashes
+	return path.Join(info.dir, info.name)
 }
 
-// readDir collects and groups nameInfo by version, so that we can
-// use this to detect inconsistencies in naming and having the same
-// migration be used for both upgrading and downgrading.
-func (mf migrationFinder) groupByVersion(dir string) (map[int64][]*nameInfo, error) {
+// groupByVersion collects and groups nameInfo by version into names, so that
+// we can use this to detect inconsistencies in naming and having the same
+// migration be used for both upgrading and downgrading. The files of
+// repeatable migrations are collected into repeatables by name. If
+// recursive, the files in subdirectories are collected too.
+func (mf migrationFinder) groupByVersion(names map[int64][]*nameInfo, repeatables map[string]*nameInfo, dir string, opts dirOptions) error {
+	pattern := opts.pattern
+	if pattern == nil {
+		pattern = nameRegexp
+	} else if pattern.SubexpIndex("version") < 0 {
+		return fmt.Errorf("emigrate: File name pattern %q has no version group.", pattern)
+	}
+
 	files, err := mf.readDir(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	names := make(map[int64][]*nameInfo)
 	for _, f := range files {
-		// Skip if it's not a file
+		// Descend into directories only if recursive
 		if f.IsDir() {
+			if opts.recursive {
+				err := mf.groupByVersion(names, repeatables, path.Join(dir, f.Name()), opts)
+				if err != nil {
+					return err
+				}
+			}
 			continue
 		}
 
 		name := f.Name()
-		info, err := parseNameInfo(dir, name)
+		if info := parseRepeatableName(dir, name); info != nil {
+			if _, ok := opts.extension(info.ext); !ok {
+				continue
+			} else if opts.exec && execExtensions[info.ext] {
+				return fmt.Errorf("emigrate: Executable %q cannot be a repeatable migration.", info.path())
+			} else if first := repeatables[info.slug]; first != nil {
+				return DuplicateRepeatableError{info.slug, first.path(), info.path()}
+			}
+			repeatables[info.slug] = info
+			continue
+		}
+		info, err := parseNameInfo(pattern, dir, name)
 		if err != nil {
-			return nil, err
+			return err
 		} else if info == nil {
-			// File does not match nameRegexp
+			// File does not match the pattern
+			continue
+		} else if _, ok := opts.extension(info.ext); !ok {
+			// File is not a migration, such as a README.md
 			continue
 		}
+		info.exec = opts.exec && execExtensions[info.ext]
 
 		names[info.version] = append(names[info.version], info)
 	}
-	return names, nil
+	return nil
 }
 
 type MissingMigrationError struct {
@@ -104,88 +536,177 @@ func (e MissingMigrationError) Error() string {
 type DuplicateMigrationError struct {
 	direction string
 	version   int64
+	first     string // where the first migration was found, if known
+	second    string // where the conflicting migration was found, if known
 }
 
 func (e DuplicateMigrationError) Error() string {
+	if e.first != "" || e.second != "" {
+		return fmt.Sprintf("emigrate: Duplicate \"%s\" migration for version %d in %s and %s", e.direction, e.version, e.first, e.second)
+	}
 	return fmt.Sprintf("emigrate: Duplicate \"%s\" migration for version %d", e.direction, e.version)
 }
 
-// getFileMigration returns a migration that upgrades or downgrades according
-// to the files matching the given name infos.
-func (mf migrationFinder) getFileMigration(names []*nameInfo) (Migration, error) {
-	if len(names) == 0 || len(names) > 2 {
+// checkFileMigration checks that the files matching the given name infos
+// make up a single migration, returning them by direction. The files must be
+// in the same directory.
+func checkFileMigration(names []*nameInfo) (map[string]*nameInfo, error) {
+	if len(names) == 0 {
 		// Logic error by caller
-		log.Fatalf("getFileMigration called with invalid infos: %#v", names)
+		log.Fatalf("checkFileMigration called with invalid infos: %#v", names)
 	}
+	version := names[0].version
 
-	var m stringMigration
-	m.version = names[0].version
-
-	// Keep track of the directions we've seen for this version
-	seen := make(map[string]bool)
+	// Keep track of the files we've seen for each direction
+	files := make(map[string]*nameInfo)
 
-	// Keep track of the extensions so they match
+	// Keep track of the extensions and slugs so they match
 	ext := ""
+	slug := names[0].slug
 
-	// For all files given, collect information about the migration and make sure
-	// they are compatible with what we have already seen
+	// For all files given, make sure they are compatible with what we have
+	// already seen
 	for _, info := range names {
-		path := filepath.Join(info.dir, info.name)
-		bytes, err := mf.readFile(path)
-		if err != nil {
-			return nil, err
-		}
-		contents := string(bytes)
-
 		if ext != "" && ext != info.ext {
 			return nil, fmt.Errorf("emigrate: Mixed extensions for migration version %d.", info.version)
 		}
 		ext = info.ext
 
-		if info.way == "up" {
-			if seen[info.way] {
-				return nil, DuplicateMigrationError{"up", info.version}
-			}
-			m.up = contents
-			seen[info.way] = true
-		} else if info.way == "down" {
-			if seen[info.way] {
-				return nil, DuplicateMigrationError{"down", info.version}
+		if info.slug != slug {
+			return nil, fmt.Errorf("emigrate: Mixed names for migration version %d.", info.version)
+		}
+
+		if info.way == "" {
+			// a single file holds both directions, so must be alone
+			if len(names) > 1 {
+				return nil, DuplicateMigrationError{"up", info.version, names[0].path(), names[1].path()}
 			}
-			m.down = contents
-			seen[info.way] = true
-		} else {
+			files["up"] = info
+			files["down"] = info
+			continue
+		}
+		if info.way != "up" && info.way != "down" {
 			// Logic error by caller
-			log.Fatalf("getFileMigration called with unexpected way value: %#v", info)
+			log.Fatalf("checkFileMigration called with unexpected way value: %#v", info)
+		}
+		if files[info.way] != nil {
+			return nil, DuplicateMigrationError{info.way, info.version, files[info.way].path(), info.path()}
+		}
+		// the upgrade and downgrade of a migration are kept side by side, so
+		// files in different directories are different migrations
+		for _, other := range files {
+			if other.dir != info.dir {
+				return nil, DuplicateMigrationError{info.way, info.version, other.path(), info.path()}
+			}
 		}
+		files[info.way] = info
 	}
 
-	if !seen["up"] {
-		return nil, MissingMigrationError{"up", m.version}
+	if files["up"] == nil {
+		return nil, MissingMigrationError{"up", version}
 	}
 
-	return m, nil
+	return files, nil
+}
+
+// ParseVersion parses the version number of a migration file name, which is
+// a positive decimal number that fits in an int64, ignoring any zero padding,
+// so that 1, 01 and 0001 are all version 1.
+func ParseVersion(s string) (int64, error) {
+	digits := strings.TrimLeft(s, "0")
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return 0, InvalidVersionError{version: s, reason: "is not a decimal number"}
+	} else if digits == "" {
+		return 0, InvalidVersionError{version: s, reason: "is not at least 1"}
+	}
+	version, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, InvalidVersionError{version: s, reason: "is too large"}
+	}
+	return version, nil
 }
 
-// parseNameInfo parses the name, returning a nameInfo.
+// InvalidVersionError indicates that the version number of a migration file
+// name cannot be parsed
+type InvalidVersionError struct {
+	file    string // the file name, if known
+	version string // the version as written
+	reason  string // why the version is invalid
+}
+
+func (e InvalidVersionError) Error() string {
+	if e.file != "" {
+		return fmt.Sprintf("emigrate: Version number of file %q %s.", e.file, e.reason)
+	}
+	return fmt.Sprintf("emigrate: Version number %q %s.", e.version, e.reason)
+}
+
+// FileName describes a migration file name, as parsed by ParseFileName
+type FileName struct {
+	Version   int64
+	Name      string // the slug describing the migration, may be empty
+	Direction string // "up", "down" or "" if the file holds both
+	Ext       string // the extension in lower case, without any .gz
+	Gzip      bool   // whether the file is gzip compressed
+}
+
+// ParseFileName parses a migration file name, such as 001_create_users.up.sql,
+// as MigrationsFromDir does, so that tooling can recognize migration files the
+// same way. It returns nil if name is not a migration file name, and an error
+// if it is but its version is invalid. Files with an extension other than sql
+// are returned, and MigrationsFromDir ignores them unless added by
+// WithExtension.
+func ParseFileName(name string) (*FileName, error) {
+	info, err := parseNameInfo(nameRegexp, "", name)
+	if info == nil {
+		return nil, err
+	}
+	return &FileName{
+		Version:   info.version,
+		Name:      info.slug,
+		Direction: info.way,
+		Ext:       info.ext,
+		Gzip:      info.gzip,
+	}, nil
+}
+
+// parseNameInfo parses the name according to pattern, returning a nameInfo.
 // If the name is invalid an error is returned.
-// If the name does not match the nameRegexp, nil is returned.
-func parseNameInfo(dir, name string) (*nameInfo, error) {
-	match := nameRegexp.FindStringSubmatch(name)
+// If the name does not match the pattern, nil is returned.
+// A name ending in .gz is matched without it, as a compressed file.
+func parseNameInfo(pattern *regexp.Regexp, dir, name string) (*nameInfo, error) {
+	base := name
+	compressed := len(name) > 3 && strings.EqualFold(name[len(name)-3:], ".gz")
+	if compressed {
+		base = name[:len(name)-3]
+	}
+	match := pattern.FindStringSubmatch(base)
 	if match == nil {
 		return nil, nil
 	}
+	group := func(name string) string {
+		if i := pattern.SubexpIndex(name); i >= 0 {
+			return match[i]
+		}
+		return ""
+	}
 
-	// Parse version number
-	version, err := strconv.ParseInt(match[1], 10, 64)
-	if err != nil || version < 1 {
-		return nil, fmt.Errorf("emigrate: Version number of file %q is invalid.", name)
+	version, err := ParseVersion(group("version"))
+	if err, ok := err.(InvalidVersionError); ok {
+		err.file = name
+		return nil, err
+	}
+	way := strings.ToLower(group("way"))
+	if way != "" && way != "up" && way != "down" {
+		return nil, fmt.Errorf("emigrate: Direction of file %q is invalid.", name)
 	}
 	return &nameInfo{
 		dir:     dir,
 		name:    name,
 		version: version,
-		way:     match[2],
-		ext:     match[3],
+		slug:    group("name"),
+		way:     way,
+		ext:     strings.ToLower(group("ext")),
+		gzip:    compressed,
 	}, nil
 }
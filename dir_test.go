@@ -1,9 +1,14 @@
 package emigrate
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 import "os"
@@ -161,3 +166,443 @@ func TestMigrationsFromDir(t *testing.T) {
 		t.Errorf("Got unexpected error %#v", err)
 	}
 }
+
+func TestFSMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/migrations/001_up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"db/migrations/001_down.sql": {Data: []byte(TestQueryDropInvoiceTable)},
+		"db/migrations/002_up.sql":   {Data: []byte(TestQueryInsertInvoices)},
+		"db/migrations/README":       {Data: []byte("not a migration")},
+	}
+
+	ms, err := FSMigrations(fsys, "db/migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	if m := ms[0].(stringMigration); m.version != 1 || m.up != TestQueryCreateInvoiceTable || m.down != TestQueryDropInvoiceTable {
+		t.Errorf("Unexpected migration %#v", m)
+	}
+	if m := ms[1].(stringMigration); m.version != 2 || m.up != TestQueryInsertInvoices {
+		t.Errorf("Unexpected migration %#v", m)
+	}
+}
+
+// Verify that MigrationsFromDir reads migrations from a directory on disk.
+func TestMigrationsFromDirOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "001_up.sql"), []byte(TestQueryCreateInvoiceTable), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := MigrationsFromDir(dir)
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 1 || ms[0].Version() != 1 {
+		t.Errorf("Expected a migration for version 1, got %#v", ms)
+	}
+}
+
+func TestFSMigrationsRecursive(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/2023/001_up.sql":    {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/2023/001_down.sql":  {Data: []byte(TestQueryDropInvoiceTable)},
+		"migrations/2024/q1/003_up.sql": {Data: []byte(TestQueryInsertInvoices)},
+		"migrations/002_up.sql":         {Data: []byte("")},
+	}
+
+	ms, err := FSMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 1 {
+		t.Errorf("Expected subdirectories to be skipped, got %d migrations", len(ms))
+	}
+
+	ms, err = FSMigrations(fsys, "migrations", Recursive())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 3 {
+		t.Fatalf("Expected %d migrations, got %d", 3, len(ms))
+	}
+	for i, m := range ms {
+		if m.Version() != int64(i+1) {
+			t.Errorf("Expected version %d, got %d", i+1, m.Version())
+		}
+	}
+	if m := ms[0].(stringMigration); m.down != TestQueryDropInvoiceTable {
+		t.Errorf("Expected %q, got %q", TestQueryDropInvoiceTable, m.down)
+	}
+}
+
+// Verify that the same version in different directories is rejected.
+func TestFSMigrationsRecursiveDuplicate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/2023/001_up.sql": {Data: []byte("")},
+		"migrations/2024/001_up.sql": {Data: []byte("")},
+	}
+
+	_, err := FSMigrations(fsys, "migrations", Recursive())
+	dup, ok := err.(DuplicateMigrationError)
+	if !ok {
+		t.Fatalf("Expected duplicate migration error, got %v", err)
+	}
+	if dup.first != "migrations/2023/001_up.sql" || dup.second != "migrations/2024/001_up.sql" {
+		t.Errorf("Expected the paths of both files, got %q and %q", dup.first, dup.second)
+	}
+}
+
+// Verify that an upgrade and a downgrade of the same version are only taken
+// to be one migration if they are in the same directory.
+func TestFSMigrationsRecursiveSplitDirections(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/2023/001_invoices.up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/2024/001_invoices.down.sql": {Data: []byte(TestQueryDropInvoiceTable)},
+	}
+
+	_, err := FSMigrations(fsys, "migrations", Recursive())
+	dup, ok := err.(DuplicateMigrationError)
+	if !ok {
+		t.Fatalf("Expected duplicate migration error, got %v", err)
+	}
+	if dup.version != 1 || dup.first == dup.second {
+		t.Errorf("Expected the paths of both files of version 1, got %q and %q", dup.first, dup.second)
+	}
+}
+
+func TestParseNameInfo(t *testing.T) {
+	var tests = []struct {
+		name    string
+		version int64
+		slug    string
+		way     string
+	}{
+		{"001_up.sql", 1, "", "up"},
+		{"2-down.SQL", 2, "", "down"},
+		{"003_create_users.up.sql", 3, "create_users", "up"},
+		{"003_create_users.down.sql", 3, "create_users", "down"},
+		{"004_add-index_up.sql", 4, "add-index", "up"},
+		{"005_up_down.sql", 5, "up", "down"},
+		{"006_create_users.sql", 6, "create_users", ""},
+		{"007.sql", 7, "", ""},
+	}
+
+	for _, test := range tests {
+		info, err := parseNameInfo(nameRegexp, "migrations", test.name)
+		if err != nil || info == nil {
+			t.Errorf("Expected %q to parse, got %v", test.name, err)
+			continue
+		}
+		if info.version != test.version || info.slug != test.slug || info.way != test.way {
+			t.Errorf("Unexpected parse of %q: %#v", test.name, info)
+		}
+	}
+
+	for _, name := range []string{"README", "001_up.sql.bak", "create_users.up.sql"} {
+		if info, _ := parseNameInfo(nameRegexp, "migrations", name); info != nil {
+			t.Errorf("Expected %q not to match, got %#v", name, info)
+		}
+	}
+}
+
+// Verify that the slug of the file names is used as the migration name.
+func TestFSMigrationsNamed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_users.up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/001_create_users.down.sql": {Data: []byte(TestQueryDropInvoiceTable)},
+		"migrations/002_up.sql":                {Data: []byte("")},
+	}
+
+	ms, err := FSMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if name := migrationName(ms[0]); name != "create_users" {
+		t.Errorf("Expected %s, got %s", "create_users", name)
+	}
+	if name := migrationName(ms[1]); name != "" {
+		t.Errorf("Expected no name, got %s", name)
+	}
+}
+
+func TestFSMigrationsMixedNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_users.up.sql":    {Data: []byte("")},
+		"migrations/001_create_people.down.sql": {Data: []byte("")},
+	}
+
+	if _, err := FSMigrations(fsys, "migrations"); err == nil {
+		t.Errorf("Expected an error for mismatched names")
+	}
+}
+
+func TestFSMigrationsSingleFile(t *testing.T) {
+	contents := `-- creates the invoice table
+-- +emigrate Up
+` + TestQueryCreateInvoiceTable + `;
+
+-- +emigrate Down
+` + TestQueryDropInvoiceTable + `;
+`
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.sql": {Data: []byte(contents)},
+		"migrations/002_up.sql":             {Data: []byte("")},
+	}
+
+	ms, err := FSMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	m := ms[0].(stringMigration)
+	if m.up != TestQueryCreateInvoiceTable+";" {
+		t.Errorf("Expected %q, got %q", TestQueryCreateInvoiceTable+";", m.up)
+	}
+	if m.down != TestQueryDropInvoiceTable+";" {
+		t.Errorf("Expected %q, got %q", TestQueryDropInvoiceTable+";", m.down)
+	}
+	if name := migrationName(m); name != "create_invoice" {
+		t.Errorf("Expected %s, got %s", "create_invoice", name)
+	}
+}
+
+func TestSplitDirectives(t *testing.T) {
+	up, down, err := splitDirectives("001.sql", "-- +emigrate Up\nSELECT 1;\n")
+	if err != nil || up != "SELECT 1;" || down != "" {
+		t.Errorf("Unexpected split: %q, %q, %v", up, down, err)
+	}
+
+	var invalid = []string{
+		"SELECT 1;\n-- +emigrate Up\nSELECT 2;\n",
+		"-- +emigrate Down\nSELECT 1;\n",
+		"-- +emigrate Up\nSELECT 1;\n-- +emigrate Up\nSELECT 2;\n",
+	}
+	for _, contents := range invalid {
+		if _, _, err := splitDirectives("001.sql", contents); err == nil {
+			t.Errorf("Expected an error splitting %q", contents)
+		}
+	}
+}
+
+// Verify that a single-file migration cannot be combined with other files for
+// the same version.
+func TestFSMigrationsSingleFileDuplicate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001.sql":    {Data: []byte("-- +emigrate Up\n")},
+		"migrations/001_up.sql": {Data: []byte("")},
+	}
+
+	_, err := FSMigrations(fsys, "migrations")
+	if _, ok := err.(DuplicateMigrationError); !ok {
+		t.Errorf("Expected duplicate migration error, got %v", err)
+	}
+}
+
+// Verify that files can be recognized by the pattern of another tool, here
+// that of Flyway, and that patterns without a version group are rejected.
+func TestFSMigrationsPattern(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/V1__create_invoice.sql":  {Data: []byte("-- +emigrate Up\n" + TestQueryCreateInvoiceTable)},
+		"migrations/V2__insert_invoices.sql": {Data: []byte("-- +emigrate Up\n" + TestQueryInsertInvoices)},
+		"migrations/001_up.sql":              {Data: []byte("")},
+	}
+
+	pattern := regexp.MustCompile(`^V(?P<version>\d+)__(?P<name>\w+)\.sql$`)
+	ms, err := FSMigrations(fsys, "migrations", WithPattern(pattern))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	if name := migrationName(ms[1]); ms[1].Version() != 2 || name != "insert_invoices" {
+		t.Errorf("Unexpected migration %d %s", ms[1].Version(), name)
+	}
+
+	pattern = regexp.MustCompile(`^V(\d+)__(\w+)\.sql$`)
+	if _, err := FSMigrations(fsys, "migrations", WithPattern(pattern)); err == nil {
+		t.Errorf("Expected an error for a pattern without a version group")
+	}
+}
+
+// Verify that files with other extensions are ignored unless added, in which
+// case their contents are converted by the handler.
+func TestFSMigrationsExtension(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/002_up.PSQL":  {Data: []byte("\\set ON_ERROR_STOP on\n" + TestQueryInsertInvoices)},
+		"migrations/003_up.ddl":   {Data: []byte(TestQueryDropInvoiceTable)},
+		"migrations/README.md":    {Data: []byte("not a migration")},
+		"migrations/004_notes.md": {Data: []byte("not a migration")},
+	}
+
+	ms, err := FSMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 1 {
+		t.Errorf("Expected other extensions to be ignored, got %d migrations", len(ms))
+	}
+
+	stripMeta := func(contents string) (string, error) {
+		var lines []string
+		for _, line := range strings.Split(contents, "\n") {
+			if !strings.HasPrefix(line, "\\") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	ms, err = FSMigrations(fsys, "migrations", WithExtension(".psql", stripMeta), WithExtension("ddl", nil))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 3 {
+		t.Fatalf("Expected %d migrations, got %d", 3, len(ms))
+	}
+	if m := ms[1].(stringMigration); m.up != TestQueryInsertInvoices {
+		t.Errorf("Expected %q, got %q", TestQueryInsertInvoices, m.up)
+	}
+	if m := ms[2].(stringMigration); m.up != TestQueryDropInvoiceTable {
+		t.Errorf("Expected %q, got %q", TestQueryDropInvoiceTable, m.up)
+	}
+}
+
+func TestFSMigrationsTemplate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_up.sql":   {Data: []byte(`CREATE TABLE {{.Schema}}.invoice (id INTEGER) TABLESPACE {{.Tablespace}}`)},
+		"migrations/001_down.sql": {Data: []byte(`DROP TABLE {{.Schema}}.invoice`)},
+	}
+	vars := map[string]interface{}{"Schema": "billing", "Tablespace": "fast"}
+
+	ms, err := FSMigrations(fsys, "migrations", WithTemplate(vars))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	m := ms[0].(stringMigration)
+	if expected := `CREATE TABLE billing.invoice (id INTEGER) TABLESPACE fast`; m.up != expected {
+		t.Errorf("Expected %q, got %q", expected, m.up)
+	}
+	if expected := `DROP TABLE billing.invoice`; m.down != expected {
+		t.Errorf("Expected %q, got %q", expected, m.down)
+	}
+
+	// without a template, the files are run as they are
+	ms, err = FSMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if m := ms[0].(stringMigration); m.down != `DROP TABLE {{.Schema}}.invoice` {
+		t.Errorf("Expected the file to be unrendered, got %q", m.down)
+	}
+
+	_, err = FSMigrations(fsys, "migrations", WithTemplate(map[string]interface{}{"Schema": "billing"}))
+	if err == nil {
+		t.Errorf("Expected an error for a missing variable")
+	}
+}
+
+func gzipped(t *testing.T, contents string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Verify that compressed files are decompressed when read, whether loaded
+// eagerly or lazily.
+func TestFSMigrationsGzip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.up.sql":     {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/002_load_invoices.up.sql.gz":   {Data: gzipped(t, TestQueryInsertInvoices)},
+		"migrations/002_load_invoices.down.SQL.GZ": {Data: gzipped(t, TestQueryDropInvoiceTable)},
+		"migrations/003_up.sql.gz":                 {Data: []byte("not gzipped")},
+	}
+
+	if _, err := FSMigrations(fsys, "migrations"); err == nil {
+		t.Errorf("Expected an error for a file that is not gzipped")
+	}
+
+	ms, err := FSMigrations(fsys, "migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 3 {
+		t.Fatalf("Expected %d migrations, got %d", 3, len(ms))
+	}
+	m := ms[1].(SQLer)
+	if sql := m.SQL("up"); sql != TestQueryInsertInvoices {
+		t.Errorf("Expected %q, got %q", TestQueryInsertInvoices, sql)
+	}
+	if sql := m.SQL("down"); sql != TestQueryDropInvoiceTable {
+		t.Errorf("Expected %q, got %q", TestQueryDropInvoiceTable, sql)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	var valid = []struct {
+		s       string
+		version int64
+	}{
+		{"1", 1},
+		{"0001", 1},
+		{"000000000000000000000000042", 42},
+		{"9223372036854775807", 9223372036854775807},
+		{"00009223372036854775807", 9223372036854775807},
+	}
+	for _, test := range valid {
+		version, err := ParseVersion(test.s)
+		if err != nil || version != test.version {
+			t.Errorf("Expected %q to parse as %d, got %d, %v", test.s, test.version, version, err)
+		}
+	}
+
+	for _, s := range []string{"", "0", "0000", "9223372036854775808", "-1", "+1", "1e3"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		} else if _, ok := err.(InvalidVersionError); !ok {
+			t.Errorf("Expected invalid version error, got %v", err)
+		}
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	name, err := ParseFileName("0042_create_users.up.sql.gz")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	expected := FileName{Version: 42, Name: "create_users", Direction: "up", Ext: "sql", Gzip: true}
+	if *name != expected {
+		t.Errorf("Expected %#v, got %#v", expected, *name)
+	}
+
+	if name, err := ParseFileName("README.md"); name != nil || err != nil {
+		t.Errorf("Expected no file name, got %#v, %v", name, err)
+	}
+	_, err = ParseFileName("99999999999999999999_up.sql")
+	expectedErr := `emigrate: Version number of file "99999999999999999999_up.sql" is too large.`
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected %q, got %v", expectedErr, err)
+	}
+}
+
+// Verify that padded and unpadded names of the same version collide.
+func TestPaddedVersionsCollide(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_up.sql":    {Data: []byte("")},
+		"migrations/0001_up.sql": {Data: []byte("")},
+	}
+	_, err := FSMigrations(fsys, "migrations")
+	if _, ok := err.(DuplicateMigrationError); !ok {
+		t.Errorf("Expected duplicate migration error, got %v", err)
+	}
+}
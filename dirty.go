@@ -0,0 +1,133 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DirtyError indicates that a migration failed on a database without
+// transactional DDL, and may have left the schema partially changed. No
+// migrations are run until the schema has been checked and ClearDirty called.
+type DirtyError struct {
+	version int64 // the version of the failed migration
+}
+
+func (e DirtyError) Error() string {
+	return fmt.Sprintf("emigrate: Database is dirty, migration %d failed and must be resolved", e.version)
+}
+
+// Verifier is implemented by migrations that can check whether the database
+// is in a state from which the migration can be run again, after it failed
+// and left the database dirty. It is given the database the Migrator runs
+// on.
+type Verifier interface {
+	Verify(db DB) error
+}
+
+// dirtyTable returns the name of the table holding the dirty versions
+func (m *Migrator) dirtyTable() string {
+	return m.table("_dirty")
+}
+
+// tracksDirty reports whether the dirty state is kept, which is only needed
+// for databases that cannot roll back a failed migration
+func (m *Migrator) tracksDirty() bool {
+	return !m.dialect().TransactionalDDL() || m.noTransactions()
+}
+
+// Dirty returns the version of the failed migration that left the database
+// dirty, if any. Databases with transactional DDL are never dirty.
+func (m *Migrator) Dirty() (int64, bool, error) {
+	if !m.tracksDirty() {
+		return 0, false, nil
+	}
+	var version int64
+	err := m.tracking().QueryRowContext(context.Background(), m.query(m.queries().GetDirtyVersion, m.dirtyTable())).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+// ClearDirty marks the database as clean once an operator has resolved the
+// failed migration, allowing migrations to run again.
+func (m *Migrator) ClearDirty() error {
+	if !m.tracksDirty() {
+		return nil
+	}
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().ClearDirty, m.dirtyTable()))
+	return err
+}
+
+// checkDirty returns a DirtyError if the database is dirty
+func (m *Migrator) checkDirty() error {
+	version, dirty, err := m.Dirty()
+	if err != nil {
+		return err
+	} else if dirty {
+		return DirtyError{version}
+	}
+	return nil
+}
+
+// dirtyWarning returns the warning for a migration that failed with err and
+// marked the database dirty, as the statements it ran were not rolled back
+func (m *Migrator) dirtyWarning(migration Migration, err error) string {
+	reason := "the database does not have transactional DDL"
+	if m.noTransactions() {
+		reason = "the Migrator runs without transactions"
+	}
+	var se StatementError
+	if errors.As(err, &se) && se.index > 1 {
+		return fmt.Sprintf("emigrate: migration %d failed at statement %d, and the statements before it were not rolled back, as %s; it is marked dirty until resolved",
+			migration.Version(), se.index, reason)
+	}
+	return fmt.Sprintf("emigrate: migration %d failed, and any changes it made were not rolled back, as %s; it is marked dirty until resolved",
+		migration.Version(), reason)
+}
+
+// initDirty creates the table of dirty versions, if it is needed
+func (m *Migrator) initDirty() error {
+	if !m.tracksDirty() {
+		return nil
+	}
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().CreateDirtyTable, m.dirtyTable()))
+	return err
+}
+
+// Resume recovers from a failed migration that left the database dirty,
+// clearing the dirty state and upgrading from the failed version onwards. The
+// failed migration must implement Verifier and pass verification, unless
+// force is set, which clears the dirty state without checking. If the
+// database is not dirty, Resume is the same as Upgrade.
+func (m *Migrator) Resume(force bool) (*Result, error) {
+	version, dirty, err := m.Dirty()
+	if err != nil {
+		return &Result{}, err
+	}
+	if dirty && !force {
+		sort.Sort(byVersion(m.migrations))
+		idx, ok := byVersion(m.migrations).Search(version)
+		if !ok {
+			return &Result{}, DirtyError{version}
+		}
+		v, ok := m.migrations[idx].(Verifier)
+		if !ok {
+			return &Result{}, DirtyError{version}
+		}
+		if err := v.Verify(m.db); err != nil {
+			return &Result{}, err
+		}
+	}
+	if dirty {
+		if err := m.ClearDirty(); err != nil {
+			return &Result{}, err
+		}
+	}
+	return m.Upgrade()
+}
@@ -0,0 +1,207 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testDirtyTable = "emigrate_dirty"
+
+func expectDirtyQuery(mock *sqlmock.MockDB, versions ...int64) {
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, version := range versions {
+		rows.AddRow(version)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetDirtyVersion(testDirtyTable))).WillReturnRows(rows)
+}
+
+// Verify that a failed migration marks a database without transactional DDL
+// dirty.
+func TestFailedMigrationMarksDirty(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), Dialect: MySQLDialect{}}
+	m.migrations[0].(*mockMigration).err = errors.New("migrate failed")
+
+	expectPrimary(mock, false)
+	expectSessionLock(mock, 1)
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertDirtyVersion(testDirtyTable))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSessionUnlock(mock)
+
+	if _, err := m.Upgrade(); err == nil {
+		t.Errorf("Expected migration to fail")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the SQL of a migration is run one statement at a time on a
+// database without transactional DDL, and that a failure warns that the
+// statements before it were not rolled back.
+func TestFailedStatementWarning(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, Dialect: MySQLDialect{}}
+	m.migrations = []Migration{NewStringMigration(1, "CREATE TABLE a (id int); CREATE TABLE b (id int)", "")}
+	failure := errors.New("table b already exists")
+
+	expectPrimary(mock, false)
+	expectSessionLock(mock, 1)
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE a (id int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b (id int)")).WillReturnError(failure)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertDirtyVersion(testDirtyTable))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSessionUnlock(mock)
+
+	result, err := m.Upgrade()
+	if se, ok := err.(StatementError); !ok || se.index != 2 || !errors.Is(err, failure) {
+		t.Errorf("Expected statement 2 to fail, got %v", err)
+	}
+	if len(result.Warnings) != 2 || !strings.Contains(result.Warnings[1], "the statements before it were not rolled back") {
+		t.Errorf("Expected a warning that the statements were not rolled back, got %q", result.Warnings)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that nothing is run while the database is dirty.
+func TestUpgradeDirty(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1, 2), Dialect: MySQLDialect{}}
+	expectPrimary(mock, false)
+	expectSessionLock(mock, 1)
+	expectDirtyQuery(mock, 2)
+	expectSessionUnlock(mock)
+
+	_, err = m.Upgrade()
+	if de, ok := err.(DirtyError); !ok || de.version != 2 {
+		t.Errorf("Expected dirty error, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestClearDirty(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, Dialect: MySQLDialect{}}
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.ClearDirty(testDirtyTable))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := m.ClearDirty(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that databases with transactional DDL are never dirty, without
+// querying the database.
+func TestDirtyTransactionalDDL(t *testing.T) {
+	m := Migrator{Dialect: PostgresDialect{}}
+	if _, dirty, err := m.Dirty(); dirty || err != nil {
+		t.Errorf("Expected clean database, got %v, %v", dirty, err)
+	}
+}
+
+type verifiedMigration struct {
+	mockMigration
+	err error // an error to be returned as the result of Verify (or nil)
+	db  DB    // the database it was verified on
+}
+
+func (vm *verifiedMigration) Verify(db DB) error {
+	vm.db = db
+	return vm.err
+}
+
+// Verify that Resume refuses to clear the dirty state when verification
+// fails.
+func TestResumeVerificationFailed(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	verifyErr := errors.New("column half added")
+	m := Migrator{db: db, Dialect: MySQLDialect{}, migrations: []Migration{
+		&verifiedMigration{mockMigration: mockMigration{version: 1}, err: verifyErr},
+	}}
+	expectDirtyQuery(mock, 1)
+
+	if _, err := m.Resume(false); err != verifyErr {
+		t.Errorf("Expected %v, got %v", verifyErr, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration without verification is only resumed with force.
+func TestResumeRequiresForce(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, Dialect: MySQLDialect{}, migrations: migrationRange(1)}
+	expectDirtyQuery(mock, 1)
+
+	if _, err := m.Resume(false); err != (DirtyError{1}) {
+		t.Errorf("Expected dirty error, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that Resume clears the dirty state and runs the failed migration.
+func TestResume(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, Dialect: MySQLDialect{}, migrations: []Migration{
+		&verifiedMigration{mockMigration: mockMigration{version: 1}},
+	}}
+	expectDirtyQuery(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.ClearDirty(testDirtyTable))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPrimary(mock, false)
+	expectSessionLock(mock, 1)
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	expectSetVersions(0, mock, 1)
+	expectSessionUnlock(mock)
+
+	if _, err := m.Resume(false); err != nil {
+		t.Errorf("Unexpected error during resume: %s", err)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,236 @@
+package emigrate
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// DowngradeToVersion reverts the applied migrations newer than version, newest
+// first and each in its own transaction. Every migration to be reverted must
+// be loaded and define a downgrade, which is checked before anything is run,
+// except those that were skipped by their condition, which are reverted
+// without running anything. Each downgrade is recorded in the history, and
+// the returned Result is never nil.
+func (m *Migrator) DowngradeToVersion(version int64) (*Result, error) {
+	m.batch = newBatch()
+	result := &Result{Batch: m.batch}
+	if err := m.warmUp(); err != nil {
+		return result, err
+	} else if err := m.checkPrimary(); err != nil {
+		return result, err
+	} else if err := m.lock(); err != nil {
+		return result, err
+	}
+	// a lock that cannot be released goes stale, so the error is ignored
+	defer m.unlock()
+	if err := m.checkDirty(); err != nil {
+		return result, err
+	}
+
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return result, err
+	} else if version > current {
+		return result, InvalidDowngradeVersion
+	} else if version == current {
+		return result, nil
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return result, err
+	}
+	var versions []int64
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(int64Slice(versions)))
+
+	// plan the downgrade before reverting anything
+	sort.Sort(byVersion(m.migrations))
+	skipped, err := m.skippedVersions(m.migrations)
+	if err != nil {
+		return result, err
+	}
+	var plan []Migration
+	for _, v := range versions {
+		if v <= version {
+			break
+		}
+		idx, ok := byVersion(m.migrations).Search(v)
+		if !ok || (!canDowngrade(m.migrations[idx]) && !skipped[v]) {
+			return result, MissingMigrationError{"down", v}
+		}
+		plan = append(plan, m.migrations[idx])
+	}
+	plan, err = planDowngrade(plan, m.migrations, applied)
+	if err != nil {
+		return result, err
+	}
+
+	expected := current
+	for _, migration := range plan {
+		// the current version becomes the newest version still applied
+		delete(applied, migration.Version())
+		var next int64
+		for v := range applied {
+			if v > next {
+				next = v
+			}
+		}
+
+		start := time.Now()
+		err := m.revert(migration, expected, next, skipped[migration.Version()])
+		mr := MigrationResult{
+			Version:  migration.Version(),
+			Name:     migrationName(migration),
+			Label:    migrationLabel(migration),
+			Duration: time.Since(start),
+			Status:   StatusReverted,
+		}
+		if err != nil {
+			mr.Status = StatusFailed
+			mr.Err = err
+			result.Migrations = append(result.Migrations, mr)
+			return result, err
+		}
+		result.Migrations = append(result.Migrations, mr)
+		expected = next
+	}
+	return result, nil
+}
+
+// downgrade runs the downgrade of a migration in tx, one statement at a time
+// if it is to be split
+func (m *Migrator) downgrade(ctx context.Context, tx Tx, migration Migration) error {
+	if s, ok := migration.(stepper); ok && len(s.steps("down")) > 0 {
+		_, err := m.runSteps(ctx, tx, migration, "down")
+		return err
+	} else if pm, ok := migration.(*paramMigration); ok {
+		_, err := m.execParams(ctx, tx, pm, "down")
+		return err
+	} else if pm, ok := migration.(*progressMigration); ok {
+		return m.runProgress(tx, pm, "down")
+	} else if em, ok := migration.(*execMigration); ok {
+		return em.run(ctx, "down")
+	}
+	if sr, ok := migration.(sqlReader); ok {
+		script, err := sr.readSQL("down")
+		if err != nil {
+			return err
+		}
+		script = m.rewriteDDL(migration, script)
+		if script != "" && m.splitsStatements() {
+			_, err = execStatements(ctx, tx, script)
+			return err
+		} else if script != "" {
+			_, err = tx.ExecContext(ctx, script)
+			return err
+		}
+	}
+	stx, err := databaseSQLTx(tx)
+	if err != nil {
+		return err
+	}
+	return migration.(Downgrader).Downgrade(stx)
+}
+
+// checkRevert locks the current version in tx and checks that it is the
+// expected version, returning it
+func (m *Migrator) checkRevert(tx Tx, expected int64) (int64, error) {
+	current, err := m.lockVersion(tx)
+	if err != nil {
+		return 0, err
+	} else if current != expected {
+		return 0, MigrationVersionChanged
+	}
+	return current, nil
+}
+
+// revert runs the downgrade of a single migration in its own transaction,
+// changing the current version from expected to next. A migration that was
+// skipped by its condition has nothing to undo, so its downgrade is not run.
+func (m *Migrator) revert(migration Migration, expected, next int64, skipped bool) error {
+	if err := prepare(migration); err != nil {
+		return err
+	} else if m.noTransactions() {
+		return m.revertWithoutTx(migration, expected, next, skipped)
+	}
+	start := time.Now()
+	ctx, cancel := m.migrationContext(migration)
+	defer cancel()
+	tx, err := m.begin(ctx, migration)
+	if err != nil {
+		return err
+	}
+
+	current, err := m.checkRevert(tx, expected)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !skipped && m.runsInTx(migration) {
+		err = m.downgrade(ctx, tx, migration)
+	} else if !skipped {
+		tx.Rollback()
+		_, err = m.execOutsideTx(ctx, migration, "down")
+	}
+	if err != nil {
+		tx.Rollback()
+		entry := m.historyEntry(migration, "down", current, current)
+		entry.Duration = time.Since(start)
+		m.recordFailure(entry)
+		return err
+	}
+
+	if !skipped && !m.runsInTx(migration) {
+		// the migration is recorded in a transaction of its own
+		tx, err = m.begin(ctx, migration)
+		if err != nil {
+			return err
+		}
+		if _, err = m.checkRevert(tx, expected); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if next != current {
+		err = m.setVersion(tx, next, current)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, m.query(m.queries().DeleteAppliedVersion, m.appliedTable()), migration.Version())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	entry := m.historyEntry(migration, "down", current, next)
+	entry.Duration = time.Since(start)
+	entry.Success = true
+	err = m.insertHistory(tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// int64Slice implements sorting a slice of versions
+type int64Slice []int64
+
+func (a int64Slice) Len() int           { return len(a) }
+func (a int64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64Slice) Less(i, j int) bool { return a[i] < a[j] }
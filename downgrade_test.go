@@ -0,0 +1,139 @@
+package emigrate
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type downgradeMigration struct {
+	mockMigration
+	reverted bool // true if the downgrade was called
+}
+
+func (dm *downgradeMigration) Downgrade(tx *sql.Tx) error {
+	dm.reverted = true
+	return dm.err
+}
+
+// Returns a slice of downgradable migrations at set version numbers
+func downgradeRange(versions ...int64) []Migration {
+	ms := make([]Migration, len(versions))
+	for idx, version := range versions {
+		ms[idx] = &downgradeMigration{mockMigration: mockMigration{version: version}}
+	}
+	return ms
+}
+
+// Sets up the database mock to expect a downgrade from the current version to
+// the next version
+func expectRevert(mock *sqlmock.MockDB, version, current, next int64) {
+	mock.ExpectBegin()
+	expectVersionQuery(mock, current)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(next, current).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteAppliedVersion(testAppliedTable))).WithArgs(version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+}
+
+func TestDowngradeToVersion(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 3)
+	m.migrations = downgradeRange(1, 2, 3)
+	expectAppliedQuery(mock, 1, 2, 3)
+	expectRevert(mock, 3, 3, 2)
+	expectRevert(mock, 2, 2, 1)
+
+	result, err := m.DowngradeToVersion(1)
+	if err != nil {
+		t.Fatalf("Unexpected error during downgrade: %s", err)
+	}
+
+	expected := []bool{false, true, true}
+	for idx, val := range expected {
+		if reverted := m.migrations[idx].(*downgradeMigration).reverted; reverted != val {
+			t.Errorf("Version %d downgrade mismatch: expected %v, got %v", idx+1, val, reverted)
+		}
+	}
+	if len(result.Migrations) != 2 || result.Migrations[0].Status != StatusReverted {
+		t.Errorf("Expected 2 reverted migrations, got %v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a downgrade is recorded in the history with the versions it
+// moved between.
+func TestDowngradeRecordedInHistory(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 2)
+	m.migrations = downgradeRange(1, 2)
+	m.AppliedBy = "deploy"
+	expectAppliedQuery(mock, 1, 2)
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 2)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteAppliedVersion(testAppliedTable))).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	entry := HistoryEntry{Version: 2, Direction: "down", FromVersion: 2, ToVersion: 1,
+		AppliedBy: "deploy", Hostname: hostname(), Success: true}
+	expectHistoryEntry(mock, entry).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := m.DowngradeToVersion(1); err != nil {
+		t.Fatalf("Unexpected error during downgrade: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestDowngradeMissingDowngrade(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 3)
+	m.migrations = append(downgradeRange(1, 2), &mockMigration{version: 3})
+	expectAppliedQuery(mock, 1, 2, 3)
+
+	expected := MissingMigrationError{"down", 3}
+	if _, result := m.DowngradeToVersion(1); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	if m.migrations[1].(*downgradeMigration).reverted {
+		t.Errorf("Downgrade called when it shouldn't have been")
+	}
+	mock.CloseTest(t)
+}
+
+func TestDowngradeToNewerVersion(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+
+	expected := InvalidDowngradeVersion
+	if _, result := m.DowngradeToVersion(2); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that migrations are only planned for a downgrade if they implement
+// Downgrader, or are provided by this package with a downgrade.
+func TestDowngrader(t *testing.T) {
+	var tests = []struct {
+		migration Migration
+		expected  bool
+	}{
+		{&mockMigration{version: 1}, false},
+		{&downgradeMigration{mockMigration: mockMigration{version: 1}}, true},
+		{NewStringMigration(1, TestQueryCreateInvoiceTable, ""), false},
+		{NewStringMigration(1, TestQueryCreateInvoiceTable, TestQueryDropInvoiceTable), true},
+	}
+	for _, test := range tests {
+		if result := canDowngrade(test.migration); result != test.expected {
+			t.Errorf("Expected %v for %#v, got %v", test.expected, test.migration, result)
+		}
+	}
+}
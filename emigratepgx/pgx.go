@@ -0,0 +1,233 @@
+// Package emigratepgx runs an emigrate Migrator on a pgx pool or connection,
+// for services that use pgx natively rather than through database/sql:
+//
+//	pool, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
+//	...
+//	m := emigratepgx.NewMigrator(pool, migrations)
+//	result, err := m.Upgrade()
+//
+// The Migrator runs on pgx directly, as an emigrate.NativeDB, so the SQL of
+// migrations and the queries of the tracking tables are sent by pgx without
+// going through database/sql. Migrations and hooks that are given a *sql.Tx,
+// such as those created by emigrate.NewFunctionMigration, cannot be run, and
+// fail with emigrate.SQLTxUnavailable. The dialect is detected, so
+// CockroachDB is supported as well as PostgreSQL.
+package emigratepgx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/jnwhiteh/emigrate"
+)
+
+// NewMigrator returns a Migrator that runs migrations on the connections of
+// pool. The pool is not closed by the Migrator.
+func NewMigrator(pool *pgxpool.Pool, migrations []emigrate.Migration) *emigrate.Migrator {
+	return emigrate.NewMigrator(db{pool}, migrations)
+}
+
+// NewConnMigrator returns a Migrator that runs migrations on conn, a
+// connection held by the caller, so that migrations see the settings of its
+// session. As conn is a single session, the tracking tables are read and
+// written on it too, unless a TrackingDB is configured, and it must not be
+// used by anything else while the Migrator runs. The connection is not closed
+// by the Migrator.
+func NewConnMigrator(conn *pgx.Conn, migrations []emigrate.Migration) *emigrate.Migrator {
+	return emigrate.NewMigrator(db{conn}, migrations)
+}
+
+// querier is implemented by *pgx.Conn, *pgxpool.Pool, *pgxpool.Conn and
+// pgx.Tx
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// beginner is implemented by *pgx.Conn, *pgxpool.Pool and *pgxpool.Conn
+type beginner interface {
+	querier
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// db is an emigrate.NativeDB running on a pgx connection or pool
+type db struct {
+	db beginner
+}
+
+func (d db) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return exec(ctx, d.db, query, args)
+}
+
+func (d db) QueryContext(ctx context.Context, query string, args ...interface{}) (emigrate.Rows, error) {
+	return queryRows(ctx, d.db, query, args)
+}
+
+func (d db) QueryRowContext(ctx context.Context, query string, args ...interface{}) emigrate.Row {
+	return row{d.db.QueryRow(ctx, query, args...)}
+}
+
+func (d db) BeginTx(ctx context.Context, opts *sql.TxOptions) (emigrate.Tx, error) {
+	txOpts, err := txOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := d.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	return tx{t}, nil
+}
+
+// Session returns a connection acquired from the pool, for the statements
+// that must share a session, and a function to release it back to the pool.
+// A connection is its own session.
+func (d db) Session(ctx context.Context) (emigrate.NativeDB, func() error, error) {
+	pool, ok := d.db.(*pgxpool.Pool)
+	if !ok {
+		return d, func() error { return nil }, nil
+	}
+	c, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db{c}, func() error { c.Release(); return nil }, nil
+}
+
+// Dialect returns the dialect of the database, which is told apart from
+// CockroachDB and Redshift, which speak the protocol of PostgreSQL, by its
+// version
+func (d db) Dialect() emigrate.Dialect {
+	var version string
+	err := d.db.QueryRow(context.Background(), `SELECT version()`).Scan(&version)
+	if err == nil && strings.Contains(version, "CockroachDB") {
+		return emigrate.CockroachDialect{}
+	} else if err == nil && strings.Contains(version, "Redshift") {
+		return emigrate.RedshiftDialect{}
+	}
+	return emigrate.PostgresDialect{}
+}
+
+// txOptions returns the pgx options of a transaction begun with opts
+func txOptions(opts *sql.TxOptions) (pgx.TxOptions, error) {
+	var txOpts pgx.TxOptions
+	if opts == nil {
+		return txOpts, nil
+	}
+	switch opts.Isolation {
+	case sql.LevelDefault:
+	case sql.LevelReadUncommitted:
+		txOpts.IsoLevel = pgx.ReadUncommitted
+	case sql.LevelReadCommitted:
+		txOpts.IsoLevel = pgx.ReadCommitted
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		txOpts.IsoLevel = pgx.RepeatableRead
+	case sql.LevelSerializable:
+		txOpts.IsoLevel = pgx.Serializable
+	default:
+		return txOpts, fmt.Errorf("emigratepgx: Unsupported isolation level %s.", opts.Isolation)
+	}
+	if opts.ReadOnly {
+		txOpts.AccessMode = pgx.ReadOnly
+	}
+	return txOpts, nil
+}
+
+// tx is an emigrate.Tx running on a pgx transaction
+type tx struct {
+	tx pgx.Tx
+}
+
+func (t tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return exec(ctx, t.tx, query, args)
+}
+
+func (t tx) QueryContext(ctx context.Context, query string, args ...interface{}) (emigrate.Rows, error) {
+	return queryRows(ctx, t.tx, query, args)
+}
+
+func (t tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) emigrate.Row {
+	return row{t.tx.QueryRow(ctx, query, args...)}
+}
+
+func (t tx) Commit() error {
+	return t.tx.Commit(context.Background())
+}
+
+func (t tx) Rollback() error {
+	return t.tx.Rollback(context.Background())
+}
+
+// exec runs a statement on q. Statements without arguments are sent with the
+// simple protocol, so a script may hold several, as those of migrations do.
+func exec(ctx context.Context, q querier, query string, args []interface{}) (sql.Result, error) {
+	tag, err := q.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result(tag), nil
+}
+
+// queryRows runs a query on q
+func queryRows(ctx context.Context, q querier, query string, args []interface{}) (emigrate.Rows, error) {
+	r, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows{r}, nil
+}
+
+// result is the sql.Result of a statement, from its command tag
+type result pgconn.CommandTag
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("emigratepgx: LastInsertId is not supported by PostgreSQL.")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return pgconn.CommandTag(r).RowsAffected(), nil
+}
+
+// rows are the emigrate.Rows of a query
+type rows struct {
+	rows pgx.Rows
+}
+
+func (r rows) Next() bool {
+	return r.rows.Next()
+}
+
+func (r rows) Scan(dest ...interface{}) error {
+	return r.rows.Scan(dest...)
+}
+
+func (r rows) Close() error {
+	r.rows.Close()
+	return r.rows.Err()
+}
+
+func (r rows) Err() error {
+	return r.rows.Err()
+}
+
+// row is the emigrate.Row of a query, which returns sql.ErrNoRows rather
+// than pgx.ErrNoRows, as the Migrator expects
+type row struct {
+	row pgx.Row
+}
+
+func (r row) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sql.ErrNoRows
+	}
+	return err
+}
@@ -0,0 +1,303 @@
+package emigratepgx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/jnwhiteh/emigrate"
+)
+
+// fakeResult is the row returned by the queries containing query
+type fakeResult struct {
+	query string
+	row   []interface{}
+}
+
+// fakeConn is a pgx connection recording the statements run on it, and
+// those run in its transactions. Its queries return the row of the first of
+// results they match, or no rows.
+type fakeConn struct {
+	results      []fakeResult
+	statements   []string
+	txStatements []string
+	commits      int
+	rollbacks    int
+}
+
+func (c *fakeConn) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	c.statements = append(c.statements, sql)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (c *fakeConn) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return &fakeRows{row: c.result(sql)}, nil
+}
+
+func (c *fakeConn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &fakeRows{row: c.result(sql)}
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	return &fakeTx{conn: c}, nil
+}
+
+func (c *fakeConn) result(query string) []interface{} {
+	for _, result := range c.results {
+		if strings.Contains(query, result.query) {
+			return result.row
+		}
+	}
+	return nil
+}
+
+// fakeTx is a transaction of a fakeConn
+type fakeTx struct {
+	pgx.Tx
+	conn *fakeConn
+}
+
+func (t *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	t.conn.txStatements = append(t.conn.txStatements, sql)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (t *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return t.conn.Query(ctx, sql, args...)
+}
+
+func (t *fakeTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return t.conn.QueryRow(ctx, sql, args...)
+}
+
+func (t *fakeTx) Commit(ctx context.Context) error {
+	t.conn.commits++
+	return nil
+}
+
+func (t *fakeTx) Rollback(ctx context.Context) error {
+	t.conn.rollbacks++
+	return nil
+}
+
+// fakeRows are the rows of a query of a fakeConn, which has at most one
+type fakeRows struct {
+	pgx.Rows
+	row  []interface{}
+	read bool
+}
+
+func (r *fakeRows) Next() bool {
+	if r.row == nil || r.read {
+		return false
+	}
+	r.read = true
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	if r.row == nil {
+		return pgx.ErrNoRows
+	}
+	for i, value := range r.row {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+func (r *fakeRows) Close() {}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+// Verify that a Migrator runs the SQL of migrations, and records them, in
+// transactions of pgx.
+func TestMigrator(t *testing.T) {
+	conn := &fakeConn{results: []fakeResult{
+		{"SELECT version()", []interface{}{"PostgreSQL 16.2"}},
+		{"pg_is_in_recovery()", []interface{}{false}},
+		{"SELECT version FROM emigrate", []interface{}{int64(0)}},
+	}}
+	m := emigrate.NewMigrator(db{conn}, []emigrate.Migration{
+		emigrate.NewStringMigration(1, "CREATE TABLE invoices (id int); CREATE INDEX invoices_idx ON invoices (id)", ""),
+	})
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if conn.commits != 1 || conn.rollbacks != 0 {
+		t.Errorf("Expected the migration to commit, got %d commits and %d rollbacks", conn.commits, conn.rollbacks)
+	}
+	var ran bool
+	for _, statement := range conn.txStatements {
+		if strings.HasPrefix(statement, "CREATE TABLE invoices") {
+			ran = true
+		}
+	}
+	if !ran {
+		t.Errorf("Expected the migration to run in its transaction, got %q", conn.txStatements)
+	}
+}
+
+// Verify that migrations that are given a *sql.Tx cannot run on pgx.
+func TestMigratorSQLTx(t *testing.T) {
+	conn := &fakeConn{results: []fakeResult{
+		{"pg_is_in_recovery()", []interface{}{false}},
+		{"SELECT version FROM emigrate", []interface{}{int64(0)}},
+	}}
+	m := emigrate.NewMigrator(db{conn}, []emigrate.Migration{
+		emigrate.NewFunctionMigration(1, func(tx *sql.Tx) error { return nil }, nil),
+	})
+	m.Dialect = emigrate.PostgresDialect{}
+
+	if _, err := m.Upgrade(); !errors.Is(err, emigrate.SQLTxUnavailable) {
+		t.Errorf("Expected SQLTxUnavailable, got %v", err)
+	}
+	if conn.commits != 0 || conn.rollbacks != 1 {
+		t.Errorf("Expected the migration to roll back, got %d commits and %d rollbacks", conn.commits, conn.rollbacks)
+	}
+}
+
+func TestDialect(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected emigrate.Dialect
+	}{
+		{"PostgreSQL 16.2 on x86_64-pc-linux-gnu", emigrate.PostgresDialect{}},
+		{"CockroachDB CCL v23.1.11", emigrate.CockroachDialect{}},
+		{"PostgreSQL 8.0.2 on i686-pc-linux-gnu, Redshift 1.0.57", emigrate.RedshiftDialect{}},
+	}
+	for _, test := range tests {
+		conn := &fakeConn{results: []fakeResult{{"SELECT version()", []interface{}{test.version}}}}
+		if dialect := (db{conn}).Dialect(); dialect != test.expected {
+			t.Errorf("%s: expected %T, got %T", test.version, test.expected, dialect)
+		}
+	}
+}
+
+// Verify that queries returning no rows fail with sql.ErrNoRows, as the
+// Migrator expects, and that rows affected are reported.
+func TestQueries(t *testing.T) {
+	d := db{&fakeConn{}}
+	ctx := context.Background()
+	var version int64
+	if err := d.QueryRowContext(ctx, "SELECT version FROM emigrate").Scan(&version); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+	res, err := d.ExecContext(ctx, "UPDATE emigrate SET version = 1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	} else if rows, err := res.RowsAffected(); err != nil || rows != 1 {
+		t.Errorf("Expected 1 row affected, got %d and %v", rows, err)
+	}
+
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	} else if err := tx.QueryRowContext(ctx, "SELECT version FROM emigrate").Scan(&version); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestTxOptions(t *testing.T) {
+	tests := []struct {
+		opts     *sql.TxOptions
+		expected pgx.TxOptions
+	}{
+		{nil, pgx.TxOptions{}},
+		{&sql.TxOptions{}, pgx.TxOptions{}},
+		{&sql.TxOptions{Isolation: sql.LevelSerializable}, pgx.TxOptions{IsoLevel: pgx.Serializable}},
+		{&sql.TxOptions{Isolation: sql.LevelSnapshot}, pgx.TxOptions{IsoLevel: pgx.RepeatableRead}},
+		{&sql.TxOptions{ReadOnly: true}, pgx.TxOptions{AccessMode: pgx.ReadOnly}},
+	}
+	for _, test := range tests {
+		opts, err := txOptions(test.opts)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		} else if opts != test.expected {
+			t.Errorf("Expected %+v, got %+v", test.expected, opts)
+		}
+	}
+	if _, err := txOptions(&sql.TxOptions{Isolation: sql.LevelLinearizable}); err == nil {
+		t.Errorf("Expected an error for an unsupported isolation level")
+	}
+}
+
+// testDatabase returns the URL of the PostgreSQL database the integration
+// tests run against, skipping the test if none is configured
+func testDatabase(t *testing.T) string {
+	url := os.Getenv("EMIGRATE_PGX_DATABASE")
+	if url == "" {
+		t.Skip("EMIGRATE_PGX_DATABASE is not set")
+	}
+	return url
+}
+
+// dropTables drops the tracking tables of a Migrator using table
+func dropTables(ctx context.Context, q querier, table string) {
+	for _, suffix := range []string{"", "_applied", "_history", "_dirty", "_checkpoint", "_lock", "_repeatable"} {
+		q.Exec(ctx, "DROP TABLE IF EXISTS "+table+suffix)
+	}
+}
+
+// Verify that a Migrator made from a connection runs its migrations in the
+// session of the connection.
+func TestConnMigratorIntegration(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, testDatabase(t))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, "CREATE TEMPORARY TABLE emigratepgx_session (id int)"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	m := NewConnMigrator(conn, []emigrate.Migration{
+		emigrate.NewStringMigration(1, "INSERT INTO emigratepgx_session VALUES (1); INSERT INTO emigratepgx_session VALUES (2)", ""),
+	})
+	m.Table = "emigratepgx_conn_test"
+	defer dropTables(ctx, conn, m.Table)
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	var count int
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM emigratepgx_session").Scan(&count); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	} else if count != 2 {
+		t.Errorf("Expected the migration to insert 2 rows in the session, got %d", count)
+	}
+}
+
+// Verify that a Migrator made from a pool releases its connections back to
+// the pool.
+func TestMigratorIntegration(t *testing.T) {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, testDatabase(t))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer pool.Close()
+
+	m := NewMigrator(pool, []emigrate.Migration{
+		emigrate.NewStringMigration(1, "SELECT 1", ""),
+	})
+	m.Table = "emigratepgx_pool_test"
+	defer dropTables(ctx, pool, m.Table)
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if acquired := pool.Stat().AcquiredConns(); acquired != 0 {
+		t.Errorf("Expected all connections to be released, got %d acquired", acquired)
+	}
+}
@@ -0,0 +1,61 @@
+package emigrate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark some Windows editors put at the start of
+// UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// EncodingError indicates that a migration file is not valid UTF-8, such as a
+// file saved as UTF-16 or in a legacy code page, which would otherwise be
+// passed to the database as is and fail with a confusing syntax error.
+type EncodingError struct {
+	file   string // the name of the file
+	line   int    // the line of the first invalid byte, or 0 for the whole file
+	reason string // what is wrong with the encoding
+}
+
+func (e EncodingError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("emigrate: Invalid encoding of %q on line %d: %s", e.file, e.line, e.reason)
+	}
+	return fmt.Sprintf("emigrate: Invalid encoding of %q: %s", e.file, e.reason)
+}
+
+// decodeText returns the contents of the file name as text, without a UTF-8
+// byte order mark and with Windows and old Mac line endings replaced by "\n",
+// so that a migration reads, and is checksummed, the same whichever platform
+// it was written or checked out on. Migrations applied from files with other
+// line endings before they were normalized have changed checksums, which
+// Repair can record.
+func decodeText(name string, contents []byte) (string, error) {
+	if bytes.HasPrefix(contents, []byte{0xFF, 0xFE}) || bytes.HasPrefix(contents, []byte{0xFE, 0xFF}) {
+		return "", EncodingError{file: name, reason: "UTF-16 is not supported, save the file as UTF-8"}
+	}
+	contents = bytes.TrimPrefix(contents, utf8BOM)
+	if !utf8.Valid(contents) {
+		line := 1
+		for len(contents) > 0 {
+			r, size := utf8.DecodeRune(contents)
+			if r == utf8.RuneError && size <= 1 {
+				break
+			} else if r == '\n' {
+				line++
+			}
+			contents = contents[size:]
+		}
+		return "", EncodingError{file: name, line: line, reason: "not valid UTF-8"}
+	}
+
+	text := string(contents)
+	if strings.Contains(text, "\r") {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = strings.ReplaceAll(text, "\r", "\n")
+	}
+	return text, nil
+}
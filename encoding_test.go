@@ -0,0 +1,65 @@
+package emigrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDecodeText(t *testing.T) {
+	var valid = []struct {
+		contents string
+		expected string
+	}{
+		{"SELECT 1;\n", "SELECT 1;\n"},
+		{"\xEF\xBB\xBFSELECT 1;\r\nSELECT 2;\r\n", "SELECT 1;\nSELECT 2;\n"},
+		{"SELECT 1;\rSELECT 'é';\r", "SELECT 1;\nSELECT 'é';\n"},
+	}
+	for _, test := range valid {
+		text, err := decodeText("001.sql", []byte(test.contents))
+		if err != nil {
+			t.Errorf("Got unexpected error %#v", err)
+		} else if text != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, text)
+		}
+	}
+
+	var invalid = []struct {
+		contents string
+		line     int
+	}{
+		{"\xFF\xFES\x00E\x00", 0},
+		{"\xFE\xFF\x00S\x00E", 0},
+		{"SELECT 1;\nSELECT 'caf\xE9';\n", 2},
+	}
+	for _, test := range invalid {
+		_, err := decodeText("001.sql", []byte(test.contents))
+		var encErr EncodingError
+		if !errors.As(err, &encErr) {
+			t.Errorf("Expected an EncodingError for %q, got %v", test.contents, err)
+		} else if encErr.file != "001.sql" || encErr.line != test.line {
+			t.Errorf("Expected line %d of %q, got %#v", test.line, "001.sql", encErr)
+		}
+	}
+}
+
+// Verify that migration files are decoded when they are loaded, so that their
+// checksums do not depend on their line endings.
+func TestLoadDecodedFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_up.sql":   {Data: []byte("\xEF\xBB\xBF" + TestQueryCreateInvoiceTable + ";\r\n")},
+		"migrations/001_down.sql": {Data: []byte(TestQueryDropInvoiceTable + ";\n")},
+	}
+	ms, err := FSMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if sum := checksum(ms[0]); sum != checksumString(TestQueryCreateInvoiceTable+";\n") {
+		t.Errorf("Expected the checksum of the decoded file, got %q", sum)
+	}
+
+	fsys["migrations/002_up.sql"] = &fstest.MapFile{Data: []byte("INSERT INTO invoices VALUES ('\x96');")}
+	if _, err := FSMigrations(fsys, "migrations"); !errors.As(err, &EncodingError{}) {
+		t.Errorf("Expected an EncodingError, got %v", err)
+	}
+}
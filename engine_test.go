@@ -4,11 +4,29 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
+// the names of the default tracking tables
+const (
+	testTable        = "emigrate"
+	testAppliedTable = "emigrate_applied"
+	testHistoryTable = "emigrate_history"
+)
+
+// the queries used by a migrator with the default configuration
+var testQueries = DefaultQueries()
+
+// the columns of the history table
+var historyColumns = []string{"version", "name", "label", "checksum", "direction", "from_version",
+	"to_version", "applied_by", "db_user", "application", "hostname", "deploy_id",
+	"batch", "sql_text", "applied_at", "duration_ms", "success"}
+
 type mockMigration struct {
 	version int64 // the version of the migration
 	err     error // an error to be returned as the result of Upgrade (or nil)
@@ -32,7 +50,7 @@ func setupVersioned(t *testing.T, currentVersion int64) (*sqlmock.MockDB, Migrat
 	}
 	// Set the current version
 	result := fmt.Sprintf("%d", currentVersion)
-	mock.ExpectQuery(QueryGetCurrentVersion).
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
 		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString(result))
 	return mock, Migrator{db: db}
 }
@@ -45,7 +63,7 @@ func TestFailingToGetCurrentVersion(t *testing.T) {
 	}
 
 	dbErr := errors.New("db failed")
-	mock.ExpectQuery(QueryGetCurrentVersion).
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
 		WillReturnError(dbErr)
 	m := Migrator{db: db}
 
@@ -94,6 +112,7 @@ func TestFutureMigrationsApplied(t *testing.T) {
 	mock, m := setupVersioned(t, 2)
 	m.migrations = migrationRange(1, 2, 3, 4)
 
+	expectAppliedQuery(mock, 1, 2)
 	expectSetVersions(2, mock, 3, 4)
 	_, err := m.UpgradeToVersion(4)
 	if err != nil {
@@ -117,6 +136,7 @@ func TestFutureMigrationsAppliedAutomatic(t *testing.T) {
 	mock, m := setupVersioned(t, 2)
 	m.migrations = migrationRange(1, 2, 3, 4)
 
+	expectAppliedQuery(mock, 1, 2)
 	expectSetVersions(2, mock, 3, 4)
 	_, err := m.Upgrade()
 	if err != nil {
@@ -170,6 +190,200 @@ func TestFailedMigrationHalts(t *testing.T) {
 	mock.CloseTest(t)
 }
 
+func TestConcurrentVersionChangeDetected(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1, 2, 3)
+
+	// another migrator applied version 2 after we read the current version
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 2)
+	mock.ExpectRollback()
+
+	expected := MigrationVersionChanged
+	if _, result := m.UpgradeToVersion(3); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	if m.migrations[1].(*mockMigration).called {
+		t.Errorf("Migration called when it shouldn't have been")
+	}
+	mock.CloseTest(t)
+}
+
+func TestUpgradeResult(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1, 2, 3, 4)
+	expectSetVersions(1, mock, 2)
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 2)
+
+	expected := errors.New("migrate failed")
+	m.migrations[2].(*mockMigration).err = expected
+
+	result, err := m.Upgrade()
+	if err != expected {
+		t.Fatalf("Expected %v, got %v", expected, err)
+	}
+	if len(result.Migrations) != 2 {
+		t.Fatalf("Expected 2 migration results, got %v", result.Migrations)
+	}
+	if mr := result.Migrations[0]; mr.Version != 2 || mr.Status != StatusApplied || mr.Err != nil {
+		t.Errorf("Expected version 2 to be applied, got %v", mr)
+	}
+	if mr := result.Migrations[1]; mr.Version != 3 || mr.Status != StatusFailed || mr.Err != expected {
+		t.Errorf("Expected version 3 to fail, got %v", mr)
+	}
+	if applied := result.Applied(); len(applied) != 1 || applied[0] != 2 {
+		t.Errorf("Expected [2] applied, got %v", applied)
+	}
+	mock.CloseTest(t)
+}
+
+func TestOutOfOrderError(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 4)
+	m.migrations = migrationRange(1, 2, 3, 4)
+	expectAppliedQuery(mock, 1, 3, 4)
+
+	expected := OutOfOrderMigrationError{2, 4}
+	if _, result := m.Upgrade(); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	mock.CloseTest(t)
+}
+
+func TestOutOfOrderSkip(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 4)
+	m.migrations = migrationRange(1, 2, 3, 4)
+	m.OutOfOrder = OutOfOrderSkip
+	expectAppliedQuery(mock, 1, 3, 4)
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Unexpected error during migration: %s", err)
+	}
+	if len(result.Migrations) != 1 || result.Migrations[0].Status != StatusSkipped {
+		t.Errorf("Expected version 2 to be skipped, got %v", result.Migrations)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("Expected a warning, got %v", result.Warnings)
+	}
+	if m.migrations[1].(*mockMigration).called {
+		t.Errorf("Migration called when it shouldn't have been")
+	}
+	mock.CloseTest(t)
+}
+
+func TestOutOfOrderApply(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 4)
+	m.migrations = migrationRange(1, 2, 3, 4, 5)
+	m.OutOfOrder = OutOfOrderApply
+	expectAppliedQuery(mock, 1, 3, 4)
+
+	// the out-of-order migration doesn't change the current version
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 4)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.CountAppliedVersion(testAppliedTable))).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).FromCSVString("0"))
+	expectInsertApplied(mock, 2)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	expectSetVersions(4, mock, 5)
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Unexpected error during migration: %s", err)
+	}
+	if applied := result.Applied(); len(applied) != 2 || applied[0] != 2 || applied[1] != 5 {
+		t.Errorf("Expected [2 5] applied, got %v", applied)
+	}
+	mock.CloseTest(t)
+}
+
+func TestGapError(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1, 2, 5)
+
+	expected := VersionGapError{2, 5}
+	if _, result := m.Upgrade(); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	if m.migrations[1].(*mockMigration).called {
+		t.Errorf("Migration called when it shouldn't have been")
+	}
+	mock.CloseTest(t)
+}
+
+func TestGapWarn(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1, 2, 5)
+	m.Gaps = GapWarn
+	expectSetVersions(1, mock, 2, 5)
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Unexpected error during migration: %s", err)
+	}
+	if applied := result.Applied(); len(applied) != 2 {
+		t.Errorf("Expected [2 5] applied, got %v", applied)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("Expected a warning, got %v", result.Warnings)
+	}
+	mock.CloseTest(t)
+}
+
+func TestContinueOnError(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1, 2, 3, 4)
+	m.ContinueOnError = true
+
+	expected := errors.New("migrate failed")
+	m.migrations[2].(*mockMigration).err = expected
+
+	expectSetVersions(1, mock, 2)
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 2)
+	expectSetVersions(2, mock, 4)
+
+	result, err := m.Upgrade()
+	uerr, ok := err.(UpgradeError)
+	if !ok {
+		t.Fatalf("Expected upgrade error, got %v", err)
+	}
+	if len(uerr.Failed) != 1 || uerr.Failed[0].Version != 3 || uerr.Failed[0].Err != expected {
+		t.Errorf("Expected version 3 to fail, got %v", uerr.Failed)
+	}
+	if applied := result.Applied(); len(applied) != 2 || applied[0] != 2 || applied[1] != 4 {
+		t.Errorf("Expected [2 4] applied, got %v", applied)
+	}
+	mock.CloseTest(t)
+}
+
+func TestGuardedVersionUpdate(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1, 2)
+
+	// no row is updated as another migrator changed the version
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(2), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	expected := MigrationVersionChanged
+	if _, result := m.Upgrade(); result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	mock.CloseTest(t)
+}
+
 // Returns a slice of migrations at set version numbers, in the order
 // specified
 func migrationRange(versions ...int64) []Migration {
@@ -186,16 +400,283 @@ func expectSetVersions(current int64, mock *sqlmock.MockDB, versions ...int64) {
 	for _, version := range versions {
 		mock.ExpectBegin()
 		expectVersionQuery(mock, current)
-		statement := QuerySetVersion(version)
-		mock.ExpectExec(statement).
+		mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).
+			WithArgs(version, current).
 			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectInsertApplied(mock, version)
+		expectInsertHistory(mock)
 		current = version
 		mock.ExpectCommit()
 	}
 }
 
+func expectAppliedQuery(mock *sqlmock.MockDB, versions ...int64) {
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, version := range versions {
+		rows.AddRow(version)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetAppliedVersions(testAppliedTable))).WillReturnRows(rows)
+}
+
+func expectInsertApplied(mock *sqlmock.MockDB, version int64) {
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertAppliedVersion(testAppliedTable))).WithArgs(version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectHistoryQuery(mock *sqlmock.MockDB, entries ...HistoryEntry) {
+	rows := sqlmock.NewRows(historyColumns)
+	for _, entry := range entries {
+		rows.AddRow(entry.Version, entry.Name, entry.Label, entry.Checksum, entry.Direction,
+			entry.FromVersion, entry.ToVersion, entry.AppliedBy, entry.DBUser,
+			entry.Application, entry.Hostname, entry.DeployID, entry.Batch, entry.SQL, entry.AppliedAt,
+			int64(entry.Duration/time.Millisecond), entry.Success)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetHistory(testHistoryTable))).WillReturnRows(rows)
+}
+
+// expectHistoryEntry expects entry to be inserted into the history as part of
+// any batch, taking any amount of time
+func expectHistoryEntry(mock *sqlmock.MockDB, entry HistoryEntry) *sqlmock.ExpectedExec {
+	return mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertHistory(testHistoryTable, "CURRENT_USER"))).
+		WithArgs(entry.Version, entry.Name, entry.Label, entry.Checksum, entry.Direction,
+			entry.FromVersion, entry.ToVersion, entry.AppliedBy, entry.Application,
+			entry.Hostname, entry.DeployID, sqlmock.AnyArg(), entry.SQL, sqlmock.AnyArg(),
+			entry.Success)
+}
+
+func expectInsertHistory(mock *sqlmock.MockDB) {
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ` + testHistoryTable)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
 func expectVersionQuery(mock *sqlmock.MockDB, version int64) {
-	mock.ExpectQuery(QueryGetCurrentVersion).
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.LockCurrentVersion(testTable))).
 		WillReturnRows(sqlmock.NewRows([]string{"version"}).
-		FromCSVString(fmt.Sprintf("%d", version)))
+			FromCSVString(fmt.Sprintf("%d", version)))
+}
+
+func TestValidateValidMigrations(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 2)
+	m.migrations = []Migration{
+		stringMigration{version: 2, up: "up", down: "down"},
+		stringMigration{version: 1, up: "up", down: "down"},
+	}
+	expectAppliedQuery(mock, 1, 2)
+	expectHistoryQuery(mock)
+
+	if err := m.Validate(); err != nil {
+		t.Errorf("Expected %v, got %v", nil, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 4)
+	m.migrations = []Migration{
+		stringMigration{version: 1, up: "up", down: "down"},
+		stringMigration{version: 2, up: "up", down: "down"},
+		stringMigration{version: 2, up: "up", down: "down"},
+		stringMigration{version: 5, up: "up", down: ""},
+	}
+	expectAppliedQuery(mock, 1, 2)
+	expectHistoryQuery(mock)
+
+	err := m.Validate()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Expected validation error, got %v", err)
+	}
+	expected := []error{
+		DuplicateMigrationError{direction: "up", version: 2},
+		VersionGapError{2, 5},
+		MissingMigrationError{"down", 5},
+		MissingCurrentMigration,
+	}
+	if len(verr.Errors) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), verr.Errors)
+	}
+	for idx, val := range expected {
+		if verr.Errors[idx] != val {
+			t.Errorf("Error %d: expected %v, got %v", idx, val, verr.Errors[idx])
+		}
+	}
+	mock.CloseTest(t)
+}
+
+type isolatedMigration struct {
+	mockMigration
+	opts *sql.TxOptions
+}
+
+func (im *isolatedMigration) TxOptions() *sql.TxOptions {
+	return im.opts
+}
+
+func TestTxOptions(t *testing.T) {
+	t.Parallel()
+	m := Migrator{TxOptions: &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true}}
+
+	opts := m.txOptions(&mockMigration{version: 1})
+	if opts.Isolation != sql.LevelReadCommitted || opts.ReadOnly {
+		t.Errorf("Expected read committed, read-write options, got %+v", opts)
+	}
+
+	serializable := &isolatedMigration{opts: &sql.TxOptions{Isolation: sql.LevelSerializable}}
+	opts = m.txOptions(serializable)
+	if opts.Isolation != sql.LevelSerializable {
+		t.Errorf("Expected %v, got %v", sql.LevelSerializable, opts.Isolation)
+	}
+
+	if opts = (&Migrator{}).txOptions(&mockMigration{version: 1}); opts != nil {
+		t.Errorf("Expected no options, got %+v", opts)
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	dbErr := errors.New("no such table: emigrate")
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnError(dbErr)
+	m := Migrator{db: db}
+
+	_, err = m.CurrentVersion()
+	if nie, ok := err.(NotInitializedError); !ok || nie.Unwrap() != dbErr {
+		t.Errorf("Expected not initialized error, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func expectCreateTables(mock *sqlmock.MockDB) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateTable(testTable))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertVersion(testTable))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateAppliedTable(testAppliedTable))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateHistoryTable(testHistoryTable))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+// Verify that Init creates the tables of a fresh database.
+func TestInitCreatesTables(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnError(errors.New("no such table: emigrate"))
+	expectCreateTables(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	expectAppliedQuery(mock)
+	expectHistoryQuery(mock)
+
+	if err := m.Init(); err != nil {
+		t.Errorf("Unexpected error during init: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that Init succeeds when another process creates the tables first.
+func TestInitConcurrent(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnError(errors.New("no such table: emigrate"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateTable(testTable))).
+		WillReturnError(errors.New("duplicate key value violates unique constraint"))
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("3"))
+	expectAppliedQuery(mock, 1, 2, 3)
+	expectHistoryQuery(mock)
+
+	if err := m.Init(); err != nil {
+		t.Errorf("Unexpected error during init: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a failed rollback is reported along with the error that caused
+// it.
+func TestInitRollbackFailure(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+
+	missing := errors.New("no such table: emigrate")
+	createErr := errors.New("permission denied")
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).WillReturnError(missing)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateTable(testTable))).
+		WillReturnError(createErr)
+	mock.ExpectRollback().WillReturnError(errors.New("connection reset"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).WillReturnError(missing)
+
+	err = m.Init()
+	if !errors.Is(err, createErr) || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("Expected creation and rollback errors, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestApplied(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+	expectAppliedQuery(mock, 3, 1, 2, 5)
+
+	versions, err := m.Applied()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []int64{1, 2, 3, 5}
+	if fmt.Sprint(versions) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, versions)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the tracking database is used to read the version, while the
+// migration runs in a transaction on the migration database.
+func TestTrackingDB(t *testing.T) {
+	t.Parallel()
+	trackingMock, m := setupVersioned(t, 0)
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m.migrations = migrationRange(1)
+	m.TrackingDB = m.db
+	m.db = db
+	expectSetVersions(0, mock, 1)
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error during upgrade: %s", err)
+	}
+	mock.CloseTest(t)
+	trackingMock.CloseTest(t)
 }
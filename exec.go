@@ -0,0 +1,121 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// execExtensions are the extensions of executable migration files
+var execExtensions = map[string]bool{"sh": true, "bin": true}
+
+// Executables recognizes .sh and .bin files, such as 001_load_invoices.up.sh,
+// as migrations that run the file as an executable, for the rare migrations
+// that must use an external tool, such as psql \copy or mysqlimport. They can
+// only be loaded from a directory on disk by MigrationsFromDir, and a
+// manifest can mark files with other extensions, added by WithExtension, as
+// executables.
+//
+// The executable runs with the environment of the process, with env added,
+// which should give it what it needs to connect to the database, such as
+// DATABASE_URL=... It is also given EMIGRATE_VERSION and EMIGRATE_DIRECTION.
+// It does not run in the transaction of the migration, so its changes are not
+// rolled back if the migration fails. It is killed if the migration times
+// out, as described by Timeouter.
+func Executables(env ...string) DirOption {
+	return func(o *dirOptions) {
+		o.exec = true
+		o.execEnv = env
+	}
+}
+
+// execMigration returns the migration for a version of s whose files are
+// executables
+func (s *fileSource) execMigration(version int64) (Migration, error) {
+	files := s.files[version]
+	if s.osDir == "" {
+		return nil, fmt.Errorf("emigrate: Executable migration %q must be loaded from a directory.", files["up"].path())
+	}
+	m := &execMigration{
+		version: version,
+		up:      filepath.Join(s.osDir, filepath.FromSlash(files["up"].path())),
+		env:     s.execEnv,
+	}
+	if down := files["down"]; down != nil {
+		m.down = filepath.Join(s.osDir, filepath.FromSlash(down.path()))
+	}
+	m.name = s.Name(version)
+	return m, nil
+}
+
+// execMigration is an implementation of Migration that runs an executable to
+// upgrade and downgrade
+type execMigration struct {
+	version int64
+	up      string   // the path of the upgrade executable
+	down    string   // the path of the downgrade executable, if any
+	env     []string // added to the environment of the executables
+	migrationOptions
+}
+
+func (m *execMigration) Version() int64 {
+	return m.version
+}
+
+// Checksum returns the checksum of the upgrade executable, or "" if it cannot
+// be read. Verification reads it with readChecksum instead, which returns the
+// error.
+func (m *execMigration) Checksum() string {
+	sum, _ := m.readChecksum()
+	return sum
+}
+
+// readChecksum returns the checksum of the upgrade executable
+func (m *execMigration) readChecksum() (string, error) {
+	contents, err := os.ReadFile(m.up)
+	if err != nil {
+		return "", err
+	}
+	return checksumString(string(contents)), nil
+}
+
+func (m *execMigration) Upgrade(tx *sql.Tx) error {
+	return m.run(context.Background(), "up")
+}
+
+func (m *execMigration) Downgrade(tx *sql.Tx) error {
+	return m.run(context.Background(), "down")
+}
+
+// execWaitDelay is how long an executable killed as its migration was
+// cancelled is waited for, before its output is abandoned to any processes
+// it started
+const execWaitDelay = 5 * time.Second
+
+// run runs the executable migrating in the given direction, killing it if
+// ctx is done first, such as when the migration times out
+func (m *execMigration) run(ctx context.Context, direction string) error {
+	name := m.up
+	if direction == "down" {
+		if name = m.down; name == "" {
+			return fmt.Errorf("emigrate: No downgrade defined for migration %d", m.version)
+		}
+	}
+	cmd := exec.CommandContext(ctx, name)
+	cmd.WaitDelay = execWaitDelay
+	cmd.Env = append(os.Environ(), m.env...)
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("EMIGRATE_VERSION=%d", m.version),
+		"EMIGRATE_DIRECTION="+direction)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("emigrate: Executable %q of migration %d was stopped: %w\n%s", name, m.version, ctx.Err(), output)
+	} else if err != nil {
+		return fmt.Errorf("emigrate: Executable %q of migration %d failed: %w\n%s", name, m.version, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package emigrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testScript = `#!/bin/sh
+echo "$EMIGRATE_DIRECTION $EMIGRATE_VERSION $DATABASE_URL" >> "$(dirname "$0")/ran.txt"
+`
+
+func TestExecutables(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"001_load_invoices.up.sh", "001_load_invoices.down.sh"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(testScript), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ms, err := MigrationsFromDir(dir)
+	if err != nil || len(ms) != 0 {
+		t.Errorf("Expected executables to be ignored without the option, got %v, %v", ms, err)
+	}
+
+	ms, err = MigrationsFromDir(dir, Executables("DATABASE_URL=postgres://localhost/billing"))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 1 || migrationName(ms[0]) != "load_invoices" || !canDowngrade(ms[0]) {
+		t.Fatalf("Unexpected migrations %#v", ms)
+	}
+	if err := ms[0].Upgrade(nil); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if err := ms[0].(Downgrader).Downgrade(nil); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+
+	ran, err := os.ReadFile(filepath.Join(dir, "ran.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "up 1 postgres://localhost/billing\ndown 1 postgres://localhost/billing\n"
+	if string(ran) != expected {
+		t.Errorf("Expected %q, got %q", expected, ran)
+	}
+}
+
+func TestExecutableFailure(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho 'mysqlimport: connection refused'\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "001_up.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := MigrationsFromDir(dir, Executables())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	err = ms[0].Upgrade(nil)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Expected the output of the executable in the error, got %v", err)
+	}
+}
+
+// Verify that an executable is killed once its migration times out.
+func TestExecutableTimeout(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "001_up.sh"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := MigrationsFromDir(dir, Executables())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	mock, m := setupVersioned(t, 0)
+	m.migrations = ms
+	m.Timeout = 50 * time.Millisecond
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	start := time.Now()
+	if _, err := m.UpgradeToVersion(1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the executable to time out, got %v", err)
+	}
+	mock.CloseTest(t)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the executable to be killed, ran for %s", elapsed)
+	}
+}
+
+// Verify that executables cannot be loaded from a file system other than a
+// directory on disk.
+func TestExecutablesNotOnDisk(t *testing.T) {
+	fsys := fstest.MapFS{"migrations/001_up.sh": {Data: []byte(testScript)}}
+	if _, err := FSMigrations(fsys, "migrations", Executables()); err == nil {
+		t.Errorf("Expected an error loading an executable from a file system")
+	}
+}
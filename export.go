@@ -0,0 +1,50 @@
+package emigrate
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// ExportedMigration is an applied migration as written by ExportHistory
+type ExportedMigration struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name,omitempty"`
+	Label     string    `json:"label,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// ExportHistory writes the migrations currently applied to the database to w
+// as a JSON array of ExportedMigration, oldest version first. The name,
+// label, checksum and time of each are taken from the latest successful
+// upgrade in the history, and are left empty for migrations applied before
+// the history was kept. The applied versions of the export can be imported
+// into another database with JSONImporter.
+func (m *Migrator) ExportHistory(w io.Writer) error {
+	versions, err := m.Applied()
+	if err != nil {
+		return err
+	}
+	entries, err := m.History()
+	if err != nil {
+		return err
+	}
+
+	recorded := latestUpgrades(entries, false)
+	exported := make([]ExportedMigration, len(versions))
+	for idx, version := range versions {
+		entry := recorded[version]
+		exported[idx] = ExportedMigration{
+			Version:   version,
+			Name:      entry.Name,
+			Label:     entry.Label,
+			Checksum:  entry.Checksum,
+			AppliedAt: entry.AppliedAt,
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(exported)
+}
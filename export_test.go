@@ -0,0 +1,49 @@
+package emigrate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExportHistory(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+
+	appliedAt := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAppliedQuery(mock, 2, 1)
+	expectHistoryQuery(mock,
+		HistoryEntry{Version: 2, Name: "add_invoices", Checksum: "abc", Direction: "up",
+			FromVersion: 1, ToVersion: 2, AppliedAt: appliedAt, Success: true},
+		HistoryEntry{Version: 3, Direction: "up", FromVersion: 2, ToVersion: 2,
+			AppliedAt: appliedAt, Success: false},
+	)
+
+	var buf bytes.Buffer
+	if err := m.ExportHistory(&buf); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `[
+  {
+    "version": 1,
+    "applied_at": "0001-01-01T00:00:00Z"
+  },
+  {
+    "version": 2,
+    "name": "add_invoices",
+    "checksum": "abc",
+    "applied_at": "2014-05-01T12:00:00Z"
+  }
+]
+`
+	if buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+	mock.CloseTest(t)
+}
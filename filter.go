@@ -0,0 +1,68 @@
+package emigrate
+
+import "path"
+
+// Filter selects the migrations to include, such as only data migrations or
+// only those below a cutoff version for a staged rollout.
+type Filter func(m Migration) bool
+
+// FilterMigrations returns the migrations of ms selected by all of filters,
+// in the same order.
+func FilterMigrations(ms []Migration, filters ...Filter) []Migration {
+	var selected []Migration
+outer:
+	for _, m := range ms {
+		for _, filter := range filters {
+			if !filter(m) {
+				continue outer
+			}
+		}
+		selected = append(selected, m)
+	}
+	return selected
+}
+
+// WithFilter only loads the migrations selected by all of filters.
+func WithFilter(filters ...Filter) DirOption {
+	return func(o *dirOptions) {
+		o.filters = append(o.filters, filters...)
+	}
+}
+
+// VersionRange selects the migrations with versions from min to max,
+// inclusive. A max of 0 selects every version from min. Repeatable
+// migrations have no version, so are always selected.
+func VersionRange(min, max int64) Filter {
+	return func(m Migration) bool {
+		return isRepeatable(m) || m.Version() >= min && (max == 0 || m.Version() <= max)
+	}
+}
+
+// NameGlob selects the migrations with names matching pattern, using the
+// syntax of path.Match, such as "data_*". A malformed pattern selects none.
+func NameGlob(pattern string) Filter {
+	return func(m Migration) bool {
+		ok, _ := path.Match(pattern, migrationName(m))
+		return ok
+	}
+}
+
+// Labels selects the migrations with any of labels.
+func Labels(labels ...string) Filter {
+	return func(m Migration) bool {
+		label := migrationLabel(m)
+		for _, l := range labels {
+			if l == label {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Exclude selects the migrations that filter does not.
+func Exclude(filter Filter) Filter {
+	return func(m Migration) bool {
+		return !filter(m)
+	}
+}
@@ -0,0 +1,76 @@
+package emigrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func filterVersions(ms []Migration) []int64 {
+	versions := make([]int64, len(ms))
+	for idx, m := range ms {
+		versions[idx] = m.Version()
+	}
+	return versions
+}
+
+func TestFilterMigrations(t *testing.T) {
+	ms := []Migration{
+		NewStringMigration(1, "", "", WithName("create_invoice")),
+		NewStringMigration(2, "", "", WithName("data_backfill_invoices"), WithLabel("2024-Q3")),
+		NewStringMigration(3, "", "", WithName("add_invoice_indexes"), WithLabel("2024-Q3")),
+		NewStringMigration(4, "", "", WithName("data_fix_totals"), WithLabel("2024-Q4")),
+	}
+
+	var tests = []struct {
+		filters  []Filter
+		expected []int64
+	}{
+		{nil, []int64{1, 2, 3, 4}},
+		{[]Filter{VersionRange(2, 3)}, []int64{2, 3}},
+		{[]Filter{VersionRange(3, 0)}, []int64{3, 4}},
+		{[]Filter{NameGlob("data_*")}, []int64{2, 4}},
+		{[]Filter{NameGlob("[")}, []int64{}},
+		{[]Filter{Labels("2024-Q3")}, []int64{2, 3}},
+		{[]Filter{Exclude(Labels("2024-Q3", "2024-Q4"))}, []int64{1}},
+		{[]Filter{NameGlob("data_*"), VersionRange(0, 3)}, []int64{2}},
+	}
+
+	for idx, test := range tests {
+		result := filterVersions(FilterMigrations(ms, test.filters...))
+		if len(result) != len(test.expected) {
+			t.Errorf("%d: Expected %v, got %v", idx, test.expected, result)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("%d: Expected %v, got %v", idx, test.expected, result)
+				break
+			}
+		}
+	}
+}
+
+func TestFSMigrationsFilter(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.up.sql":  {Data: []byte("")},
+		"migrations/002_data_backfill.up.sql":   {Data: []byte("")},
+		"migrations/003_add_indexes.up.sql":     {Data: []byte("")},
+		"migrations/004_data_fix_totals.up.sql": {Data: []byte("")},
+	}
+
+	ms, err := FSMigrations(fsys, "migrations", WithFilter(NameGlob("data_*")), WithFilter(VersionRange(0, 3)))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if versions := filterVersions(ms); len(versions) != 1 || versions[0] != 2 {
+		t.Errorf("Expected version 2, got %v", versions)
+	}
+
+	ms, err = FSMigrations(fsys, "migrations", Lazy(), WithFilter(VersionRange(3, 0)))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if versions := filterVersions(ms); len(versions) != 2 || versions[0] != 3 {
+		t.Errorf("Expected versions 3 and 4, got %v", versions)
+	}
+}
@@ -0,0 +1,192 @@
+package emigrate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FleetTarget is a database migrated by a Fleet, such as the database of one
+// tenant.
+type FleetTarget struct {
+	Name string
+	DB   DB
+}
+
+// Fleet applies the same migrations to many databases, such as one database
+// per tenant. Each target has a Migrator of its own.
+type Fleet struct {
+	targets   []FleetTarget
+	migrators []*Migrator
+
+	// Parallel is how many targets are upgraded at once. If zero, they are
+	// upgraded one at a time, in the order they were given.
+	Parallel int
+
+	// ContinueOnError causes an upgrade to carry on with the other targets
+	// when a target fails, rather than starting no more of them. Targets
+	// already being upgraded are always finished.
+	ContinueOnError bool
+}
+
+// NewFleet returns a Fleet applying migrations to targets, which must have
+// unique, non-empty names. The Migrator of each target can be configured
+// through Migrator.
+func NewFleet(targets []FleetTarget, migrations []Migration) (*Fleet, error) {
+	f := &Fleet{targets: targets}
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target.Name == "" {
+			return nil, fmt.Errorf("emigrate: Fleet target has no name.")
+		} else if seen[target.Name] {
+			return nil, fmt.Errorf("emigrate: Duplicate fleet target %q.", target.Name)
+		}
+		seen[target.Name] = true
+		f.migrators = append(f.migrators, NewMigrator(target.DB, migrations))
+	}
+	return f, nil
+}
+
+// Migrator returns the Migrator of the named target, or nil if there is
+// none.
+func (f *Fleet) Migrator(name string) *Migrator {
+	for idx, target := range f.targets {
+		if target.Name == name {
+			return f.migrators[idx]
+		}
+	}
+	return nil
+}
+
+// TargetStatus describes what became of a target in an upgrade of a Fleet
+type TargetStatus int
+
+const (
+	TargetUpgraded TargetStatus = iota // the target was upgraded
+	TargetFailed                       // the target failed to upgrade
+	TargetSkipped                      // the target was not started after another failed
+)
+
+func (s TargetStatus) String() string {
+	switch s {
+	case TargetUpgraded:
+		return "upgraded"
+	case TargetFailed:
+		return "failed"
+	case TargetSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("TargetStatus(%d)", int(s))
+}
+
+// TargetResult describes the upgrade of a target of a Fleet.
+type TargetResult struct {
+	Name    string // the name of the target
+	Status  TargetStatus
+	*Result       // nil if the target was skipped
+	Err     error // the error upgrading the target, if it failed
+}
+
+// FleetError indicates that one or more targets of a Fleet failed to
+// upgrade, and describes each of them.
+type FleetError struct {
+	Failed []TargetResult
+}
+
+func (e FleetError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for idx, tr := range e.Failed {
+		msgs[idx] = fmt.Sprintf("target %q: %s", tr.Name, tr.Err)
+	}
+	return fmt.Sprintf("emigrate: %d fleet targets failed to upgrade:\n\t%s", len(e.Failed), strings.Join(msgs, "\n\t"))
+}
+
+// Unwrap allows errors.Is and errors.As to inspect the individual errors
+func (e FleetError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for idx, tr := range e.Failed {
+		errs[idx] = tr.Err
+	}
+	return errs
+}
+
+// Init initializes the tracking tables of every target, stopping at the
+// first that fails unless ContinueOnError is set.
+func (f *Fleet) Init() error {
+	_, err := f.each(func(m *Migrator) (*Result, error) {
+		return nil, m.Init()
+	})
+	return err
+}
+
+// Upgrade upgrades the targets, returning the result of each in the order
+// the targets were given. Once a target fails, no more are started unless
+// ContinueOnError is set, and those not started are skipped. If any target
+// failed, a FleetError describes them.
+func (f *Fleet) Upgrade() ([]TargetResult, error) {
+	return f.each(func(m *Migrator) (*Result, error) {
+		return m.Upgrade()
+	})
+}
+
+// each runs fn with the Migrator of every target, as Upgrade describes
+func (f *Fleet) each(fn func(m *Migrator) (*Result, error)) ([]TargetResult, error) {
+	results := make([]TargetResult, len(f.targets))
+	for idx, target := range f.targets {
+		results[idx] = TargetResult{Name: target.Name, Status: TargetSkipped}
+	}
+	forEach(len(f.targets), f.Parallel, !f.ContinueOnError, func(idx int) error {
+		result, err := fn(f.migrators[idx])
+		results[idx].Result, results[idx].Err = result, err
+		if err != nil {
+			results[idx].Status = TargetFailed
+		} else {
+			results[idx].Status = TargetUpgraded
+		}
+		return err
+	})
+
+	var failed []TargetResult
+	for _, tr := range results {
+		if tr.Status == TargetFailed {
+			failed = append(failed, tr)
+		}
+	}
+	if len(failed) > 0 {
+		return results, FleetError{failed}
+	}
+	return results, nil
+}
+
+// forEach calls fn for each index up to count, running up to parallel calls
+// at once, or one at a time in order if parallel is zero. Once a call fails,
+// no more are started if stopOnError is set.
+func forEach(count, parallel int, stopOnError bool, fn func(idx int) error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+	for idx := 0; idx < count; idx++ {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := failed && stopOnError
+		mu.Unlock()
+		if stop {
+			break
+		}
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			if err := fn(idx); err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+			<-sem
+		}(idx)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,67 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newFailingFleet returns a Fleet of two targets, expecting the version
+// query of the first to fail, and of the second if it is started
+func newFailingFleet(t *testing.T, started bool) (*Fleet, []*sqlmock.MockDB) {
+	var targets []FleetTarget
+	var mocks []*sqlmock.MockDB
+	for idx, name := range []string{"tenant_a", "tenant_b"} {
+		mock, db, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Error creating mock: %s", err)
+		}
+		if idx == 0 || started {
+			mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+				WillReturnError(errors.New("connection refused"))
+		}
+		targets = append(targets, FleetTarget{name, db})
+		mocks = append(mocks, mock)
+	}
+	f, err := NewFleet(targets, migrationRange(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return f, mocks
+}
+
+// Verify that no more targets are started once one fails.
+func TestFleetStopOnError(t *testing.T) {
+	f, mocks := newFailingFleet(t, false)
+
+	results, err := f.Upgrade()
+	if fe, ok := err.(FleetError); !ok || len(fe.Failed) != 1 || fe.Failed[0].Name != "tenant_a" {
+		t.Errorf("Expected tenant_a to fail, got %v", err)
+	}
+	if len(results) != 2 || results[0].Status != TargetFailed || results[1].Status != TargetSkipped {
+		t.Errorf("Expected tenant_b to be skipped, got %v", results)
+	}
+	for _, mock := range mocks {
+		mock.CloseTest(t)
+	}
+}
+
+// Verify that every target is started with ContinueOnError, in parallel.
+func TestFleetContinueOnError(t *testing.T) {
+	f, mocks := newFailingFleet(t, true)
+	f.ContinueOnError = true
+	f.Parallel = 2
+
+	results, err := f.Upgrade()
+	if fe, ok := err.(FleetError); !ok || len(fe.Failed) != 2 {
+		t.Errorf("Expected both targets to fail, got %v", err)
+	}
+	if len(results) != 2 || results[1].Name != "tenant_b" || results[1].Status != TargetFailed {
+		t.Errorf("Expected the results in order of the targets, got %v", results)
+	}
+	for _, mock := range mocks {
+		mock.CloseTest(t)
+	}
+}
@@ -1,17 +1,33 @@
 package emigrate
 
-import "database/sql"
+import (
+	"database/sql"
+	"fmt"
+)
 
-// functionMigration is an implementaiton of Migration that performs all
+// functionMigration is an implementation of Migration that performs all
 // upgrade and downgrade actions with Go functions.
 type functionMigration struct {
 	version int64                  // the version number of the migration
 	up      func(tx *sql.Tx) error // the function to run on upgrade
 	down    func(tx *sql.Tx) error // the function to run on downgrade
+	migrationOptions
 }
 
-func NewFunctionMigration(version int64, up, down func(tx *sql.Tx) error) Migration {
-	return &functionMigration{version, up, down}
+// NewFunctionMigration returns a migration that runs Go functions, for changes
+// that are awkward to express in SQL, such as transforming data. The
+// functions run in the transaction of the migration, and down may be nil if
+// the migration cannot be downgraded. Its checksum is declared with
+// WithChecksum.
+func NewFunctionMigration(version int64, up, down func(tx *sql.Tx) error, opts ...MigrationOption) Migration {
+	m := &functionMigration{version: version, up: up, down: down}
+	m.set(opts)
+	return m
+}
+
+// Checksum returns the declared checksum of the migration
+func (m *functionMigration) Checksum() string {
+	return m.checksum
 }
 
 func (m *functionMigration) Version() int64 {
@@ -23,5 +39,8 @@ func (m *functionMigration) Upgrade(tx *sql.Tx) error {
 }
 
 func (m *functionMigration) Downgrade(tx *sql.Tx) error {
+	if m.down == nil {
+		return fmt.Errorf("emigrate: No downgrade defined for migration %d", m.version)
+	}
 	return m.down(tx)
 }
@@ -10,7 +10,7 @@ import (
 
 func TestVersionFunctionMigration(t *testing.T) {
 	var expected int64 = 1
-	m := functionMigration{expected, nil, nil}
+	m := functionMigration{version: expected}
 
 	result := m.Version()
 	if result != expected {
@@ -23,12 +23,12 @@ func TestVersionFunctionMigration(t *testing.T) {
 func TestUpgradeFunctionMigration(t *testing.T) {
 	mock, m := setupVersioned(t, 0)
 	v1 := &functionMigration{
-		1,
-		func(tx *sql.Tx) error {
+		version: 1,
+		up: func(tx *sql.Tx) error {
 			_, err := tx.Exec(TestQueryCreateInvoiceTable)
 			return err
 		},
-		func(tx *sql.Tx) error {
+		down: func(tx *sql.Tx) error {
 			_, err := tx.Exec(TestQueryDropInvoiceTable)
 			return err
 		},
@@ -39,8 +39,10 @@ func TestUpgradeFunctionMigration(t *testing.T) {
 	expectVersionQuery(mock, 0)
 	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
 		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec(QuerySetVersion(1)).
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
 	mock.ExpectCommit()
 
 	_, err := m.UpgradeToVersion(1)
@@ -49,3 +51,24 @@ func TestUpgradeFunctionMigration(t *testing.T) {
 	}
 	mock.CloseTest(t)
 }
+
+func TestChecksumFunctionMigration(t *testing.T) {
+	if sum := NewFunctionMigration(1, nil, nil).(Checksummed).Checksum(); sum != "" {
+		t.Errorf("Expected no checksum, got %s", sum)
+	}
+	m := NewFunctionMigration(1, nil, nil, WithChecksum("v2"))
+	if sum := m.(Checksummed).Checksum(); sum != "v2" {
+		t.Errorf("Expected %s, got %s", "v2", sum)
+	}
+}
+
+// Verify that a function migration without a downgrade cannot be downgraded.
+func TestDowngradeFunctionMigrationWithoutDown(t *testing.T) {
+	m := NewFunctionMigration(1, func(tx *sql.Tx) error { return nil }, nil)
+	if canDowngrade(m) {
+		t.Errorf("Expected the migration to have no downgrade")
+	}
+	if err := m.(Downgrader).Downgrade(nil); err == nil {
+		t.Errorf("Expected an error downgrading a migration without a downgrade")
+	}
+}
@@ -0,0 +1,52 @@
+package emigrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// TimestampFormat is the layout of timestamp versions, such as
+// 20240815123045. Migrations created on parallel branches are unlikely to
+// collide on a timestamp version, but the versions are not contiguous, so a
+// Migrator using them needs Gaps set to GapIgnore.
+const TimestampFormat = "20060102150405"
+
+// TimestampVersion returns the timestamp version for t, taken in UTC
+func TimestampVersion(t time.Time) int64 {
+	version, _ := strconv.ParseInt(t.UTC().Format(TimestampFormat), 10, 64)
+	return version
+}
+
+// slugRegexp matches the slugs that can be used in migration file names
+var slugRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CreateMigration creates empty up and down files in dir for a new migration
+// described by slug and versioned by the current time, such as
+// 20240815123045_add_invoices_up.sql, returning the paths of the files. It
+// fails rather than overwrite existing files.
+func CreateMigration(dir, slug string) (up, down string, err error) {
+	return createMigration(dir, slug, time.Now())
+}
+
+func createMigration(dir, slug string, t time.Time) (up, down string, err error) {
+	if !slugRegexp.MatchString(slug) {
+		return "", "", fmt.Errorf("emigrate: Invalid migration name %q.", slug)
+	}
+
+	prefix := filepath.Join(dir, fmt.Sprintf("%d_%s", TimestampVersion(t), slug))
+	up, down = prefix+"_up.sql", prefix+"_down.sql"
+	for _, name := range []string{up, down} {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", "", err
+		}
+	}
+	return up, down, nil
+}
@@ -0,0 +1,56 @@
+package emigrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimestampVersion(t *testing.T) {
+	at := time.Date(2024, 8, 15, 14, 30, 45, 0, time.FixedZone("CEST", 2*60*60))
+	var expected int64 = 20240815123045
+	if version := TimestampVersion(at); version != expected {
+		t.Errorf("Expected %d, got %d", expected, version)
+	}
+}
+
+// Verify that created migrations are found with their timestamp version and
+// name.
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 8, 15, 12, 30, 45, 0, time.UTC)
+
+	up, down, err := createMigration(dir, "add_invoices", at)
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if expected := filepath.Join(dir, "20240815123045_add_invoices_up.sql"); up != expected {
+		t.Errorf("Expected %s, got %s", expected, up)
+	}
+	if expected := filepath.Join(dir, "20240815123045_add_invoices_down.sql"); down != expected {
+		t.Errorf("Expected %s, got %s", expected, down)
+	}
+
+	ms, err := MigrationsFromDir(dir)
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 1 || ms[0].Version() != 20240815123045 || migrationName(ms[0]) != "add_invoices" {
+		t.Errorf("Unexpected migrations %#v", ms)
+	}
+
+	// creating the same migration again must not overwrite it
+	if err := os.WriteFile(up, []byte(TestQueryCreateInvoiceTable), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := createMigration(dir, "add_invoices", at); err == nil {
+		t.Errorf("Expected an error creating an existing migration")
+	}
+}
+
+func TestCreateMigrationInvalidName(t *testing.T) {
+	if _, _, err := createMigration(t.TempDir(), "add invoices", time.Now()); err == nil {
+		t.Errorf("Expected an error for an invalid name")
+	}
+}
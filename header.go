@@ -0,0 +1,113 @@
+package emigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// headerRegexp matches the directive lines in the header of a migration file,
+// which set how the migration is run, as in
+//
+//	-- emigrate:no-transaction
+//	-- emigrate:timeout=5m
+//	CREATE INDEX CONCURRENTLY invoices_customer_idx ON invoices (customer_id);
+//
+// The header is made up of the comment and blank lines at the start of the
+// file. The directives are:
+//
+//	no-transaction  run the migration outside of a transaction, as described
+//	                by Transactional
+//	timeout=<d>     cancel the migration once it has run for the duration d,
+//	                such as 30s or 5m, as described by Timeouter
+//	retryable       the migration is safe to run again after failing, as
+//	                described by Retryable
+//	tags=<t>,...    tag the migration with the comma-separated tags t, such
+//	                as destructive, as described by Tagged
+//	online-ddl      run the ALTER TABLE statements of the migration as online
+//	                schema changes, as described by OnlineDDL
+//	repeatable      apply the migration whenever it changes, as the files
+//	                named R__<name>.sql are, rather than once; its version is
+//	                ignored, and it is named by the slug of its file
+var headerRegexp = regexp.MustCompile(`^--\s*emigrate:([A-Za-z-]+)(?:=(.*))?$`)
+
+// headerReader is implemented by sources whose files have headers that the
+// SQL they read leaves out, such as the comments before the "-- +emigrate Up"
+// directive of a single-file migration
+type headerReader interface {
+	readHeader(version int64) (string, error)
+}
+
+// readHeader returns the contents of the upgrade file of version, which holds
+// its header
+func (s *fileSource) readHeader(version int64) (string, error) {
+	return s.contents(s.files[version]["up"])
+}
+
+// preparer is implemented by migrations that read their header when they are
+// run, rather than when they are loaded
+type preparer interface {
+	prepare() error
+}
+
+// prepare readies a migration to be run
+func prepare(m Migration) error {
+	if p, ok := m.(preparer); ok {
+		return p.prepare()
+	}
+	return nil
+}
+
+// sourceHeader returns the script of version of src whose header declares its
+// options, given its upgrade SQL
+func sourceHeader(src MigrationSource, version int64, up string) (string, error) {
+	if hr, ok := src.(headerReader); ok {
+		return hr.readHeader(version)
+	}
+	return up, nil
+}
+
+// parseHeader sets the options declared by the directives in the header of
+// script, read from the file or location name.
+func (o *migrationOptions) parseHeader(name, script string) error {
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		} else if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		match := headerRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		directive, value := match[1], strings.TrimSpace(match[2])
+		switch {
+		case directive == "no-transaction" && value == "":
+			o.noTransaction = true
+		case directive == "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("emigrate: Invalid timeout %q in %q.", value, name)
+			}
+			o.timeout = timeout
+		case directive == "retryable" && value == "":
+			o.retryable = true
+		case directive == "online-ddl" && value == "":
+			o.onlineDDL = true
+		case directive == "tags" && value != "":
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					o.tags = append(o.tags, tag)
+				}
+			}
+		case directive == "repeatable" && value == "":
+			o.repeatable = true
+		default:
+			return fmt.Errorf("emigrate: Unknown directive %q in %q.", line, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseHeader(t *testing.T) {
+	var o migrationOptions
+	script := "-- Build the index without locking the table\n\n--emigrate:no-transaction\n-- emigrate:timeout=5m\n" +
+		"CREATE INDEX CONCURRENTLY invoices_idx ON invoices (id);\n-- emigrate:timeout=bogus\n"
+	if err := o.parseHeader("001.sql", script); err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if o.Transactional() {
+		t.Errorf("Expected the migration to run outside a transaction")
+	}
+	if o.Timeout() != 5*time.Minute {
+		t.Errorf("Expected a timeout of %s, got %s", 5*time.Minute, o.Timeout())
+	}
+
+	var invalid = []string{
+		"-- emigrate:timeout=soon\n",
+		"-- emigrate:timeout=-1s\n",
+		"-- emigrate:no-transaction=yes\n",
+		"-- emigrate:no-transcation\n",
+		"-- emigrate:repeatable=yes\n",
+	}
+	for _, script := range invalid {
+		var o migrationOptions
+		if err := o.parseHeader("001.sql", script); err == nil {
+			t.Errorf("Expected an error for %q", script)
+		}
+	}
+}
+
+// Verify that the loaders read the headers of the migration files, including
+// those before the directive of a single-file migration.
+func TestLoadHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.sql": {Data: []byte("-- emigrate:timeout=30s\n-- +emigrate Up\n" + TestQueryCreateInvoiceTable)},
+		"migrations/002_index.up.sql":       {Data: []byte("-- emigrate:no-transaction\nCREATE INDEX CONCURRENTLY i ON invoices (id)")},
+	}
+
+	for _, load := range []func() ([]Migration, error){
+		func() ([]Migration, error) { return FSMigrations(fsys, "migrations") },
+		func() ([]Migration, error) { return SourceMigrations(FSSource(fsys, "migrations")) },
+		func() ([]Migration, error) { return FSMigrations(fsys, "migrations", Lazy()) },
+	} {
+		ms, err := load()
+		if err != nil {
+			t.Fatalf("Got unexpected error %#v", err)
+		}
+		for _, m := range ms {
+			if err := prepare(m); err != nil {
+				t.Fatalf("Got unexpected error %#v", err)
+			}
+		}
+		if timeout := ms[0].(Timeouter).Timeout(); timeout != 30*time.Second {
+			t.Errorf("Expected a timeout of %s, got %s", 30*time.Second, timeout)
+		}
+		if !transactional(ms[0]) || transactional(ms[1]) {
+			t.Errorf("Expected only version 2 to run outside a transaction")
+		}
+	}
+
+	fsys["migrations/003_up.sql"] = &fstest.MapFile{Data: []byte("-- emigrate:unknown\nSELECT 1")}
+	if _, err := FSMigrations(fsys, "migrations"); err == nil {
+		t.Errorf("Expected an error for an unknown directive")
+	}
+}
+
+// Verify that a migration that runs outside a transaction runs its statements
+// one at a time, and is recorded in a transaction of its own.
+func TestNoTransaction(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{stringMigration{
+		version:          1,
+		up:               "CREATE INDEX CONCURRENTLY a ON invoices (id);\nCREATE INDEX CONCURRENTLY b ON invoices (date);\n",
+		migrationOptions: migrationOptions{noTransaction: true},
+	}}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX CONCURRENTLY a ON invoices (id)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX CONCURRENTLY b ON invoices (date)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that only migrations that run SQL can run outside a transaction.
+func TestNoTransactionRequiresSQL(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	up := func(tx *sql.Tx) error { return nil }
+	m.migrations = []Migration{&functionMigration{version: 1, up: up, migrationOptions: migrationOptions{noTransaction: true}}}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	if _, err := m.UpgradeToVersion(1); err == nil {
+		t.Errorf("Expected an error for a Go migration outside a transaction")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration is cancelled once its timeout has passed.
+func TestTimeout(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{stringMigration{
+		version:          1,
+		up:               TestQueryCreateInvoiceTable,
+		migrationOptions: migrationOptions{timeout: time.Nanosecond},
+	}}
+
+	if _, err := m.UpgradeToVersion(1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the Timeout of the Migrator applies to migrations without their
+// own, and is overridden by those that declare one.
+func TestMigratorTimeout(t *testing.T) {
+	m := Migrator{Timeout: time.Minute}
+	ctx, cancel := m.migrationContext(NewStringMigration(1, "up", ""))
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("Expected a deadline within %s, got %v", time.Minute, deadline)
+	}
+
+	ctx, cancel = m.migrationContext(NewStringMigration(2, "up", "", WithTimeout(time.Hour)))
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) <= time.Minute {
+		t.Errorf("Expected a deadline after %s, got %v", time.Minute, deadline)
+	}
+
+	ctx, cancel = (&Migrator{}).migrationContext(NewStringMigration(3, "up", ""))
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("Expected no deadline")
+	}
+}
@@ -0,0 +1,185 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/user"
+	"time"
+)
+
+// HistoryEntry is a single row of the migration history, recorded each time
+// a migration is applied or reverted, or fails to be.
+type HistoryEntry struct {
+	Version     int64         // the version of the migration, or 0 if it is repeatable
+	Name        string        // the name of the migration, if known
+	Label       string        // the label of the migration, if known
+	Checksum    string        // the checksum of the migration, if known
+	Direction   string        // "up" for an upgrade, "down" for a downgrade or "skip" for a skipped upgrade
+	FromVersion int64         // the current version before the migration
+	ToVersion   int64         // the current version after the migration
+	AppliedBy   string        // who applied the migration
+	DBUser      string        // the database user the migration was run as
+	Application string        // the application that applied the migration
+	Hostname    string        // the host the migration was applied from
+	DeployID    string        // identifies the deployment, if configured
+	Batch       int64         // identifies the run the migration was part of
+	SQL         string        // the SQL that was run, if recorded
+	AppliedAt   time.Time     // when the migration was applied, by the database clock
+	Duration    time.Duration // how long the migration took to run
+	Success     bool          // false if the migration failed and was rolled back
+}
+
+// History returns every recorded attempt to apply a migration, oldest first.
+func (m *Migrator) History() ([]HistoryEntry, error) {
+	rows, err := m.tracking().QueryContext(context.Background(), m.query(m.queries().GetHistory, m.historyTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var durationMs int64
+		err := rows.Scan(&entry.Version, &entry.Name, &entry.Label, &entry.Checksum,
+			&entry.Direction, &entry.FromVersion, &entry.ToVersion,
+			&entry.AppliedBy, &entry.DBUser, &entry.Application, &entry.Hostname,
+			&entry.DeployID, &entry.Batch, &entry.SQL, &entry.AppliedAt, &durationMs, &entry.Success)
+		if err != nil {
+			return nil, err
+		}
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// latestUpgrades returns the latest successful upgrade of each version in
+// entries, which are ordered oldest first, counting those skipped by their
+// condition as upgrades if skips is set
+func latestUpgrades(entries []HistoryEntry, skips bool) map[int64]HistoryEntry {
+	latest := make(map[int64]HistoryEntry)
+	for _, entry := range entries {
+		if entry.Success && (entry.Direction == "up" || (skips && entry.Direction == "skip")) {
+			latest[entry.Version] = entry
+		}
+	}
+	return latest
+}
+
+// historyEntry returns the history entry recording an attempt to run a
+// migration in the given direction, changing the current version from one
+// version to another.
+func (m *Migrator) historyEntry(migration Migration, direction string, from, to int64) HistoryEntry {
+	var sql string
+	if s, ok := migration.(SQLer); ok && m.RecordSQL {
+		sql = s.SQL(direction)
+	}
+	return HistoryEntry{
+		Version:     migration.Version(),
+		Name:        migrationName(migration),
+		Label:       migrationLabel(migration),
+		Checksum:    directionChecksum(migration, direction),
+		Direction:   direction,
+		FromVersion: from,
+		ToVersion:   to,
+		AppliedBy:   m.appliedBy(),
+		Application: m.Application,
+		Hostname:    hostname(),
+		DeployID:    m.DeployID,
+		Batch:       m.batch,
+		SQL:         sql,
+	}
+}
+
+// execer is implemented by every DB and Tx, and by *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertHistory records entry in the history
+func (m *Migrator) insertHistory(db execer, entry HistoryEntry) error {
+	query := rebind(m.dialect(), m.queries().InsertHistory(m.historyTable(), m.dialect().CurrentUser()))
+	_, err := db.ExecContext(context.Background(), query, entry.Version, entry.Name, entry.Label, entry.Checksum,
+		entry.Direction, entry.FromVersion, entry.ToVersion, entry.AppliedBy,
+		entry.Application, entry.Hostname, entry.DeployID, entry.Batch, entry.SQL,
+		int64(entry.Duration/time.Millisecond), entry.Success)
+	return err
+}
+
+// hostname returns the name of the host, or an empty string if unknown
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// appliedBy returns who is applying migrations, which is the configured
+// AppliedBy or the name of the operating system user.
+func (m *Migrator) appliedBy() string {
+	if m.AppliedBy != "" {
+		return m.AppliedBy
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// recordFailure records a failed migration in the history, and marks the
+// database dirty if it does not have transactional DDL. As the migration
+// transaction has been rolled back, this is done outside of it and any error
+// is ignored in favour of that of the migration.
+func (m *Migrator) recordFailure(entry HistoryEntry) {
+	m.insertHistory(m.tracking(), entry)
+	if m.tracksDirty() {
+		_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().InsertDirtyVersion, m.dirtyTable()), entry.Version)
+		m.dirtied = err == nil
+	}
+}
+
+// PruneHistory removes the history of migrations older than version, along
+// with their record in the applied versions, returning the number of history
+// rows removed. It is intended for use after replacing those migrations with
+// a squashed baseline, and they should no longer be loaded afterwards. The
+// version must not be newer than the current version, so the record of the
+// current version is always kept.
+func (m *Migrator) PruneHistory(version int64) (int64, error) {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return 0, err
+	} else if version > current {
+		return 0, InvalidPruneVersion
+	}
+
+	tx, err := m.tracking().BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(context.Background(), m.query(m.queries().PruneHistory, m.historyTable()), version)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.ExecContext(context.Background(), m.query(m.queries().PruneAppliedVersions, m.appliedTable()), version)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	// not all drivers support RowsAffected, so ignore the error
+	rows, _ := res.RowsAffected()
+	return rows, nil
+}
+
+// initHistory creates the history table for a database that was initialized
+// before the table existed.
+func (m *Migrator) initHistory() error {
+	if _, err := m.History(); err == nil {
+		return nil
+	}
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().CreateHistoryTable, m.historyTable()))
+	return err
+}
@@ -0,0 +1,134 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db}
+
+	appliedAt := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetHistory(testHistoryTable))).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow(1, "", "", "", "up", 0, 1, "deploy", "app", "billing", "web-1", "r42", 7, "", appliedAt, 1500, true).
+			AddRow(2, "", "", "", "up", 1, 1, "deploy", "app", "billing", "web-1", "r42", 7, "", appliedAt, 20, false))
+
+	entries, err := m.History()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []HistoryEntry{
+		{Version: 1, Direction: "up", FromVersion: 0, ToVersion: 1, AppliedBy: "deploy",
+			DBUser: "app", Application: "billing", Hostname: "web-1", DeployID: "r42",
+			Batch: 7, AppliedAt: appliedAt, Duration: 1500 * time.Millisecond, Success: true},
+		{Version: 2, Direction: "up", FromVersion: 1, ToVersion: 1, AppliedBy: "deploy",
+			DBUser: "app", Application: "billing", Hostname: "web-1", DeployID: "r42",
+			Batch: 7, AppliedAt: appliedAt, Duration: 20 * time.Millisecond, Success: false},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %v", len(expected), entries)
+	}
+	for idx, val := range expected {
+		if entries[idx] != val {
+			t.Errorf("Entry %d: expected %v, got %v", idx, val, entries[idx])
+		}
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a failed migration is recorded in the history after the
+// migration transaction is rolled back.
+func TestFailedMigrationRecordedInHistory(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1)
+	m.migrations[0].(*mockMigration).err = errors.New("migrate failed")
+	m.AppliedBy = "deploy"
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectHistoryEntry(mock, HistoryEntry{
+		Version: 1, Direction: "up", AppliedBy: "deploy", Hostname: hostname(), Success: false,
+	}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := m.Upgrade(); err == nil {
+		t.Errorf("Expected migration to fail")
+	}
+	mock.CloseTest(t)
+}
+
+func TestHistoryEntryName(t *testing.T) {
+	m := NewStringMigration(42, "CREATE INDEX ...", "", WithName("add_invoice_indexes"))
+	entry := (&Migrator{}).historyEntry(m, "up", 41, 42)
+	if entry.Name != "add_invoice_indexes" || entry.Checksum != checksumString("CREATE INDEX ...") {
+		t.Errorf("Expected name and checksum to be recorded, got %v", entry)
+	}
+}
+
+func TestHistoryEntryDeployment(t *testing.T) {
+	m := &Migrator{Application: "billing", DeployID: "r42", Dialect: MySQLDialect{}}
+	entry := m.historyEntry(NewStringMigration(1, "", ""), "up", 0, 1)
+	if entry.Application != "billing" || entry.DeployID != "r42" || entry.Hostname != hostname() {
+		t.Errorf("Unexpected deployment in %v", entry)
+	}
+	query := m.queries().InsertHistory(m.historyTable(), m.dialect().CurrentUser())
+	if !strings.Contains(query, "?, CURRENT_USER(), ?") {
+		t.Errorf("Expected database user expression in %s", query)
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 10)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.PruneHistory(testHistoryTable))).WithArgs(int64(8)).
+		WillReturnResult(sqlmock.NewResult(0, 9))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.PruneAppliedVersions(testAppliedTable))).WithArgs(int64(8)).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectCommit()
+
+	rows, err := m.PruneHistory(8)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	} else if rows != 9 {
+		t.Errorf("Expected %d rows pruned, got %d", 9, rows)
+	}
+	mock.CloseTest(t)
+}
+
+func TestPruneHistoryNewerThanCurrent(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 10)
+
+	if _, err := m.PruneHistory(11); err != InvalidPruneVersion {
+		t.Errorf("Expected %v, got %v", InvalidPruneVersion, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestHistoryEntrySQL(t *testing.T) {
+	m := NewStringMigration(1, "CREATE TABLE invoices ()", "DROP TABLE invoices")
+	if entry := (&Migrator{}).historyEntry(m, "up", 0, 1); entry.SQL != "" {
+		t.Errorf("Expected no SQL unless recorded, got %q", entry.SQL)
+	}
+	migrator := &Migrator{RecordSQL: true}
+	if entry := migrator.historyEntry(m, "up", 0, 1); entry.SQL != "CREATE TABLE invoices ()" {
+		t.Errorf("Expected upgrade SQL, got %q", entry.SQL)
+	}
+	if entry := migrator.historyEntry(m, "down", 1, 0); entry.SQL != "DROP TABLE invoices" {
+		t.Errorf("Expected downgrade SQL, got %q", entry.SQL)
+	}
+}
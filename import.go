@@ -0,0 +1,280 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ImportNotEmpty = errors.New("Cannot import into a database with applied migrations")
+	ImportDirty    = errors.New("Cannot import from a database in a dirty state")
+)
+
+// Importer reads the migrations applied by another migration tool, so that a
+// database can be switched to emigrate without re-applying them.
+type Importer interface {
+	// ImportVersions returns the current version and the applied versions
+	// recorded by the other tool. If the tool only records the current
+	// version, applied is nil and every loaded migration up to the current
+	// version is taken to be applied. It is given the database the Migrator
+	// runs on.
+	ImportVersions(db DB) (current int64, applied []int64, err error)
+}
+
+// Import seeds the emigrate tables, which must have been created by Init and
+// be empty, with the migrations applied by another tool. Each imported
+// version is recorded in the history.
+func (m *Migrator) Import(importer Importer) error {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return err
+	} else if current != 0 || len(applied) > 0 {
+		return ImportNotEmpty
+	}
+
+	var imported int64
+	var versions []int64
+	if mi, ok := importer.(migratorImporter); ok {
+		imported, versions, err = mi.importVersions(m)
+	} else {
+		imported, versions, err = importer.ImportVersions(m.db)
+	}
+	if err != nil {
+		return err
+	}
+	sort.Sort(byVersion(m.migrations))
+	if versions == nil {
+		for _, migration := range m.migrations {
+			if migration.Version() < imported {
+				versions = append(versions, migration.Version())
+			}
+		}
+		if imported > 0 {
+			versions = append(versions, imported)
+		}
+	}
+	sort.Sort(int64Slice(versions))
+
+	tx, err := m.tracking().BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if imported > 0 {
+		if err = m.setVersion(tx, imported, 0); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	var previous int64
+	for _, version := range versions {
+		// the history records the loaded migration, if there is one
+		var migration Migration = NewFunctionMigration(version, nil, nil)
+		if idx, ok := byVersion(m.migrations).Search(version); ok {
+			migration = m.migrations[idx]
+		}
+		entry := m.historyEntry(migration, "up", previous, version)
+		entry.Success = true
+
+		_, err = tx.ExecContext(context.Background(), m.query(m.queries().InsertAppliedVersion, m.appliedTable()), version)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = m.insertHistory(tx, entry)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		previous = version
+	}
+	return tx.Commit()
+}
+
+// migratorImporter is implemented by the importers of other tools, which read
+// their tables with the queries and dialect of the Migrator importing them
+type migratorImporter interface {
+	importVersions(m *Migrator) (int64, []int64, error)
+}
+
+// importTable returns the quoted name of a table of another migration tool,
+// which may be qualified by its schema
+func (m *Migrator) importTable(name string) string {
+	parts := strings.Split(name, ".")
+	for idx, part := range parts {
+		parts[idx] = m.dialect().QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// GolangMigrateImporter imports from the schema_migrations table of
+// golang-migrate, which only records the current version.
+type GolangMigrateImporter struct {
+	Table string // the table to import from, which may be qualified by its schema, or schema_migrations if empty
+}
+
+// ImportVersions reads the table with the default queries for the dialect of
+// db, rather than those of a Migrator
+func (i GolangMigrateImporter) ImportVersions(db DB) (int64, []int64, error) {
+	return i.importVersions(NewMigrator(db, nil))
+}
+
+func (i GolangMigrateImporter) importVersions(m *Migrator) (int64, []int64, error) {
+	table := i.Table
+	if table == "" {
+		table = "schema_migrations"
+	}
+	var current int64
+	var dirty bool
+	query := m.query(m.queries().GetGolangMigrateVersion, m.importTable(table))
+	err := nativeDB(m.db).QueryRowContext(context.Background(), query).Scan(&current, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, nil, nil
+	} else if err != nil {
+		return 0, nil, err
+	} else if dirty {
+		return 0, nil, ImportDirty
+	}
+	return current, nil, nil
+}
+
+// GooseImporter imports from the goose_db_version table of goose, which
+// records each migration applied or rolled back.
+type GooseImporter struct {
+	Table string // the table to import from, which may be qualified by its schema, or goose_db_version if empty
+}
+
+// ImportVersions reads the table with the default queries for the dialect of
+// db, rather than those of a Migrator
+func (i GooseImporter) ImportVersions(db DB) (int64, []int64, error) {
+	return i.importVersions(NewMigrator(db, nil))
+}
+
+func (i GooseImporter) importVersions(m *Migrator) (int64, []int64, error) {
+	table := i.Table
+	if table == "" {
+		table = "goose_db_version"
+	}
+	query := m.query(m.queries().GetGooseVersions, m.importTable(table))
+	rows, err := nativeDB(m.db).QueryContext(context.Background(), query)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	// later rows take precedence, as a migration may be rolled back and
+	// applied again
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		if err := rows.Scan(&version, &isApplied); err != nil {
+			return 0, nil, err
+		}
+		// goose records version 0 when it creates its table
+		if version > 0 {
+			applied[version] = isApplied
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return appliedSet(applied)
+}
+
+// FlywayImporter imports from the flyway_schema_history table of Flyway.
+// Only versioned migrations with integer versions can be imported.
+type FlywayImporter struct {
+	Table string // the table to import from, which may be qualified by its schema, or flyway_schema_history if empty
+}
+
+// ImportVersions reads the table with the default queries for the dialect of
+// db, rather than those of a Migrator
+func (i FlywayImporter) ImportVersions(db DB) (int64, []int64, error) {
+	return i.importVersions(NewMigrator(db, nil))
+}
+
+func (i FlywayImporter) importVersions(m *Migrator) (int64, []int64, error) {
+	table := i.Table
+	if table == "" {
+		table = "flyway_schema_history"
+	}
+	query := m.query(m.queries().GetFlywayVersions, m.importTable(table))
+	rows, err := nativeDB(m.db).QueryContext(context.Background(), query)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var raw, kind string
+		var success bool
+		if err := rows.Scan(&raw, &kind, &success); err != nil {
+			return 0, nil, err
+		}
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("emigrate: Cannot import Flyway version %q: %v", raw, err)
+		}
+		if !success {
+			continue
+		}
+		// undone and deleted migrations are recorded as rows of their own
+		undone := strings.HasPrefix(kind, "UNDO") || kind == "DELETE"
+		applied[version] = !undone
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return appliedSet(applied)
+}
+
+// JSONImporter imports from the JSON written by ExportHistory, such as to
+// seed the tracking tables of a fresh environment with the migrations applied
+// to another. Only the applied versions are restored: the name, label,
+// checksum and time exported are ignored, and the history records the loaded
+// migration of each version, if there is one, as applied at the time of the
+// import.
+type JSONImporter struct {
+	Reader io.Reader // the exported history
+}
+
+func (i JSONImporter) ImportVersions(db DB) (int64, []int64, error) {
+	var exported []ExportedMigration
+	if err := json.NewDecoder(i.Reader).Decode(&exported); err != nil {
+		return 0, nil, fmt.Errorf("emigrate: Cannot import the exported history: %v", err)
+	}
+	applied := make(map[int64]bool)
+	for _, migration := range exported {
+		applied[migration.Version] = true
+	}
+	return appliedSet(applied)
+}
+
+// appliedSet returns the newest and all of the versions marked as applied
+func appliedSet(applied map[int64]bool) (int64, []int64, error) {
+	var current int64
+	versions := []int64{}
+	for version, ok := range applied {
+		if !ok {
+			continue
+		}
+		versions = append(versions, version)
+		if version > current {
+			current = version
+		}
+	}
+	sort.Sort(int64Slice(versions))
+	return current, versions, nil
+}
@@ -0,0 +1,190 @@
+package emigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectImport(mock *sqlmock.MockDB, current int64, versions ...int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(current, int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, version := range versions {
+		expectInsertApplied(mock, version)
+		expectInsertHistory(mock)
+	}
+	mock.ExpectCommit()
+}
+
+// Verify that importing from golang-migrate marks every loaded migration up to
+// its version as applied.
+func TestImportGolangMigrate(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1, 2, 3)
+	expectAppliedQuery(mock)
+	mock.ExpectQuery(testQueries.GetGolangMigrateVersion("schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+	expectImport(mock, 2, 1, 2)
+
+	if err := m.Import(GolangMigrateImporter{}); err != nil {
+		t.Errorf("Unexpected error during import: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that importers read the tables of other tools with the queries of
+// the Migrator, quoting the name of the table.
+func TestImportQueries(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1, 2, 3)
+	queries := DefaultQueries()
+	queries.GetGolangMigrateVersion = func(table string) string {
+		return fmt.Sprintf(`SELECT version, dirty FROM %s ORDER BY version DESC LIMIT 1`, table)
+	}
+	m.Queries = queries
+	expectAppliedQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version, dirty FROM legacy."schema migrations" ORDER BY version DESC LIMIT 1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+	expectImport(mock, 2, 1, 2)
+
+	if err := m.Import(GolangMigrateImporter{Table: "legacy.schema migrations"}); err != nil {
+		t.Errorf("Unexpected error during import: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestImportGolangMigrateDirty(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	expectAppliedQuery(mock)
+	mock.ExpectQuery(testQueries.GetGolangMigrateVersion("schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, true))
+
+	if err := m.Import(GolangMigrateImporter{}); err != ImportDirty {
+		t.Errorf("Expected %v, got %v", ImportDirty, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that goose migrations that were rolled back are not imported.
+func TestImportGoose(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	expectAppliedQuery(mock)
+	mock.ExpectQuery(testQueries.GetGooseVersions("goose_db_version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version_id", "is_applied"}).
+			AddRow(0, true).AddRow(1, true).AddRow(2, true).AddRow(3, true).AddRow(3, false))
+	expectImport(mock, 2, 1, 2)
+
+	if err := m.Import(GooseImporter{}); err != nil {
+		t.Errorf("Unexpected error during import: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestImportNotEmpty(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	expectAppliedQuery(mock, 1)
+
+	if err := m.Import(GooseImporter{}); err != ImportNotEmpty {
+		t.Errorf("Expected %v, got %v", ImportNotEmpty, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestFlywayImportVersions(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetFlywayVersions("flyway_schema_history"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "type", "success"}).
+			AddRow("1", "BASELINE", true).
+			AddRow("2", "SQL", true).
+			AddRow("3", "SQL", false).
+			AddRow("4", "SQL", true).
+			AddRow("4", "UNDO_SQL", true))
+
+	current, versions, err := FlywayImporter{}.ImportVersions(db)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	} else if current != 2 || fmt.Sprint(versions) != "[1 2]" {
+		t.Errorf("Expected version 2 with [1 2] applied, got %d with %v", current, versions)
+	}
+	mock.CloseTest(t)
+}
+
+func TestFlywayImportNonIntegerVersion(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetFlywayVersions("flyway_schema_history"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "type", "success"}).
+			AddRow("1.1", "SQL", true))
+
+	if _, _, err := (FlywayImporter{}).ImportVersions(db); err == nil {
+		t.Errorf("Expected error importing version 1.1")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the versions written by ExportHistory are imported.
+func TestImportJSON(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1, 2, 3)
+	expectAppliedQuery(mock)
+	expectImport(mock, 2, 1, 2)
+
+	exported := `[{"version": 1, "applied_at": "0001-01-01T00:00:00Z"}, {"version": 2, "name": "add_invoices", "checksum": "abc", "applied_at": "2014-05-01T12:00:00Z"}]`
+	if err := m.Import(JSONImporter{strings.NewReader(exported)}); err != nil {
+		t.Errorf("Unexpected error during import: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestImportJSONInvalid(t *testing.T) {
+	if _, _, err := (JSONImporter{strings.NewReader(`{"version": 1}`)}).ImportVersions(nil); err == nil {
+		t.Errorf("Expected an error importing an invalid export")
+	}
+}
+
+// Verify that only the versions written by ExportHistory are imported, with
+// the history recording the loaded migrations rather than the exported names
+// and checksums.
+func TestImportJSONVersionsOnly(t *testing.T) {
+	t.Parallel()
+	mock, m := setupVersioned(t, 0)
+	loaded := NewStringMigration(2, "CREATE TABLE invoices (id int)", "", WithName("create_invoices"))
+	m.migrations = []Migration{loaded}
+	m.AppliedBy = "deploy"
+	expectAppliedQuery(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(2), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectHistoryEntry(mock, HistoryEntry{Version: 1, Direction: "up", ToVersion: 1,
+		AppliedBy: "deploy", Hostname: hostname(), Success: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 2)
+	expectHistoryEntry(mock, HistoryEntry{Version: 2, Name: "create_invoices", Checksum: checksum(loaded),
+		Direction: "up", FromVersion: 1, ToVersion: 2, AppliedBy: "deploy", Hostname: hostname(), Success: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	exported := `[{"version": 1, "name": "create_customers", "checksum": "def", "applied_at": "2014-04-01T12:00:00Z"}, {"version": 2, "name": "add_invoices", "checksum": "abc", "applied_at": "2014-05-01T12:00:00Z"}]`
+	if err := m.Import(JSONImporter{strings.NewReader(exported)}); err != nil {
+		t.Errorf("Unexpected error during import: %s", err)
+	}
+	mock.CloseTest(t)
+}
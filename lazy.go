@@ -0,0 +1,134 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Lazy defers reading the contents of migration files until they are needed,
+// so that only their names are read when the migrations are loaded. This
+// keeps memory flat for large sets of large migrations, but errors reading a
+// file, or in its header, are only returned when its migration is run. The
+// header of a migration is read earlier if its tags are needed, by the Tags
+// filter or the RefuseTags policy of a Migrator. As headers are not read when
+// loaded, a lazy migration cannot declare itself repeatable, so repeatable
+// migrations must be named R__<name>.sql, whose files are read when loaded.
+func Lazy() DirOption {
+	return func(o *dirOptions) {
+		o.lazy = true
+	}
+}
+
+// lazyMigration returns a migration for version of s that reads its files
+// when it is run
+func (s *fileSource) lazyMigration(version int64) *lazyMigration {
+	m := &lazyMigration{
+		src:     s,
+		version: version,
+		down:    s.files[version]["down"] != nil,
+	}
+	m.name = s.Name(version)
+	return m
+}
+
+// lazyMigration is an implementation of Migration that reads the SQL of its
+// upgrade and downgrade from its source each time it is needed
+type lazyMigration struct {
+	src     *fileSource
+	version int64
+	down    bool   // whether there is a file for the downgrade
+	header  bool   // whether the header has been parsed
+	sum     string // the checksum of the upgrade, once it has been read
+	migrationOptions
+}
+
+func (m *lazyMigration) Version() int64 {
+	return m.version
+}
+
+// prepare parses the header of the migration, once
+func (m *lazyMigration) prepare() error {
+	if m.header {
+		return nil
+	}
+	if err := m.src.parseHeader(m.version, &m.migrationOptions); err != nil {
+		return err
+	} else if m.repeatable {
+		return fmt.Errorf("emigrate: Repeatable migration %q cannot be loaded lazily, name it R__<name>.sql instead.", m.src.Location(m.version))
+	}
+	m.header = true
+	return nil
+}
+
+// Tags returns the tags declared in the header of the migration, which is
+// parsed if it has not been, so that it can be selected by the Tags filter or
+// refused by RefuseTags before it is run. If the header cannot be parsed the
+// migration has no tags, and the error is returned when it is run.
+func (m *lazyMigration) Tags() []string {
+	if err := m.prepare(); err != nil {
+		return nil
+	}
+	return m.tags
+}
+
+// Checksum returns the checksum of the upgrade SQL, or "" if it cannot be
+// read. Verification reads it with readChecksum instead, which returns the
+// error.
+func (m *lazyMigration) Checksum() string {
+	sum, _ := m.readChecksum()
+	return sum
+}
+
+// readChecksum returns the checksum of the upgrade SQL, which is read the
+// first time it is needed, and kept so that later verifications do not read
+// the file again
+func (m *lazyMigration) readChecksum() (string, error) {
+	if m.sum == "" {
+		up, err := m.src.Read(m.version, "up")
+		if err != nil {
+			return "", err
+		}
+		m.sum = checksumString(up)
+	}
+	return m.sum, nil
+}
+
+// DirectionChecksum returns the checksum of the SQL run in the given
+// direction, or "" if there is none or it cannot be read
+func (m *lazyMigration) DirectionChecksum(direction string) string {
+	sql, err := m.src.Read(m.version, direction)
+	if err != nil || sql == "" {
+		return ""
+	}
+	return checksumString(sql)
+}
+
+func (m *lazyMigration) Upgrade(tx *sql.Tx) error {
+	up, err := m.src.Read(m.version, "up")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(up)
+	return err
+}
+
+// SQL returns the upgrade or downgrade script of the migration, or "" if it
+// cannot be read
+func (m *lazyMigration) SQL(direction string) string {
+	sql, _ := m.src.Read(m.version, direction)
+	return sql
+}
+
+// Downgrade runs the downgrade script of the migration. A single-file
+// migration is only known to have no downgrade once it has been read.
+func (m *lazyMigration) Downgrade(tx *sql.Tx) error {
+	down, err := m.src.Read(m.version, "down")
+	if err != nil {
+		return err
+	}
+	if down == "" {
+		return fmt.Errorf("emigrate: No downgrade defined for migration %d", m.version)
+	}
+	_, err = tx.Exec(down)
+	return err
+}
@@ -0,0 +1,110 @@
+package emigrate
+
+import (
+	"errors"
+	"io/fs"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that lazy migrations read no files until they are run.
+func TestLazyMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/001_create_invoice.down.sql": {Data: []byte(TestQueryDropInvoiceTable)},
+		"migrations/002_up.sql":                  {Data: []byte(TestQueryInsertInvoices)},
+	}
+	reads := 0
+	mf := migrationFinder{
+		readDir: FSSource(fsys, "").(*fileSource).finder.readDir,
+		readFile: func(name string) ([]byte, error) {
+			reads++
+			return fsys.ReadFile(name)
+		},
+	}
+
+	ms, err := mf.getMigrations("migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if reads != 0 {
+		t.Errorf("Expected no files to be read, read %d", reads)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	if name := migrationName(ms[0]); name != "create_invoice" {
+		t.Errorf("Expected %s, got %s", "create_invoice", name)
+	}
+	if !canDowngrade(ms[0]) || canDowngrade(ms[1]) {
+		t.Errorf("Expected only version 1 to be able to downgrade")
+	}
+	if sql := ms[0].(SQLer).SQL("down"); sql != TestQueryDropInvoiceTable {
+		t.Errorf("Expected %q, got %q", TestQueryDropInvoiceTable, sql)
+	}
+	if sum := checksum(ms[1]); sum != checksumString(TestQueryInsertInvoices) {
+		t.Errorf("Expected the checksum of the upgrade, got %q", sum)
+	}
+
+	mock, m := setupVersioned(t, 0)
+	m.migrations = ms[:1]
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the checksum of a lazy migration is read once, and that an
+// error reading it is returned by verification rather than disabling it.
+func TestLazyChecksum(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_up.sql": {Data: []byte(TestQueryCreateInvoiceTable)},
+	}
+	reads := 0
+	mf := migrationFinder{
+		readDir: FSSource(fsys, "").(*fileSource).finder.readDir,
+		readFile: func(name string) ([]byte, error) {
+			reads++
+			return fsys.ReadFile(name)
+		},
+	}
+
+	ms, err := mf.getMigrations("migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if sum, err := verifiedChecksum(ms[0]); err != nil || sum != checksumString(TestQueryCreateInvoiceTable) {
+			t.Errorf("Expected the checksum of the upgrade, got %q, %v", sum, err)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Expected the file to be read once, read %d", reads)
+	}
+
+	ms, err = mf.getMigrations("migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	delete(fsys, "migrations/001_up.sql")
+	mock, m := setupVersioned(t, 1)
+	m.migrations = ms
+	if _, err := m.UpgradeToVersion(1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the error reading the migration, got %v", err)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,45 @@
+package emigrate
+
+import "context"
+
+// TrackingMode determines how the Migrator records the migrations that have
+// been applied to the database.
+type TrackingMode int
+
+const (
+	// TrackVersion keeps the current version in a single row of the tracking
+	// table, and the applied versions in a separate table.
+	TrackVersion TrackingMode = iota
+
+	// TrackLedger keeps one row per applied migration in the tracking table,
+	// like the schema_migrations table used by other migration tools. The
+	// current version is the newest applied version.
+	TrackLedger
+)
+
+// ledger reports whether the tracking table is a ledger of applied versions
+func (m *Migrator) ledger() bool {
+	return m.Tracking == TrackLedger
+}
+
+// currentVersionQuery returns the query that reads the current version
+func (m *Migrator) currentVersionQuery() string {
+	if m.ledger() {
+		return m.query(m.queries().GetLedgerVersion, m.versionTable())
+	}
+	return m.query(m.queries().GetCurrentVersion, m.versionTable())
+}
+
+// lockVersion reads the current version within tx. In version mode the
+// version row is locked, so a concurrent migrator cannot apply the same
+// migration between our check and commit. A ledger cannot be locked this way,
+// but its primary key stops a version being recorded twice.
+func (m *Migrator) lockVersion(tx Tx) (int64, error) {
+	query := m.query(m.queries().LockCurrentVersion, m.versionTable())
+	if m.ledger() {
+		query = m.query(m.queries().GetLedgerVersion, m.versionTable())
+	}
+	var current int64
+	err := tx.QueryRowContext(context.Background(), query).Scan(&current)
+	return current, err
+}
@@ -0,0 +1,130 @@
+package emigrate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupLedger(t *testing.T, currentVersion int64) (*sqlmock.MockDB, Migrator) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	expectLedgerVersion(mock, currentVersion)
+	return mock, Migrator{db: db, Tracking: TrackLedger}
+}
+
+func expectLedgerVersion(mock *sqlmock.MockDB, version int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetLedgerVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).
+			FromCSVString(fmt.Sprintf("%d", version)))
+}
+
+func TestLedgerCurrentVersion(t *testing.T) {
+	t.Parallel()
+	mock, m := setupLedger(t, 3)
+
+	current, err := m.CurrentVersion()
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	} else if current != 3 {
+		t.Errorf("Expected %d, got %d", 3, current)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that upgrading with a ledger records each version without updating
+// a version row.
+func TestLedgerUpgrade(t *testing.T) {
+	t.Parallel()
+	mock, m := setupLedger(t, 1)
+	m.migrations = migrationRange(1, 2, 3)
+	for _, version := range []int64{2, 3} {
+		mock.ExpectBegin()
+		expectLedgerVersion(mock, version-1)
+		mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertAppliedVersion(testTable))).WithArgs(version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectInsertHistory(mock)
+		mock.ExpectCommit()
+	}
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error during upgrade: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that an older migration missing from the ledger is applied out of
+// order.
+func TestLedgerOutOfOrder(t *testing.T) {
+	t.Parallel()
+	mock, m := setupLedger(t, 3)
+	m.migrations = migrationRange(1, 2, 3)
+	m.OutOfOrder = OutOfOrderApply
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetAppliedVersions(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1).AddRow(3))
+	mock.ExpectBegin()
+	expectLedgerVersion(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.CountAppliedVersion(testTable))).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertAppliedVersion(testTable))).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error during upgrade: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestLedgerDowngrade(t *testing.T) {
+	t.Parallel()
+	mock, m := setupLedger(t, 2)
+	m.migrations = downgradeRange(1, 2)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetAppliedVersions(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1).AddRow(2))
+	mock.ExpectBegin()
+	expectLedgerVersion(mock, 2)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteAppliedVersion(testTable))).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.DowngradeToVersion(1); err != nil {
+		t.Errorf("Unexpected error during downgrade: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that Init creates only the ledger and history tables.
+func TestLedgerInit(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, Tracking: TrackLedger}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetLedgerVersion(testTable))).
+		WillReturnError(errors.New("no such table: emigrate"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateLedgerTable(testTable))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.CreateHistoryTable(testHistoryTable))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	expectLedgerVersion(mock, 0)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetAppliedVersions(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	expectHistoryQuery(mock)
+
+	if err := m.Init(); err != nil {
+		t.Errorf("Unexpected error during init: %s", err)
+	}
+	mock.CloseTest(t)
+}
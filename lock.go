@@ -0,0 +1,109 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultLockExpiry is how long a lock is held before it is considered stale,
+// if the Migrator does not set LockExpiry.
+const DefaultLockExpiry = 15 * time.Minute
+
+// LockedError indicates that another migrator holds the lock
+type LockedError struct {
+	by string    // the owner of the lock
+	at time.Time // when the lock was taken
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("emigrate: Migrations are locked by %s since %s", e.by, e.at.Format(time.RFC3339))
+}
+
+// lockTable returns the name of the table holding the lock row
+func (m *Migrator) lockTable() string {
+	return m.table("_lock")
+}
+
+// lockExpiry returns how long a lock is held before it is considered stale
+func (m *Migrator) lockExpiry() time.Duration {
+	if m.LockExpiry > 0 {
+		return m.LockExpiry
+	}
+	return DefaultLockExpiry
+}
+
+// initLock creates the lock table and its row, if they do not exist
+func (m *Migrator) initLock() error {
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().CreateLockTable, m.lockTable()))
+	if err != nil {
+		return err
+	}
+	_, err = m.tracking().ExecContext(context.Background(), m.query(m.queries().InsertLock, m.lockTable()))
+	return err
+}
+
+// lock takes the lock row, returning a LockedError if it is held by another
+// migrator and has not gone stale. Unless LockTable is set, it takes the lock
+// of a dialect that is a SessionLocker instead, if any. Stale locks are
+// detected using the clock of the host, so the clocks of the hosts running
+// migrations should be kept in sync.
+func (m *Migrator) lock() error {
+	if !m.LockTable {
+		return m.lockSession()
+	}
+	m.lockOwner = fmt.Sprintf("%s:%d:%d", hostname(), os.Getpid(), time.Now().UnixNano())
+
+	now := time.Now().UTC()
+	res, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().AcquireLock, m.lockTable()),
+		m.lockOwner, now, now.Add(-m.lockExpiry()))
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 1 {
+		m.lockRefreshed = now
+		return nil
+	}
+
+	var by sql.NullString
+	var at time.Time
+	err = m.tracking().QueryRowContext(context.Background(), m.query(m.queries().GetLock, m.lockTable())).Scan(&by, &at)
+	if err == sql.ErrNoRows {
+		return NotInitializedError{m.lockTable(), err}
+	} else if err != nil {
+		return err
+	}
+	return LockedError{by.String, at}
+}
+
+// unlock releases the lock row or the session lock taken by lock
+func (m *Migrator) unlock() error {
+	if !m.LockTable {
+		return m.unlockSession()
+	}
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().ReleaseLock, m.lockTable()), m.lockOwner)
+	return err
+}
+
+// refreshLock updates the time of the lock row taken by lock in db, so that
+// it does not go stale during a long migration, returning the time it was
+// refreshed to, or LockLost if the lock is no longer held by this migrator
+func (m *Migrator) refreshLock(db execer) (time.Time, error) {
+	now := time.Now().UTC()
+	res, err := db.ExecContext(context.Background(), m.query(m.queries().RefreshLock, m.lockTable()), now, m.lockOwner)
+	if err != nil {
+		return now, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return now, err
+	} else if rows != 1 {
+		return now, LockLost
+	}
+	return now, nil
+}
@@ -0,0 +1,73 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testLockTable = "emigrate_lock"
+
+func expectAcquireLock(mock *sqlmock.MockDB, rows int64) {
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE ` + testLockTable + ` SET locked_by = `)).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+}
+
+func expectReleaseLock(mock *sqlmock.MockDB) {
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE ` + testLockTable + ` SET locked_by = NULL`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// Verify that the lock is taken before upgrading and released afterwards.
+func TestUpgradeWithLockTable(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), LockTable: true}
+
+	expectAcquireLock(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	expectSetVersions(0, mock, 1)
+	expectReleaseLock(mock)
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error during upgrade: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that nothing is run while another migrator holds the lock.
+func TestUpgradeLocked(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), LockTable: true}
+
+	lockedAt := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAcquireLock(mock, 0)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetLock(testLockTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_by", "locked_at"}).
+			AddRow("web-2:42:1", lockedAt))
+
+	_, err = m.Upgrade()
+	if le, ok := err.(LockedError); !ok || le.by != "web-2:42:1" || !le.at.Equal(lockedAt) {
+		t.Errorf("Expected locked error, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestLockExpiry(t *testing.T) {
+	if expiry := (&Migrator{}).lockExpiry(); expiry != DefaultLockExpiry {
+		t.Errorf("Expected %s, got %s", DefaultLockExpiry, expiry)
+	}
+	if expiry := (&Migrator{LockExpiry: time.Hour}).lockExpiry(); expiry != time.Hour {
+		t.Errorf("Expected %s, got %s", time.Hour, expiry)
+	}
+}
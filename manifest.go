@@ -0,0 +1,131 @@
+package emigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// WithManifest lists the migrations in the JSON manifest file name, found in
+// the directory the migrations are read from, so that their metadata can be
+// reviewed in one place. The manifest has the form
+//
+//	{"migrations": [
+//		{"version": 1, "name": "create_users", "up": "001_create_users.up.sql", "down": "001_create_users.down.sql"},
+//		{"version": 2, "up": "2024/002_up.sql"}
+//	]}
+//
+// where files are relative to the directory. The manifest is validated
+// against the directory: every migration file must be listed, with its
+// version and direction, and every listed migration must exist. A name given
+// in the manifest names a migration whose files have no slug, and "exec":
+// true marks its files as executables, as for Executables.
+func WithManifest(name string) DirOption {
+	return func(o *dirOptions) {
+		o.manifest = name
+	}
+}
+
+// manifest is the contents of a manifest file
+type manifest struct {
+	Migrations []manifestEntry `json:"migrations"`
+}
+
+// manifestEntry describes a migration in a manifest file
+type manifestEntry struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"up"`
+	Down    string `json:"down"`
+	Exec    bool   `json:"exec"` // the files are executables, see Executables
+
+	// options the engine does not support yet, which are rejected rather
+	// than silently ignored
+	NoTransaction bool   `json:"no_transaction"`
+	Timeout       string `json:"timeout"`
+}
+
+// checkManifest validates the files found by s against its manifest, naming
+// the migrations the manifest names.
+func (s *fileSource) checkManifest() error {
+	name := path.Join(s.dir, s.manifest)
+	contents, err := s.finder.readFile(name)
+	if err != nil {
+		return err
+	}
+
+	text, err := decodeText(name, contents)
+	if err != nil {
+		return err
+	}
+
+	var mf manifest
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&mf); err != nil {
+		return fmt.Errorf("emigrate: Invalid manifest %q: %w", name, err)
+	}
+
+	listed := make(map[int64]bool, len(mf.Migrations))
+	for _, entry := range mf.Migrations {
+		if listed[entry.Version] {
+			return fmt.Errorf("emigrate: Migration %d is listed twice in manifest %q.", entry.Version, name)
+		}
+		listed[entry.Version] = true
+
+		if entry.NoTransaction || entry.Timeout != "" {
+			return fmt.Errorf("emigrate: Options of migration %d in manifest %q are not supported.", entry.Version, name)
+		}
+
+		files := s.files[entry.Version]
+		if files == nil {
+			return fmt.Errorf("emigrate: Migration %d in manifest %q not found.", entry.Version, name)
+		}
+		for _, way := range []string{"up", "down"} {
+			file := entry.Up
+			if way == "down" {
+				file = entry.Down
+			}
+			info := files[way]
+			if way == "down" && info != nil && info.way == "" && file == "" {
+				// a single file holds both directions
+				continue
+			}
+			if info == nil && file != "" || info != nil && s.rel(info) != file {
+				return fmt.Errorf("emigrate: Manifest %q does not match the %q file of migration %d.", name, way, entry.Version)
+			}
+		}
+
+		if entry.Exec {
+			if !s.exec {
+				return fmt.Errorf("emigrate: Manifest %q lists executable migration %d without Executables.", name, entry.Version)
+			}
+			for _, info := range files {
+				info.exec = true
+			}
+		}
+
+		up := files["up"]
+		if entry.Name != "" && up.slug != "" && entry.Name != up.slug {
+			return fmt.Errorf("emigrate: Manifest %q names migration %d %q, but its files name it %q.", name, entry.Version, entry.Name, up.slug)
+		} else if entry.Name != "" {
+			up.slug = entry.Name
+		}
+	}
+
+	for version, files := range s.files {
+		if !listed[version] {
+			return fmt.Errorf("emigrate: Migration file %q is not listed in manifest %q.", files["up"].path(), name)
+		}
+	}
+	return nil
+}
+
+// rel returns the path of the file relative to the directory of s
+func (s *fileSource) rel(info *nameInfo) string {
+	if s.dir == "." {
+		return info.path()
+	}
+	return strings.TrimPrefix(info.path(), s.dir+"/")
+}
@@ -0,0 +1,63 @@
+package emigrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func manifestFS(manifest string) fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/manifest.json":               {Data: []byte(manifest)},
+		"migrations/001_create_invoice.up.sql":   {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/001_create_invoice.down.sql": {Data: []byte(TestQueryDropInvoiceTable)},
+		"migrations/2024/002_up.sql":             {Data: []byte(TestQueryInsertInvoices)},
+	}
+}
+
+func TestManifest(t *testing.T) {
+	fsys := manifestFS(`{"migrations": [
+		{"version": 1, "name": "create_invoice", "up": "001_create_invoice.up.sql", "down": "001_create_invoice.down.sql"},
+		{"version": 2, "name": "load_invoices", "up": "2024/002_up.sql"}
+	]}`)
+
+	ms, err := FSMigrations(fsys, "migrations", Recursive(), WithManifest("manifest.json"))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	if name := migrationName(ms[1]); name != "load_invoices" {
+		t.Errorf("Expected %s, got %s", "load_invoices", name)
+	}
+}
+
+func TestManifestMismatch(t *testing.T) {
+	var tests = []struct {
+		manifest string
+		expected string
+	}{
+		{`{"migrations": [{"version": 1, "up": "001_create_invoice.up.sql", "down": "001_create_invoice.down.sql"}]}`,
+			`Migration file "migrations/2024/002_up.sql" is not listed`},
+		{`{"migrations": [{"version": 1, "up": "001_create_invoice.up.sql"}, {"version": 2, "up": "2024/002_up.sql"}]}`,
+			`does not match the "down" file of migration 1`},
+		{`{"migrations": [{"version": 1, "up": "001_create_invoice.up.sql", "down": "001_create_invoice.down.sql"}, {"version": 2, "up": "002_up.sql"}]}`,
+			`does not match the "up" file of migration 2`},
+		{`{"migrations": [{"version": 3, "up": "003_up.sql"}]}`,
+			`Migration 3 in manifest "migrations/manifest.json" not found`},
+		{`{"migrations": [{"version": 1, "name": "create_invoices", "up": "001_create_invoice.up.sql", "down": "001_create_invoice.down.sql"}, {"version": 2, "up": "2024/002_up.sql"}]}`,
+			`names migration 1 "create_invoices", but its files name it "create_invoice"`},
+		{`{"migrations": [{"version": 1, "no_transaction": true, "up": "001_create_invoice.up.sql", "down": "001_create_invoice.down.sql"}, {"version": 2, "up": "2024/002_up.sql"}]}`,
+			`Options of migration 1`},
+		{`{"migrations": [{"version": 1, "checksum": "abc"}]}`,
+			`Invalid manifest`},
+	}
+
+	for _, test := range tests {
+		_, err := FSMigrations(manifestFS(test.manifest), "migrations", Recursive(), WithManifest("manifest.json"))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error containing %q, got %v", test.expected, err)
+		}
+	}
+}
@@ -1,35 +1,216 @@
 package emigrate
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"time"
 )
 
 // Errors that could be returned
 var (
-	MissingCurrentMigration = errors.New("Cannot find current migration")
-	DowngradesUnsupported   = errors.New("Downgrades are not currently supported")
-	MigrationVersionChanged = errors.New("Current migration version changed")
-	InitVersionMismatch     = errors.New("Migration version mismatch during init")
-)
+	MissingCurrentMigration   = errors.New("Cannot find current migration")
+	DowngradesUnsupported     = errors.New("Downgrades are not supported by UpgradeToVersion")
+	InvalidDowngradeVersion   = errors.New("Cannot downgrade to a version newer than the current version")
+	MigrationVersionChanged   = errors.New("Current migration version changed")
+	InitVersionMismatch       = errors.New("Migration version mismatch during init")
+	InvalidPruneVersion       = errors.New("Cannot prune history newer than the current version")
+	InvalidBaselineVersion    = errors.New("Cannot rebaseline a database partway through the squashed migrations")
+	LockLost                  = errors.New("The migration lock is no longer held")
+	SQLTxUnavailable          = errors.New("Cannot give a *sql.Tx to a migration or hook run on a NativeDB")
+	RepeatableChecksumChanged = errors.New("Repeatable migration was applied by another migrator while it ran")
 
-// Queries that might be executed by emigrate
-var (
-	QueryGetCurrentVersion = `SELECT version FROM emigrate LIMIT 1`
-	QuerySetVersion        = func(version int64) string {
-		return fmt.Sprintf(`UPDATE emigrate SET version = %d`, version)
-	}
-	QueryCreateTable   = `CREATE TABLE emigrate (version INTEGER)`
-	QueryInsertVersion = `INSERT INTO emigrate (version) VALUES (0)`
+	// BatchNotLatest is returned by DowngradeBatch for a batch that has
+	// migrations from a later batch applied after it
+	BatchNotLatest = errors.New("Cannot downgrade a batch older than the latest applied batch")
 )
 
+// DefaultTable is the name of the table used to track the current version
+// when no other name is configured. The applied versions and history are kept
+// in tables with the same name and an "_applied" and "_history" suffix.
+const DefaultTable = "emigrate"
+
 type Migration interface {
 	Version() int64
 	Upgrade(db *sql.Tx) error
 }
 
+// Named is implemented by migrations that have a human-readable name, which is
+// shown in results and recorded in the history alongside the version.
+type Named interface {
+	Name() string
+}
+
+// migrationName returns the name of a migration, or "" if it has none
+func migrationName(m Migration) string {
+	if n, ok := m.(Named); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// Labeled is implemented by migrations that carry a label, such as the release
+// they shipped in, which is shown in results and recorded in the history
+// alongside the version.
+type Labeled interface {
+	Label() string
+}
+
+// migrationLabel returns the label of a migration, or "" if it has none
+func migrationLabel(m Migration) string {
+	if l, ok := m.(Labeled); ok {
+		return l.Label()
+	}
+	return ""
+}
+
+// SQLer is implemented by migrations that run SQL statements, returning the
+// statements run in the given direction, "up" or "down". The statements are
+// recorded in the history when the Migrator has RecordSQL set.
+type SQLer interface {
+	SQL(direction string) string
+}
+
+// TxOptioner can be implemented by a migration that needs transaction options
+// (such as an isolation level) other than those configured on the Migrator.
+type TxOptioner interface {
+	TxOptions() *sql.TxOptions
+}
+
+// Transactional is implemented by migrations that may have to run outside of
+// a transaction, such as those that build indexes concurrently, which cannot
+// run in one. Only migrations that run SQL can run outside of a transaction,
+// and their SQL is run one statement at a time, on a connection of their own.
+// The version is checked before and after, in transactions of their own, so
+// the migration relies on the lock of the Migrator for protection from
+// concurrent migrators, and is left partially applied if it fails.
+type Transactional interface {
+	Transactional() bool
+}
+
+// transactional reports whether a migration runs in a transaction
+func transactional(m Migration) bool {
+	if t, ok := m.(Transactional); ok {
+		return t.Transactional()
+	}
+	return true
+}
+
+// Timeouter is implemented by migrations that must not run for longer than a
+// given duration, after which their statements are cancelled and the
+// migration fails. A Timeout of 0 leaves the migration to the Timeout of the
+// Migrator, if any. The statements run by Go migrations are not cancelled,
+// but their transaction can no longer commit.
+type Timeouter interface {
+	Timeout() time.Duration
+}
+
+// migrationContext returns the context in which a migration runs, which is
+// cancelled once its Timeout has passed, or failing that the Timeout of the
+// Migrator, if either is set
+func (m *Migrator) migrationContext(migration Migration) (context.Context, context.CancelFunc) {
+	timeout := m.Timeout
+	if t, ok := migration.(Timeouter); ok && t.Timeout() > 0 {
+		timeout = t.Timeout()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Downgrader is implemented by migrations that are able to undo their
+// upgrade. Only migrations that implement it can be reverted by
+// DowngradeToVersion, which checks every migration it would revert before
+// reverting any, and Validate reports those that do not. The migrations
+// provided by this package implement it whether or not they were given a
+// downgrade, but are only treated as Downgraders if they were.
+type Downgrader interface {
+	Downgrade(tx *sql.Tx) error
+}
+
+// canDowngrade reports whether the migration defines a downgrade
+func canDowngrade(m Migration) bool {
+	switch m := m.(type) {
+	case stringMigration:
+		return m.down != ""
+	case *stringMigration:
+		return m.down != ""
+	case *functionMigration:
+		return m.down != nil
+	case *progressMigration:
+		return m.down != nil
+	case *lazyMigration:
+		return m.down
+	case *execMigration:
+		return m.down != ""
+	case *paramMigration:
+		return m.down.SQL != ""
+	case stepper:
+		return len(m.steps("down")) > 0
+	}
+	_, ok := m.(Downgrader)
+	return ok
+}
+
+// VersionGapError indicates that the migration versions are not contiguous
+type VersionGapError struct {
+	previous int64 // the version before the gap
+	next     int64 // the version after the gap
+}
+
+func (e VersionGapError) Error() string {
+	return fmt.Sprintf("emigrate: Gap in migration versions between %d and %d", e.previous, e.next)
+}
+
+// NotInitializedError indicates that the tables used to track migrations do
+// not exist, and Init needs to be called.
+type NotInitializedError struct {
+	table string // the table that could not be found
+	err   error  // the error returned by the database
+}
+
+func (e NotInitializedError) Error() string {
+	return fmt.Sprintf("emigrate: Database not initialized, table %s does not exist", e.table)
+}
+
+// Unwrap returns the error returned by the database
+func (e NotInitializedError) Unwrap() error {
+	return e.err
+}
+
+// OutOfOrderMigrationError indicates that a migration older than the current
+// database version has never been applied
+type OutOfOrderMigrationError struct {
+	version int64 // the version of the unapplied migration
+	current int64 // the current database version
+}
+
+func (e OutOfOrderMigrationError) Error() string {
+	return fmt.Sprintf("emigrate: Migration %d has not been applied but database is at version %d", e.version, e.current)
+}
+
+// ValidationError collects all of the problems found by Validate
+type ValidationError struct {
+	Errors []error
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for idx, err := range e.Errors {
+		msgs[idx] = err.Error()
+	}
+	return fmt.Sprintf("emigrate: %d validation errors:\n\t%s", len(e.Errors), strings.Join(msgs, "\n\t"))
+}
+
+// Unwrap allows errors.Is and errors.As to inspect the individual errors
+func (e ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
 // byVersion implements sorting a migration list by version
 type byVersion []Migration
 
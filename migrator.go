@@ -1,25 +1,210 @@
 package emigrate
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"sort"
+	"time"
 )
 
 type Migrator struct {
-	db         *sql.DB     // the database on which to perform the migrations
-	migrations []Migration // a list of migrations
+	db            DB           // the database on which to perform the migrations
+	migrations    []Migration  // a list of migrations
+	repeatables   []Migration  // the repeatable migrations, by name
+	lockOwner     string       // identifies the lock row taken by this migrator
+	lockRefreshed time.Time    // when the lock row was last taken or refreshed
+	lockConn      DB           // the session holding the session lock, if any
+	lockRelease   func() error // releases lockConn
+	detected      Dialect      // the dialect detected if none is configured
+	dirtied       bool         // whether the last failed migration marked the database dirty
+	batch         int64        // identifies the run in progress
+
+	// TxOptions are used when beginning the transaction for each migration,
+	// unless the migration implements TxOptioner. The transaction is never
+	// read-only, regardless of the ReadOnly field.
+	TxOptions *sql.TxOptions
+
+	// OutOfOrder controls what happens to migrations with a version lower
+	// than the current database version that have never been applied.
+	OutOfOrder OutOfOrderPolicy
+
+	// Gaps controls whether gaps in the version sequence are allowed.
+	Gaps GapPolicy
+
+	// TrackingDB, if set, is used for everything other than running the
+	// migrations, so that session state changed by a migration, such as the
+	// search_path or role, cannot affect how the tracking tables are read and
+	// locked. The tracking tables are still updated within the transaction of
+	// each migration, so migrations should leave their session state as they
+	// found it, or a Schema should be configured.
+	TrackingDB DB
+
+	// Schema and Table configure where the tables used to track migrations
+	// are kept. If Table is empty, DefaultTable is used, and if Schema is
+	// empty the tables are created in the default schema. Names that are
+	// not plain lowercase identifiers, or are reserved words, are quoted by
+	// the Dialect.
+	Schema string
+	Table  string
+
+	// TargetSchema, if set, is made the schema of the session at the start
+	// of every migration, with SET search_path or USE as the Dialect
+	// requires, so that the same migrations can be applied to any schema,
+	// such as one per tenant. Unless Schema is set, the tracking tables are
+	// kept in TargetSchema too, so each schema is tracked separately. The
+	// connections of the database are left using the schema, so should not
+	// be shared with code relying on the default one.
+	TargetSchema string
+
+	// Dialect adapts the queries run by emigrate to the database engine. If
+	// nil, it is detected from the driver of the database by DetectDialect,
+	// and if the driver is not recognized queries follow the SQL standard.
+	Dialect Dialect
+
+	// Queries are run against the tracking tables. If nil, the queries for
+	// the Dialect are used, or DefaultQueries if there is no Dialect.
+	Queries Queries
+
+	// AppliedBy is recorded in the history as who applied each migration. If
+	// empty, the name of the operating system user is recorded.
+	AppliedBy string
+
+	// Application and DeployID are recorded in the history, identifying the
+	// application and the deployment that applied each migration.
+	Application string
+	DeployID    string
+
+	// Tracking selects how applied migrations are recorded. It must not be
+	// changed once the database has been initialized.
+	Tracking TrackingMode
+
+	// RecordSQL records the SQL run by each migration in the history, for
+	// migrations that implement SQLer.
+	RecordSQL bool
+
+	// LockTable protects upgrades and downgrades from concurrent migrators
+	// with a lock row in a table of its own, for databases that cannot lock
+	// otherwise. A lock older than LockExpiry, or DefaultLockExpiry if zero,
+	// is considered stale and taken over, so it should be longer than the
+	// slowest migration.
+	LockTable  bool
+	LockExpiry time.Duration
+
+	// SplitStatements runs the SQL of SQL migrations one statement at a time,
+	// split by SplitSQL, for drivers that reject more than one statement in
+	// a single Exec. The statements run in the transaction of the migration.
+	// Statements are always split on databases without transactional DDL,
+	// so that a failure shows which statements ran, as they are not rolled
+	// back.
+	SplitStatements bool
+
+	// ContinueOnError causes an upgrade to carry on with later migrations
+	// when a migration fails, rather than stopping. The failed migration is
+	// left unapplied, and all failures are returned in an UpgradeError.
+	ContinueOnError bool
+
+	// Timeout cancels any migration that has run for longer, unless the
+	// migration declares a Timeout of its own, as described by Timeouter, so
+	// that ordinary DDL can fail quickly while backfills run for as long as
+	// they need. A Timeout of 0 sets no limit.
+	Timeout time.Duration
+
+	// RefuseTags is a policy refusing to apply migrations with any of the
+	// tags, such as TagDestructive in production unless destructive changes
+	// are explicitly allowed. An upgrade fails with a RefusedTagError before
+	// running any migration if one to be applied has a refused tag.
+	// Downgrades are not affected.
+	RefuseTags []string
+
+	// Retries is how many times a migration that declares itself Retryable
+	// is run again after failing with a transient error, as reported by
+	// IsTransient, waiting RetryDelay before each retry. Other migrations are
+	// never retried. If IsTransient is nil, IsTransientError is used.
+	Retries     int
+	RetryDelay  time.Duration
+	IsTransient func(error) bool
+
+	// WarmUp is how long an upgrade or downgrade waits for a database that
+	// is paused or still starting, such as a serverless database resuming
+	// from a cold start, before giving up. The current version is read
+	// repeatedly, backing off between attempts, for as long as it fails
+	// with an error recognized by IsColdStartError. If zero, the first
+	// error fails the upgrade.
+	WarmUp time.Duration
+
+	// OnlineDDLExecutor, if set, runs the ALTER TABLE statements of
+	// migrations declared OnlineDDL in place of the Migrator, such as by
+	// invoking gh-ost, returning once the table has been altered. Those
+	// migrations run outside a transaction, with their other statements run
+	// by the Migrator in order.
+	OnlineDDLExecutor func(ctx context.Context, statement string) error
+
+	// NoTransactions runs migrations without transactions, for databases
+	// that cannot begin one, such as some analytics engines. Only SQL
+	// migrations can be run, one statement at a time, with the progress of
+	// the migration recorded after each statement, so that a migration that
+	// fails resumes from the failed statement once the dirty database has
+	// been resolved. Repeatable migrations are run again from the start if
+	// they fail. The tracking tables are updated one statement at a time
+	// too. Capabilities reports what is given up. A Transactionless
+	// Dialect, such as BigQueryDialect, implies NoTransactions.
+	NoTransactions bool
+
+	// OnStep, if set, is called as each step of a migration created by
+	// NewStepMigration or NewCompositeMigration completes, to report the
+	// progress of long migrations. It is called within the transaction of
+	// the migration, so the step may still be rolled back.
+	OnStep func(StepProgress)
+
+	// OnProgress, if set, is called whenever a migration created by
+	// NewProgressMigration reports its progress, and after each chunk of a
+	// Chunked migration. Like OnStep, it is called within the transaction
+	// of the migration.
+	OnProgress func(Progress)
+
+	// Params are bound by name to the placeholders of migrations created by
+	// NewParamMigration, such as the number of partitions or the name of a
+	// tablespace for the environment being migrated.
+	Params map[string]interface{}
 }
 
-func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
-	return &Migrator{db, migrations}
+// OutOfOrderPolicy determines how the Migrator handles migrations that are
+// older than the current database version but have not been applied, such as
+// those merged in from a long-running feature branch.
+type OutOfOrderPolicy int
+
+const (
+	OutOfOrderError OutOfOrderPolicy = iota // fail before applying anything
+	OutOfOrderSkip                          // skip them, with a warning in the result
+	OutOfOrderApply                         // apply them before newer migrations
+)
+
+// GapPolicy determines how the Migrator handles gaps in the version sequence
+// of the migrations, such as migrations 1, 2 and 5.
+type GapPolicy int
+
+const (
+	GapError  GapPolicy = iota // fail before applying anything
+	GapWarn                    // allow the gap, with a warning in the result
+	GapIgnore                  // allow the gap silently
+)
+
+// NewMigrator returns a Migrator that runs migrations on db. Repeatable
+// migrations are kept apart from the versioned ones.
+func NewMigrator(db DB, migrations []Migration) *Migrator {
+	versioned, repeatables := splitRepeatable(migrations)
+	return &Migrator{db: db, migrations: versioned, repeatables: repeatables}
 }
 
-// CurrentVersion returns the current migration version of the database
+// CurrentVersion returns the current migration version of the database. If
+// the database has not been initialized a NotInitializedError is returned.
 func (m *Migrator) CurrentVersion() (int64, error) {
 	var currentVersion int64
-	err := m.db.QueryRow(QueryGetCurrentVersion).Scan(&currentVersion)
-	if err != nil {
+	err := m.tracking().QueryRowContext(context.Background(), m.currentVersionQuery()).Scan(&currentVersion)
+	if err != nil && m.dialect().IsMissingTable(err) {
+		return 0, NotInitializedError{m.versionTable(), err}
+	} else if err != nil {
 		return 0, err
 	}
 	return currentVersion, err
@@ -35,74 +220,655 @@ func (m *Migrator) MaxVersion() int64 {
 	return max
 }
 
-func (m *Migrator) setVersion(tx *sql.Tx, version int64) error {
-	query := QuerySetVersion(version)
-	_, err := tx.Exec(query)
-	return err
+// tracking returns the database used for tracking migrations
+func (m *Migrator) tracking() NativeDB {
+	if m.TrackingDB != nil {
+		return nativeDB(m.TrackingDB)
+	}
+	return nativeDB(m.db)
+}
+
+// dialect returns the configured Dialect or, failing that, the one detected
+// from the driver of the database, or the standard one
+func (m *Migrator) dialect() Dialect {
+	if m.Dialect != nil {
+		return m.Dialect
+	}
+	if m.detected == nil {
+		if db, ok := m.db.(*sql.DB); ok {
+			m.detected = DetectDialect(db)
+		} else if d, ok := m.db.(dialecter); ok {
+			m.detected = d.Dialect()
+		}
+		if m.detected == nil {
+			m.detected = ansiDialect{}
+		}
+	}
+	return m.detected
+}
+
+// table returns the quoted and qualified name of the tracking table with the
+// given suffix.
+func (m *Migrator) table(suffix string) string {
+	name := m.Table
+	if name == "" {
+		name = DefaultTable
+	}
+	name = m.dialect().QuoteIdentifier(name + suffix)
+	if m.Schema != "" {
+		name = m.dialect().QuoteIdentifier(m.Schema) + "." + name
+	} else if m.TargetSchema != "" {
+		name = m.dialect().QuoteIdentifier(m.TargetSchema) + "." + name
+	}
+	return name
+}
+
+// versionTable returns the name of the tracking table, which holds either the
+// current version or the ledger of applied versions
+func (m *Migrator) versionTable() string {
+	return m.table("")
+}
+
+// appliedTable returns the name of the table holding the applied versions
+func (m *Migrator) appliedTable() string {
+	if m.ledger() {
+		return m.versionTable()
+	}
+	return m.table("_applied")
+}
+
+// historyTable returns the name of the table holding the migration history
+func (m *Migrator) historyTable() string {
+	return m.table("_history")
+}
+
+// appliedVersions returns the set of versions that have been applied
+func (m *Migrator) appliedVersions() (map[int64]bool, error) {
+	rows, err := m.tracking().QueryContext(context.Background(), m.query(m.queries().GetAppliedVersions, m.appliedTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// Applied returns the versions that have been applied to the database, in
+// ascending order. Migrations applied out of order are included, and reverted
+// migrations are not.
+func (m *Migrator) Applied() ([]int64, error) {
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(int64Slice(versions))
+	return versions, nil
+}
+
+// outOfOrder returns the migrations, which must be sorted, that are older
+// than the current version but have never been applied. The applied versions
+// are only queried if there are migrations older than the current version.
+func (m *Migrator) outOfOrder(migrations []Migration, current int64) ([]Migration, error) {
+	if len(migrations) == 0 || migrations[0].Version() >= current {
+		return nil, nil
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var unapplied []Migration
+	for _, migration := range migrations {
+		if migration.Version() >= current {
+			break
+		} else if !applied[migration.Version()] {
+			unapplied = append(unapplied, migration)
+		}
+	}
+	return unapplied, nil
 }
 
-func (m *Migrator) Upgrade() ([]string, error) {
+// Validate checks the loaded migrations for duplicate versions, gaps in the
+// version sequence (unless allowed by the Gaps policy), migrations missing a
+// downgrade, migrations older than the current version that were never
+// applied, applied migrations that have changed since, a database version
+// that does not correspond to any loaded migration, dependencies on
+// migrations that are not loaded or that form a cycle, parameters missing
+// from Params and, for dialects that are a TransactionChecker, statements
+// that cannot run in the transaction of their migration. All problems are
+// reported together in a ValidationError, and nothing is executed.
+func (m *Migrator) Validate() error {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	migrations := make([]Migration, len(m.migrations))
+	copy(migrations, m.migrations)
+	sort.Sort(byVersion(migrations))
+
+	var errs []error
+	var previous int64
+	for idx, migration := range migrations {
+		version := migration.Version()
+		if idx > 0 && version == previous {
+			errs = append(errs, DuplicateMigrationError{direction: "up", version: version})
+		} else if version > previous+1 && m.Gaps == GapError {
+			errs = append(errs, VersionGapError{previous, version})
+		}
+		if !canDowngrade(migration) {
+			errs = append(errs, MissingMigrationError{"down", version})
+		}
+		previous = version
+	}
+
+	unapplied, err := m.outOfOrder(migrations, current)
+	if err != nil {
+		return err
+	}
+	for _, migration := range unapplied {
+		errs = append(errs, OutOfOrderMigrationError{migration.Version(), current})
+	}
+
+	mismatches, err := m.verifyChecksums(migrations, current)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, mismatches...)
+
+	if current > 0 {
+		if _, ok := byVersion(migrations).Search(current); !ok {
+			errs = append(errs, MissingCurrentMigration)
+		}
+	}
+	errs = append(errs, checkDependencies(migrations)...)
+	errs = append(errs, m.checkParams(migrations)...)
+	errs = append(errs, m.checkTransactions(migrations)...)
+	errs = append(errs, checkRepeatableNames(m.repeatables)...)
+
+	if len(errs) > 0 {
+		return ValidationError{errs}
+	}
+	return nil
+}
+
+// setVersion changes the current version from previous to version. The update
+// is guarded by the previous version, so if another migrator has changed the
+// version in the meantime no row is updated and MigrationVersionChanged is
+// returned. A ledger has no version to update.
+func (m *Migrator) setVersion(db execer, version, previous int64) error {
+	if m.ledger() {
+		return nil
+	}
+	query := m.query(m.queries().SetVersion, m.versionTable())
+	res, err := db.ExecContext(context.Background(), query, version, previous)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows != 1 {
+		return MigrationVersionChanged
+	}
+	return nil
+}
+
+// Upgrade applies all migrations newer than the current database version.
+func (m *Migrator) Upgrade() (*Result, error) {
 	maxVersion := m.MaxVersion()
 	return m.UpgradeToVersion(maxVersion)
 }
 
-// Migration currently only supports upgrades
-func (m *Migrator) UpgradeToVersion(version int64) ([]string, error) {
+// UpgradeToVersion applies the migrations between the current database
+// version and version, handling older unapplied migrations according to the
+// OutOfOrder policy. The returned Result is never nil and describes every
+// migration that was attempted, including the one that failed, if any. It
+// returns DowngradesUnsupported for a version below the current one, which
+// DowngradeToVersion reverts to instead.
+func (m *Migrator) UpgradeToVersion(version int64) (*Result, error) {
+	m.batch = newBatch()
+	result := &Result{Batch: m.batch}
+	if err := m.warmUp(); err != nil {
+		return result, err
+	} else if err := m.checkPrimary(); err != nil {
+		return result, err
+	} else if err := m.lock(); err != nil {
+		return result, err
+	}
+	// a lock that cannot be released goes stale, so the error is ignored
+	defer m.unlock()
+	if err := m.checkDirty(); err != nil {
+		return result, err
+	}
+
 	current, err := m.CurrentVersion()
 	if err != nil {
-		return nil, err
+		return result, err
 	} else if version < current {
-		return nil, DowngradesUnsupported
-	} else if current == version {
-		message := "emigrate: database already at current version"
-		return []string{message}, nil
+		return result, DowngradesUnsupported
 	}
 
 	sort.Sort(byVersion(m.migrations))
 
 	migrations := m.migrations
-	if current > 0 {
+	if current > 0 && version > current {
 		idx, ok := byVersion(m.migrations).Search(current)
 		if !ok {
-			return nil, MissingCurrentMigration
+			return result, MissingCurrentMigration
 		}
 		migrations = migrations[idx+1:]
 	}
 
-	var log []string
-	for _, migration := range migrations {
-		err = m.apply(migration)
+	mismatches, err := m.verifyChecksums(m.migrations, current)
+	if err != nil {
+		return result, err
+	} else if len(mismatches) > 0 {
+		return result, mismatches[0]
+	}
+
+	unapplied, err := m.outOfOrder(m.migrations, current)
+	if err != nil {
+		return result, err
+	}
+	for _, migration := range unapplied {
+		switch m.OutOfOrder {
+		case OutOfOrderError:
+			return result, OutOfOrderMigrationError{migration.Version(), current}
+		case OutOfOrderSkip:
+			result.Migrations = append(result.Migrations, MigrationResult{
+				Version: migration.Version(),
+				Name:    migrationName(migration),
+				Label:   migrationLabel(migration),
+				Status:  StatusSkipped,
+			})
+			result.Warnings = append(result.Warnings, fmt.Sprintf(
+				"emigrate: skipped out-of-order migration %d, database is at version %d",
+				migration.Version(), current))
+		}
+	}
+
+	// only apply the migrations up to the requested version
+	pending := migrations[:0]
+	if version > current {
+		idx, _ := byVersion(migrations).Search(version + 1)
+		pending = migrations[:idx]
+	}
+
+	expected := current
+	for _, migration := range pending {
+		if migration.Version() != expected+1 {
+			switch m.Gaps {
+			case GapError:
+				return result, VersionGapError{expected, migration.Version()}
+			case GapWarn:
+				result.Warnings = append(result.Warnings, fmt.Sprintf(
+					"emigrate: gap in migration versions between %d and %d",
+					expected, migration.Version()))
+			}
+		}
+		expected = migration.Version()
+	}
+
+	if err := m.checkTags(pending); err != nil {
+		return result, err
+	} else if m.OutOfOrder == OutOfOrderApply {
+		if err := m.checkTags(unapplied); err != nil {
+			return result, err
+		}
+	}
+
+	var failed []MigrationResult
+	if m.OutOfOrder == OutOfOrderApply {
+		for _, migration := range unapplied {
+			mr := m.run(result, migration, current)
+			if mr.Err != nil && !m.ContinueOnError {
+				return result, mr.Err
+			} else if mr.Err != nil {
+				failed = append(failed, mr)
+			}
+		}
+	}
+
+	pending, err = planUpgrade(pending, current)
+	if err != nil {
+		return result, err
+	}
+
+	expected = current
+	for _, migration := range pending {
+		var mr MigrationResult
+		if dependency, ok := failedDependency(migration, failed); ok {
+			mr = MigrationResult{
+				Version: migration.Version(),
+				Name:    migrationName(migration),
+				Label:   migrationLabel(migration),
+				Status:  StatusFailed,
+				Err:     DependencyError{migration.Version(), dependency, "which failed"},
+			}
+			result.Migrations = append(result.Migrations, mr)
+		} else {
+			mr = m.run(result, migration, expected)
+		}
+		if mr.Err != nil && !m.ContinueOnError {
+			return result, mr.Err
+		} else if mr.Err != nil {
+			// the version is not bumped, so the next migration expects the
+			// same version as this one did
+			failed = append(failed, mr)
+			continue
+		}
+		// a migration run after a newer one it depends on is applied out of
+		// order, leaving the version alone
+		if migration.Version() > expected {
+			expected = migration.Version()
+		}
+	}
+
+	if len(failed) == 0 && version >= m.MaxVersion() {
+		failed, err = m.runRepeatables(result)
 		if err != nil {
-			return nil, err
+			return result, err
 		}
-		log = append(log, fmt.Sprintf("emigrate: upgraded to version %d", migration.Version()))
 	}
 
-	return log, nil
+	if len(failed) > 0 {
+		return result, UpgradeError{failed}
+	}
+	return result, nil
+}
+
+// run applies a migration and records the outcome in result
+func (m *Migrator) run(result *Result, migration Migration, expected int64) MigrationResult {
+	if warning := m.implicitCommitWarning(migration); warning != "" {
+		result.Warnings = append(result.Warnings, warning)
+	}
+	start := time.Now()
+	m.dirtied = false
+	rows, skipped, err := m.applyWithRetry(migration, expected)
+	if err != nil && m.dirtied {
+		result.Warnings = append(result.Warnings, m.dirtyWarning(migration, err))
+	}
+	mr := MigrationResult{
+		Version:      migration.Version(),
+		Name:         migrationName(migration),
+		Label:        migrationLabel(migration),
+		Duration:     time.Since(start),
+		RowsAffected: rows,
+		Status:       StatusApplied,
+	}
+	if err != nil {
+		mr.Status = StatusFailed
+		mr.Err = err
+	} else if skipped {
+		mr.Status = StatusSkipped
+	}
+	result.Migrations = append(result.Migrations, mr)
+	return mr
 }
 
-func (m *Migrator) apply(migration Migration) error {
+// txOptions returns the transaction options for a migration, preferring those
+// of the migration over those of the migrator. Migration transactions are
+// never read-only.
+func (m *Migrator) txOptions(migration Migration) *sql.TxOptions {
+	opts := m.TxOptions
+	if txo, ok := migration.(TxOptioner); ok {
+		opts = txo.TxOptions()
+	}
+	if opts == nil {
+		return nil
+	}
+	return &sql.TxOptions{Isolation: opts.Isolation}
+}
 
-	tx, err := m.db.Begin()
+// begin starts the transaction in which a migration is applied, taking the
+// lock of the dialect and using the TargetSchema if there is one
+func (m *Migrator) begin(ctx context.Context, migration Migration) (Tx, error) {
+	if m.noTransactions() {
+		return nil, fmt.Errorf("emigrate: Migration %d needs a transaction, but the Migrator runs without transactions.", migration.Version())
+	}
+	tx, err := nativeDB(m.db).BeginTx(ctx, m.txOptions(migration))
 	if err != nil {
-		return err
+		return nil, err
+	} else if err := m.lockTx(ctx, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if err := m.useTargetSchema(ctx, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if m.LockTable {
+		return &heartbeatTx{Tx: tx, m: m}, nil
 	}
+	return tx, nil
+}
 
-	current, err := m.CurrentVersion()
+// useTargetSchema makes the TargetSchema, if there is one, that of the
+// session of db
+func (m *Migrator) useTargetSchema(ctx context.Context, db execer) error {
+	if m.TargetSchema == "" {
+		return nil
+	}
+	s, ok := m.dialect().(SchemaScoper)
+	if !ok {
+		return fmt.Errorf("emigrate: The dialect does not support setting the TargetSchema.")
+	}
+	_, err := db.ExecContext(ctx, s.UseSchema(m.TargetSchema))
+	return err
+}
+
+// checkApply locks the current version in tx and checks that the migration
+// can be applied at the expected version, returning the current version
+func (m *Migrator) checkApply(tx Tx, migration Migration, expected int64) (int64, error) {
+	current, err := m.lockVersion(tx)
 	if err != nil {
-		return err
-	} else if current != migration.Version()-1 {
-		return MigrationVersionChanged
+		return 0, err
+	} else if current != expected {
+		return 0, MigrationVersionChanged
+	} else if migration.Version() < expected {
+		var count int
+		err = tx.QueryRowContext(context.Background(), m.query(m.queries().CountAppliedVersion, m.appliedTable()), migration.Version()).Scan(&count)
+		if err != nil {
+			return 0, err
+		} else if count > 0 {
+			return 0, MigrationVersionChanged
+		}
 	}
+	return current, nil
+}
+
+// splitsStatements reports whether SQL is run one statement at a time, as
+// configured or because the database does not have transactional DDL
+func (m *Migrator) splitsStatements() bool {
+	return m.SplitStatements || !m.dialect().TransactionalDDL()
+}
 
-	err = migration.Upgrade(tx)
+// upgrade runs the upgrade of a migration in tx, returning the number of rows
+// affected if known. SQL is run one statement at a time if it is to be split.
+func (m *Migrator) upgrade(ctx context.Context, tx Tx, migration Migration) (int64, error) {
+	if _, ok := migration.(stepper); ok {
+		return m.runSteps(ctx, tx, migration, "up")
+	} else if pm, ok := migration.(*paramMigration); ok {
+		return m.execParams(ctx, tx, pm, "up")
+	} else if pm, ok := migration.(*progressMigration); ok {
+		return 0, m.runProgress(tx, pm, "up")
+	} else if em, ok := migration.(*execMigration); ok {
+		return 0, em.run(ctx, "up")
+	}
+	sr, ok := migration.(sqlReader)
+	if !ok {
+		stx, err := databaseSQLTx(tx)
+		if err != nil {
+			return 0, err
+		}
+		return 0, migration.Upgrade(stx)
+	}
+	script, err := sr.readSQL("up")
+	if err != nil {
+		return 0, err
+	}
+	script = m.rewriteDDL(migration, script)
+	if m.splitsStatements() {
+		return execStatements(ctx, tx, script)
+	}
+	res, err := tx.ExecContext(ctx, script)
+	if err != nil {
+		return 0, err
+	}
+	// not all drivers support RowsAffected, so ignore the error
+	rows, _ := res.RowsAffected()
+	return rows, nil
+}
+
+// execOutsideTx runs the SQL of a migration in the given direction directly
+// on the database, for migrations that cannot run in a transaction, whose
+// DDL is handed to the OnlineDDLExecutor or which run DDL the dialect cannot
+// run in one. The statements are run one at a
+// time, as drivers may wrap several statements sent at once in a
+// transaction, and on a single connection, so that they share the session.
+func (m *Migrator) execOutsideTx(ctx context.Context, migration Migration, direction string) (int64, error) {
+	sr, ok := migration.(sqlReader)
+	if !ok {
+		return 0, fmt.Errorf("emigrate: Migration %d cannot run outside a transaction, as it does not run SQL.", migration.Version())
+	}
+	script, err := sr.readSQL(direction)
+	if err != nil {
+		return 0, err
+	} else if script == "" && direction == "down" {
+		return 0, fmt.Errorf("emigrate: No downgrade defined for migration %d", migration.Version())
+	}
+
+	if _, ok := m.db.(*txDB); ok {
+		return 0, fmt.Errorf("emigrate: Migration %d cannot run outside a transaction, as the Migrator runs in one.", migration.Version())
+	}
+	conn, release, err := session(ctx, m.db)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	if err := m.useTargetSchema(ctx, conn); err != nil {
+		return 0, err
+	}
+	script = m.rewriteDDL(migration, script)
+	if m.executesOnline(migration) {
+		return m.execOnline(ctx, conn, script)
+	}
+	return execStatements(ctx, conn, script)
+}
+
+// apply runs a single migration in its own transaction, returning the number
+// of rows affected by the upgrade if the migration reports it, and whether it
+// was skipped as its condition did not hold. The database must be at the
+// expected version, and migrations older than the expected version are
+// applied out of order, without changing the current version.
+func (m *Migrator) apply(migration Migration, expected int64) (int64, bool, error) {
+	if err := prepare(migration); err != nil {
+		return 0, false, err
+	} else if m.noTransactions() {
+		return m.applyWithoutTx(migration, expected)
+	} else if err := m.checkTransaction(migration); err != nil {
+		return 0, false, err
+	} else if c, ok := migration.(Chunked); ok {
+		return m.applyChunked(c, expected)
+	}
+	start := time.Now()
+	ctx, cancel := m.migrationContext(migration)
+	defer cancel()
+	tx, err := m.begin(ctx, migration)
+	if err != nil {
+		return 0, false, err
+	}
+
+	current, err := m.checkApply(tx, migration, expected)
+	if err != nil {
+		tx.Rollback()
+		return 0, false, err
+	}
+
+	var rows int64
+	run, err := shouldApply(tx, migration)
+	if err == nil && run {
+		err = preCheck(tx, migration)
+	}
+	if err == nil && !run {
+		return 0, true, m.commitApplied(tx, migration, true, current, expected, start)
+	} else if err == nil && m.runsInTx(migration) {
+		if rows, err = m.upgrade(ctx, tx, migration); err == nil {
+			err = postCheck(tx, migration)
+		}
+	} else if err == nil {
+		tx.Rollback()
+		rows, err = m.execOutsideTx(ctx, migration, "up")
+	}
+	if err != nil {
+		tx.Rollback()
+		entry := m.historyEntry(migration, "up", current, current)
+		entry.Duration = time.Since(start)
+		m.recordFailure(entry)
+		return 0, false, err
+	}
+
+	if !m.runsInTx(migration) {
+		// the migration is recorded in a transaction of its own
+		tx, err = m.begin(ctx, migration)
+		if err != nil {
+			return 0, false, err
+		}
+		if _, err = m.checkApply(tx, migration, expected); err != nil {
+			tx.Rollback()
+			return 0, false, err
+		}
+	}
+
+	if err := m.commitApplied(tx, migration, false, current, expected, start); err != nil {
+		return 0, false, err
+	}
+	return rows, false, nil
+}
+
+// commitApplied records in tx that a migration started at start has been
+// applied at the current version, which is the expected version, and commits
+// tx. A migration skipped by its condition is recorded in the history with a
+// direction of "skip". Migrations applied out of order leave the current
+// version alone.
+func (m *Migrator) commitApplied(tx Tx, migration Migration, skipped bool, current, expected int64, start time.Time) error {
+	next := current
+	if migration.Version() >= expected {
+		next = migration.Version()
+		err := m.setVersion(tx, next, current)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(context.Background(), m.query(m.queries().InsertAppliedVersion, m.appliedTable()), migration.Version())
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	current = migration.Version()
-	err = m.setVersion(tx, current)
+	entry := m.historyEntry(migration, "up", current, next)
+	if skipped {
+		// nothing was run, so there is no SQL to record
+		entry.Direction = "skip"
+		entry.SQL = ""
+	}
+	entry.Duration = time.Since(start)
+	entry.Success = true
+	err = m.insertHistory(tx, entry)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -117,39 +883,88 @@ func (m *Migrator) apply(migration Migration) error {
 }
 
 // Init ensures that the database is properly initialized to be managed by
-// emigrate. If the emigrate tables do not exist they are created.
+// emigrate. If the emigrate tables do not exist they are created. When the
+// table of applied versions is added to an existing database, every loaded
+// migration up to the current version is recorded as applied. If LockTable is
+// set, the lock table is created as well, and for databases without
+// transactional DDL so is the table recording the dirty state.
+//
+// Init is safe to call on a database that is already initialized, and from
+// several processes at once; if another process initializes the database
+// first, Init uses the tables it created.
 func (m *Migrator) Init() error {
-	// try to get the current version, may fail if table doesn't exist
+	// try to get the current version, fails if the table doesn't exist
 	current, err := m.CurrentVersion()
-	if err == nil {
-		return nil
+	if _, ok := err.(NotInitializedError); ok {
+		created := m.createTables()
+		current, err = m.CurrentVersion()
+		if created != nil && err != nil {
+			return created
+		}
 	}
-
-	// try to create the emigrate table
-	tx, err := m.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(QueryCreateTable)
-	if err != nil {
+	if err := m.initApplied(current); err != nil {
 		return err
 	}
-	_, err = tx.Exec(QueryInsertVersion)
-	if err != nil {
+	if err := m.initHistory(); err != nil {
 		return err
 	}
-	err = tx.Commit()
-	if err != nil {
+	if err := m.initDirty(); err != nil {
 		return err
 	}
-
-	current, err = m.CurrentVersion()
-	if err != nil {
+	if err := m.initRepeatable(); err != nil {
 		return err
-	} else if current != 0 {
-		return InitVersionMismatch
 	}
-
+	if err := m.initCheckpoint(); err != nil {
+		return err
+	}
+	if m.LockTable {
+		return m.initLock()
+	}
 	return nil
 }
+
+// createTables creates the emigrate tables, leaving any that already exist
+// untouched.
+func (m *Migrator) createTables() error {
+	queries := []string{
+		m.query(m.queries().CreateTable, m.versionTable()),
+		m.query(m.queries().InsertVersion, m.versionTable()),
+		m.query(m.queries().CreateAppliedTable, m.appliedTable()),
+		m.query(m.queries().CreateHistoryTable, m.historyTable()),
+	}
+	if m.ledger() {
+		queries = []string{
+			m.query(m.queries().CreateLedgerTable, m.versionTable()),
+			m.query(m.queries().CreateHistoryTable, m.historyTable()),
+		}
+	}
+	statements := make([]statement, len(queries))
+	for idx, query := range queries {
+		statements[idx] = statement{query: query}
+	}
+	return m.execTracking(statements)
+}
+
+// initApplied creates the table of applied versions for a database that was
+// initialized before the table existed.
+func (m *Migrator) initApplied(current int64) error {
+	if _, err := m.appliedVersions(); err == nil {
+		return nil
+	}
+
+	statements := []statement{{m.query(m.queries().CreateAppliedTable, m.appliedTable()), nil}}
+	for _, migration := range m.migrations {
+		if migration.Version() > current {
+			continue
+		}
+		statements = append(statements, statement{
+			m.query(m.queries().InsertAppliedVersion, m.appliedTable()),
+			[]interface{}{migration.Version()},
+		})
+	}
+	return m.execTracking(statements)
+}
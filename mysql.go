@@ -0,0 +1,104 @@
+package emigrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// SessionLocker is implemented by dialects that can take a named lock held by
+// a session, such as with GET_LOCK in MySQL. Unless LockTable is set, the
+// Migrator takes it on a connection of its own for the whole of an upgrade or
+// downgrade, so concurrent migrators wait for each other, for up to
+// LockExpiry, rather than failing with MigrationVersionChanged.
+type SessionLocker interface {
+	// LockSession returns a query taking the lock named by its first
+	// argument, waiting for up to its second argument in seconds, which
+	// returns 1 if the lock was taken
+	LockSession() string
+
+	// UnlockSession returns a statement releasing the lock named by its
+	// argument
+	UnlockSession() string
+}
+
+func (MySQLDialect) LockSession() string {
+	return "SELECT GET_LOCK(?, ?)"
+}
+
+func (MySQLDialect) UnlockSession() string {
+	return "DO RELEASE_LOCK(?)"
+}
+
+// ddlRegexp matches statements that change the schema, which databases
+// without transactional DDL commit implicitly, after any leading comments
+var ddlRegexp = regexp.MustCompile(`(?is)^(?:\s*--[^\n]*\n)*\s*(?:CREATE|ALTER|DROP|RENAME|TRUNCATE)\b`)
+
+// lockName returns the name of the session lock, identifying the version
+// table so that migrators of different tables do not wait for each other
+func (m *Migrator) lockName() string {
+	return fmt.Sprintf("emigrate_%x", uint64(m.lockKey()))
+}
+
+// lockSession takes the session lock of the dialect, if it has one, on a
+// connection held until unlockSession
+func (m *Migrator) lockSession() error {
+	l, ok := m.dialect().(SessionLocker)
+	if !ok {
+		return nil
+	}
+	ctx := context.Background()
+	conn, release, err := session(ctx, m.tracking())
+	if err != nil {
+		return err
+	}
+
+	var locked int
+	err = conn.QueryRowContext(ctx, rebind(m.dialect(), l.LockSession()), m.lockName(), int64(m.lockExpiry()/time.Second)).Scan(&locked)
+	if err != nil {
+		release()
+		return err
+	} else if locked != 1 {
+		release()
+		return LockedError{"another session", time.Now()}
+	}
+	m.lockConn, m.lockRelease = conn, release
+	return nil
+}
+
+// unlockSession releases the session lock taken by lockSession, if any
+func (m *Migrator) unlockSession() error {
+	if m.lockConn == nil {
+		return nil
+	}
+	l := m.dialect().(SessionLocker)
+	_, err := m.lockConn.ExecContext(context.Background(), rebind(m.dialect(), l.UnlockSession()), m.lockName())
+	m.lockRelease()
+	m.lockConn, m.lockRelease = nil, nil
+	return err
+}
+
+// implicitCommitWarning returns a warning if a migration running in a
+// transaction runs DDL on a database that commits it implicitly, so that the
+// migration cannot be rolled back if it fails, or "" otherwise
+func (m *Migrator) implicitCommitWarning(migration Migration) string {
+	if m.dialect().TransactionalDDL() || !m.runsInTx(migration) || m.noTransactions() {
+		return ""
+	}
+	sr, ok := migration.(sqlReader)
+	if !ok {
+		return ""
+	}
+	script, err := sr.readSQL("up")
+	if err != nil {
+		return ""
+	}
+	for _, statement := range SplitSQL(script) {
+		if ddlRegexp.MatchString(statement) {
+			return fmt.Sprintf("emigrate: migration %d runs DDL, which is committed implicitly, so it cannot be rolled back if the migration fails",
+				migration.Version())
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package emigrate
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectSessionLock(mock *sqlmock.MockDB, locked int) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT GET_LOCK(?, ?)")).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(locked))
+}
+
+func expectSessionUnlock(mock *sqlmock.MockDB) {
+	mock.ExpectExec(regexp.QuoteMeta("DO RELEASE_LOCK(?)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// Verify that nothing is run while another session holds the lock.
+func TestMySQLSessionLocked(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), Dialect: MySQLDialect{}}
+	expectPrimary(mock, false)
+	expectSessionLock(mock, 0)
+
+	if _, err := m.Upgrade(); err == nil {
+		t.Errorf("Expected a locked error")
+	} else if _, ok := err.(LockedError); !ok {
+		t.Errorf("Expected a locked error, got %v", err)
+	}
+	if m.lockConn != nil {
+		t.Errorf("Expected the lock connection to be closed")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that migrations running DDL in a transaction are warned about on
+// databases that commit DDL implicitly.
+func TestImplicitCommitWarning(t *testing.T) {
+	m := &Migrator{Dialect: MySQLDialect{}}
+	if warning := m.implicitCommitWarning(NewStringMigration(1, "-- add totals\nALTER TABLE invoices ADD total INTEGER", "")); !strings.Contains(warning, "committed implicitly") {
+		t.Errorf("Expected a warning, got %q", warning)
+	}
+	if warning := m.implicitCommitWarning(NewStringMigration(2, "UPDATE invoices SET total = 0", "")); warning != "" {
+		t.Errorf("Expected no warning for DML, got %q", warning)
+	}
+	m.Dialect = PostgresDialect{}
+	if warning := m.implicitCommitWarning(NewStringMigration(1, "ALTER TABLE invoices ADD total INTEGER", "")); warning != "" {
+		t.Errorf("Expected no warning with transactional DDL, got %q", warning)
+	}
+}
+
+func TestMySQLQueriesInnoDB(t *testing.T) {
+	m := &Migrator{Dialect: MySQLDialect{}}
+	expected := "CREATE TABLE IF NOT EXISTS emigrate (version BIGINT) ENGINE=InnoDB"
+	if result := m.query(m.queries().CreateTable, m.versionTable()); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
@@ -0,0 +1,261 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transactionless is implemented by dialects of databases that cannot begin a
+// transaction at all, such as BigQuery, on which the Migrator runs as if
+// NoTransactions were set.
+type Transactionless interface {
+	// NoTransactions reports that the database cannot begin a transaction
+	NoTransactions() bool
+}
+
+// noTransactions reports whether migrations run without transactions, as
+// configured or because the dialect is Transactionless
+func (m *Migrator) noTransactions() bool {
+	t, ok := m.dialect().(Transactionless)
+	return m.NoTransactions || ok && t.NoTransactions()
+}
+
+// Capabilities describes what a Migrator relies on the database for when it
+// runs migrations, and so what is given up on databases that cannot provide
+// it.
+type Capabilities struct {
+	Transactions     bool // each migration runs in a transaction, rolled back if it fails
+	TransactionalDDL bool // schema changes are rolled back along with the rest of the migration
+	Locking          bool // concurrent migrators cannot run the same migration
+}
+
+// Capabilities reports what the Migrator relies on the database for, given
+// its configuration and Dialect.
+func (m *Migrator) Capabilities() Capabilities {
+	_, sessionLock := m.dialect().(SessionLocker)
+	return Capabilities{
+		Transactions:     !m.noTransactions(),
+		TransactionalDDL: !m.noTransactions() && m.dialect().TransactionalDDL(),
+		Locking:          !m.noTransactions() || m.LockTable || sessionLock,
+	}
+}
+
+// Limitations describes what is given up for each capability that is
+// missing, or returns nil if none are.
+func (c Capabilities) Limitations() []string {
+	var limits []string
+	if !c.Transactions {
+		limits = append(limits, "migrations run without transactions, so only SQL migrations can be run, "+
+			"and a failed migration leaves the statements before it applied")
+	} else if !c.TransactionalDDL {
+		limits = append(limits, "schema changes are committed implicitly, so a failed migration may leave some of them applied")
+	}
+	if !c.Locking {
+		limits = append(limits, "concurrent migrators are not kept apart, so only one should run at a time, or LockTable be set")
+	}
+	return limits
+}
+
+// checkVersionWithoutTx checks that the database is at the expected version,
+// for a migration run without a transaction, returning the current version.
+// The version cannot be locked, so it is only read. If out is set the
+// migration is to be applied, and must not have been applied out of order
+// already.
+func (m *Migrator) checkVersionWithoutTx(ctx context.Context, migration Migration, expected int64, out bool) (int64, error) {
+	var current int64
+	if err := m.tracking().QueryRowContext(ctx, m.currentVersionQuery()).Scan(&current); err != nil {
+		return 0, err
+	} else if current != expected {
+		return 0, MigrationVersionChanged
+	} else if out && migration.Version() < expected {
+		var count int
+		err := m.tracking().QueryRowContext(ctx, m.query(m.queries().CountAppliedVersion, m.appliedTable()), migration.Version()).Scan(&count)
+		if err != nil {
+			return 0, err
+		} else if count > 0 {
+			return 0, MigrationVersionChanged
+		}
+	}
+	return current, nil
+}
+
+// applyWithoutTx applies a migration on a database without transactions, as
+// described by NoTransactions, returning the number of rows affected if
+// known. The migration is recorded as applied once its last statement has
+// run.
+func (m *Migrator) applyWithoutTx(migration Migration, expected int64) (int64, bool, error) {
+	start := time.Now()
+	ctx, cancel := m.migrationContext(migration)
+	defer cancel()
+
+	if err := checkWithoutTx(migration); err != nil {
+		return 0, false, err
+	}
+	current, err := m.checkVersionWithoutTx(ctx, migration, expected, true)
+	if err != nil {
+		return 0, false, err
+	}
+	rows, err := m.execWithoutTx(ctx, migration, "up")
+	if err != nil {
+		entry := m.historyEntry(migration, "up", current, current)
+		entry.Duration = time.Since(start)
+		m.recordFailure(entry)
+		return rows, false, err
+	}
+
+	next := current
+	if migration.Version() >= expected {
+		next = migration.Version()
+		if err := m.setVersion(m.tracking(), next, current); err != nil {
+			return rows, false, err
+		}
+	}
+	entry := m.historyEntry(migration, "up", current, next)
+	entry.Duration = time.Since(start)
+	entry.Success = true
+	return rows, false, m.recordWithoutTx(ctx, m.query(m.queries().InsertAppliedVersion, m.appliedTable()), migration, entry)
+}
+
+// revertWithoutTx reverts a migration on a database without transactions,
+// changing the current version from expected to next, as revert does. A
+// migration that was skipped by its condition has nothing to undo.
+func (m *Migrator) revertWithoutTx(migration Migration, expected, next int64, skipped bool) error {
+	start := time.Now()
+	ctx, cancel := m.migrationContext(migration)
+	defer cancel()
+
+	if err := checkWithoutTx(migration); err != nil && !skipped {
+		return err
+	}
+	current, err := m.checkVersionWithoutTx(ctx, migration, expected, false)
+	if err != nil {
+		return err
+	}
+	if !skipped {
+		if _, err := m.execWithoutTx(ctx, migration, "down"); err != nil {
+			entry := m.historyEntry(migration, "down", current, current)
+			entry.Duration = time.Since(start)
+			m.recordFailure(entry)
+			return err
+		}
+	}
+
+	if next != current {
+		if err := m.setVersion(m.tracking(), next, current); err != nil {
+			return err
+		}
+	}
+	entry := m.historyEntry(migration, "down", current, next)
+	entry.Duration = time.Since(start)
+	entry.Success = true
+	return m.recordWithoutTx(ctx, m.query(m.queries().DeleteAppliedVersion, m.appliedTable()), migration, entry)
+}
+
+// recordWithoutTx records the applied version of a migration with query,
+// which takes its version, and entry in the history, and forgets the
+// statements it ran
+func (m *Migrator) recordWithoutTx(ctx context.Context, query string, migration Migration, entry HistoryEntry) error {
+	if _, err := m.tracking().ExecContext(ctx, query, migration.Version()); err != nil {
+		return err
+	} else if err := m.insertHistory(m.tracking(), entry); err != nil {
+		return err
+	}
+	_, err := m.tracking().ExecContext(ctx, m.query(m.queries().DeleteCheckpoint, m.checkpointTable()), migration.Version())
+	return err
+}
+
+// execWithoutTx runs the SQL of a migration accepted by checkWithoutTx in
+// the given direction, one statement at a time on a single connection,
+// returning the number of rows affected if known. Each statement is recorded
+// once it has run, and the statements recorded by an earlier attempt in the
+// same direction are skipped, so that a failed migration resumes where it
+// stopped. A failed statement is returned in a StatementError.
+func (m *Migrator) execWithoutTx(ctx context.Context, migration Migration, direction string) (int64, error) {
+	script, err := migration.(sqlReader).readSQL(direction)
+	if err != nil {
+		return 0, err
+	} else if script == "" && direction == "down" {
+		return 0, fmt.Errorf("emigrate: No downgrade defined for migration %d", migration.Version())
+	}
+
+	done, err := m.statementsDone(ctx, migration.Version(), direction)
+	if err != nil {
+		return 0, err
+	}
+	conn, release, err := session(ctx, m.db)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	if err := m.useTargetSchema(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for idx, stmt := range SplitSQL(m.rewriteDDL(migration, script)) {
+		if idx < done {
+			continue
+		}
+		res, err := conn.ExecContext(ctx, stmt)
+		if err != nil {
+			return total, StatementError{idx + 1, stmt, err}
+		}
+		// not all drivers support RowsAffected, so ignore the error
+		rows, _ := res.RowsAffected()
+		total += rows
+		if err := m.recordStatements(ctx, migration.Version(), direction, idx+1); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// checkWithoutTx returns an error if a migration cannot be run without a
+// transaction, as it does not run SQL or has checks that are given one
+func checkWithoutTx(migration Migration) error {
+	if _, ok := migration.(sqlReader); !ok {
+		return fmt.Errorf("emigrate: Migration %d cannot run without a transaction, as it does not run SQL.", migration.Version())
+	}
+	switch migration.(type) {
+	case Conditional, PreChecker, PostChecker:
+		return fmt.Errorf("emigrate: Migration %d cannot run without a transaction, as its checks need one.", migration.Version())
+	}
+	return nil
+}
+
+// statementsDone returns the number of statements of a migration already run
+// in the given direction, as recorded in its checkpoint
+func (m *Migrator) statementsDone(ctx context.Context, version int64, direction string) (int, error) {
+	var checkpoint string
+	err := m.tracking().QueryRowContext(ctx, m.query(m.queries().GetCheckpoint, m.checkpointTable()), version).Scan(&checkpoint)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	parts := strings.SplitN(checkpoint, ":", 2)
+	if len(parts) != 2 || parts[0] != direction {
+		return 0, nil
+	}
+	done, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("emigrate: Invalid checkpoint %q of migration %d.", checkpoint, version)
+	}
+	return done, nil
+}
+
+// recordStatements records in the checkpoint of a migration that count of its
+// statements have run in the given direction, such as "up:3"
+func (m *Migrator) recordStatements(ctx context.Context, version int64, direction string, count int) error {
+	_, err := m.tracking().ExecContext(ctx, m.query(m.queries().DeleteCheckpoint, m.checkpointTable()), version)
+	if err != nil {
+		return err
+	}
+	_, err = m.tracking().ExecContext(ctx, m.query(m.queries().InsertCheckpoint, m.checkpointTable()),
+		version, fmt.Sprintf("%s:%d", direction, count))
+	return err
+}
@@ -0,0 +1,134 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Sets up the database mock to expect the statements run of migration 1 to
+// be recorded
+func expectStatementsDone(mock *sqlmock.MockDB, checkpoint string) {
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1), checkpoint).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// Verify that a migration run without transactions resumes after the
+// statements recorded as run, records each statement it runs, and is then
+// recorded as applied, without beginning a transaction.
+func TestNoTransactionsUpgrade(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, NoTransactions: true}
+	m.migrations = []Migration{NewStringMigration(1, "CREATE TABLE a (id int); CREATE TABLE b (id int); CREATE TABLE c (id int)", "")}
+
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}).AddRow("up:1"))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b (id int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatementsDone(mock, "up:2")
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE c (id int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatementsDone(mock, "up:3")
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a failed statement run without transactions marks the database
+// dirty, with a warning naming why the statements before it were not rolled
+// back.
+func TestNoTransactionsFailure(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, NoTransactions: true}
+	m.migrations = []Migration{NewStringMigration(1, "CREATE TABLE a (id int); CREATE TABLE b (id int)", "")}
+	failure := errors.New("table b already exists")
+
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCheckpoint(testCheckpointTable))).
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"checkpoint"}))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE a (id int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatementsDone(mock, "up:1")
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b (id int)")).WillReturnError(failure)
+	expectInsertHistory(mock)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertDirtyVersion(testDirtyTable))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := m.Upgrade()
+	if se, ok := err.(StatementError); !ok || se.index != 2 || !errors.Is(err, failure) {
+		t.Errorf("Expected statement 2 to fail, got %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "the Migrator runs without transactions") {
+		t.Errorf("Expected a warning that the Migrator runs without transactions, got %q", result.Warnings)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that migrations that need a transaction are refused without running
+// anything.
+func TestNoTransactionsGoMigration(t *testing.T) {
+	t.Parallel()
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), NoTransactions: true}
+
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+
+	if _, err := m.Upgrade(); err == nil || !strings.Contains(err.Error(), "does not run SQL") {
+		t.Errorf("Expected the migration to be refused, got %v", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		m        Migrator
+		expected Capabilities
+		limits   int
+	}{
+		{Migrator{Dialect: PostgresDialect{}}, Capabilities{true, true, true}, 0},
+		{Migrator{Dialect: MySQLDialect{}}, Capabilities{true, false, true}, 1},
+		{Migrator{Dialect: MySQLDialect{}, NoTransactions: true}, Capabilities{false, false, true}, 1},
+		{Migrator{Dialect: ansiDialect{}, NoTransactions: true}, Capabilities{false, false, false}, 2},
+		{Migrator{Dialect: ansiDialect{}, NoTransactions: true, LockTable: true}, Capabilities{false, false, true}, 1},
+	}
+	for _, test := range tests {
+		c := test.m.Capabilities()
+		if c != test.expected {
+			t.Errorf("%T: expected %+v, got %+v", test.m.Dialect, test.expected, c)
+		} else if limits := c.Limitations(); len(limits) != test.limits {
+			t.Errorf("%T: expected %d limitations, got %q", test.m.Dialect, test.limits, limits)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package emigrate
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// OnlineDDL is implemented by migrations that alter large tables, whose ALTER
+// TABLE statements should be run by an online schema change, which copies the
+// table in the background rather than locking it. If the dialect is a
+// DDLRewriter, such as a MySQLDialect with an OnlineDDL strategy, the
+// statements are rewritten for it. If the Migrator has an OnlineDDLExecutor,
+// such as one running gh-ost, the statements are handed to it instead, and the
+// migration runs outside a transaction.
+type OnlineDDL interface {
+	OnlineDDL() bool
+}
+
+// onlineDDL reports whether a migration declares its DDL to be run online
+func onlineDDL(m Migration) bool {
+	o, ok := m.(OnlineDDL)
+	return ok && o.OnlineDDL()
+}
+
+// DDLRewriter is implemented by dialects that can rewrite a statement of a
+// migration declared OnlineDDL so that it runs as an online schema change.
+type DDLRewriter interface {
+	// RewriteDDL returns statement rewritten to run online, or statement
+	// itself if it need not be
+	RewriteDDL(statement string) string
+}
+
+// OnlineDDLStrategy selects how a MySQLDialect rewrites the ALTER TABLE
+// statements of migrations declared OnlineDDL.
+type OnlineDDLStrategy int
+
+const (
+	OnlineDDLNone    OnlineDDLStrategy = iota // run the statements as written
+	OnlineDDLInPlace                          // add ALGORITHM=INPLACE, LOCK=NONE, so MySQL fails rather than lock the table
+	OnlineDDLVitess                           // add the /*vt+ strategy=vitess */ hint, so Vitess runs an online migration
+)
+
+// alterTableRegexp matches ALTER TABLE statements, after any leading
+// comments, capturing the comments
+var alterTableRegexp = regexp.MustCompile(`(?is)^((?:\s*--[^\n]*\n)*\s*)ALTER\s+TABLE\b`)
+
+// RewriteDDL rewrites ALTER TABLE statements according to the OnlineDDL
+// strategy of the dialect. TiDB alters tables online without being told to,
+// so needs no strategy.
+func (d MySQLDialect) RewriteDDL(statement string) string {
+	match := alterTableRegexp.FindStringSubmatchIndex(statement)
+	if match == nil {
+		return statement
+	}
+	switch d.OnlineDDL {
+	case OnlineDDLInPlace:
+		return strings.TrimRight(strings.TrimSpace(statement), ";") + ", ALGORITHM=INPLACE, LOCK=NONE"
+	case OnlineDDLVitess:
+		return statement[:match[3]] + "ALTER /*vt+ strategy=vitess */ TABLE" + statement[match[1]:]
+	}
+	return statement
+}
+
+// rewriteDDL rewrites the statements of script for the dialect, if the
+// migration declares its DDL to be run online and the dialect is a
+// DDLRewriter
+func (m *Migrator) rewriteDDL(migration Migration, script string) string {
+	r, ok := m.dialect().(DDLRewriter)
+	if !ok || !onlineDDL(migration) {
+		return script
+	}
+	statements := SplitSQL(script)
+	for idx, statement := range statements {
+		statements[idx] = r.RewriteDDL(statement)
+	}
+	return joinScripts(statements)
+}
+
+// executesOnline reports whether the ALTER TABLE statements of a migration
+// are handed to the OnlineDDLExecutor
+func (m *Migrator) executesOnline(migration Migration) bool {
+	return m.OnlineDDLExecutor != nil && onlineDDL(migration)
+}
+
+// runsInTx reports whether a migration runs in a transaction, which those
+// whose statements are handed to the OnlineDDLExecutor do not, nor those
+// running DDL that the dialect cannot run in one
+func (m *Migrator) runsInTx(migration Migration) bool {
+	return transactional(migration) && !m.executesOnline(migration) && !m.separatesDDL(migration)
+}
+
+// execOnline runs the statements of script on db one at a time, handing the
+// ALTER TABLE statements to the OnlineDDLExecutor, and returns the number of
+// rows affected by the others
+func (m *Migrator) execOnline(ctx context.Context, db execer, script string) (int64, error) {
+	var total int64
+	for idx, statement := range SplitSQL(script) {
+		if alterTableRegexp.MatchString(statement) {
+			if err := m.OnlineDDLExecutor(ctx, statement); err != nil {
+				return total, StatementError{idx + 1, statement, err}
+			}
+			continue
+		}
+		res, err := db.ExecContext(ctx, statement)
+		if err != nil {
+			return total, StatementError{idx + 1, statement, err}
+		}
+		// not all drivers support RowsAffected, so ignore the error
+		rows, _ := res.RowsAffected()
+		total += rows
+	}
+	return total, nil
+}
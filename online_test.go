@@ -0,0 +1,80 @@
+package emigrate
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRewriteDDL(t *testing.T) {
+	tests := []struct {
+		strategy  OnlineDDLStrategy
+		statement string
+		expected  string
+	}{
+		{OnlineDDLNone, "ALTER TABLE invoice ADD total int", "ALTER TABLE invoice ADD total int"},
+		{OnlineDDLInPlace, "ALTER TABLE invoice ADD total int;", "ALTER TABLE invoice ADD total int, ALGORITHM=INPLACE, LOCK=NONE"},
+		{OnlineDDLVitess, "-- totals\nalter table invoice ADD total int", "-- totals\nALTER /*vt+ strategy=vitess */ TABLE invoice ADD total int"},
+		{OnlineDDLVitess, "UPDATE invoice SET total = 0", "UPDATE invoice SET total = 0"},
+	}
+	for _, test := range tests {
+		if result := (MySQLDialect{OnlineDDL: test.strategy}).RewriteDDL(test.statement); result != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.statement, test.expected, result)
+		}
+	}
+}
+
+// Verify that only the migrations declared OnlineDDL are rewritten.
+func TestRewriteOnlineMigrations(t *testing.T) {
+	m := Migrator{Dialect: MySQLDialect{OnlineDDL: OnlineDDLVitess}}
+	script := "ALTER TABLE invoice ADD total int"
+
+	if result := m.rewriteDDL(NewStringMigration(1, script, ""), script); result != script {
+		t.Errorf("Expected the migration to be left alone, got %q", result)
+	}
+	expected := "ALTER /*vt+ strategy=vitess */ TABLE invoice ADD total int;\n\n"
+	if result := m.rewriteDDL(NewStringMigration(1, script, "", WithOnlineDDL()), script); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	var o migrationOptions
+	if err := o.parseHeader("001.sql", "-- emigrate:online-ddl\n"+script); err != nil || !o.OnlineDDL() {
+		t.Errorf("Expected the header to declare online DDL, got %v", err)
+	}
+}
+
+// Verify that the ALTER TABLE statements of an online migration are handed
+// to the OnlineDDLExecutor, outside a transaction, and the others are run in
+// order.
+func TestOnlineDDLExecutor(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := NewMigrator(db, []Migration{NewStringMigration(1,
+		"ALTER TABLE invoice ADD total int; UPDATE invoice SET total = 0", "", WithOnlineDDL())})
+	var executed []string
+	m.OnlineDDLExecutor = func(ctx context.Context, statement string) error {
+		executed = append(executed, statement)
+		return nil
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE invoice SET total = 0")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	expectSetVersions(0, mock, 1)
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error upgrading: %s", err)
+	}
+	if len(executed) != 1 || executed[0] != "ALTER TABLE invoice ADD total int" {
+		t.Errorf("Expected the ALTER TABLE statement to be executed online, got %q", executed)
+	}
+	mock.CloseTest(t)
+}
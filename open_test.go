@@ -0,0 +1,61 @@
+package emigrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		drivers []string
+		driver  string
+		dsn     string
+		dialect Dialect
+	}{
+		{"postgres://u:p@db:5432/app?sslmode=disable", []string{"postgres"}, "postgres", "postgres://u:p@db:5432/app?sslmode=disable", nil},
+		{"postgresql://db/app", []string{"postgres", "pgx"}, "pgx", "postgresql://db/app", nil},
+		{"cockroach://root@db:26257/app", []string{"pgx"}, "pgx", "postgres://root@db:26257/app", CockroachDialect{}},
+		{"mysql://u:p%40ss@db:3306/app?parseTime=true", []string{"mysql"}, "mysql", "u:p@ss@tcp(db:3306)/app?parseTime=true", nil},
+		{"mysql:///app", []string{"mysql"}, "mysql", "/app", nil},
+		{"sqlite:///var/lib/app.db", []string{"sqlite"}, "sqlite", "/var/lib/app.db", nil},
+		{"sqlite3://:memory:", []string{"sqlite3"}, "sqlite3", ":memory:", nil},
+		{"spanner://projects/p/instances/i/databases/app", []string{"spanner"}, "spanner", "projects/p/instances/i/databases/app", SpannerDialect{}},
+		{"redshift://u:p@cluster:5439/dev", []string{"postgres"}, "postgres", "postgres://u:p@cluster:5439/dev", RedshiftDialect{}},
+		{"bigquery://proj/us/analytics", []string{"bigquery"}, "bigquery", "bigquery://proj/us/analytics", BigQueryDialect{}},
+	}
+	for _, test := range tests {
+		driver, dsn, dialect, err := parseURL(test.url, test.drivers)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.url, err)
+		} else if driver != test.driver || dsn != test.dsn || dialect != test.dialect {
+			t.Errorf("%s: expected %s %s %T, got %s %s %T", test.url, test.driver, test.dsn, test.dialect, driver, dsn, dialect)
+		}
+	}
+}
+
+func TestParseURLInvalid(t *testing.T) {
+	for _, url := range []string{"app.db", "oracle://db/app", "mysql://db/app"} {
+		if _, _, _, err := parseURL(url, []string{"postgres"}); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+// Verify that pinging gives up once the context is done.
+func TestPingWithBackoff(t *testing.T) {
+	_, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := pingWithBackoff(ctx, db); err == nil {
+		t.Errorf("Expected the closed database to be unreachable")
+	}
+}
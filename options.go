@@ -0,0 +1,139 @@
+package emigrate
+
+import "time"
+
+// migrationOptions holds the optional settings of the migrations provided by
+// this package
+type migrationOptions struct {
+	name     string // a human-readable name
+	label    string // a semantic tag, such as a release
+	checksum string // a declared checksum
+
+	noTransaction bool          // run outside of a transaction
+	timeout       time.Duration // cancel the migration after this long
+	dependsOn     []int64       // the versions the migration depends on
+	retryable     bool          // safe to run again after failing
+	tags          []string      // what the migration does
+	onlineDDL     bool          // run its DDL as an online schema change
+	repeatable    bool          // applied whenever it changes, rather than once
+}
+
+// MigrationOption configures an optional setting of a migration created by
+// NewStringMigration or NewFunctionMigration
+type MigrationOption func(*migrationOptions)
+
+// WithName gives a migration a human-readable name, such as
+// "add_invoice_indexes", which is shown in results and recorded in the
+// history.
+func WithName(name string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.name = name
+	}
+}
+
+// WithLabel tags a migration with a label, such as the release it shipped in
+// or "2024-Q3-invoice-split", which is shown in results and recorded in the
+// history alongside the version.
+func WithLabel(label string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.label = label
+	}
+}
+
+// WithChecksum declares the checksum of a migration, used to detect changes
+// to the migration once it has been applied. Migrations that run Go
+// functions, such as those of NewFunctionMigration, cannot checksum them, so
+// have no checksum unless one is declared, which should be changed whenever
+// the functions are.
+func WithChecksum(checksum string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.checksum = checksum
+	}
+}
+
+// WithTimeout declares how long a migration may run, overriding the Timeout of
+// the Migrator, as described by Timeouter.
+func WithTimeout(timeout time.Duration) MigrationOption {
+	return func(o *migrationOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithDependencies declares the versions a migration depends on, as
+// described by Dependent.
+func WithDependencies(versions ...int64) MigrationOption {
+	return func(o *migrationOptions) {
+		o.dependsOn = versions
+	}
+}
+
+// WithTags tags a migration with what it does, such as TagDestructive, as
+// described by Tagged.
+func WithTags(tags ...string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// WithRetryable declares that a migration is safe to run again after failing,
+// as described by Retryable.
+func WithRetryable() MigrationOption {
+	return func(o *migrationOptions) {
+		o.retryable = true
+	}
+}
+
+// WithOnlineDDL declares that the ALTER TABLE statements of a migration should
+// run as online schema changes, as described by OnlineDDL.
+func WithOnlineDDL() MigrationOption {
+	return func(o *migrationOptions) {
+		o.onlineDDL = true
+	}
+}
+
+func (o *migrationOptions) set(opts []MigrationOption) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+// Name returns the name of the migration, which may be empty
+func (o migrationOptions) Name() string {
+	return o.name
+}
+
+// Label returns the label of the migration, which may be empty
+func (o migrationOptions) Label() string {
+	return o.label
+}
+
+// Transactional reports whether the migration runs in a transaction
+func (o migrationOptions) Transactional() bool {
+	return !o.noTransaction
+}
+
+// Timeout returns how long the migration may run, or 0 if there is no limit
+func (o migrationOptions) Timeout() time.Duration {
+	return o.timeout
+}
+
+// DependsOn returns the versions the migration depends on
+func (o migrationOptions) DependsOn() []int64 {
+	return o.dependsOn
+}
+
+// Retryable reports whether the migration is safe to run again after failing
+func (o migrationOptions) Retryable() bool {
+	return o.retryable
+}
+
+// Tags returns the tags of the migration
+func (o migrationOptions) Tags() []string {
+	return o.tags
+}
+
+// OnlineDDL reports whether the DDL of the migration runs as an online schema
+// change
+func (o migrationOptions) OnlineDDL() bool {
+	return o.onlineDDL
+}
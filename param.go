@@ -0,0 +1,132 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ParamSQL is SQL with placeholders, written in the style of the driver such
+// as $1 or ?, whose arguments are the parameters of the Migrator named by
+// Params, in order.
+type ParamSQL struct {
+	SQL    string
+	Params []string
+}
+
+// paramMigration is an implementation of Migration whose SQL takes arguments
+// from the parameters of the Migrator
+type paramMigration struct {
+	version int64
+	up      ParamSQL
+	down    ParamSQL
+	migrationOptions
+}
+
+// NewParamMigration returns a migration that runs SQL with arguments bound
+// from the Params of the Migrator when it is run, such as the number of
+// partitions to create, so that values that differ between environments are
+// not written into the SQL. The migration cannot be downgraded if the SQL of
+// down is empty. Its checksum is that of the upgrade SQL, so changing the
+// parameters does not change the migration. As the arguments are bound by
+// the database, the SQL is run as a single statement, even if the Migrator
+// splits statements.
+func NewParamMigration(version int64, up, down ParamSQL, opts ...MigrationOption) Migration {
+	m := &paramMigration{version: version, up: up, down: down}
+	m.set(opts)
+	return m
+}
+
+func (m *paramMigration) Version() int64 {
+	return m.version
+}
+
+// Checksum returns the declared checksum of the migration or, failing that,
+// the checksum of the upgrade SQL
+func (m *paramMigration) Checksum() string {
+	if m.checksum != "" {
+		return m.checksum
+	}
+	return checksumString(m.up.SQL)
+}
+
+// SQL returns the upgrade or downgrade SQL of the migration, with its
+// placeholders
+func (m *paramMigration) SQL(direction string) string {
+	if direction == "down" {
+		return m.down.SQL
+	}
+	return m.up.SQL
+}
+
+// Upgrade fails, as the migration can only be run by a Migrator, which binds
+// its parameters
+func (m *paramMigration) Upgrade(tx *sql.Tx) error {
+	return fmt.Errorf("emigrate: Migration %d must be run by a Migrator, which binds its parameters.", m.version)
+}
+
+// Downgrade fails, as the migration can only be run by a Migrator, which
+// binds its parameters
+func (m *paramMigration) Downgrade(tx *sql.Tx) error {
+	return m.Upgrade(tx)
+}
+
+// MissingParamError indicates that a migration uses a parameter that the
+// Migrator does not have
+type MissingParamError struct {
+	version int64  // the version of the migration
+	param   string // the name of the missing parameter
+}
+
+func (e MissingParamError) Error() string {
+	return fmt.Sprintf("emigrate: Migration %d needs parameter %q, which is not in the Params of the Migrator", e.version, e.param)
+}
+
+// execParams runs the SQL of a parameterized migration in the given
+// direction in tx, with the arguments bound from the Params of the Migrator,
+// returning the number of rows affected if known
+func (m *Migrator) execParams(ctx context.Context, tx Tx, migration *paramMigration, direction string) (int64, error) {
+	ps := migration.up
+	if direction == "down" {
+		ps = migration.down
+		if ps.SQL == "" {
+			return 0, fmt.Errorf("emigrate: No downgrade defined for migration %d", migration.version)
+		}
+	}
+
+	args := make([]interface{}, len(ps.Params))
+	for idx, name := range ps.Params {
+		value, ok := m.Params[name]
+		if !ok {
+			return 0, MissingParamError{migration.version, name}
+		}
+		args[idx] = value
+	}
+	res, err := tx.ExecContext(ctx, ps.SQL, args...)
+	if err != nil {
+		return 0, err
+	}
+	// not all drivers support RowsAffected, so ignore the error
+	rows, _ := res.RowsAffected()
+	return rows, nil
+}
+
+// checkParams returns an error for each parameter used by migrations that is
+// missing from the Params of the Migrator
+func (m *Migrator) checkParams(migrations []Migration) []error {
+	var errs []error
+	for _, migration := range migrations {
+		pm, ok := migration.(*paramMigration)
+		if !ok {
+			continue
+		}
+		for _, ps := range []ParamSQL{pm.up, pm.down} {
+			for _, name := range ps.Params {
+				if _, ok := m.Params[name]; !ok {
+					errs = append(errs, MissingParamError{pm.version, name})
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,57 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testQueryCreatePartitions = `SELECT create_partitions('invoices', $1)`
+
+// Verify that the parameters of a migration are bound from the Params of the
+// Migrator.
+func TestParamMigration(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{NewParamMigration(1, ParamSQL{testQueryCreatePartitions, []string{"partitions"}}, ParamSQL{})}
+	m.Params = map[string]interface{}{"partitions": int64(16)}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(testQueryCreatePartitions)).WithArgs(int64(16)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration using a parameter the Migrator does not have is
+// rejected.
+func TestParamMigrationMissing(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{NewParamMigration(1, ParamSQL{testQueryCreatePartitions, []string{"partitions"}}, ParamSQL{})}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	expected := MissingParamError{1, "partitions"}
+	if _, err := m.UpgradeToVersion(1); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	if errs := m.checkParams(m.migrations); len(errs) != 1 || errs[0] != expected {
+		t.Errorf("Expected %v, got %v", expected, errs)
+	}
+	if canDowngrade(m.migrations[0]) {
+		t.Errorf("Expected a migration without down SQL to have no downgrade")
+	}
+	mock.CloseTest(t)
+}
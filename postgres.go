@@ -0,0 +1,132 @@
+package emigrate
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+)
+
+// TxLocker is implemented by dialects that can take a lock held until the end
+// of a transaction, such as an advisory lock. The Migrator takes it at the
+// start of the transaction of every migration, so concurrent migrators wait
+// for each other rather than failing with MigrationVersionChanged.
+type TxLocker interface {
+	// LockTx returns the statement taking the lock identified by key, which
+	// protects the quoted version table
+	LockTx(key int64, table string) string
+}
+
+// txSetter is implemented by dialects that set up the transaction of each
+// migration, once it is locked
+type txSetter interface {
+	setupTx() []string
+}
+
+// TransactionChecker is implemented by dialects that recognize statements that
+// cannot run in a transaction, so that migrations running them in one fail
+// with a NoTransactionError before anything is run, and are reported by
+// Validate.
+type TransactionChecker interface {
+	RequiresNoTransaction(statement string) bool
+}
+
+// LockTx returns a call to pg_advisory_xact_lock, which holds the lock until
+// the transaction ends
+func (PostgresDialect) LockTx(key int64, table string) string {
+	return fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", key)
+}
+
+// postgresNoTxRegexp matches the PostgreSQL statements that cannot run in a
+// transaction, after any leading comments
+var postgresNoTxRegexp = regexp.MustCompile(`(?is)^(?:\s*--[^\n]*\n)*\s*(?:` +
+	`CREATE\s+(?:UNIQUE\s+)?INDEX\s+CONCURRENTLY|DROP\s+INDEX\s+CONCURRENTLY|` +
+	`REINDEX\b.*\bCONCURRENTLY|ALTER\s+TABLE\b.*\bDETACH\s+PARTITION\b.*\bCONCURRENTLY|` +
+	`VACUUM|(?:CREATE|DROP)\s+(?:DATABASE|TABLESPACE)|ALTER\s+SYSTEM)\b`)
+
+// RequiresNoTransaction recognizes statements such as CREATE INDEX
+// CONCURRENTLY, VACUUM and CREATE DATABASE
+func (PostgresDialect) RequiresNoTransaction(statement string) bool {
+	return postgresNoTxRegexp.MatchString(statement)
+}
+
+// NoTransactionError indicates that a migration running in a transaction has
+// a statement that cannot, and needs to be declared to run outside of one,
+// such as with the no-transaction directive
+type NoTransactionError struct {
+	version   int64  // the version of the migration
+	statement string // the statement that cannot run in a transaction
+}
+
+func (e NoTransactionError) Error() string {
+	return fmt.Sprintf("emigrate: Migration %d must run outside of a transaction, as it runs %q", e.version, e.statement)
+}
+
+// lockTx takes the lock of the dialect in tx, if it has one, keyed by the
+// version table so that migrators of different tables do not wait for each
+// other, and then sets up tx as the dialect requires
+func (m *Migrator) lockTx(ctx context.Context, tx Tx) error {
+	l, ok := m.dialect().(TxLocker)
+	if !ok {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, l.LockTx(m.lockKey(), m.versionTable())); err != nil {
+		return err
+	}
+	if s, ok := m.dialect().(txSetter); ok {
+		for _, statement := range s.setupTx() {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lockKey returns the key of the locks of the dialect, derived from the
+// version table
+func (m *Migrator) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(m.versionTable()))
+	return int64(h.Sum64())
+}
+
+// checkTransaction returns a NoTransactionError if a migration running in a
+// transaction runs a statement that the dialect recognizes cannot
+func (m *Migrator) checkTransaction(migration Migration) error {
+	tc, ok := m.dialect().(TransactionChecker)
+	if !ok || !transactional(migration) {
+		return nil
+	}
+	sr, ok := migration.(sqlReader)
+	if !ok {
+		return nil
+	}
+	script, err := sr.readSQL("up")
+	if err != nil {
+		return err
+	}
+	for _, statement := range SplitSQL(script) {
+		if tc.RequiresNoTransaction(statement) {
+			return NoTransactionError{migration.Version(), statement}
+		}
+	}
+	return nil
+}
+
+// checkTransactions returns an error for each migration that checkTransaction
+// rejects
+func (m *Migrator) checkTransactions(migrations []Migration) []error {
+	if _, ok := m.dialect().(TransactionChecker); !ok {
+		return nil
+	}
+	var errs []error
+	for _, migration := range migrations {
+		if err := prepare(migration); err != nil {
+			errs = append(errs, err)
+		} else if err := m.checkTransaction(migration); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
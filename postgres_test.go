@@ -0,0 +1,82 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPostgresRequiresNoTransaction(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  bool
+	}{
+		{"CREATE INDEX CONCURRENTLY invoices_idx ON invoices (id)", true},
+		{"-- build without locking\ncreate unique index concurrently invoices_idx ON invoices (id)", true},
+		{"REINDEX INDEX CONCURRENTLY invoices_idx", true},
+		{"VACUUM ANALYZE invoices", true},
+		{"CREATE DATABASE reports", true},
+		{"CREATE INDEX invoices_idx ON invoices (id)", false},
+		{"INSERT INTO notes (text) VALUES ('VACUUM')", false},
+	}
+	for _, test := range tests {
+		if result := (PostgresDialect{}).RequiresNoTransaction(test.statement); result != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.statement, test.expected, result)
+		}
+	}
+}
+
+// Verify that the advisory lock is taken at the start of every migration
+// transaction.
+func TestPostgresAdvisoryLock(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), Dialect: PostgresDialect{}}
+
+	expectPrimary(mock, false)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock(")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE emigrate SET version = $1 WHERE version = $2")).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO " + testAppliedTable)).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration running a statement that cannot run in a
+// transaction fails before anything is run.
+func TestPostgresNoTransaction(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, Dialect: PostgresDialect{}}
+	m.migrations = []Migration{NewStringMigration(1, "CREATE INDEX CONCURRENTLY invoices_idx ON invoices (id)", "")}
+	expectPrimary(mock, false)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+
+	_, err = m.UpgradeToVersion(1)
+	if _, ok := err.(NoTransactionError); !ok {
+		t.Errorf("Expected a NoTransactionError, got %v", err)
+	}
+	mock.CloseTest(t)
+
+	m.migrations = []Migration{NewNoTxStringMigration(1, "CREATE INDEX CONCURRENTLY invoices_idx ON invoices (id)", "")}
+	if err := m.checkTransaction(m.migrations[0]); err != nil {
+		t.Errorf("Expected no error outside a transaction, got %v", err)
+	}
+}
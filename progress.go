@@ -0,0 +1,150 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Progress describes how far a migration created by NewProgressMigration, or
+// a Chunked migration, has got, as reported to the OnProgress of the Migrator
+type Progress struct {
+	Version   int64
+	Direction string // "up" or "down"
+	Done      int64  // the amount of work done, such as rows backfilled
+	Total     int64  // the total amount of work, or 0 if unknown
+	Message   string
+}
+
+// ProgressFunc is called by a migration created by NewProgressMigration to
+// report its progress, and should be called periodically by long migrations
+// such as backfills. If the Migrator holds the lock, it is refreshed so that
+// the migration is not mistaken for a stale one, and an error is returned if
+// the lock has been lost, which the migration should return.
+type ProgressFunc func(done, total int64, message string) error
+
+// progressMigration is an implementation of Migration that runs Go functions
+// which report their progress
+type progressMigration struct {
+	version int64
+	up      func(tx *sql.Tx, progress ProgressFunc) error
+	down    func(tx *sql.Tx, progress ProgressFunc) error
+	migrationOptions
+}
+
+// NewProgressMigration returns a migration that runs Go functions as
+// NewFunctionMigration does, which are given a ProgressFunc to report their
+// progress to the OnProgress of the Migrator and keep its lock from going
+// stale. Its checksum is declared with WithChecksum.
+func NewProgressMigration(version int64, up, down func(tx *sql.Tx, progress ProgressFunc) error, opts ...MigrationOption) Migration {
+	m := &progressMigration{version: version, up: up, down: down}
+	m.set(opts)
+	return m
+}
+
+// Checksum returns the declared checksum of the migration
+func (m *progressMigration) Checksum() string {
+	return m.checksum
+}
+
+func (m *progressMigration) Version() int64 {
+	return m.version
+}
+
+// Upgrade runs the upgrade function without reporting its progress
+func (m *progressMigration) Upgrade(tx *sql.Tx) error {
+	return m.up(tx, ignoreProgress)
+}
+
+// Downgrade runs the downgrade function without reporting its progress
+func (m *progressMigration) Downgrade(tx *sql.Tx) error {
+	if m.down == nil {
+		return fmt.Errorf("emigrate: No downgrade defined for migration %d", m.version)
+	}
+	return m.down(tx, ignoreProgress)
+}
+
+// ignoreProgress is the ProgressFunc of a migration run outside a Migrator
+func ignoreProgress(done, total int64, message string) error {
+	return nil
+}
+
+// runProgress runs the function of a progress migration in the given
+// direction in tx, reporting its progress
+func (m *Migrator) runProgress(tx Tx, migration *progressMigration, direction string) error {
+	fn := migration.up
+	if direction == "down" {
+		if fn = migration.down; fn == nil {
+			return fmt.Errorf("emigrate: No downgrade defined for migration %d", migration.version)
+		}
+	}
+	stx, err := databaseSQLTx(tx)
+	if err != nil {
+		return err
+	}
+	return fn(stx, func(done, total int64, message string) error {
+		if m.OnProgress != nil {
+			m.OnProgress(Progress{migration.version, direction, done, total, message})
+		}
+		return m.heartbeat(tx)
+	})
+}
+
+// heartbeat refreshes the lock, if it is held and has not been refreshed for
+// a third of its expiry, from within tx, the transaction of the running
+// migration, unless the tracking tables are kept in a TrackingDB. Refreshing
+// the lock on another connection of the same database would wait for tx on
+// databases that lock it whole for writes, such as SQLite, while the lock
+// row updated in tx keeps other migrators from taking it until tx ends. A
+// refresh made in tx only counts once tx commits, as it is undone if tx is
+// rolled back.
+func (m *Migrator) heartbeat(tx Tx) error {
+	if !m.LockTable {
+		return nil
+	}
+	ht, _ := tx.(*heartbeatTx)
+	last := m.lockRefreshed
+	if ht != nil && ht.refreshed.After(last) {
+		last = ht.refreshed
+	}
+	if time.Since(last) < m.lockExpiry()/3 {
+		return nil
+	}
+
+	if m.TrackingDB != nil {
+		now, err := m.refreshLock(m.TrackingDB)
+		if err == nil {
+			m.lockRefreshed = now
+		}
+		return err
+	}
+	now, err := m.refreshLock(tx)
+	if err == nil && ht != nil {
+		ht.refreshed = now
+	}
+	return err
+}
+
+// heartbeatTx is the transaction of a migration run by a Migrator holding the
+// lock, which records when the lock was refreshed within it, so that the
+// refresh only counts once it commits
+type heartbeatTx struct {
+	Tx
+	m         *Migrator
+	refreshed time.Time // when the lock was last refreshed in the transaction
+}
+
+func (t *heartbeatTx) Commit() error {
+	err := t.Tx.Commit()
+	if err == nil && t.refreshed.After(t.m.lockRefreshed) {
+		t.m.lockRefreshed = t.refreshed
+	}
+	return err
+}
+
+func (t *heartbeatTx) sqlTx() (*sql.Tx, bool) {
+	if s, ok := t.Tx.(sqlTxer); ok {
+		return s.sqlTx()
+	}
+	return nil, false
+}
@@ -0,0 +1,133 @@
+package emigrate
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that the progress of a migration is relayed to OnProgress and
+// refreshes the lock once it is due.
+func TestProgressMigration(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	// refresh the lock on every report
+	m := Migrator{db: db, LockTable: true, LockExpiry: time.Nanosecond}
+	m.migrations = []Migration{NewProgressMigration(1, func(tx *sql.Tx, progress ProgressFunc) error {
+		for done := int64(1); done <= 2; done++ {
+			if err := progress(done, 2, "backfilling"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)}
+	var reported []Progress
+	m.OnProgress = func(p Progress) {
+		reported = append(reported, p)
+	}
+
+	expectAcquireLock(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	for idx := 0; idx < 2; idx++ {
+		mock.ExpectExec(regexp.QuoteMeta(testQueries.RefreshLock(testLockTable))).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	expectReleaseLock(mock)
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("Expected %d reports, got %d", 2, len(reported))
+	}
+	if p := reported[1]; p.Version != 1 || p.Direction != "up" || p.Done != 2 || p.Total != 2 || p.Message != "backfilling" {
+		t.Errorf("Unexpected progress %#v", p)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a migration is told when the lock has been lost.
+func TestProgressMigrationLockLost(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, LockTable: true, LockExpiry: time.Nanosecond}
+	m.migrations = []Migration{NewProgressMigration(1, func(tx *sql.Tx, progress ProgressFunc) error {
+		return progress(1, 0, "")
+	}, nil)}
+
+	expectAcquireLock(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RefreshLock(testLockTable))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+	expectReleaseLock(mock)
+
+	if _, err := m.UpgradeToVersion(1); err != LockLost {
+		t.Errorf("Expected %v, got %v", LockLost, err)
+	}
+	if canDowngrade(m.migrations[0]) {
+		t.Errorf("Expected a migration without a down function to have no downgrade")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a refresh of the lock within the transaction of a migration
+// only counts once the transaction commits, as it is undone by a rollback.
+func TestProgressMigrationRefreshRolledBack(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	// refresh the lock once a minute
+	m := Migrator{db: db, LockTable: true, LockExpiry: 3 * time.Minute}
+	var acquired, reported time.Time
+	m.migrations = []Migration{NewProgressMigration(1, func(tx *sql.Tx, progress ProgressFunc) error {
+		// backdate the lock, so that it is due to be refreshed
+		acquired = m.lockRefreshed.Add(-2 * time.Minute)
+		m.lockRefreshed = acquired
+		if err := progress(1, 0, ""); err != nil {
+			return err
+		}
+		reported = m.lockRefreshed
+		return errors.New("backfill failed")
+	}, nil)}
+
+	expectAcquireLock(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RefreshLock(testLockTable))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+	expectReleaseLock(mock)
+
+	if _, err := m.UpgradeToVersion(1); err == nil {
+		t.Fatalf("Expected the migration to fail")
+	}
+	if !reported.Equal(acquired) || !m.lockRefreshed.Equal(acquired) {
+		t.Errorf("Expected the lock to be last refreshed at %s, got %s and then %s", acquired, reported, m.lockRefreshed)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,329 @@
+package emigrate
+
+import "fmt"
+
+// Queries provides the queries a Migrator runs against its tracking tables. A
+// *QuerySet is a Queries, so the simplest way to customize the queries is to
+// change those returned by DefaultQueries or one of the engine-specific query
+// sets and set them as the Queries of the Migrator.
+type Queries interface {
+	QuerySet() *QuerySet
+}
+
+// QuerySet holds the queries a Migrator runs against its tracking tables. Each
+// is given the quoted and qualified name of the table it uses, and takes its
+// values as arguments, written as ? placeholders that are rebound to the bind
+// style of the Dialect.
+type QuerySet struct {
+	// the table holding the current version
+	GetCurrentVersion  func(table string) string
+	LockCurrentVersion func(table string) string
+	SetVersion         func(table string) string // takes version and previous version
+	CreateTable        func(table string) string
+	InsertVersion      func(table string) string
+
+	// the table of applied versions, which is also the ledger
+	CreateAppliedTable   func(table string) string
+	GetAppliedVersions   func(table string) string
+	InsertAppliedVersion func(table string) string // takes version
+	DeleteAppliedVersion func(table string) string // takes version
+	CountAppliedVersion  func(table string) string // takes version
+	PruneAppliedVersions func(table string) string // takes version
+	CreateLedgerTable    func(table string) string
+	GetLedgerVersion     func(table string) string
+
+	// the history table, where InsertHistory takes the columns of the table
+	// in order, except for db_user which is given by the currentUser
+	// expression and applied_at which is set by the database
+	CreateHistoryTable    func(table string) string
+	GetHistory            func(table string) string
+	InsertHistory         func(table, currentUser string) string
+	PruneHistory          func(table string) string // takes version
+	RepairHistoryChecksum func(table string) string // takes checksum, version and true
+	RepairHistoryName     func(table string) string // takes name, version and true
+	DeleteHistory         func(table string) string // takes version
+
+	// the lock table
+	CreateLockTable func(table string) string
+	InsertLock      func(table string) string
+	AcquireLock     func(table string) string // takes owner, current time and stale time
+	ReleaseLock     func(table string) string // takes owner
+	RefreshLock     func(table string) string // takes current time and owner
+	GetLock         func(table string) string
+
+	// the table of dirty versions
+	CreateDirtyTable   func(table string) string
+	GetDirtyVersion    func(table string) string
+	InsertDirtyVersion func(table string) string // takes version
+	ClearDirty         func(table string) string
+
+	// the table of the checksums of applied repeatable migrations
+	CreateRepeatableTable func(table string) string
+	GetRepeatableChecksum func(table string) string // takes name
+	DeleteRepeatable      func(table string) string // takes name
+	InsertRepeatable      func(table string) string // takes name and checksum
+
+	// the table of the checkpoints of data migrations in progress
+	CreateCheckpointTable func(table string) string
+	GetCheckpoint         func(table string) string // takes version
+	DeleteCheckpoint      func(table string) string // takes version
+	InsertCheckpoint      func(table string) string // takes version and checkpoint
+
+	// savepoints within the transaction of a migration, which are given the
+	// name of the savepoint rather than a table
+	Savepoint           func(name string) string
+	RollbackToSavepoint func(name string) string
+	ReleaseSavepoint    func(name string) string
+
+	// the tables of other migration tools, read by their Importer
+	GetGolangMigrateVersion func(table string) string
+	GetGooseVersions        func(table string) string
+	GetFlywayVersions       func(table string) string
+}
+
+// QuerySet returns q itself, so that a *QuerySet is a Queries
+func (q *QuerySet) QuerySet() *QuerySet {
+	return q
+}
+
+// DefaultQueries returns the queries used by a Migrator that has neither
+// Queries nor a Dialect configured, which follow the SQL standard. Versions
+// are stored as BIGINT, so that they can be timestamps.
+func DefaultQueries() *QuerySet {
+	return &QuerySet{
+		GetCurrentVersion: func(table string) string {
+			return fmt.Sprintf(`SELECT version FROM %s LIMIT 1`, table)
+		},
+		LockCurrentVersion: func(table string) string {
+			return fmt.Sprintf(`SELECT version FROM %s LIMIT 1 FOR UPDATE`, table)
+		},
+		SetVersion: func(table string) string {
+			return fmt.Sprintf(`UPDATE %s SET version = ? WHERE version = ?`, table)
+		},
+		CreateTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT)`, table)
+		},
+		InsertVersion: func(table string) string {
+			return fmt.Sprintf(`INSERT INTO %[1]s (version) SELECT version FROM (SELECT 0 AS version) init WHERE NOT EXISTS (SELECT version FROM %[1]s)`, table)
+		},
+
+		CreateAppliedTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT)`, table)
+		},
+		GetAppliedVersions: func(table string) string {
+			return fmt.Sprintf(`SELECT version FROM %s`, table)
+		},
+		InsertAppliedVersion: func(table string) string {
+			return fmt.Sprintf(`INSERT INTO %s (version) VALUES (?)`, table)
+		},
+		DeleteAppliedVersion: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s WHERE version = ?`, table)
+		},
+		CountAppliedVersion: func(table string) string {
+			return fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE version = ?`, table)
+		},
+		PruneAppliedVersions: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s WHERE version < ?`, table)
+		},
+		CreateLedgerTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY)`, table)
+		},
+		GetLedgerVersion: func(table string) string {
+			return fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s`, table)
+		},
+
+		CreateHistoryTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT, name TEXT, label TEXT, checksum TEXT, direction TEXT, from_version BIGINT, to_version BIGINT, applied_by TEXT, db_user TEXT, application TEXT, hostname TEXT, deploy_id TEXT, batch BIGINT, sql_text TEXT, applied_at TIMESTAMP, duration_ms BIGINT, success BOOLEAN)`, table)
+		},
+		GetHistory: func(table string) string {
+			return fmt.Sprintf(`SELECT version, name, label, checksum, direction, from_version, to_version, applied_by, db_user, application, hostname, deploy_id, batch, sql_text, applied_at, duration_ms, success FROM %s ORDER BY applied_at, version`, table)
+		},
+		InsertHistory: func(table, currentUser string) string {
+			return fmt.Sprintf(`INSERT INTO %s (version, name, label, checksum, direction, from_version, to_version, applied_by, db_user, application, hostname, deploy_id, batch, sql_text, applied_at, duration_ms, success) VALUES (?, ?, ?, ?, ?, ?, ?, ?, %s, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?)`, table, currentUser)
+		},
+		PruneHistory: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s WHERE version < ?`, table)
+		},
+		RepairHistoryChecksum: func(table string) string {
+			return fmt.Sprintf(`UPDATE %s SET checksum = ? WHERE version = ? AND direction = 'up' AND success = ?`, table)
+		},
+		RepairHistoryName: func(table string) string {
+			return fmt.Sprintf(`UPDATE %s SET name = ? WHERE version = ? AND direction = 'up' AND success = ?`, table)
+		},
+		DeleteHistory: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s WHERE version = ?`, table)
+		},
+
+		CreateLockTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, locked_by TEXT, locked_at TIMESTAMP)`, table)
+		},
+		InsertLock: func(table string) string {
+			return fmt.Sprintf(`INSERT INTO %[1]s (id) SELECT id FROM (SELECT 1 AS id) init WHERE NOT EXISTS (SELECT id FROM %[1]s)`, table)
+		},
+		AcquireLock: func(table string) string {
+			return fmt.Sprintf(`UPDATE %s SET locked_by = ?, locked_at = ? WHERE id = 1 AND (locked_by IS NULL OR locked_at < ?)`, table)
+		},
+		ReleaseLock: func(table string) string {
+			return fmt.Sprintf(`UPDATE %s SET locked_by = NULL, locked_at = NULL WHERE id = 1 AND locked_by = ?`, table)
+		},
+		RefreshLock: func(table string) string {
+			return fmt.Sprintf(`UPDATE %s SET locked_at = ? WHERE id = 1 AND locked_by = ?`, table)
+		},
+		GetLock: func(table string) string {
+			return fmt.Sprintf(`SELECT locked_by, locked_at FROM %s WHERE id = 1`, table)
+		},
+
+		CreateDirtyTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT)`, table)
+		},
+		GetDirtyVersion: func(table string) string {
+			return fmt.Sprintf(`SELECT version FROM %s ORDER BY version LIMIT 1`, table)
+		},
+		InsertDirtyVersion: func(table string) string {
+			return fmt.Sprintf(`INSERT INTO %s (version) VALUES (?)`, table)
+		},
+		ClearDirty: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s`, table)
+		},
+
+		CreateRepeatableTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (name TEXT, checksum TEXT)`, table)
+		},
+		GetRepeatableChecksum: func(table string) string {
+			return fmt.Sprintf(`SELECT checksum FROM %s WHERE name = ?`, table)
+		},
+		DeleteRepeatable: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s WHERE name = ?`, table)
+		},
+		InsertRepeatable: func(table string) string {
+			return fmt.Sprintf(`INSERT INTO %s (name, checksum) VALUES (?, ?)`, table)
+		},
+
+		CreateCheckpointTable: func(table string) string {
+			return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT, checkpoint TEXT)`, table)
+		},
+		GetCheckpoint: func(table string) string {
+			return fmt.Sprintf(`SELECT checkpoint FROM %s WHERE version = ?`, table)
+		},
+		DeleteCheckpoint: func(table string) string {
+			return fmt.Sprintf(`DELETE FROM %s WHERE version = ?`, table)
+		},
+		InsertCheckpoint: func(table string) string {
+			return fmt.Sprintf(`INSERT INTO %s (version, checkpoint) VALUES (?, ?)`, table)
+		},
+
+		Savepoint: func(name string) string {
+			return fmt.Sprintf(`SAVEPOINT %s`, name)
+		},
+		RollbackToSavepoint: func(name string) string {
+			return fmt.Sprintf(`ROLLBACK TO SAVEPOINT %s`, name)
+		},
+		ReleaseSavepoint: func(name string) string {
+			return fmt.Sprintf(`RELEASE SAVEPOINT %s`, name)
+		},
+
+		GetGolangMigrateVersion: func(table string) string {
+			return fmt.Sprintf(`SELECT version, dirty FROM %s LIMIT 1`, table)
+		},
+		GetGooseVersions: func(table string) string {
+			return fmt.Sprintf(`SELECT version_id, is_applied FROM %s ORDER BY id`, table)
+		},
+		GetFlywayVersions: func(table string) string {
+			return fmt.Sprintf(`SELECT version, type, success FROM %s WHERE version IS NOT NULL ORDER BY installed_rank`, table)
+		},
+	}
+}
+
+// PostgresQueries returns the queries for PostgreSQL, which store times with
+// their time zone.
+func PostgresQueries() *QuerySet {
+	q := DefaultQueries()
+	q.CreateHistoryTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT, name TEXT, label TEXT, checksum TEXT, direction TEXT, from_version BIGINT, to_version BIGINT, applied_by TEXT, db_user TEXT, application TEXT, hostname TEXT, deploy_id TEXT, batch BIGINT, sql_text TEXT, applied_at TIMESTAMPTZ, duration_ms BIGINT, success BOOLEAN)`, table)
+	}
+	q.CreateLockTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, locked_by TEXT, locked_at TIMESTAMPTZ)`, table)
+	}
+	return q
+}
+
+// MySQLQueries returns the queries for MySQL and MariaDB, which store times as
+// DATETIME, as TIMESTAMP columns are updated implicitly, and the SQL of
+// migrations as LONGTEXT, as TEXT is limited to 64KB. The tracking tables use
+// InnoDB, so that they are updated in the transaction of each migration and
+// the current version can be locked, whatever the default storage engine.
+func MySQLQueries() *QuerySet {
+	q := DefaultQueries()
+	q.CreateHistoryTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT, name TEXT, label TEXT, checksum TEXT, direction TEXT, from_version BIGINT, to_version BIGINT, applied_by TEXT, db_user TEXT, application TEXT, hostname TEXT, deploy_id TEXT, batch BIGINT, sql_text LONGTEXT, applied_at DATETIME, duration_ms BIGINT, success BOOLEAN)`, table)
+	}
+	q.CreateLockTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, locked_by TEXT, locked_at DATETIME)`, table)
+	}
+	for _, create := range []*func(string) string{
+		&q.CreateTable, &q.CreateAppliedTable, &q.CreateLedgerTable, &q.CreateHistoryTable, &q.CreateLockTable,
+		&q.CreateDirtyTable, &q.CreateRepeatableTable, &q.CreateCheckpointTable,
+	} {
+		*create = innoDB(*create)
+	}
+	return q
+}
+
+// innoDB returns a query creating a table as create does, using InnoDB
+func innoDB(create func(table string) string) func(table string) string {
+	return func(table string) string {
+		return create(table) + " ENGINE=InnoDB"
+	}
+}
+
+// SQLiteQueries returns the queries for SQLite, which has no row locks, so the
+// current version is read without FOR UPDATE. The write lock SQLite takes on
+// the first write of the transaction serializes migrations instead.
+func SQLiteQueries() *QuerySet {
+	q := DefaultQueries()
+	q.LockCurrentVersion = q.GetCurrentVersion
+	return q
+}
+
+// The queries used by migrators that have no Queries configured, selected by
+// their Dialect
+var (
+	defaultQueries   = DefaultQueries()
+	postgresQueries  = PostgresQueries()
+	mysqlQueries     = MySQLQueries()
+	cockroachQueries = CockroachQueries()
+	sqliteQueries    = SQLiteQueries()
+	spannerQueries   = SpannerQueries()
+	redshiftQueries  = RedshiftQueries()
+	bigQueryQueries  = BigQueryQueries()
+)
+
+// queries returns the configured queries, or those for the dialect
+func (m *Migrator) queries() *QuerySet {
+	if m.Queries != nil {
+		return m.Queries.QuerySet()
+	}
+	switch m.dialect().(type) {
+	case PostgresDialect:
+		return postgresQueries
+	case MySQLDialect:
+		return mysqlQueries
+	case CockroachDialect:
+		return cockroachQueries
+	case SQLiteDialect:
+		return sqliteQueries
+	case SpannerDialect:
+		return spannerQueries
+	case RedshiftDialect:
+		return redshiftQueries
+	case BigQueryDialect:
+		return bigQueryQueries
+	}
+	return defaultQueries
+}
+
+// query returns the query built for table, with its placeholders rebound for
+// the dialect
+func (m *Migrator) query(build func(table string) string, table string) string {
+	return rebind(m.dialect(), build(table))
+}
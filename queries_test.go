@@ -0,0 +1,62 @@
+package emigrate
+
+import "testing"
+
+// Verify that a Migrator without Queries uses those for its Dialect, so that
+// migrators for different engines can be used side by side.
+func TestDialectQueries(t *testing.T) {
+	var tests = []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{nil, `SELECT version FROM emigrate LIMIT 1 FOR UPDATE`},
+		{PostgresDialect{}, `SELECT version FROM emigrate LIMIT 1 FOR UPDATE`},
+		{MySQLDialect{}, `SELECT version FROM emigrate LIMIT 1 FOR UPDATE`},
+		{SQLiteDialect{}, `SELECT version FROM emigrate LIMIT 1`},
+	}
+
+	for _, test := range tests {
+		m := &Migrator{Dialect: test.dialect}
+		result := m.query(m.queries().LockCurrentVersion, m.versionTable())
+		if result != test.expected {
+			t.Errorf("Expected %q for %T, got %q", test.expected, test.dialect, result)
+		}
+	}
+}
+
+func TestPostgresQueries(t *testing.T) {
+	m := &Migrator{Dialect: PostgresDialect{}}
+	expected := `UPDATE emigrate SET version = $1 WHERE version = $2`
+	if result := m.query(m.queries().SetVersion, m.versionTable()); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+	expected = `CREATE TABLE IF NOT EXISTS emigrate (version BIGINT)`
+	if result := m.query(m.queries().CreateTable, m.versionTable()); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+type testCustomQueries struct{}
+
+func (testCustomQueries) QuerySet() *QuerySet {
+	q := DefaultQueries()
+	q.GetCurrentVersion = func(table string) string {
+		return "SELECT current_version FROM " + table
+	}
+	return q
+}
+
+// Verify that configured Queries take precedence over those of the Dialect.
+func TestCustomQueries(t *testing.T) {
+	m := &Migrator{Dialect: SQLiteDialect{}, Queries: testCustomQueries{}}
+	expected := `SELECT current_version FROM emigrate`
+	if result := m.query(m.queries().GetCurrentVersion, m.versionTable()); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	q := MySQLQueries()
+	m = &Migrator{Queries: q}
+	if m.queries() != q {
+		t.Errorf("Expected the configured QuerySet to be used")
+	}
+}
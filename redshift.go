@@ -0,0 +1,65 @@
+package emigrate
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedshiftDialect is the Dialect for Amazon Redshift, which speaks the
+// protocol of PostgreSQL, so is opened with its drivers. Its DDL is
+// transactional, except for statements such as VACUUM and those on external
+// tables, which cannot run in a transaction, so it is a TransactionChecker.
+// It takes no advisory lock, as Redshift does not support them, nor locks the
+// current version, as it has no FOR UPDATE, relying on its serializable
+// transactions to fail concurrent migrators instead. It uses
+// RedshiftQueries.
+type RedshiftDialect struct{}
+
+func (RedshiftDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, `"`)
+}
+
+func (RedshiftDialect) CurrentUser() string {
+	return "current_user"
+}
+
+func (RedshiftDialect) Placeholder(n int) string {
+	return DollarPlaceholder(n)
+}
+
+func (RedshiftDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (RedshiftDialect) IsMissingTable(err error) bool {
+	return PostgresDialect{}.IsMissingTable(err)
+}
+
+func (d RedshiftDialect) UseSchema(name string) string {
+	return "SET search_path TO " + d.QuoteIdentifier(name)
+}
+
+// redshiftNoTxRegexp matches the Redshift statements that cannot run in a
+// transaction, after any leading comments
+var redshiftNoTxRegexp = regexp.MustCompile(`(?is)^(?:\s*--[^\n]*\n)*\s*(?:` +
+	`VACUUM|(?:CREATE|DROP)\s+DATABASE|(?:CREATE|DROP|ALTER)\s+EXTERNAL\s+(?:TABLE|SCHEMA|VIEW)|` +
+	`ALTER\s+TABLE\b.*\bAPPEND\s+FROM|ALTER\s+TABLE\b.*\bALTER\s+COLUMN\b.*\bTYPE)\b`)
+
+// RequiresNoTransaction recognizes statements such as VACUUM, CREATE EXTERNAL
+// TABLE and ALTER TABLE APPEND
+func (RedshiftDialect) RequiresNoTransaction(statement string) bool {
+	return redshiftNoTxRegexp.MatchString(statement)
+}
+
+// RedshiftQueries returns the queries for Amazon Redshift, which are those
+// for PostgreSQL except that the current version is read without FOR
+// UPDATE, and the SQL of migrations is stored as VARCHAR(65535) and their
+// names and labels as VARCHAR(1024), as TEXT is limited to 256 bytes.
+func RedshiftQueries() *QuerySet {
+	q := PostgresQueries()
+	q.LockCurrentVersion = q.GetCurrentVersion
+	q.CreateHistoryTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version BIGINT, name VARCHAR(1024), label VARCHAR(1024), checksum TEXT, direction TEXT, from_version BIGINT, to_version BIGINT, applied_by TEXT, db_user TEXT, application TEXT, hostname TEXT, deploy_id TEXT, batch BIGINT, sql_text VARCHAR(65535), applied_at TIMESTAMPTZ, duration_ms BIGINT, success BOOLEAN)`, table)
+	}
+	return q
+}
@@ -0,0 +1,57 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRedshiftRequiresNoTransaction(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  bool
+	}{
+		{"VACUUM DELETE ONLY events", true},
+		{"CREATE EXTERNAL TABLE spectrum.events (id BIGINT) STORED AS PARQUET LOCATION 's3://events/'", true},
+		{"ALTER TABLE events APPEND FROM staging_events", true},
+		{"ALTER TABLE events ALTER COLUMN name TYPE VARCHAR(512)", true},
+		{"ALTER TABLE events ADD COLUMN name VARCHAR(256)", false},
+		{"CREATE TABLE events (id BIGINT) DISTKEY (id)", false},
+	}
+	for _, test := range tests {
+		if result := (RedshiftDialect{}).RequiresNoTransaction(test.statement); result != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.statement, test.expected, result)
+		}
+	}
+}
+
+// Verify that a migration on Redshift runs in a transaction, reading the
+// current version without locking it.
+func TestRedshiftUpgrade(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, Dialect: RedshiftDialect{}}
+	m.migrations = []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE emigrate SET version = $1 WHERE version = $2")).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO " + testAppliedTable)).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
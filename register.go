@@ -0,0 +1,50 @@
+package emigrate
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// registry holds the migrations registered with Register
+var registry struct {
+	sync.Mutex
+	migrations []Migration
+}
+
+// Register adds a migration to those returned by CollectRegistered, so that Go
+// migrations can register themselves from the init function of the file that
+// defines them, keeping one migration per file:
+//
+//	func init() {
+//		emigrate.Register(emigrate.NewFunctionMigration(20240101120000, upBackfillTotals, nil))
+//	}
+//
+// Like sql.Register, Register panics if the migration is nil or its version,
+// or the name of a repeatable migration, has already been registered.
+func Register(m Migration) {
+	if m == nil {
+		panic("emigrate: Register migration is nil")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for _, r := range registry.migrations {
+		if isRepeatable(m) && isRepeatable(r) && migrationName(m) == migrationName(r) {
+			panic(fmt.Sprintf("emigrate: Register called twice for repeatable migration %q", migrationName(m)))
+		} else if !isRepeatable(m) && !isRepeatable(r) && m.Version() == r.Version() {
+			panic(fmt.Sprintf("emigrate: Register called twice for migration %d", m.Version()))
+		}
+	}
+	registry.migrations = append(registry.migrations, m)
+}
+
+// CollectRegistered returns the migrations added by Register, sorted by
+// version, with the repeatable migrations last. They can be combined with
+// migrations loaded from files using MergeMigrations.
+func CollectRegistered() []Migration {
+	registry.Lock()
+	defer registry.Unlock()
+	versioned, repeatables := splitRepeatable(registry.migrations)
+	sort.Sort(byVersion(versioned))
+	return append(versioned, repeatables...)
+}
@@ -0,0 +1,34 @@
+package emigrate
+
+import "testing"
+
+// Verify that registered migrations are collected in order, and that
+// registering a version twice panics.
+func TestRegister(t *testing.T) {
+	saved := registry.migrations
+	registry.migrations = nil
+	defer func() { registry.migrations = saved }()
+
+	Register(NewRepeatableMigration("totals", TestQueryCreateTotalsView))
+	Register(NewFunctionMigration(2, nil, nil))
+	Register(NewStringMigration(1, TestQueryCreateInvoiceTable, TestQueryDropInvoiceTable))
+
+	ms := CollectRegistered()
+	if len(ms) != 3 {
+		t.Fatalf("Expected %d migrations, got %d", 3, len(ms))
+	}
+	if ms[0].Version() != 1 || ms[1].Version() != 2 || !isRepeatable(ms[2]) {
+		t.Errorf("Expected versions 1 and 2 followed by the repeatable migration")
+	}
+
+	for _, m := range []Migration{nil, NewFunctionMigration(1, nil, nil), NewRepeatableMigration("totals", "")} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected Register to panic for %#v", m)
+				}
+			}()
+			Register(m)
+		}()
+	}
+}
@@ -0,0 +1,137 @@
+package emigrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// statement is a query to be run with its arguments
+type statement struct {
+	query string
+	args  []interface{}
+}
+
+// execTracking runs statements against the tracking tables in a single
+// transaction, or one at a time if the Migrator runs without transactions or
+// any of them is DDL that the dialect cannot run in one
+func (m *Migrator) execTracking(statements []statement) error {
+	ctx := context.Background()
+	separate := m.noTransactions()
+	for _, stmt := range statements {
+		separate = separate || m.isSeparateDDL(stmt.query)
+	}
+	if separate {
+		for _, stmt := range statements {
+			if _, err := m.tracking().ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tx, err := m.tracking().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				return fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RepairOptions confirms which problems Repair is allowed to fix
+type RepairOptions struct {
+	Checksums bool // record the checksums of applied migrations that have changed
+	Names     bool // record the names of applied migrations that were renamed
+	Deleted   bool // remove the history of migrations that are no longer loaded
+}
+
+// RepairResult lists the versions found to need repair, whether or not they
+// were repaired
+type RepairResult struct {
+	Checksums []int64 // applied migrations whose checksum differs from the history
+	Names     []int64 // applied migrations whose name differs from the history
+	Deleted   []int64 // migrations in the history that are no longer loaded
+}
+
+// Repair brings the history in line with the loaded migrations. The recorded
+// checksums and names of applied migrations are replaced with those of the
+// migrations as loaded, and the history of migrations that are no longer
+// loaded is removed, each only if confirmed by opts. The result lists every
+// problem found, so calling Repair with no options reports what would be
+// changed without changing anything. All repairs are made in a single
+// transaction.
+func (m *Migrator) Repair(opts RepairOptions) (*RepairResult, error) {
+	result := &RepairResult{}
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return result, err
+	}
+	entries, err := m.History()
+	if err != nil {
+		return result, err
+	}
+
+	recorded := latestUpgrades(entries, false)
+	loaded := make(map[int64]bool)
+	var statements []statement
+	sort.Sort(byVersion(m.migrations))
+	for _, migration := range m.migrations {
+		version := migration.Version()
+		loaded[version] = true
+		entry, ok := recorded[version]
+		if !ok || !applied[version] {
+			continue
+		}
+		sum, err := verifiedChecksum(migration)
+		if err != nil {
+			return result, err
+		}
+		if sum != "" && sum != entry.Checksum {
+			result.Checksums = append(result.Checksums, version)
+			if opts.Checksums {
+				statements = append(statements, statement{
+					m.query(m.queries().RepairHistoryChecksum, m.historyTable()),
+					[]interface{}{sum, version, true},
+				})
+			}
+		}
+		if name := migrationName(migration); name != entry.Name {
+			result.Names = append(result.Names, version)
+			if opts.Names {
+				statements = append(statements, statement{
+					m.query(m.queries().RepairHistoryName, m.historyTable()),
+					[]interface{}{name, version, true},
+				})
+			}
+		}
+	}
+
+	// repeatable migrations are recorded with no version, so are never deleted
+	deleted := map[int64]bool{0: true}
+	for _, entry := range entries {
+		if !loaded[entry.Version] && !deleted[entry.Version] {
+			deleted[entry.Version] = true
+			result.Deleted = append(result.Deleted, entry.Version)
+		}
+	}
+	sort.Sort(int64Slice(result.Deleted))
+	if opts.Deleted {
+		for _, version := range result.Deleted {
+			statements = append(statements, statement{
+				m.query(m.queries().DeleteHistory, m.historyTable()),
+				[]interface{}{version},
+			})
+		}
+	}
+
+	if len(statements) == 0 {
+		return result, nil
+	}
+	return result, m.execTracking(statements)
+}
@@ -0,0 +1,67 @@
+package emigrate
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupRepair(t *testing.T) (*sqlmock.MockDB, Migrator) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, migrations: []Migration{
+		NewStringMigration(1, "up", "down", WithName("create_invoices")),
+		NewStringMigration(2, "changed", "down", WithName("index_invoices")),
+	}}
+	expectAppliedQuery(mock, 1, 2)
+	expectHistoryQuery(mock,
+		HistoryEntry{Version: 1, Name: "create_invoices", Checksum: checksumString("up"),
+			Direction: "up", ToVersion: 1, Success: true},
+		HistoryEntry{Version: 2, Name: "add_invoice_index", Checksum: checksumString("up"),
+			Direction: "up", FromVersion: 1, ToVersion: 2, Success: true},
+		HistoryEntry{Version: 3, Direction: "up", FromVersion: 2, ToVersion: 3, Success: true},
+		HistoryEntry{Version: 3, Direction: "down", FromVersion: 3, ToVersion: 2, Success: true},
+	)
+	return mock, m
+}
+
+// Verify that Repair reports problems without changing anything unless
+// confirmed.
+func TestRepairReport(t *testing.T) {
+	t.Parallel()
+	mock, m := setupRepair(t)
+
+	result, err := m.Repair(RepairOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error during repair: %s", err)
+	}
+	expected := RepairResult{Checksums: []int64{2}, Names: []int64{2}, Deleted: []int64{3}}
+	if fmt.Sprint(*result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, *result)
+	}
+	mock.CloseTest(t)
+}
+
+func TestRepair(t *testing.T) {
+	t.Parallel()
+	mock, m := setupRepair(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RepairHistoryChecksum(testHistoryTable))).
+		WithArgs(checksumString("changed"), int64(2), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RepairHistoryName(testHistoryTable))).
+		WithArgs("index_invoices", int64(2), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteHistory(testHistoryTable))).WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if _, err := m.Repair(RepairOptions{Checksums: true, Names: true, Deleted: true}); err != nil {
+		t.Errorf("Unexpected error during repair: %s", err)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,380 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Repeatable is implemented by migrations that have no version, such as those
+// that define views, functions or grants. Repeatable migrations are
+// identified by their name, and applied whenever their checksum differs from
+// the one recorded when they were last applied, so they should replace what
+// they define, as with CREATE OR REPLACE VIEW. They are applied in order of
+// name once an upgrade has brought the database to the newest version, each
+// in its own transaction, and cannot be downgraded.
+//
+// The Migrator only treats a migration as repeatable if Repeatable returns
+// true, and ignores its Version.
+type Repeatable interface {
+	Repeatable() bool
+}
+
+// isRepeatable reports whether a migration is repeatable
+func isRepeatable(m Migration) bool {
+	r, ok := m.(Repeatable)
+	return ok && r.Repeatable()
+}
+
+// splitRepeatable separates the repeatable migrations of ms, sorted by name,
+// from the versioned ones
+func splitRepeatable(ms []Migration) (versioned, repeatable []Migration) {
+	for _, m := range ms {
+		if isRepeatable(m) {
+			repeatable = append(repeatable, m)
+		} else {
+			versioned = append(versioned, m)
+		}
+	}
+	sort.SliceStable(repeatable, func(i, j int) bool {
+		return migrationName(repeatable[i]) < migrationName(repeatable[j])
+	})
+	return versioned, repeatable
+}
+
+// NewRepeatableMigration returns a repeatable migration that runs the SQL up
+// whenever it changes. The name identifies the migration, so must be unique
+// among the repeatable migrations and not change.
+func NewRepeatableMigration(name, up string, opts ...MigrationOption) Migration {
+	m := &repeatableMigration{up: up}
+	m.set(opts)
+	m.name = name
+	return m
+}
+
+// repeatableMigration is an implementation of Migration that runs SQL whenever
+// it changes, rather than once
+type repeatableMigration struct {
+	up string // the SQL to run
+	migrationOptions
+}
+
+// Version returns 0, as repeatable migrations have no version
+func (m *repeatableMigration) Version() int64 {
+	return 0
+}
+
+func (m *repeatableMigration) Repeatable() bool {
+	return true
+}
+
+// Checksum returns the declared checksum of the migration or, failing that,
+// the checksum of its SQL
+func (m *repeatableMigration) Checksum() string {
+	if m.checksum != "" {
+		return m.checksum
+	}
+	return checksumString(m.up)
+}
+
+func (m *repeatableMigration) Upgrade(tx *sql.Tx) error {
+	_, err := tx.Exec(m.up)
+	return err
+}
+
+// SQL returns the SQL of the migration, which only runs up
+func (m *repeatableMigration) SQL(direction string) string {
+	if direction == "down" {
+		return ""
+	}
+	return m.up
+}
+
+func (m *repeatableMigration) readSQL(direction string) (string, error) {
+	return m.SQL(direction), nil
+}
+
+// DuplicateRepeatableError indicates that more than one repeatable migration
+// has the same name
+type DuplicateRepeatableError struct {
+	name   string
+	first  string // where the first migration was found, if known
+	second string // where the conflicting migration was found, if known
+}
+
+func (e DuplicateRepeatableError) Error() string {
+	if e.first != "" || e.second != "" {
+		return fmt.Sprintf("emigrate: Duplicate repeatable migration %q in %q and %q", e.name, e.first, e.second)
+	}
+	return fmt.Sprintf("emigrate: Duplicate repeatable migration %q", e.name)
+}
+
+// checkRepeatableNames returns an error for each name shared by more than one
+// of the repeatable migrations ms, which must be sorted by name
+func checkRepeatableNames(ms []Migration) []error {
+	var errs []error
+	for idx := 1; idx < len(ms); idx++ {
+		name := migrationName(ms[idx])
+		if name == migrationName(ms[idx-1]) && (idx == 1 || name != migrationName(ms[idx-2])) {
+			errs = append(errs, DuplicateRepeatableError{name: name})
+		}
+	}
+	return errs
+}
+
+// repeatableRegexp matches the names of repeatable migration files, such as
+// R__invoice_totals_view.sql
+var repeatableRegexp = regexp.MustCompile(`^R__(?P<name>[A-Za-z0-9_-]+)\.(?P<ext>[A-Za-z0-9]+)$`)
+
+// parseRepeatableName returns the information in the name of a repeatable
+// migration file, or nil if it is not one
+func parseRepeatableName(dir, name string) *nameInfo {
+	base := name
+	compressed := len(name) > 3 && strings.EqualFold(name[len(name)-3:], ".gz")
+	if compressed {
+		base = name[:len(name)-3]
+	}
+	match := repeatableRegexp.FindStringSubmatch(base)
+	if match == nil {
+		return nil
+	}
+	return &nameInfo{
+		dir:  dir,
+		name: name,
+		slug: match[1],
+		way:  "up",
+		ext:  strings.ToLower(match[2]),
+		gzip: compressed,
+	}
+}
+
+// repeatableMigrations returns the repeatable migrations found by s, sorted by
+// name, including those whose header declares them repeatable. They are
+// always read when loaded, even if s is lazy.
+func (s *fileSource) repeatableMigrations() ([]Migration, error) {
+	names := make([]string, 0, len(s.repeatables))
+	for name := range s.repeatables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ms := make([]Migration, 0, len(names))
+	for _, name := range names {
+		info := s.repeatables[name]
+		up, err := s.contents(info)
+		if err != nil {
+			return nil, err
+		}
+		m := &repeatableMigration{up: up}
+		m.name = name
+		if err := m.parseHeader(info.path(), up); err != nil {
+			return nil, err
+		}
+		if info.way == "" {
+			var down string
+			if m.up, down, err = splitDirectives(info.name, up); err != nil {
+				return nil, err
+			} else if down != "" {
+				return nil, fmt.Errorf("emigrate: Repeatable migration %q cannot be downgraded.", info.path())
+			}
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// repeatableTable returns the name of the table holding the checksums of the
+// applied repeatable migrations
+func (m *Migrator) repeatableTable() string {
+	return m.table("_repeatable")
+}
+
+// initRepeatable creates the table of repeatable migrations, if any are
+// loaded and it does not exist
+func (m *Migrator) initRepeatable() error {
+	if len(m.repeatables) == 0 {
+		return nil
+	}
+	_, err := m.tracking().ExecContext(context.Background(), m.query(m.queries().CreateRepeatableTable, m.repeatableTable()))
+	return err
+}
+
+// runRepeatables applies the repeatable migrations that have changed since
+// they were last applied, recording the outcome in result. The failed
+// migrations are returned if ContinueOnError is set, and otherwise the first
+// failure stops the run.
+func (m *Migrator) runRepeatables(result *Result) ([]MigrationResult, error) {
+	var failed []MigrationResult
+	for _, migration := range m.repeatables {
+		start := time.Now()
+		applied, rows, err := m.applyRepeatable(migration)
+		if !applied && err == nil {
+			continue
+		}
+		mr := MigrationResult{
+			Name:         migrationName(migration),
+			Label:        migrationLabel(migration),
+			Duration:     time.Since(start),
+			RowsAffected: rows,
+			Status:       StatusApplied,
+		}
+		if err != nil {
+			mr.Status = StatusFailed
+			mr.Err = err
+		}
+		result.Migrations = append(result.Migrations, mr)
+		if err != nil && !m.ContinueOnError {
+			return failed, err
+		} else if err != nil {
+			failed = append(failed, mr)
+		}
+	}
+	return failed, nil
+}
+
+// applyRepeatable applies a repeatable migration in its own transaction if it
+// has changed since it was last applied, reporting whether it was applied and
+// the number of rows affected if known. Each run is recorded in the history,
+// as is each failure.
+func (m *Migrator) applyRepeatable(migration Migration) (bool, int64, error) {
+	if err := prepare(migration); err != nil {
+		return false, 0, err
+	}
+	start := time.Now()
+	ctx, cancel := m.migrationContext(migration)
+	defer cancel()
+	if m.noTransactions() {
+		return m.applyRepeatableWithoutTx(ctx, migration, start)
+	}
+	tx, err := m.begin(ctx, migration)
+	if err != nil {
+		return false, 0, err
+	}
+
+	current, recorded, err := m.checkRepeatable(tx, migration)
+	if err != nil || recorded == checksum(migration) {
+		tx.Rollback()
+		return false, 0, err
+	}
+
+	var rows int64
+	if transactional(migration) {
+		rows, err = m.upgrade(ctx, tx, migration)
+	} else {
+		tx.Rollback()
+		rows, err = m.execOutsideTx(ctx, migration, "up")
+	}
+	if err != nil {
+		tx.Rollback()
+		entry := m.historyEntry(migration, "up", current, current)
+		entry.Duration = time.Since(start)
+		m.recordFailure(entry)
+		return false, 0, err
+	}
+
+	if !transactional(migration) {
+		// the migration is recorded in a transaction of its own, as long as
+		// no other migrator has applied it in the meantime
+		tx, err = m.begin(ctx, migration)
+		if err != nil {
+			return false, 0, err
+		}
+		_, again, err := m.checkRepeatable(tx, migration)
+		if err == nil && again != recorded {
+			err = RepeatableChecksumChanged
+		}
+		if err != nil {
+			tx.Rollback()
+			return false, 0, err
+		}
+	}
+
+	if err := m.recordRepeatable(ctx, tx, migration); err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	entry := m.historyEntry(migration, "up", current, current)
+	entry.Duration = time.Since(start)
+	entry.Success = true
+	if err := m.insertHistory(tx, entry); err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+	return true, rows, nil
+}
+
+// applyRepeatableWithoutTx applies a repeatable migration started at start on
+// a database without transactions, as described by NoTransactions, if it has
+// changed since it was last applied. Its checksum is recorded once its last
+// statement has run, so a migration that fails is run again from the start.
+func (m *Migrator) applyRepeatableWithoutTx(ctx context.Context, migration Migration, start time.Time) (bool, int64, error) {
+	recorded, err := m.repeatableChecksum(ctx, m.tracking(), migration)
+	if err != nil || recorded == checksum(migration) {
+		return false, 0, err
+	}
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return false, 0, err
+	}
+	entry := m.historyEntry(migration, "up", current, current)
+	rows, err := m.execOutsideTx(ctx, migration, "up")
+	entry.Duration = time.Since(start)
+	if err != nil {
+		m.recordFailure(entry)
+		return false, 0, err
+	} else if err := m.recordRepeatable(ctx, m.tracking(), migration); err != nil {
+		return false, 0, err
+	}
+	entry.Success = true
+	return true, rows, m.insertHistory(m.tracking(), entry)
+}
+
+// checkRepeatable locks the current version in tx, so that concurrent
+// migrators apply repeatable migrations one at a time, returning it and the
+// checksum recorded when the migration was last applied
+func (m *Migrator) checkRepeatable(tx Tx, migration Migration) (int64, string, error) {
+	current, err := m.lockVersion(tx)
+	if err != nil {
+		return 0, "", err
+	}
+	recorded, err := m.repeatableChecksum(context.Background(), tx, migration)
+	return current, recorded, err
+}
+
+// rowQueryer is implemented by every NativeDB and Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) Row
+}
+
+// repeatableChecksum returns the checksum recorded in db when a repeatable
+// migration was last applied, or "" if it never has been
+func (m *Migrator) repeatableChecksum(ctx context.Context, db rowQueryer, migration Migration) (string, error) {
+	var recorded string
+	query := m.query(m.queries().GetRepeatableChecksum, m.repeatableTable())
+	err := db.QueryRowContext(ctx, query, migrationName(migration)).Scan(&recorded)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return recorded, err
+}
+
+// recordRepeatable records in db the checksum of a repeatable migration that
+// has been applied
+func (m *Migrator) recordRepeatable(ctx context.Context, db execer, migration Migration) error {
+	name := migrationName(migration)
+	if _, err := db.ExecContext(ctx, m.query(m.queries().DeleteRepeatable, m.repeatableTable()), name); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, m.query(m.queries().InsertRepeatable, m.repeatableTable()), name, checksum(migration))
+	return err
+}
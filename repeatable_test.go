@@ -0,0 +1,365 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testRepeatableTable = "emigrate_repeatable"
+
+// the SQL of a repeatable migration used in tests
+const TestQueryCreateTotalsView = "CREATE OR REPLACE VIEW invoice_totals AS SELECT SUM(amount) FROM invoices"
+
+// Sets up the database mock to expect a repeatable migration to be checked,
+// with the checksum recorded when it was last applied, if any
+func expectRepeatableChecksum(mock *sqlmock.MockDB, name, recorded string) {
+	expectVersionQuery(mock, 1)
+	rows := sqlmock.NewRows([]string{"checksum"})
+	if recorded != "" {
+		rows.AddRow(recorded)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetRepeatableChecksum(testRepeatableTable))).
+		WithArgs(name).WillReturnRows(rows)
+}
+
+// Verify that repeatable migrations are loaded from files named R__<name>,
+// after the versioned migrations and in order of name.
+func TestLoadRepeatable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.up.sql": {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/R__totals.sql":             {Data: []byte(TestQueryCreateTotalsView)},
+		"migrations/views/R__by_customer.sql":  {Data: []byte("-- emigrate:no-transaction\nSELECT 1")},
+		"migrations/R__notes.md":               {Data: []byte("Not a migration")},
+	}
+
+	ms, err := FSMigrations(fsys, "migrations", Recursive())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 3 {
+		t.Fatalf("Expected %d migrations, got %d", 3, len(ms))
+	}
+	if isRepeatable(ms[0]) || !isRepeatable(ms[1]) || !isRepeatable(ms[2]) {
+		t.Errorf("Expected the repeatable migrations to follow the versioned one")
+	}
+	if name := migrationName(ms[1]); name != "by_customer" {
+		t.Errorf("Expected %s, got %s", "by_customer", name)
+	}
+	if transactional(ms[1]) {
+		t.Errorf("Expected the header of a repeatable migration to be read")
+	}
+	if sum := checksum(ms[2]); sum != checksumString(TestQueryCreateTotalsView) {
+		t.Errorf("Expected the checksum of the SQL, got %q", sum)
+	}
+
+	fsys["migrations/views/R__totals.sql"] = &fstest.MapFile{Data: []byte(TestQueryCreateTotalsView)}
+	if _, err := FSMigrations(fsys, "migrations", Recursive()); !errors.As(err, &DuplicateRepeatableError{}) {
+		t.Errorf("Expected a DuplicateRepeatableError, got %v", err)
+	}
+}
+
+// Verify that migration files whose header declares them repeatable are
+// loaded as repeatable migrations named by their slug, whatever their version.
+func TestLoadRepeatableHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_invoice.up.sql": {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/002_totals.up.sql":         {Data: []byte("-- emigrate:repeatable\n" + TestQueryCreateTotalsView)},
+		"migrations/003_by_customer.sql":       {Data: []byte("-- emigrate:repeatable\n-- +emigrate Up\nSELECT 1")},
+	}
+
+	for _, load := range []func() ([]Migration, error){
+		func() ([]Migration, error) { return FSMigrations(fsys, "migrations") },
+		func() ([]Migration, error) { return SourceMigrations(FSSource(fsys, "migrations")) },
+	} {
+		ms, err := load()
+		if err != nil {
+			t.Fatalf("Got unexpected error %#v", err)
+		}
+		if len(ms) != 3 || isRepeatable(ms[0]) || !isRepeatable(ms[1]) || !isRepeatable(ms[2]) {
+			t.Fatalf("Expected one versioned and two repeatable migrations, got %d", len(ms))
+		}
+		if name := migrationName(ms[1]); name != "by_customer" {
+			t.Errorf("Expected %s, got %s", "by_customer", name)
+		}
+		if sql := ms[1].(*repeatableMigration).up; sql != "SELECT 1" {
+			t.Errorf("Expected the up section to run, got %q", sql)
+		}
+	}
+
+	ms, err := FSMigrations(fsys, "migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	} else if err := prepare(ms[1]); err == nil {
+		t.Errorf("Expected an error for a lazy migration declared repeatable")
+	}
+
+	fsys["migrations/002_totals.down.sql"] = &fstest.MapFile{Data: []byte("DROP VIEW invoice_totals")}
+	if _, err := FSMigrations(fsys, "migrations"); err == nil {
+		t.Errorf("Expected an error for a repeatable migration with a downgrade")
+	}
+	delete(fsys, "migrations/002_totals.down.sql")
+	fsys["migrations/003_by_customer.sql"] = &fstest.MapFile{Data: []byte("-- emigrate:repeatable\n-- +emigrate Up\nSELECT 1\n-- +emigrate Down\nSELECT 2")}
+	for _, load := range []func() ([]Migration, error){
+		func() ([]Migration, error) { return FSMigrations(fsys, "migrations") },
+		func() ([]Migration, error) { return SourceMigrations(FSSource(fsys, "migrations")) },
+	} {
+		if _, err := load(); err == nil {
+			t.Errorf("Expected an error for a repeatable migration with a down section")
+		}
+	}
+	delete(fsys, "migrations/003_by_customer.sql")
+	fsys["migrations/R__totals.sql"] = &fstest.MapFile{Data: []byte(TestQueryCreateTotalsView)}
+	if _, err := FSMigrations(fsys, "migrations"); !errors.As(err, &DuplicateRepeatableError{}) {
+		t.Errorf("Expected a DuplicateRepeatableError, got %v", err)
+	}
+}
+
+// Verify that a migration file whose header declares it repeatable is applied
+// again once its checksum changes.
+func TestUpgradeRepeatableHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/002_totals.up.sql": {Data: []byte("-- emigrate:repeatable\n" + TestQueryCreateTotalsView)},
+	}
+	upgrade := func(mock *sqlmock.MockDB, m Migrator) *Result {
+		ms, err := FSMigrations(fsys, "migrations")
+		if err != nil {
+			t.Fatalf("Got unexpected error %#v", err)
+		}
+		m.migrations, m.repeatables = splitRepeatable(ms)
+		result, err := m.Upgrade()
+		if err != nil {
+			t.Fatalf("Error during migration: %s", err)
+		}
+		mock.CloseTest(t)
+		return result
+	}
+	applied := checksumString(string(fsys["migrations/002_totals.up.sql"].Data))
+
+	mock, m := setupVersioned(t, 0)
+	mock.ExpectBegin()
+	expectRepeatableChecksum(mock, "totals", applied)
+	mock.ExpectRollback()
+	if result := upgrade(mock, m); len(result.Migrations) != 0 {
+		t.Errorf("Expected the unchanged migration to be skipped, got %#v", result.Migrations)
+	}
+
+	changed := "-- emigrate:repeatable\nCREATE OR REPLACE VIEW invoice_totals AS SELECT SUM(amount), COUNT(*) FROM invoices"
+	fsys["migrations/002_totals.up.sql"] = &fstest.MapFile{Data: []byte(changed)}
+	mock, m = setupVersioned(t, 0)
+	mock.ExpectBegin()
+	expectRepeatableChecksum(mock, "totals", applied)
+	mock.ExpectExec(regexp.QuoteMeta(changed)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteRepeatable(testRepeatableTable))).WithArgs("totals").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertRepeatable(testRepeatableTable))).
+		WithArgs("totals", checksumString(changed)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	if result := upgrade(mock, m); len(result.Migrations) != 1 || result.Migrations[0].Status != StatusApplied {
+		t.Errorf("Expected the changed migration to be applied, got %#v", result.Migrations)
+	}
+}
+
+// Verify that repeatable migrations are kept apart from the versioned ones,
+// and are not confused with each other when merged.
+func TestSplitRepeatable(t *testing.T) {
+	totals := NewRepeatableMigration("totals", TestQueryCreateTotalsView)
+	m := NewMigrator(nil, []Migration{totals, &mockMigration{version: 1}, NewRepeatableMigration("by_customer", "SELECT 1")})
+	if len(m.migrations) != 1 || len(m.repeatables) != 2 {
+		t.Fatalf("Expected %d versioned and %d repeatable migrations, got %d and %d", 1, 2, len(m.migrations), len(m.repeatables))
+	}
+	if name := migrationName(m.repeatables[0]); name != "by_customer" {
+		t.Errorf("Expected %s, got %s", "by_customer", name)
+	}
+
+	ms, err := MergeMigrations([]Migration{totals}, []Migration{NewRepeatableMigration("by_customer", "SELECT 1")})
+	if err != nil || len(ms) != 2 {
+		t.Errorf("Expected both repeatable migrations, got %d and %v", len(ms), err)
+	}
+	if _, err := MergeMigrations([]Migration{totals}, []Migration{totals}); !errors.As(err, &DuplicateRepeatableError{}) {
+		t.Errorf("Expected a DuplicateRepeatableError, got %v", err)
+	}
+}
+
+// Verify that repeatable migrations are applied after the versioned ones when
+// they have changed, and skipped when they have not.
+func TestUpgradeRepeatable(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1)
+	m.AppliedBy = "deploy"
+	unchanged := NewRepeatableMigration("by_customer", "SELECT 1")
+	m.repeatables = []Migration{unchanged, NewRepeatableMigration("totals", TestQueryCreateTotalsView)}
+
+	expectSetVersions(0, mock, 1)
+	mock.ExpectBegin()
+	expectRepeatableChecksum(mock, "by_customer", checksum(unchanged))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectRepeatableChecksum(mock, "totals", "changed")
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateTotalsView)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteRepeatable(testRepeatableTable))).WithArgs("totals").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertRepeatable(testRepeatableTable))).
+		WithArgs("totals", checksumString(TestQueryCreateTotalsView)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistoryEntry(mock, HistoryEntry{
+		Name:        "totals",
+		Checksum:    checksumString(TestQueryCreateTotalsView),
+		Direction:   "up",
+		FromVersion: 1,
+		ToVersion:   1,
+		AppliedBy:   "deploy",
+		Hostname:    hostname(),
+		Success:     true,
+	}).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(result.Migrations) != 2 {
+		t.Fatalf("Expected %d migrations in the result, got %d", 2, len(result.Migrations))
+	}
+	if mr := result.Migrations[1]; mr.Name != "totals" || mr.Status != StatusApplied {
+		t.Errorf("Expected totals to be applied, got %#v", mr)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a repeatable migration that fails is recorded in the history,
+// and its checksum is not recorded, so it is run again.
+func TestUpgradeRepeatableFailure(t *testing.T) {
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1)
+	m.AppliedBy = "deploy"
+	m.repeatables = []Migration{NewRepeatableMigration("totals", TestQueryCreateTotalsView)}
+
+	mock.ExpectBegin()
+	expectRepeatableChecksum(mock, "totals", "")
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateTotalsView)).
+		WillReturnError(errors.New("view failed"))
+	mock.ExpectRollback()
+	expectHistoryEntry(mock, HistoryEntry{
+		Name:        "totals",
+		Checksum:    checksumString(TestQueryCreateTotalsView),
+		Direction:   "up",
+		FromVersion: 1,
+		ToVersion:   1,
+		AppliedBy:   "deploy",
+		Hostname:    hostname(),
+	}).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := m.Upgrade()
+	if err == nil {
+		t.Fatalf("Expected the repeatable migration to fail")
+	}
+	if len(result.Migrations) != 1 || result.Migrations[0].Status != StatusFailed {
+		t.Errorf("Expected totals to fail, got %#v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a repeatable migration run outside of a transaction is only
+// recorded if no other migrator applied it while it ran.
+func TestUpgradeRepeatableOutsideTx(t *testing.T) {
+	migration := NewRepeatableMigration("totals", TestQueryCreateTotalsView)
+	migration.(*repeatableMigration).noTransaction = true
+	expect := func(mock *sqlmock.MockDB, recorded string) {
+		mock.ExpectBegin()
+		expectRepeatableChecksum(mock, "totals", "changed")
+		mock.ExpectRollback()
+		mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateTotalsView)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		expectRepeatableChecksum(mock, "totals", recorded)
+	}
+
+	mock, m := setupVersioned(t, 1)
+	m.migrations = migrationRange(1)
+	m.repeatables = []Migration{migration}
+	expect(mock, "changed")
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteRepeatable(testRepeatableTable))).WithArgs("totals").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertRepeatable(testRepeatableTable))).
+		WithArgs("totals", checksumString(TestQueryCreateTotalsView)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+	if _, err := m.Upgrade(); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+
+	mock, m = setupVersioned(t, 1)
+	m.migrations = migrationRange(1)
+	m.repeatables = []Migration{migration}
+	expect(mock, checksumString(TestQueryCreateTotalsView))
+	mock.ExpectRollback()
+	if _, err := m.Upgrade(); err != RepeatableChecksumChanged {
+		t.Errorf("Expected %v, got %v", RepeatableChecksumChanged, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that repeatable migrations are not applied by an upgrade that stops
+// short of the newest version.
+func TestUpgradeRepeatableOnlyWhenComplete(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = migrationRange(1, 2)
+	m.repeatables = []Migration{NewRepeatableMigration("totals", TestQueryCreateTotalsView)}
+
+	expectSetVersions(0, mock, 1)
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that repeatable migrations are applied without transactions when
+// the Migrator runs without them, recording their checksum once they have
+// run.
+func TestUpgradeRepeatableNoTransactions(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	m := Migrator{db: db, NoTransactions: true}
+	unchanged := NewRepeatableMigration("by_customer", "SELECT 1")
+	m.repeatables = []Migration{unchanged, NewRepeatableMigration("totals", TestQueryCreateTotalsView)}
+
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetRepeatableChecksum(testRepeatableTable))).
+		WithArgs("by_customer").WillReturnRows(sqlmock.NewRows([]string{"checksum"}).AddRow(checksum(unchanged)))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetRepeatableChecksum(testRepeatableTable))).
+		WithArgs("totals").WillReturnRows(sqlmock.NewRows([]string{"checksum"}))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateTotalsView)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteRepeatable(testRepeatableTable))).WithArgs("totals").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertRepeatable(testRepeatableTable))).
+		WithArgs("totals", checksumString(TestQueryCreateTotalsView)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+
+	result, err := m.Upgrade()
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(result.Migrations) != 1 || result.Migrations[0].Name != "totals" || result.Migrations[0].Status != StatusApplied {
+		t.Errorf("Expected totals to be applied, got %#v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,52 @@
+package emigrate
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReplicaChecker is implemented by dialects that can tell whether the
+// database is a read-only replica, so that the Migrator refuses to migrate it
+// with a ReplicaError before taking its lock, rather than failing part way
+// through or, with a TrackingDB pointing at the primary, recording migrations
+// that were never applied.
+type ReplicaChecker interface {
+	// IsReplica returns a query returning true if the database is a
+	// read-only replica
+	IsReplica() string
+}
+
+// ReplicaError indicates that the database is a read-only replica, such as a
+// standby, and migrations must be run against the primary.
+type ReplicaError struct{}
+
+func (e ReplicaError) Error() string {
+	return "emigrate: Database is a read-only replica, migrations must be run against the primary"
+}
+
+// IsReplica returns a query of pg_is_in_recovery, which is true on a standby
+func (PostgresDialect) IsReplica() string {
+	return "SELECT pg_is_in_recovery()"
+}
+
+// IsReplica returns a query of the read_only variable, which is set on
+// replicas
+func (MySQLDialect) IsReplica() string {
+	return "SELECT @@global.read_only = 1"
+}
+
+// checkPrimary returns a ReplicaError if the database of the migrations is a
+// replica, as far as the dialect can tell
+func (m *Migrator) checkPrimary() error {
+	c, ok := m.dialect().(ReplicaChecker)
+	if !ok {
+		return nil
+	}
+	var replica bool
+	if err := nativeDB(m.db).QueryRowContext(context.Background(), c.IsReplica()).Scan(&replica); err != nil {
+		return fmt.Errorf("emigrate: Cannot tell whether the database is a replica: %w", err)
+	} else if replica {
+		return ReplicaError{}
+	}
+	return nil
+}
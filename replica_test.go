@@ -0,0 +1,35 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectPrimary(mock *sqlmock.MockDB, replica bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_is_in_recovery()") + "|" + regexp.QuoteMeta("SELECT @@global.read_only")).
+		WillReturnRows(sqlmock.NewRows([]string{"replica"}).AddRow(replica))
+}
+
+// Verify that nothing is run, not even the lock, against a replica.
+func TestUpgradeReplica(t *testing.T) {
+	for _, dialect := range []Dialect{PostgresDialect{}, MySQLDialect{}} {
+		mock, db, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Error creating mock: %s", err)
+		}
+		m := Migrator{db: db, migrations: migrationRange(1), Dialect: dialect}
+		expectPrimary(mock, true)
+		expectPrimary(mock, true)
+
+		if _, err := m.Upgrade(); !errors.As(err, &ReplicaError{}) {
+			t.Errorf("%T: expected a ReplicaError, got %v", dialect, err)
+		}
+		if _, err := m.DowngradeToVersion(0); !errors.As(err, &ReplicaError{}) {
+			t.Errorf("%T: expected a ReplicaError, got %v", dialect, err)
+		}
+		mock.CloseTest(t)
+	}
+}
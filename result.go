@@ -0,0 +1,105 @@
+package emigrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status describes the outcome of a single migration during an upgrade
+type Status int
+
+const (
+	StatusApplied  Status = iota // the migration was applied and committed
+	StatusFailed                 // the migration failed and was rolled back
+	StatusSkipped                // the migration was deliberately not applied
+	StatusReverted               // the migration was downgraded and committed
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusApplied:
+		return "applied"
+	case StatusFailed:
+		return "failed"
+	case StatusSkipped:
+		return "skipped"
+	case StatusReverted:
+		return "reverted"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// MigrationResult describes what happened to a single migration
+type MigrationResult struct {
+	Version      int64         // the version of the migration
+	Name         string        // the name of the migration, if it has one
+	Label        string        // the label of the migration, if it has one
+	Duration     time.Duration // how long the migration took to run
+	RowsAffected int64         // rows affected by the upgrade, if reported
+	Status       Status        // the outcome of the migration
+	Err          error         // the error that caused the migration to fail
+}
+
+func (r MigrationResult) String() string {
+	version := fmt.Sprint(r.Version)
+	if r.Name != "" {
+		version += " " + r.Name
+	}
+	if r.Label != "" {
+		version += " (" + r.Label + ")"
+	}
+	switch r.Status {
+	case StatusApplied:
+		return fmt.Sprintf("emigrate: upgraded to version %s", version)
+	case StatusFailed:
+		return fmt.Sprintf("emigrate: upgrade to version %s failed: %s", version, r.Err)
+	case StatusSkipped:
+		return fmt.Sprintf("emigrate: skipped version %s", version)
+	case StatusReverted:
+		return fmt.Sprintf("emigrate: downgraded version %s", version)
+	}
+	return fmt.Sprintf("emigrate: version %s %s", version, r.Status)
+}
+
+// Result describes the outcome of an upgrade, with one entry per migration
+// that was attempted in the order they were attempted.
+type Result struct {
+	Migrations []MigrationResult
+	Warnings   []string // problems that did not prevent the upgrade
+	Batch      int64    // identifies the run in the history, see DowngradeBatch
+}
+
+// Applied returns the versions of the migrations that were applied
+func (r *Result) Applied() []int64 {
+	var versions []int64
+	for _, mr := range r.Migrations {
+		if mr.Status == StatusApplied {
+			versions = append(versions, mr.Version)
+		}
+	}
+	return versions
+}
+
+// UpgradeError is returned by an upgrade with ContinueOnError set when one or
+// more migrations failed, and describes each of the failed migrations.
+type UpgradeError struct {
+	Failed []MigrationResult
+}
+
+func (e UpgradeError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for idx, mr := range e.Failed {
+		msgs[idx] = fmt.Sprintf("version %d: %s", mr.Version, mr.Err)
+	}
+	return fmt.Sprintf("emigrate: %d migrations failed:\n\t%s", len(e.Failed), strings.Join(msgs, "\n\t"))
+}
+
+// Unwrap allows errors.Is and errors.As to inspect the individual errors
+func (e UpgradeError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for idx, mr := range e.Failed {
+		errs[idx] = mr.Err
+	}
+	return errs
+}
@@ -0,0 +1,75 @@
+package emigrate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Retryable is implemented by migrations that are safe to run again after
+// failing, such as those whose statements are idempotent. Only migrations
+// whose Retryable returns true are retried, and only if they fail with a
+// transient error, so that other migrations still fail fast.
+type Retryable interface {
+	Retryable() bool
+}
+
+// retryable reports whether a migration declares itself safe to retry
+func retryable(m Migration) bool {
+	r, ok := m.(Retryable)
+	return ok && r.Retryable()
+}
+
+// IsTransientError reports whether err is likely to succeed if retried, such
+// as a deadlock, a serialization failure, a lock timeout or a lost
+// connection, recognizing the errors of any of the supported databases.
+func IsTransientError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	if state := sqlState(err); state != "" {
+		// serialization failures, deadlocks, lock timeouts and connection
+		// exceptions
+		return state == "40001" || state == "40P01" || state == "55P03" || strings.HasPrefix(state, "08")
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Error 1213") || // MySQL deadlock
+		strings.Contains(msg, "Error 1205") || // MySQL lock wait timeout
+		strings.Contains(msg, "database is locked") // SQLite busy
+}
+
+// isTransient reports whether err is transient, as judged by the Migrator
+func (m *Migrator) isTransient(err error) bool {
+	if m.IsTransient != nil {
+		return m.IsTransient(err)
+	}
+	return IsTransientError(err)
+}
+
+// applyWithRetry applies a migration as apply does, running it again up to
+// Retries times if it is retryable and fails with a transient error, or if
+// its transaction was aborted to be retried. A database left dirty by the
+// failure is marked clean before the retry, as the migration is safe to run
+// again.
+func (m *Migrator) applyWithRetry(migration Migration, expected int64) (int64, bool, error) {
+	for attempt := 0; ; attempt++ {
+		rows, skipped, err := m.apply(migration, expected)
+		if err == nil || !m.shouldRetry(migration, err, attempt) {
+			return rows, skipped, err
+		}
+		if err := m.ClearDirty(); err != nil {
+			return 0, false, err
+		}
+		time.Sleep(m.RetryDelay)
+	}
+}
+
+// shouldRetry reports whether a migration that failed with err should be run
+// again after the given number of retries
+func (m *Migrator) shouldRetry(migration Migration, err error, attempt int) bool {
+	if m.retriesTx(migration, err) {
+		return attempt < m.Retries || attempt < DefaultTxRetries
+	}
+	return attempt < m.Retries && retryable(migration) && m.isTransient(err)
+}
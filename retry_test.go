@@ -0,0 +1,93 @@
+package emigrate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIsTransientError(t *testing.T) {
+	var cases = []struct {
+		err       error
+		transient bool
+	}{
+		{sqlStateError("40001"), true},
+		{sqlStateError("40P01"), true},
+		{sqlStateError("08006"), true},
+		{sqlStateError("42P01"), false},
+		{fmt.Errorf("exec: %w", driver.ErrBadConn), true},
+		{errors.New("Error 1213: Deadlock found when trying to get lock"), true},
+		{errors.New("database is locked"), true},
+		{errors.New("syntax error"), false},
+	}
+	for _, c := range cases {
+		if IsTransientError(c.err) != c.transient {
+			t.Errorf("Expected %v for %v", c.transient, c.err)
+		}
+	}
+}
+
+// Verify that a retryable migration is run again after a transient error.
+func TestRetryableMigration(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "", WithRetryable())}
+	m.Retries = 1
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnError(sqlStateError("40P01"))
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that migrations that do not declare themselves retryable fail fast.
+func TestRetryNotRetryable(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")}
+	m.Retries = 3
+
+	expected := sqlStateError("40P01")
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnError(expected)
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	if _, err := m.UpgradeToVersion(1); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestParseHeaderRetryable(t *testing.T) {
+	var o migrationOptions
+	if err := o.parseHeader("001.sql", "-- emigrate:retryable\nUPDATE invoices SET total = 0;\n"); err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if !o.Retryable() {
+		t.Errorf("Expected the migration to be retryable")
+	}
+	if err := o.parseHeader("001.sql", "-- emigrate:retryable=yes\n"); err == nil {
+		t.Errorf("Expected an error for a retryable directive with a value")
+	}
+}
@@ -0,0 +1,63 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// savepointRegexp restricts savepoint names to plain identifiers, as they
+// cannot be passed as query parameters.
+var savepointRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// InvalidSavepointError indicates that a savepoint name is not a plain
+// identifier
+type InvalidSavepointError struct {
+	name string
+}
+
+func (e InvalidSavepointError) Error() string {
+	return fmt.Sprintf("emigrate: Invalid savepoint name %q", e.name)
+}
+
+func execSavepoint(tx *sql.Tx, name string, query func(string) string) error {
+	if !savepointRegexp.MatchString(name) {
+		return InvalidSavepointError{name}
+	}
+	_, err := tx.Exec(query(name))
+	return err
+}
+
+// Savepoint creates a savepoint with the given name within the transaction
+func Savepoint(tx *sql.Tx, name string) error {
+	return execSavepoint(tx, name, defaultQueries.Savepoint)
+}
+
+// RollbackToSavepoint undoes everything done within the transaction since the
+// named savepoint was created, leaving the transaction usable.
+func RollbackToSavepoint(tx *sql.Tx, name string) error {
+	return execSavepoint(tx, name, defaultQueries.RollbackToSavepoint)
+}
+
+// ReleaseSavepoint discards the named savepoint, keeping the changes made
+// since it was created.
+func ReleaseSavepoint(tx *sql.Tx, name string) error {
+	return execSavepoint(tx, name, defaultQueries.ReleaseSavepoint)
+}
+
+// WithSavepoint runs fn within the named savepoint. If fn fails the
+// transaction is rolled back to the savepoint and the error of fn is
+// returned, but the rest of the migration can continue, which allows for
+// best-effort steps such as creating an optional index.
+func WithSavepoint(tx *sql.Tx, name string, fn func(tx *sql.Tx) error) error {
+	if err := Savepoint(tx, name); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rerr := RollbackToSavepoint(tx, name); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	return ReleaseSavepoint(tx, name)
+}
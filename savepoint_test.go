@@ -0,0 +1,68 @@
+package emigrate
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupTx(t *testing.T) (*sqlmock.MockDB, *sql.Tx) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while opening mock db connection", err)
+	}
+	mock.ExpectBegin()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Unexpected error '%s' while beginning transaction", err)
+	}
+	return mock, tx
+}
+
+func TestWithSavepointReleases(t *testing.T) {
+	t.Parallel()
+	mock, tx := setupTx(t)
+	mock.ExpectExec(testQueries.Savepoint("optional_index")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(testQueries.ReleaseSavepoint("optional_index")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := WithSavepoint(tx, "optional_index", func(tx *sql.Tx) error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected %v, got %v", nil, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestWithSavepointRollsBack(t *testing.T) {
+	t.Parallel()
+	mock, tx := setupTx(t)
+	mock.ExpectExec(testQueries.Savepoint("optional_index")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(testQueries.RollbackToSavepoint("optional_index")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	expected := errors.New("index failed")
+	err := WithSavepoint(tx, "optional_index", func(tx *sql.Tx) error {
+		return expected
+	})
+	if err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestInvalidSavepointName(t *testing.T) {
+	t.Parallel()
+	mock, tx := setupTx(t)
+
+	expected := InvalidSavepointError{"x; DROP TABLE invoice"}
+	if err := Savepoint(tx, "x; DROP TABLE invoice"); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,206 @@
+package emigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Column describes a column of a table created or altered by a Schema. Type
+// and Default are written into the SQL as they are given, so must suit the
+// dialect and must not come from untrusted input.
+type Column struct {
+	Name       string
+	Type       string // the SQL type, such as INTEGER or VARCHAR(255)
+	NotNull    bool
+	PrimaryKey bool
+	Default    string // an SQL expression for the default value, if any
+}
+
+// schemaChange is a change made by a Schema, with the SQL that makes and
+// reverts it
+type schemaChange struct {
+	up, down string
+}
+
+// Schema builds a migration from a sequence of schema changes, each of which
+// knows how to revert itself, so that the downgrade SQL is generated along
+// with the upgrade SQL rather than written by hand. Changes are reverted in
+// reverse order.
+//
+//	s := emigrate.NewSchema(emigrate.PostgresDialect{}).
+//		CreateTable("invoices",
+//			emigrate.Column{Name: "id", Type: "BIGINT", PrimaryKey: true},
+//			emigrate.Column{Name: "total", Type: "NUMERIC(12, 2)", NotNull: true}).
+//		AddIndex("invoices", "invoices_total_idx", "total")
+//	migration := s.Migration(42, emigrate.WithName("add_invoices"))
+type Schema struct {
+	dialect Dialect
+	changes []schemaChange
+}
+
+// NewSchema returns an empty Schema generating SQL for dialect, or standard
+// SQL if dialect is nil
+func NewSchema(dialect Dialect) *Schema {
+	if dialect == nil {
+		dialect = ansiDialect{}
+	}
+	return &Schema{dialect: dialect}
+}
+
+// quote quotes an identifier for the dialect of the schema
+func (s *Schema) quote(name string) string {
+	return s.dialect.QuoteIdentifier(name)
+}
+
+// column returns the definition of a column
+func (s *Schema) column(c Column) string {
+	def := s.quote(c.Name) + " " + c.Type
+	if c.PrimaryKey {
+		def += " PRIMARY KEY"
+	}
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+	if c.Default != "" {
+		def += " DEFAULT " + c.Default
+	}
+	return def
+}
+
+// CreateTable creates a table with columns, and drops it when reverted
+func (s *Schema) CreateTable(table string, columns ...Column) *Schema {
+	defs := make([]string, len(columns))
+	for idx, c := range columns {
+		defs[idx] = s.column(c)
+	}
+	s.changes = append(s.changes, schemaChange{
+		up:   fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", s.quote(table), strings.Join(defs, ",\n\t")),
+		down: fmt.Sprintf("DROP TABLE %s", s.quote(table)),
+	})
+	return s
+}
+
+// AddColumn adds a column to a table, and drops it when reverted
+func (s *Schema) AddColumn(table string, column Column) *Schema {
+	s.changes = append(s.changes, schemaChange{
+		up:   fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", s.quote(table), s.column(column)),
+		down: fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", s.quote(table), s.quote(column.Name)),
+	})
+	return s
+}
+
+// DropTable drops a table with columns, and creates it again when reverted
+func (s *Schema) DropTable(table string, columns ...Column) *Schema {
+	s.CreateTable(table, columns...)
+	s.revertLast()
+	return s
+}
+
+// DropColumn drops a column from a table, and adds it again when reverted
+func (s *Schema) DropColumn(table string, column Column) *Schema {
+	s.AddColumn(table, column)
+	s.revertLast()
+	return s
+}
+
+// ChangeColumn changes the type, nullability and default of a column of a
+// table from those of from to those of to, and changes them back when
+// reverted. Whether the column is a primary key is not changed.
+func (s *Schema) ChangeColumn(table string, from, to Column) *Schema {
+	s.changes = append(s.changes, schemaChange{
+		up:   s.changeColumn(table, from, to),
+		down: s.changeColumn(table, to, from),
+	})
+	return s
+}
+
+// changeColumn returns the SQL changing a column from from to to
+func (s *Schema) changeColumn(table string, from, to Column) string {
+	to.PrimaryKey = false
+	if _, ok := s.dialect.(MySQLDialect); ok {
+		// MySQL redefines the column as a whole
+		return fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", s.quote(table), s.column(to))
+	}
+
+	alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", s.quote(table), s.quote(to.Name))
+	var stmts []string
+	if from.Type != to.Type {
+		stmts = append(stmts, alter+"TYPE "+to.Type)
+	}
+	if from.NotNull != to.NotNull {
+		if to.NotNull {
+			stmts = append(stmts, alter+"SET NOT NULL")
+		} else {
+			stmts = append(stmts, alter+"DROP NOT NULL")
+		}
+	}
+	if from.Default != to.Default {
+		if to.Default != "" {
+			stmts = append(stmts, alter+"SET DEFAULT "+to.Default)
+		} else {
+			stmts = append(stmts, alter+"DROP DEFAULT")
+		}
+	}
+	return strings.Join(stmts, ";\n")
+}
+
+// revertLast swaps the SQL of the last change, so that it reverts what it
+// would otherwise make
+func (s *Schema) revertLast() {
+	last := &s.changes[len(s.changes)-1]
+	last.up, last.down = last.down, last.up
+}
+
+// AddIndex creates an index on columns of a table, and drops it when
+// reverted
+func (s *Schema) AddIndex(table, index string, columns ...string) *Schema {
+	quoted := make([]string, len(columns))
+	for idx, column := range columns {
+		quoted[idx] = s.quote(column)
+	}
+	drop := fmt.Sprintf("DROP INDEX %s", s.quote(index))
+	if _, ok := s.dialect.(MySQLDialect); ok {
+		// MySQL indexes belong to their table
+		drop += " ON " + s.quote(table)
+	}
+	s.changes = append(s.changes, schemaChange{
+		up:   fmt.Sprintf("CREATE INDEX %s ON %s (%s)", s.quote(index), s.quote(table), strings.Join(quoted, ", ")),
+		down: drop,
+	})
+	return s
+}
+
+// RenameColumn renames a column of a table, and renames it back when
+// reverted
+func (s *Schema) RenameColumn(table, from, to string) *Schema {
+	rename := "ALTER TABLE %s RENAME COLUMN %s TO %s"
+	s.changes = append(s.changes, schemaChange{
+		up:   fmt.Sprintf(rename, s.quote(table), s.quote(from), s.quote(to)),
+		down: fmt.Sprintf(rename, s.quote(table), s.quote(to), s.quote(from)),
+	})
+	return s
+}
+
+// Up returns the SQL making the changes of the schema, in order
+func (s *Schema) Up() string {
+	ups := make([]string, len(s.changes))
+	for idx, change := range s.changes {
+		ups[idx] = change.up
+	}
+	return joinScripts(ups)
+}
+
+// Down returns the SQL reverting the changes of the schema, in reverse order
+func (s *Schema) Down() string {
+	downs := make([]string, len(s.changes))
+	for idx, change := range s.changes {
+		downs[len(s.changes)-1-idx] = change.down
+	}
+	return joinScripts(downs)
+}
+
+// Migration returns a migration at version that makes the changes of the
+// schema when upgraded and reverts them when downgraded
+func (s *Schema) Migration(version int64, opts ...MigrationOption) Migration {
+	return NewStringMigration(version, s.Up(), s.Down(), opts...)
+}
@@ -0,0 +1,58 @@
+package emigrate
+
+import "testing"
+
+func TestSchema(t *testing.T) {
+	s := NewSchema(PostgresDialect{}).
+		CreateTable("invoices",
+			Column{Name: "id", Type: "BIGINT", PrimaryKey: true},
+			Column{Name: "total", Type: "NUMERIC(12, 2)", NotNull: true, Default: "0"}).
+		AddColumn("invoices", Column{Name: "Customer", Type: "TEXT"}).
+		AddIndex("invoices", "invoices_total_idx", "total").
+		RenameColumn("invoices", "total", "amount")
+
+	up := "CREATE TABLE invoices (\n\tid BIGINT PRIMARY KEY,\n\ttotal NUMERIC(12, 2) NOT NULL DEFAULT 0\n);\n\n" +
+		"ALTER TABLE invoices ADD COLUMN \"Customer\" TEXT;\n\n" +
+		"CREATE INDEX invoices_total_idx ON invoices (total);\n\n" +
+		"ALTER TABLE invoices RENAME COLUMN total TO amount;\n\n"
+	if s.Up() != up {
+		t.Errorf("Expected %q, got %q", up, s.Up())
+	}
+	down := "ALTER TABLE invoices RENAME COLUMN amount TO total;\n\n" +
+		"DROP INDEX invoices_total_idx;\n\n" +
+		"ALTER TABLE invoices DROP COLUMN \"Customer\";\n\n" +
+		"DROP TABLE invoices;\n\n"
+	if s.Down() != down {
+		t.Errorf("Expected %q, got %q", down, s.Down())
+	}
+
+	m := s.Migration(1, WithName("add_invoices"))
+	if !canDowngrade(m) || m.(SQLer).SQL("down") != down || migrationName(m) != "add_invoices" {
+		t.Errorf("Expected a named migration with the generated downgrade")
+	}
+}
+
+// Verify that reserved words are quoted in the generated SQL.
+func TestSchemaReservedWords(t *testing.T) {
+	s := NewSchema(MySQLDialect{}).
+		CreateTable("order",
+			Column{Name: "id", Type: "BIGINT", PrimaryKey: true},
+			Column{Name: "key", Type: "TEXT"})
+
+	up := "CREATE TABLE `order` (\n\tid BIGINT PRIMARY KEY,\n\t`key` TEXT\n);\n\n"
+	if s.Up() != up {
+		t.Errorf("Expected %q, got %q", up, s.Up())
+	}
+	if down := "DROP TABLE `order`;\n\n"; s.Down() != down {
+		t.Errorf("Expected %q, got %q", down, s.Down())
+	}
+}
+
+// Verify that indexes are dropped from their table in MySQL.
+func TestSchemaMySQL(t *testing.T) {
+	s := NewSchema(MySQLDialect{}).AddIndex("Invoices", "invoices_total_idx", "total")
+	expected := "DROP INDEX invoices_total_idx ON `Invoices`;\n\n"
+	if s.Down() != expected {
+		t.Errorf("Expected %q, got %q", expected, s.Down())
+	}
+}
@@ -0,0 +1,43 @@
+package emigrate
+
+// DefaultSeedTable is the name of the table used to track the version of the
+// seeds when no other name is configured. Like the migrations, the applied
+// seeds and their history are kept in tables with an "_applied" and
+// "_history" suffix.
+const DefaultSeedTable = DefaultTable + "_seeds"
+
+// Seeder applies seeds, which are migrations that load data, such as
+// reference or demo data, rather than change the schema. Seeds are loaded
+// like migrations, typically from a directory of their own, and have a
+// version sequence of their own, tracked in tables apart from those of the
+// migrations so that the two are never mixed in the history. Seeds run with
+// the same machinery as migrations, and the Migrator embedded in the Seeder
+// can be configured in the same way.
+type Seeder struct {
+	*Migrator
+}
+
+// NewSeeder returns a Seeder that applies seeds to db, tracked in the
+// DefaultSeedTable.
+func NewSeeder(db DB, seeds []Migration) *Seeder {
+	m := NewMigrator(db, seeds)
+	m.Table = DefaultSeedTable
+	return &Seeder{m}
+}
+
+// Apply initializes the tracking tables of the seeds if needed and applies
+// every seed newer than the current seed version. It should be run after the
+// migrations, as seeds rely on the schema they create.
+func (s *Seeder) Apply() (*Result, error) {
+	if err := s.Init(); err != nil {
+		return &Result{}, err
+	}
+	return s.Upgrade()
+}
+
+// Reset reverts every applied seed, newest first, so that the data can be
+// seeded afresh by Apply. Every applied seed must define a downgrade, such as
+// one that deletes the rows it inserted.
+func (s *Seeder) Reset() (*Result, error) {
+	return s.DowngradeToVersion(0)
+}
@@ -0,0 +1,45 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that seeds are tracked in tables of their own, and that a reset
+// reverts every applied seed.
+func TestSeederReset(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSeeder(db, downgradeRange(1))
+	if s.Table != "emigrate_seeds" {
+		t.Errorf("Expected seeds to be tracked in %s, got %s", "emigrate_seeds", s.Table)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("emigrate_seeds"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(1)))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetAppliedVersions("emigrate_seeds_applied"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(1)))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.LockCurrentVersion("emigrate_seeds"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(1)))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion("emigrate_seeds"))).WithArgs(int64(0), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.DeleteAppliedVersion("emigrate_seeds_applied"))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO emigrate_seeds_history`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := s.Reset()
+	if err != nil {
+		t.Fatalf("Unexpected error during reset: %s", err)
+	}
+	if len(result.Migrations) != 1 || result.Migrations[0].Status != StatusReverted {
+		t.Errorf("Expected the seed to be reverted, got %v", result.Migrations)
+	}
+	mock.CloseTest(t)
+}
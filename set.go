@@ -0,0 +1,121 @@
+package emigrate
+
+import "fmt"
+
+// MigrationSet is a named group of migrations with a version sequence of its
+// own, such as the migrations of one module of an application.
+type MigrationSet struct {
+	Name       string
+	Migrations []Migration
+}
+
+// Coordinator migrates several migration sets in the same database, in the
+// order they were declared, such as core before billing before analytics.
+// Each set has a Migrator of its own, whose tracking tables are named after
+// the set, as in billing_emigrate, so the version of each set is tracked
+// independently.
+type Coordinator struct {
+	sets      []MigrationSet
+	migrators []*Migrator
+}
+
+// NewCoordinator returns a Coordinator for sets, which must have unique,
+// non-empty names. The Migrator of each set can be configured through
+// Migrator.
+func NewCoordinator(db DB, sets ...MigrationSet) (*Coordinator, error) {
+	c := &Coordinator{sets: sets}
+	seen := make(map[string]bool, len(sets))
+	for _, set := range sets {
+		if set.Name == "" {
+			return nil, fmt.Errorf("emigrate: Migration set has no name.")
+		} else if seen[set.Name] {
+			return nil, fmt.Errorf("emigrate: Duplicate migration set %q.", set.Name)
+		}
+		seen[set.Name] = true
+
+		m := NewMigrator(db, set.Migrations)
+		m.Table = set.Name + "_" + DefaultTable
+		c.migrators = append(c.migrators, m)
+	}
+	return c, nil
+}
+
+// Migrator returns the Migrator of the named set, or nil if there is none.
+func (c *Coordinator) Migrator(name string) *Migrator {
+	for idx, set := range c.sets {
+		if set.Name == name {
+			return c.migrators[idx]
+		}
+	}
+	return nil
+}
+
+// SetError indicates that migrating a migration set failed
+type SetError struct {
+	set string // the name of the migration set
+	err error  // the error returned by the Migrator of the set
+}
+
+func (e SetError) Error() string {
+	return fmt.Sprintf("%s (migration set %q)", e.err, e.set)
+}
+
+// Unwrap returns the error returned by the Migrator of the set
+func (e SetError) Unwrap() error {
+	return e.err
+}
+
+// Init initializes the tracking tables of every set.
+func (c *Coordinator) Init() error {
+	for idx, m := range c.migrators {
+		if err := m.Init(); err != nil {
+			return SetError{c.sets[idx].Name, err}
+		}
+	}
+	return nil
+}
+
+// Validate validates every set, as described by Migrator.Validate, returning
+// the error of the first set that is invalid.
+func (c *Coordinator) Validate() error {
+	for idx, m := range c.migrators {
+		if err := m.Validate(); err != nil {
+			return SetError{c.sets[idx].Name, err}
+		}
+	}
+	return nil
+}
+
+// CurrentVersions returns the current version of each set, by name.
+func (c *Coordinator) CurrentVersions() (map[string]int64, error) {
+	versions := make(map[string]int64, len(c.sets))
+	for idx, m := range c.migrators {
+		version, err := m.CurrentVersion()
+		if err != nil {
+			return nil, SetError{c.sets[idx].Name, err}
+		}
+		versions[c.sets[idx].Name] = version
+	}
+	return versions, nil
+}
+
+// SetResult describes the upgrade of a migration set.
+type SetResult struct {
+	Name string // the name of the set
+	*Result
+}
+
+// Upgrade upgrades each set in order, stopping at the first set that fails
+// to upgrade. The results of the sets that were upgraded, including the one
+// that failed, are returned in order.
+func (c *Coordinator) Upgrade() ([]SetResult, error) {
+	var results []SetResult
+	for idx, m := range c.migrators {
+		result, err := m.Upgrade()
+		results = append(results, SetResult{c.sets[idx].Name, result})
+		if err != nil {
+			return results, SetError{c.sets[idx].Name, err}
+		}
+	}
+	return results, nil
+}
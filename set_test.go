@@ -0,0 +1,89 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that each migration set is tracked in tables of its own, and that
+// the sets are upgraded in order.
+func TestCoordinatorUpgrade(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCoordinator(db,
+		MigrationSet{"core", []Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")}},
+		MigrationSet{"billing", migrationRange(1)},
+	)
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("core_emigrate"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(0)))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.LockCurrentVersion("core_emigrate"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(0)))
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion("core_emigrate"))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertAppliedVersion("core_emigrate_applied"))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO core_emigrate_history`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("billing_emigrate"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(1)))
+
+	results, err := c.Upgrade()
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(results) != 2 || results[0].Name != "core" || len(results[0].Migrations) != 1 || len(results[1].Migrations) != 0 {
+		t.Errorf("Expected core to be upgraded and billing to be up to date, got %#v", results)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that an upgrade stops at the first set that fails, naming it.
+func TestCoordinatorUpgradeFails(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewCoordinator(db, MigrationSet{"core", migrationRange(1)}, MigrationSet{"billing", migrationRange(1)})
+
+	expected := errors.New("no such table")
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("core_emigrate"))).WillReturnError(expected)
+
+	results, err := c.Upgrade()
+	var setErr SetError
+	if !errors.As(err, &setErr) || setErr.set != "core" || !errors.Is(err, expected) {
+		t.Errorf("Expected a SetError for core, got %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected %d result, got %d", 1, len(results))
+	}
+	mock.CloseTest(t)
+}
+
+func TestNewCoordinatorInvalidSets(t *testing.T) {
+	if _, err := NewCoordinator(nil, MigrationSet{Name: ""}); err == nil {
+		t.Errorf("Expected an error for a set without a name")
+	}
+	if _, err := NewCoordinator(nil, MigrationSet{Name: "core"}, MigrationSet{Name: "core"}); err == nil {
+		t.Errorf("Expected an error for a duplicate set")
+	}
+	c, _ := NewCoordinator(nil, MigrationSet{Name: "core"})
+	if m := c.Migrator("core"); m == nil || m.Table != "core_emigrate" {
+		t.Errorf("Expected the Migrator of core to use its own table, got %#v", m)
+	}
+	if c.Migrator("billing") != nil {
+		t.Errorf("Expected no Migrator for an unknown set")
+	}
+}
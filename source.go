@@ -0,0 +1,172 @@
+package emigrate
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MigrationSource provides the SQL of migrations from wherever it is stored,
+// such as a directory, a configuration service or an artifact store.
+type MigrationSource interface {
+	// List returns the versions of the available migrations
+	List() ([]int64, error)
+
+	// Read returns the SQL that migrates version in the given direction, "up"
+	// or "down". It is only called for listed versions, and returns an empty
+	// string if the migration cannot be downgraded.
+	Read(version int64, direction string) (string, error)
+}
+
+// NamedSource is implemented by MigrationSources that know the names of their
+// migrations, which are shown in results and recorded in the history.
+type NamedSource interface {
+	// Name returns the name of the migration of a listed version, which may
+	// be empty
+	Name(version int64) string
+}
+
+// LocatedSource is implemented by MigrationSources that can tell where the
+// migrations they provide are stored, such as the path of a file, which is
+// reported when the versions of combined sources collide.
+type LocatedSource interface {
+	// Location returns where the migration of a listed version is stored
+	Location(version int64) string
+}
+
+// sourceLocation returns where src stores version, or the type of src if it
+// cannot tell
+func sourceLocation(src MigrationSource, version int64) string {
+	if ls, ok := src.(LocatedSource); ok {
+		return ls.Location(version)
+	}
+	return fmt.Sprintf("%T", src)
+}
+
+// SourceMigrations returns a slice of migrations that run the SQL read from
+// src, sorted by version, followed by those whose header declares them
+// repeatable, sorted by name. An error is returned if the migrations cannot
+// be listed or read, or if a version is listed twice.
+func SourceMigrations(src MigrationSource) ([]Migration, error) {
+	versions, err := src.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var repeatables []Migration
+	ms := make([]Migration, 0, len(versions))
+	seen := make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		if seen[version] {
+			return nil, DuplicateMigrationError{direction: "up", version: version}
+		}
+		seen[version] = true
+
+		up, err := src.Read(version, "up")
+		if err != nil {
+			return nil, err
+		}
+		down, err := src.Read(version, "down")
+		if err != nil {
+			return nil, err
+		}
+		m := stringMigration{version: version, up: up, down: down}
+		if ns, ok := src.(NamedSource); ok {
+			m.name = ns.Name(version)
+		}
+		header, err := sourceHeader(src, version, up)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.parseHeader(sourceLocation(src, version), header); err != nil {
+			return nil, err
+		}
+		if m.repeatable {
+			if m.name == "" {
+				return nil, fmt.Errorf("emigrate: Repeatable migration %q has no name.", sourceLocation(src, version))
+			} else if down != "" {
+				return nil, fmt.Errorf("emigrate: Repeatable migration %q cannot be downgraded.", sourceLocation(src, version))
+			}
+			repeatables = append(repeatables, &repeatableMigration{up: up, migrationOptions: m.migrationOptions})
+			continue
+		}
+		ms = append(ms, m)
+	}
+
+	sort.Sort(byVersion(ms))
+	_, repeatables = splitRepeatable(repeatables)
+	if errs := checkRepeatableNames(repeatables); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return append(ms, repeatables...), nil
+}
+
+// CombineSources returns a MigrationSource that provides the migrations of
+// all of sources, such as the directories of core and plugin modules. Listing
+// its versions fails if a version is provided by more than one of them.
+func CombineSources(sources ...MigrationSource) MigrationSource {
+	return &combinedSource{sources: sources}
+}
+
+// combinedSource is a MigrationSource that reads each version from the source
+// that listed it
+type combinedSource struct {
+	sources []MigrationSource
+	owners  map[int64]MigrationSource
+}
+
+func (s *combinedSource) List() ([]int64, error) {
+	s.owners = make(map[int64]MigrationSource)
+	var versions []int64
+	for _, src := range s.sources {
+		listed, err := src.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range listed {
+			if owner := s.owners[version]; owner != nil {
+				return nil, DuplicateMigrationError{"up", version, sourceLocation(owner, version), sourceLocation(src, version)}
+			}
+			s.owners[version] = src
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func (s *combinedSource) Read(version int64, direction string) (string, error) {
+	return s.owners[version].Read(version, direction)
+}
+
+func (s *combinedSource) Name(version int64) string {
+	if ns, ok := s.owners[version].(NamedSource); ok {
+		return ns.Name(version)
+	}
+	return ""
+}
+
+// MergeMigrations returns the migrations of all of sets as one slice, sorted
+// by version, with the repeatable migrations last. An error is returned if a
+// version, or the name of a repeatable migration, appears in more than one.
+func MergeMigrations(sets ...[]Migration) ([]Migration, error) {
+	var ms, repeatables []Migration
+	seen := make(map[int64]bool)
+	for _, set := range sets {
+		for _, m := range set {
+			if isRepeatable(m) {
+				repeatables = append(repeatables, m)
+				continue
+			} else if seen[m.Version()] {
+				return nil, DuplicateMigrationError{direction: "up", version: m.Version()}
+			}
+			seen[m.Version()] = true
+			ms = append(ms, m)
+		}
+	}
+	sort.Sort(byVersion(ms))
+
+	_, repeatables = splitRepeatable(repeatables)
+	if errs := checkRepeatableNames(repeatables); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return append(ms, repeatables...), nil
+}
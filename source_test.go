@@ -0,0 +1,134 @@
+package emigrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+// mapSource is a MigrationSource that keeps its migrations in memory
+type mapSource struct {
+	versions []int64
+	sql      map[int64]map[string]string
+	err      error
+}
+
+func (s mapSource) List() ([]int64, error) {
+	return s.versions, nil
+}
+
+func (s mapSource) Read(version int64, direction string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.sql[version][direction], nil
+}
+
+func TestSourceMigrations(t *testing.T) {
+	src := mapSource{
+		versions: []int64{2, 1},
+		sql: map[int64]map[string]string{
+			1: {"up": TestQueryCreateInvoiceTable, "down": TestQueryDropInvoiceTable},
+			2: {"up": TestQueryInsertInvoices},
+		},
+	}
+
+	ms, err := SourceMigrations(src)
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	if v := ms[0].Version(); v != 1 {
+		t.Errorf("Expected version %d first, got %d", 1, v)
+	}
+	if !canDowngrade(ms[0]) {
+		t.Errorf("Expected version 1 to be able to downgrade")
+	}
+	if canDowngrade(ms[1]) {
+		t.Errorf("Expected version 2 not to be able to downgrade")
+	}
+	if sql := ms[1].(SQLer).SQL("up"); sql != TestQueryInsertInvoices {
+		t.Errorf("Expected %q, got %q", TestQueryInsertInvoices, sql)
+	}
+}
+
+func TestSourceMigrationsDuplicateVersion(t *testing.T) {
+	src := mapSource{versions: []int64{1, 1}}
+	_, err := SourceMigrations(src)
+	if _, ok := err.(DuplicateMigrationError); !ok {
+		t.Errorf("Expected duplicate migration error, got %v", err)
+	}
+}
+
+func TestSourceMigrationsReadError(t *testing.T) {
+	readErr := errors.New("artifact store unavailable")
+	src := mapSource{versions: []int64{1}, err: readErr}
+	ms, err := SourceMigrations(src)
+	if err != readErr {
+		t.Errorf("Expected %v, got %v", readErr, err)
+	}
+	if ms != nil {
+		t.Errorf("Expected no migrations, got %v", ms)
+	}
+}
+
+func TestCombineSources(t *testing.T) {
+	core := fstest.MapFS{
+		"migrations/001_create_invoice.up.sql": {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/003_up.sql":                {Data: []byte("")},
+	}
+	plugin := mapSource{
+		versions: []int64{2},
+		sql:      map[int64]map[string]string{2: {"up": TestQueryInsertInvoices}},
+	}
+
+	ms, err := SourceMigrations(CombineSources(FSSource(core, "migrations"), plugin))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 3 {
+		t.Fatalf("Expected %d migrations, got %d", 3, len(ms))
+	}
+	for i, m := range ms {
+		if m.Version() != int64(i+1) {
+			t.Errorf("Expected version %d, got %d", i+1, m.Version())
+		}
+	}
+	if name := migrationName(ms[0]); name != "create_invoice" {
+		t.Errorf("Expected %s, got %s", "create_invoice", name)
+	}
+	if sql := ms[1].(SQLer).SQL("up"); sql != TestQueryInsertInvoices {
+		t.Errorf("Expected %q, got %q", TestQueryInsertInvoices, sql)
+	}
+
+	plugin.versions = []int64{3}
+	_, err = SourceMigrations(CombineSources(FSSource(core, "migrations"), plugin))
+	expected := `emigrate: Duplicate "up" migration for version 3 in migrations/003_up.sql and emigrate.mapSource`
+	if _, ok := err.(DuplicateMigrationError); !ok || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+
+	plugins := fstest.MapFS{"plugins/billing/003_add_totals.up.sql": {Data: []byte("")}}
+	_, err = SourceMigrations(CombineSources(FSSource(core, "migrations"), FSSource(plugins, "plugins/billing")))
+	expected = `emigrate: Duplicate "up" migration for version 3 in migrations/003_up.sql and plugins/billing/003_add_totals.up.sql`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
+func TestMergeMigrations(t *testing.T) {
+	ms, err := MergeMigrations(migrationRange(1, 3), migrationRange(2))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 3 || ms[1].Version() != 2 {
+		t.Errorf("Expected versions 1 to 3, got %v", ms)
+	}
+
+	_, err = MergeMigrations(migrationRange(1, 2), migrationRange(2))
+	if _, ok := err.(DuplicateMigrationError); !ok {
+		t.Errorf("Expected duplicate migration error, got %v", err)
+	}
+}
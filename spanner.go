@@ -0,0 +1,139 @@
+package emigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DDLSeparator is implemented by dialects of databases that never run DDL in
+// a transaction, such as Cloud Spanner, where schema changes are applied
+// through an admin API rather than the sessions that run DML. A migration
+// running DDL recognized by IsDDL runs outside a transaction, as if declared
+// no-transaction, with each of its statements run on its own, and the
+// tracking tables are created outside of one.
+type DDLSeparator interface {
+	IsDDL(statement string) bool
+}
+
+// SpannerDialect is the Dialect for Cloud Spanner, using the GoogleSQL
+// dialect through the database/sql driver of go-sql-spanner, which applies
+// DDL with the database admin API and runs DML in sessions. It is a
+// DDLSeparator, so migrations running DDL are run outside a transaction, and
+// uses SpannerQueries. It takes no lock of its own, as the read-write
+// transactions of Spanner lock the current version they read.
+type SpannerDialect struct{}
+
+func (SpannerDialect) QuoteIdentifier(name string) string {
+	return quoteGoogleSQL(name)
+}
+
+// quoteGoogleSQL quotes name with backticks for GoogleSQL, as used by Spanner
+// and BigQuery, escaping any backticks and backslashes within it with a
+// backslash, as GoogleSQL does not double them, unless name is a plain
+// identifier that doesn't need quoting
+func quoteGoogleSQL(name string) string {
+	if plainIdentifierRegexp.MatchString(name) && !reservedWords[name] {
+		return name
+	}
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
+
+// CurrentUser returns an empty string, as Spanner has no database users
+func (SpannerDialect) CurrentUser() string {
+	return "''"
+}
+
+func (SpannerDialect) Placeholder(n int) string {
+	return AtPlaceholder(n)
+}
+
+func (SpannerDialect) TransactionalDDL() bool {
+	return false
+}
+
+func (SpannerDialect) IsMissingTable(err error) bool {
+	return strings.Contains(err.Error(), "Table not found")
+}
+
+// spannerDDLRegexp matches the GoogleSQL statements that change the schema,
+// after any leading comments
+var spannerDDLRegexp = regexp.MustCompile(`(?is)^(?:\s*--[^\n]*\n)*\s*(?:CREATE|ALTER|DROP|RENAME|GRANT|REVOKE|ANALYZE)\b`)
+
+// IsDDL recognizes statements such as CREATE TABLE and ALTER TABLE
+func (SpannerDialect) IsDDL(statement string) bool {
+	return spannerDDLRegexp.MatchString(statement)
+}
+
+// SpannerQueries returns the queries for Cloud Spanner, whose tables must
+// have a primary key, and whose DELETE statements must have a WHERE clause.
+// The history table is keyed by a generated UUID. The current version is
+// read without FOR UPDATE, as reading it in a read-write transaction locks
+// it.
+func SpannerQueries() *QuerySet {
+	q := DefaultQueries()
+	q.LockCurrentVersion = q.GetCurrentVersion
+	q.CreateTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INT64 NOT NULL, version INT64) PRIMARY KEY (id)`, table)
+	}
+	q.InsertVersion = func(table string) string {
+		return fmt.Sprintf(`INSERT OR IGNORE INTO %s (id, version) VALUES (1, 0)`, table)
+	}
+	q.CreateAppliedTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INT64 NOT NULL) PRIMARY KEY (version)`, table)
+	}
+	q.CreateLedgerTable = q.CreateAppliedTable
+	q.CreateHistoryTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id STRING(36) NOT NULL DEFAULT (GENERATE_UUID()), version INT64, name STRING(MAX), label STRING(MAX), checksum STRING(MAX), direction STRING(MAX), from_version INT64, to_version INT64, applied_by STRING(MAX), db_user STRING(MAX), application STRING(MAX), hostname STRING(MAX), deploy_id STRING(MAX), batch INT64, sql_text STRING(MAX), applied_at TIMESTAMP, duration_ms INT64, success BOOL) PRIMARY KEY (id)`, table)
+	}
+	q.InsertHistory = func(table, currentUser string) string {
+		return fmt.Sprintf(`INSERT INTO %s (version, name, label, checksum, direction, from_version, to_version, applied_by, db_user, application, hostname, deploy_id, batch, sql_text, applied_at, duration_ms, success) VALUES (?, ?, ?, ?, ?, ?, ?, ?, %s, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP(), ?, ?)`, table, currentUser)
+	}
+	q.CreateLockTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INT64 NOT NULL, locked_by STRING(MAX), locked_at TIMESTAMP) PRIMARY KEY (id)`, table)
+	}
+	q.InsertLock = func(table string) string {
+		return fmt.Sprintf(`INSERT OR IGNORE INTO %s (id) VALUES (1)`, table)
+	}
+	q.CreateDirtyTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INT64 NOT NULL) PRIMARY KEY (version)`, table)
+	}
+	q.ClearDirty = func(table string) string {
+		return fmt.Sprintf(`DELETE FROM %s WHERE true`, table)
+	}
+	q.CreateRepeatableTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (name STRING(MAX) NOT NULL, checksum STRING(MAX)) PRIMARY KEY (name)`, table)
+	}
+	q.CreateCheckpointTable = func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INT64 NOT NULL, checkpoint STRING(MAX)) PRIMARY KEY (version)`, table)
+	}
+	return q
+}
+
+// separatesDDL reports whether a migration runs DDL that the dialect cannot
+// run in a transaction, as described by DDLSeparator
+func (m *Migrator) separatesDDL(migration Migration) bool {
+	sr, ok := migration.(sqlReader)
+	if !ok {
+		return false
+	}
+	for _, direction := range []string{"up", "down"} {
+		script, err := sr.readSQL(direction)
+		if err != nil {
+			continue
+		}
+		for _, statement := range SplitSQL(script) {
+			if m.isSeparateDDL(statement) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSeparateDDL reports whether statement is DDL that the dialect cannot run
+// in a transaction
+func (m *Migrator) isSeparateDDL(statement string) bool {
+	s, ok := m.dialect().(DDLSeparator)
+	return ok && s.IsDDL(statement)
+}
@@ -0,0 +1,81 @@
+package emigrate
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSpannerQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"emigrate", "emigrate"},
+		{"migration-history", "`migration-history`"},
+		{"order", "`order`"},
+		{"unnest", "`unnest`"},
+		{"odd`name", "`odd\\`name`"},
+	}
+	for _, test := range tests {
+		if result := (SpannerDialect{}).QuoteIdentifier(test.name); result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, result)
+		}
+	}
+}
+
+// Verify that every tracking table of Spanner has the primary key it
+// requires.
+func TestSpannerQueries(t *testing.T) {
+	q := SpannerQueries()
+	for _, create := range []func(string) string{
+		q.CreateTable, q.CreateAppliedTable, q.CreateLedgerTable, q.CreateHistoryTable, q.CreateLockTable,
+		q.CreateDirtyTable, q.CreateRepeatableTable, q.CreateCheckpointTable,
+	} {
+		if query := create(testTable); !strings.HasSuffix(query, ")") || !strings.Contains(query, ") PRIMARY KEY (") {
+			t.Errorf("Expected a primary key, got %s", query)
+		}
+	}
+}
+
+// Verify that a migration running DDL on Spanner is run outside a
+// transaction, and recorded as applied in a transaction of its own.
+func TestSpannerDDLOutsideTx(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, Dialect: SpannerDialect{}}
+	m.migrations = []Migration{NewStringMigration(1, "CREATE TABLE a (id INT64) PRIMARY KEY (id);\nINSERT INTO a (id) VALUES (1)", "")}
+
+	expectDirtyQuery(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE a (id INT64) PRIMARY KEY (id)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO a (id) VALUES (1)")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE emigrate SET version = @p1 WHERE version = @p2")).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO " + testAppliedTable)).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	result, err := m.UpgradeToVersion(1)
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	} else if len(result.Warnings) > 0 {
+		t.Errorf("Expected no warnings, got %q", result.Warnings)
+	}
+	mock.CloseTest(t)
+}
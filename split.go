@@ -0,0 +1,217 @@
+package emigrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// SplitSQL splits a script into its statements at each semicolon, except for
+// semicolons within string literals, quoted identifiers, comments and
+// dollar-quoted strings, such as the bodies of PostgreSQL functions. The
+// statements are trimmed of surrounding space, and those with nothing but
+// comments are dropped. Backslashes are not treated as escapes within string
+// literals, as the SQL standard has it.
+//
+// Statements the splitter cannot make sense of, such as triggers in dialects
+// without dollar-quoted strings, are kept whole by putting them between
+// directive lines:
+//
+//	-- +emigrate StatementBegin
+//	CREATE TRIGGER ... BEGIN ...; END;
+//	-- +emigrate StatementEnd
+//
+// A StatementBegin without a StatementEnd runs to the end of the script.
+func SplitSQL(script string) []string {
+	var statements []string
+	var chunk strings.Builder  // the lines to split outside of any block
+	var block *strings.Builder // the lines of the current block, if any
+	for _, line := range strings.SplitAfter(script, "\n") {
+		match := statementRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		switch {
+		case match != nil && match[1] == "Begin" && block == nil:
+			statements = append(statements, splitStatements(chunk.String())...)
+			chunk.Reset()
+			block = new(strings.Builder)
+		case match != nil && match[1] == "End" && block != nil:
+			statements = appendBlock(statements, block)
+			block = nil
+		case match != nil:
+			// a stray directive, such as a StatementEnd outside a block
+		case block != nil:
+			block.WriteString(line)
+		default:
+			chunk.WriteString(line)
+		}
+	}
+	if block != nil {
+		statements = appendBlock(statements, block)
+	}
+	return append(statements, splitStatements(chunk.String())...)
+}
+
+// statementRegexp matches the lines around a statement that SplitSQL keeps
+// whole
+var statementRegexp = regexp.MustCompile(`^--\s*\+emigrate\s+Statement(Begin|End)\s*$`)
+
+// appendBlock appends the statement held by block to statements, unless it is
+// empty
+func appendBlock(statements []string, block *strings.Builder) []string {
+	if statement := strings.TrimSpace(block.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+// splitStatements splits script at each semicolon outside of literals and
+// comments, as described by SplitSQL
+func splitStatements(script string) []string {
+	var statements []string
+	start := 0    // the start of the current statement
+	code := false // whether the current statement has more than comments
+
+	end := func(i int) {
+		if code {
+			statements = append(statements, strings.TrimSpace(script[start:i]))
+		}
+		start, code = i+1, false
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == ';':
+			end(i)
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(script, i, c)
+			code = true
+		case strings.HasPrefix(script[i:], "--"):
+			if j := strings.IndexByte(script[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(script)
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			i = skipBlockComment(script, i)
+		case c == '$':
+			if tag := dollarTag(script, i); tag != "" {
+				if j := strings.Index(script[i+len(tag):], tag); j >= 0 {
+					i += len(tag) + j + len(tag) - 1
+				} else {
+					i = len(script)
+				}
+			}
+			code = true
+		case !unicode.IsSpace(rune(c)):
+			code = true
+		}
+	}
+	end(len(script))
+	return statements
+}
+
+// skipQuoted returns the index of the quote closing the quoted string or
+// identifier opened at i, where a doubled quote stands for itself
+func skipQuoted(script string, i int, quote byte) int {
+	for i++; i < len(script); i++ {
+		if script[i] == quote {
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(script)
+}
+
+// skipBlockComment returns the index of the last character of the block
+// comment opened at i, allowing for nested comments as PostgreSQL does
+func skipBlockComment(script string, i int) int {
+	depth := 0
+	for ; i < len(script); i++ {
+		if strings.HasPrefix(script[i:], "/*") {
+			depth++
+			i++
+		} else if strings.HasPrefix(script[i:], "*/") {
+			depth--
+			i++
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(script)
+}
+
+// dollarTag returns the tag, such as $$ or $body$, that opens a dollar-quoted
+// string at i, or "" if there is none there, as for a $1 parameter
+func dollarTag(script string, i int) string {
+	if i > 0 && isIdentifierByte(script[i-1]) {
+		return ""
+	}
+	for j := i + 1; j < len(script); j++ {
+		c := script[j]
+		if c == '$' {
+			return script[i : j+1]
+		}
+		if !isIdentifierByte(c) || j == i+1 && c >= '0' && c <= '9' {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// sqlReader is implemented by migrations that only run SQL, so that the
+// Migrator can run their statements one at a time
+type sqlReader interface {
+	readSQL(direction string) (string, error)
+}
+
+func (m stringMigration) readSQL(direction string) (string, error) {
+	return m.SQL(direction), nil
+}
+
+func (m *lazyMigration) readSQL(direction string) (string, error) {
+	return m.src.Read(m.version, direction)
+}
+
+// StatementError indicates that a statement of a migration run one
+// statement at a time failed, after the statements before it had run.
+type StatementError struct {
+	index     int    // the position of the statement, counting from 1
+	statement string // the statement that failed
+	err       error  // the error returned by the statement
+}
+
+func (e StatementError) Error() string {
+	return fmt.Sprintf("emigrate: Statement %d failed: %s\n\t%s", e.index, e.err, e.statement)
+}
+
+// Unwrap returns the error returned by the statement
+func (e StatementError) Unwrap() error {
+	return e.err
+}
+
+// execStatements runs the statements of script on db one at a time, returning
+// the total number of rows affected, for drivers that cannot run more than one
+// statement at once. A failed statement is returned in a StatementError.
+func execStatements(ctx context.Context, db execer, script string) (int64, error) {
+	var total int64
+	for idx, statement := range SplitSQL(script) {
+		res, err := db.ExecContext(ctx, statement)
+		if err != nil {
+			return total, StatementError{idx + 1, statement, err}
+		}
+		// not all drivers support RowsAffected, so ignore the error
+		rows, _ := res.RowsAffected()
+		total += rows
+	}
+	return total, nil
+}
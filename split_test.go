@@ -0,0 +1,105 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSplitSQL(t *testing.T) {
+	var tests = []struct {
+		script   string
+		expected []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"INSERT INTO t VALUES ('a;b', 'it''s;');", []string{"INSERT INTO t VALUES ('a;b', 'it''s;')"}},
+		{`CREATE TABLE "semi;colon" (id INTEGER); CREATE TABLE ` + "`x;y`" + ` (id INTEGER)`,
+			[]string{`CREATE TABLE "semi;colon" (id INTEGER)`, "CREATE TABLE `x;y` (id INTEGER)"}},
+		{"-- drop it; now\nDROP TABLE t; /* a; /* nested; */ comment */ SELECT 1;",
+			[]string{"-- drop it; now\nDROP TABLE t", "/* a; /* nested; */ comment */ SELECT 1"}},
+		{"SELECT 1;\n-- trailing; comment\n", []string{"SELECT 1"}},
+		{"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql; SELECT f();",
+			[]string{"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql", "SELECT f()"}},
+		{"DO $body$ BEGIN PERFORM '$$;'; END $body$; SELECT 2",
+			[]string{"DO $body$ BEGIN PERFORM '$$;'; END $body$", "SELECT 2"}},
+		{"PREPARE p AS SELECT $1; EXECUTE p(1);", []string{"PREPARE p AS SELECT $1", "EXECUTE p(1)"}},
+		{";;  ;", nil},
+	}
+
+	for _, test := range tests {
+		result := SplitSQL(test.script)
+		if len(result) != len(test.expected) {
+			t.Errorf("Expected %q, got %q", test.expected, result)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+				break
+			}
+		}
+	}
+}
+
+// Verify that statements between StatementBegin and StatementEnd directives
+// are kept whole.
+func TestSplitSQLStatementBlocks(t *testing.T) {
+	trigger := "CREATE TRIGGER t AFTER INSERT ON invoices BEGIN\n  UPDATE totals SET n = n + 1;\nEND;"
+	var tests = []struct {
+		script   string
+		expected []string
+	}{
+		{"SELECT 1;\n-- +emigrate StatementBegin\n" + trigger + "\n-- +emigrate StatementEnd\nSELECT 2;\n",
+			[]string{"SELECT 1", trigger, "SELECT 2"}},
+		{"SELECT 1\n  --   +emigrate   StatementBegin  \n" + trigger + "\n-- +emigrate StatementEnd\n",
+			[]string{"SELECT 1", trigger}},
+		{"-- +emigrate StatementBegin\n" + trigger + "\n", []string{trigger}},
+		{"-- +emigrate StatementBegin\n-- +emigrate StatementEnd\n-- +emigrate StatementEnd\nSELECT 1;", []string{"SELECT 1"}},
+	}
+
+	for _, test := range tests {
+		result := SplitSQL(test.script)
+		if len(result) != len(test.expected) {
+			t.Errorf("Expected %q, got %q", test.expected, result)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+				break
+			}
+		}
+	}
+}
+
+// Verify that the statements of a migration are run one at a time when
+// splitting statements, with their rows affected added up.
+func TestSplitStatements(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.SplitStatements = true
+	script := TestQueryCreateInvoiceTable + ";\n" + TestQueryInsertInvoices + ";\n"
+	m.migrations = append(m.migrations, stringMigration{version: 1, up: script})
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec("^" + regexp.QuoteMeta(TestQueryCreateInvoiceTable) + "$").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^" + regexp.QuoteMeta(TestQueryInsertInvoices) + "$").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	result, err := m.UpgradeToVersion(1)
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if rows := result.Migrations[0].RowsAffected; rows != 3 {
+		t.Errorf("Expected %d rows affected, got %d", 3, rows)
+	}
+	mock.CloseTest(t)
+}
@@ -0,0 +1,271 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Squash returns a baseline migration at version that runs the upgrade SQL of
+// every migration up to and including version, in order, so that they can be
+// replaced by it. If every squashed migration has a downgrade, the baseline
+// downgrades by running them in reverse order. The baseline is named
+// "baseline" unless given another name, and runs outside of a transaction if
+// any of the squashed migrations do. Only migrations that run SQL can be
+// squashed, and repeatable migrations are left out, as they are kept.
+//
+// Before replacing the migrations, check the baseline with VerifyBaseline,
+// write it with WriteBaseline and rewrite the tracking tables of existing
+// databases with Rebaseline.
+func Squash(migrations []Migration, version int64, opts ...MigrationOption) (Migration, error) {
+	versioned, _ := splitRepeatable(migrations)
+	sorted := make([]Migration, len(versioned))
+	copy(sorted, versioned)
+	sort.Sort(byVersion(sorted))
+	idx, ok := byVersion(sorted).Search(version)
+	if !ok {
+		return nil, fmt.Errorf("emigrate: No migration %d to squash up to.", version)
+	}
+	sorted = sorted[:idx+1]
+
+	var ups, downs []string
+	downgradable, noTransaction := true, false
+	for _, migration := range sorted {
+		if err := prepare(migration); err != nil {
+			return nil, err
+		}
+		up, down, err := squashSQL(migration)
+		if err != nil {
+			return nil, err
+		}
+		ups = append(ups, strings.TrimSpace(up))
+		downs = append([]string{strings.TrimSpace(down)}, downs...)
+		downgradable = downgradable && strings.TrimSpace(down) != ""
+		noTransaction = noTransaction || !transactional(migration)
+	}
+
+	m := &stringMigration{version: version, up: joinScripts(ups)}
+	if downgradable {
+		m.down = joinScripts(downs)
+	}
+	m.name = "baseline"
+	m.set(opts)
+	m.noTransaction = noTransaction
+	return m, nil
+}
+
+// squashSQL returns the upgrade and downgrade SQL of a migration to be
+// squashed, failing if it does not run SQL
+func squashSQL(m Migration) (up, down string, err error) {
+	if sr, ok := m.(sqlReader); ok {
+		if up, err = sr.readSQL("up"); err != nil {
+			return "", "", err
+		}
+		down, err = sr.readSQL("down")
+		return up, down, err
+	}
+	switch s := m.(type) {
+	case stepper, *paramMigration:
+		// their SQL is incomplete without their functions or parameters
+	case SQLer:
+		return s.SQL("up"), s.SQL("down"), nil
+	}
+	return "", "", fmt.Errorf("emigrate: Migration %d cannot be squashed, as it does not run SQL.", m.Version())
+}
+
+// joinScripts joins scripts into one, ending each with a semicolon
+func joinScripts(scripts []string) string {
+	var b strings.Builder
+	for _, script := range scripts {
+		if script == "" {
+			continue
+		}
+		b.WriteString(script)
+		if !strings.HasSuffix(script, ";") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+// WriteBaseline writes the SQL of a baseline returned by Squash to files in
+// dir named after its version and name, such as 42_baseline_up.sql, returning
+// their paths. The down file is only written if the baseline has a
+// downgrade. It fails rather than overwrite existing files, and the files of
+// the squashed migrations are left for the caller to remove.
+func WriteBaseline(dir string, baseline Migration) (up, down string, err error) {
+	s, ok := baseline.(SQLer)
+	if !ok {
+		return "", "", fmt.Errorf("emigrate: Baseline %d does not run SQL.", baseline.Version())
+	}
+	name := migrationName(baseline)
+	if name == "" {
+		name = "baseline"
+	}
+	if !slugRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("emigrate: Invalid migration name %q.", name)
+	}
+
+	var header string
+	if !transactional(baseline) {
+		header = "-- emigrate:no-transaction\n\n"
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("%d_%s", baseline.Version(), name))
+	up = prefix + "_up.sql"
+	if err := writeNewFile(up, header+s.SQL("up")); err != nil {
+		return "", "", err
+	}
+	if canDowngrade(baseline) {
+		down = prefix + "_down.sql"
+		if err := writeNewFile(down, header+s.SQL("down")); err != nil {
+			return up, "", err
+		}
+	}
+	return up, down, nil
+}
+
+// writeNewFile writes contents to a file that must not already exist
+func writeNewFile(name, contents string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(contents); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SchemaFunc describes the schema of a database, such as by listing its
+// tables and columns or dumping its DDL, so that two schemas can be compared
+// line by line.
+type SchemaFunc func(db *sql.DB) ([]string, error)
+
+// InformationSchema is a SchemaFunc that lists the columns of every table,
+// with their type and nullability, from the standard information_schema,
+// which is available in PostgreSQL and MySQL among others.
+func InformationSchema(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT table_name, column_name, data_type, is_nullable FROM information_schema.columns ` +
+		`WHERE table_schema NOT IN ('information_schema', 'pg_catalog', 'mysql', 'performance_schema', 'sys') ` +
+		`ORDER BY table_name, column_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var table, column, dataType, nullable string
+		if err := rows.Scan(&table, &column, &dataType, &nullable); err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s %s nullable=%s", table, column, dataType, nullable))
+	}
+	return lines, rows.Err()
+}
+
+// SchemaMismatchError indicates that a baseline does not reproduce the schema
+// of the migrations it squashes
+type SchemaMismatchError struct {
+	Missing []string // lines of the schema of the migrations not in that of the baseline
+	Extra   []string // lines of the schema of the baseline not in that of the migrations
+}
+
+func (e SchemaMismatchError) Error() string {
+	var msgs []string
+	for _, line := range e.Missing {
+		msgs = append(msgs, "- "+line)
+	}
+	for _, line := range e.Extra {
+		msgs = append(msgs, "+ "+line)
+	}
+	return fmt.Sprintf("emigrate: Baseline does not reproduce the schema:\n\t%s", strings.Join(msgs, "\n\t"))
+}
+
+// VerifyBaseline checks that baseline reproduces the schema of the
+// migrations it squashes, by applying the migrations up to its version to
+// the empty database original and the baseline to the empty database
+// squashed, and comparing their schemas as described by schema. A
+// SchemaMismatchError describes any difference.
+func VerifyBaseline(original, squashed *sql.DB, migrations []Migration, baseline Migration, schema SchemaFunc) error {
+	versioned, _ := splitRepeatable(migrations)
+	dbs := []*sql.DB{original, squashed}
+	sets := [][]Migration{versioned, {baseline}}
+	schemas := make([][]string, len(dbs))
+	for idx, db := range dbs {
+		m := NewMigrator(db, sets[idx])
+		m.Gaps = GapIgnore
+		if err := m.Init(); err != nil {
+			return err
+		}
+		if _, err := m.UpgradeToVersion(baseline.Version()); err != nil {
+			return err
+		}
+		lines, err := schema(db)
+		if err != nil {
+			return err
+		}
+		schemas[idx] = lines
+	}
+
+	if missing, extra := diffLines(schemas[0], schemas[1]); len(missing) > 0 || len(extra) > 0 {
+		return SchemaMismatchError{missing, extra}
+	}
+	return nil
+}
+
+// diffLines returns the lines of a not in b, and of b not in a
+func diffLines(a, b []string) (missing, extra []string) {
+	count := make(map[string]int)
+	for _, line := range a {
+		count[line]++
+	}
+	for _, line := range b {
+		if count[line] > 0 {
+			count[line]--
+		} else {
+			extra = append(extra, line)
+		}
+	}
+	for _, line := range a {
+		if count[line] > 0 {
+			count[line]--
+			missing = append(missing, line)
+		}
+	}
+	return missing, extra
+}
+
+// Rebaseline rewrites the tracking tables of a database for baseline, which
+// replaces the migrations up to its version, as returned by Squash. The
+// history and applied versions of the replaced migrations are removed, as by
+// PruneHistory, and the checksum and name recorded for the version of the
+// baseline are replaced by its own, so that it is taken to be applied. A
+// database that has not reached the version of the baseline is left alone if
+// it has no migrations applied, as the baseline will be applied to it, but
+// is otherwise at a version that no longer exists, and InvalidBaselineVersion
+// is returned.
+func (m *Migrator) Rebaseline(baseline Migration) error {
+	version := baseline.Version()
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	} else if current == 0 {
+		return nil
+	} else if current < version {
+		return InvalidBaselineVersion
+	}
+
+	statements := []statement{
+		{m.query(m.queries().PruneHistory, m.historyTable()), []interface{}{version}},
+		{m.query(m.queries().PruneAppliedVersions, m.appliedTable()), []interface{}{version}},
+		{m.query(m.queries().RepairHistoryChecksum, m.historyTable()), []interface{}{directionChecksum(baseline, "up"), version, true}},
+		{m.query(m.queries().RepairHistoryName, m.historyTable()), []interface{}{migrationName(baseline), version, true}},
+	}
+	return m.execTracking(statements)
+}
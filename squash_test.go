@@ -0,0 +1,127 @@
+package emigrate
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSquash(t *testing.T) {
+	ms := []Migration{
+		NewStringMigration(2, TestQueryInsertInvoices, "DELETE FROM invoice"),
+		NewStringMigration(1, TestQueryCreateInvoiceTable, TestQueryDropInvoiceTable),
+		NewStringMigration(3, "ALTER TABLE invoice ADD total INTEGER", ""),
+	}
+	baseline, err := Squash(ms, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := TestQueryCreateInvoiceTable + ";\n\n" + TestQueryInsertInvoices + ";\n\n"
+	if up := baseline.(SQLer).SQL("up"); up != expected {
+		t.Errorf("Expected %q, got %q", expected, up)
+	}
+	expected = "DELETE FROM invoice;\n\n" + TestQueryDropInvoiceTable + ";\n\n"
+	if down := baseline.(SQLer).SQL("down"); down != expected {
+		t.Errorf("Expected %q, got %q", expected, down)
+	}
+	if baseline.Version() != 2 || migrationName(baseline) != "baseline" {
+		t.Errorf("Expected baseline version 2, got %d %s", baseline.Version(), migrationName(baseline))
+	}
+
+	// the baseline cannot be downgraded if one of the squashed migrations can't
+	if baseline, _ = Squash(ms, 3); canDowngrade(baseline) {
+		t.Errorf("Expected the baseline to have no downgrade")
+	}
+}
+
+func TestSquashInvalid(t *testing.T) {
+	if _, err := Squash(migrationRange(1, 2), 3); err == nil {
+		t.Errorf("Expected an error for a missing version")
+	}
+	if _, err := Squash([]Migration{NewFunctionMigration(1, nil, nil)}, 1); err == nil {
+		t.Errorf("Expected an error for a Go migration")
+	}
+}
+
+// Verify that a written baseline is loaded as the migrations it squashes.
+func TestWriteBaseline(t *testing.T) {
+	dir := t.TempDir()
+	baseline, _ := Squash([]Migration{
+		NewStringMigration(1, TestQueryCreateInvoiceTable, TestQueryDropInvoiceTable),
+		NewStringMigration(2, "CREATE INDEX CONCURRENTLY i ON invoice (id)", "", func(o *migrationOptions) { o.noTransaction = true }),
+	}, 2)
+
+	up, down, err := WriteBaseline(dir, baseline)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	} else if up != filepath.Join(dir, "2_baseline_up.sql") || down != "" {
+		t.Errorf("Unexpected baseline files %s and %s", up, down)
+	}
+	ms, err := MigrationsFromDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(ms) != 1 || ms[0].Version() != 2 || transactional(ms[0]) {
+		t.Errorf("Expected a non-transactional baseline at version 2, got %v", ms)
+	}
+	if _, _, err := WriteBaseline(dir, baseline); err == nil {
+		t.Errorf("Expected an error overwriting the baseline")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	missing, extra := diffLines([]string{"a", "b", "b"}, []string{"b", "c"})
+	if len(missing) != 2 || missing[0] != "a" || missing[1] != "b" || len(extra) != 1 || extra[0] != "c" {
+		t.Errorf("Expected [a b] missing and [c] extra, got %v and %v", missing, extra)
+	}
+	err := SchemaMismatchError{Missing: []string{"invoice.total bigint nullable=YES"}}
+	if !regexp.MustCompile(`- invoice.total`).MatchString(err.Error()) {
+		t.Errorf("Expected the missing column in %q", err.Error())
+	}
+}
+
+// Verify that rebaselining prunes the squashed migrations and records the
+// checksum of the baseline.
+func TestRebaseline(t *testing.T) {
+	mock, m := setupVersioned(t, 3)
+	baseline, _ := Squash(migrationStrings(1, 2), 2)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.PruneHistory(testHistoryTable))).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.PruneAppliedVersions(testAppliedTable))).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RepairHistoryChecksum(testHistoryTable))).
+		WithArgs(checksum(baseline), int64(2), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.RepairHistoryName(testHistoryTable))).
+		WithArgs("baseline", int64(2), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := m.Rebaseline(baseline); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestRebaselinePartway(t *testing.T) {
+	mock, m := setupVersioned(t, 1)
+	baseline, _ := Squash(migrationStrings(1, 2), 2)
+	if err := m.Rebaseline(baseline); !errors.Is(err, InvalidBaselineVersion) {
+		t.Errorf("Expected %v, got %v", InvalidBaselineVersion, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Returns string migrations at set version numbers, each creating a table
+func migrationStrings(versions ...int64) []Migration {
+	ms := make([]Migration, len(versions))
+	for idx, version := range versions {
+		ms[idx] = NewStringMigration(version, "CREATE TABLE t"+string(rune('0'+version))+" (id INTEGER)", "")
+	}
+	return ms
+}
@@ -0,0 +1,201 @@
+package emigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Step is one step of a migration created by NewStepMigration or
+// NewCompositeMigration, which either runs SQL or calls a Go function.
+type Step struct {
+	name string                 // the name of the step, if it has one
+	sql  string                 // the SQL to run, if any
+	fn   func(tx *sql.Tx) error // the function to call, if any
+}
+
+// SQLStep returns a step that runs the SQL statements in script.
+func SQLStep(script string) Step {
+	return Step{sql: script}
+}
+
+// FuncStep returns a step that calls fn.
+func FuncStep(fn func(tx *sql.Tx) error) Step {
+	return Step{fn: fn}
+}
+
+// stepMigration is an implementation of Migration that runs a sequence of SQL
+// and Go steps
+type stepMigration struct {
+	version int64  // the version number of the migration
+	up      []Step // the steps to run on upgrade
+	down    []Step // the steps to run on downgrade
+	migrationOptions
+}
+
+// NewStepMigration returns a migration that runs a sequence of steps, each of
+// which either runs SQL or calls a Go function, such as a schema change
+// followed by a transformation of the data that is awkward to express in SQL.
+// The steps run in order in the transaction of the migration, so the
+// migration is applied or rolled back as a whole. The migration cannot be
+// downgraded if down is empty. Its checksum is declared with WithChecksum.
+func NewStepMigration(version int64, up, down []Step, opts ...MigrationOption) Migration {
+	m := &stepMigration{version: version, up: up, down: down}
+	m.set(opts)
+	return m
+}
+
+func (m *stepMigration) Version() int64 {
+	return m.version
+}
+
+// Checksum returns the declared checksum of the migration
+func (m *stepMigration) Checksum() string {
+	return m.checksum
+}
+
+// SQL returns the SQL of the upgrade or downgrade steps of the migration,
+// which leaves out the steps that call functions
+func (m *stepMigration) SQL(direction string) string {
+	var scripts []string
+	for _, step := range m.steps(direction) {
+		if step.fn == nil {
+			scripts = append(scripts, step.sql)
+		}
+	}
+	return strings.Join(scripts, "\n")
+}
+
+// steps returns the upgrade or downgrade steps of the migration
+func (m *stepMigration) steps(direction string) []Step {
+	if direction == "down" {
+		return m.down
+	}
+	return m.up
+}
+
+func (m *stepMigration) Upgrade(tx *sql.Tx) error {
+	_, err := execSteps(context.Background(), sqlTx{tx}, m.up, false, nil)
+	return err
+}
+
+func (m *stepMigration) Downgrade(tx *sql.Tx) error {
+	if len(m.down) == 0 {
+		return fmt.Errorf("emigrate: No downgrade defined for migration %d", m.version)
+	}
+	_, err := execSteps(context.Background(), sqlTx{tx}, m.down, false, nil)
+	return err
+}
+
+// stepper is implemented by migrations that run a sequence of steps, so that
+// the Migrator can run each step itself
+type stepper interface {
+	steps(direction string) []Step
+}
+
+// CompositeMigration is a migration built up from named steps, each of which
+// either runs SQL or calls a Go function, for long migrations whose progress
+// is reported step by step to the OnStep function of the Migrator. The steps
+// run in order in the transaction of the migration, as for NewStepMigration.
+type CompositeMigration struct {
+	stepMigration
+}
+
+// NewCompositeMigration returns a CompositeMigration with no steps, which are
+// added with AddStep and AddDownStep.
+func NewCompositeMigration(version int64, opts ...MigrationOption) *CompositeMigration {
+	m := &CompositeMigration{stepMigration{version: version}}
+	m.set(opts)
+	return m
+}
+
+// AddStep adds a named step to the upgrade of the migration, such as
+// SQLStep("CREATE TABLE ...") or FuncStep(backfill), returning the migration
+// so that calls can be chained.
+func (m *CompositeMigration) AddStep(name string, step Step) *CompositeMigration {
+	step.name = name
+	m.up = append(m.up, step)
+	return m
+}
+
+// AddDownStep adds a named step to the downgrade of the migration. The
+// migration cannot be downgraded unless it has at least one.
+func (m *CompositeMigration) AddDownStep(name string, step Step) *CompositeMigration {
+	step.name = name
+	m.down = append(m.down, step)
+	return m
+}
+
+// StepProgress reports the completion of a step of a migration, which is
+// only committed along with the migration.
+type StepProgress struct {
+	Version   int64         // the version of the migration
+	Direction string        // "up" for an upgrade or "down" for a downgrade
+	Step      string        // the name of the step, if it has one
+	Index     int           // the position of the step, counting from 1
+	Total     int           // the number of steps in the migration
+	Duration  time.Duration // how long the step took to run
+}
+
+// runSteps runs the steps of a migration in the given direction in tx,
+// returning the number of rows affected by their SQL, and reporting each step
+// completed to the OnStep function, if there is one. The SQL is run one
+// statement at a time if configured to split statements.
+func (m *Migrator) runSteps(ctx context.Context, tx Tx, migration Migration, direction string) (int64, error) {
+	steps := migration.(stepper).steps(direction)
+	var done func(idx int, step Step, elapsed time.Duration)
+	if m.OnStep != nil {
+		done = func(idx int, step Step, elapsed time.Duration) {
+			m.OnStep(StepProgress{
+				Version:   migration.Version(),
+				Direction: direction,
+				Step:      step.name,
+				Index:     idx + 1,
+				Total:     len(steps),
+				Duration:  elapsed,
+			})
+		}
+	}
+	return execSteps(ctx, tx, steps, m.splitsStatements(), done)
+}
+
+// execSteps runs steps in order in tx, returning the number of rows affected by
+// their SQL and calling done, if not nil, as each step completes. The SQL is
+// run one statement at a time if split is set.
+func execSteps(ctx context.Context, tx Tx, steps []Step, split bool, done func(idx int, step Step, elapsed time.Duration)) (int64, error) {
+	var total int64
+	for idx, step := range steps {
+		start := time.Now()
+		rows, err := runStep(ctx, tx, step, split)
+		total += rows
+		if err != nil {
+			return total, err
+		} else if done != nil {
+			done(idx, step, time.Since(start))
+		}
+	}
+	return total, nil
+}
+
+// runStep runs a single step in tx, returning the number of rows affected by
+// its SQL
+func runStep(ctx context.Context, tx Tx, step Step, split bool) (int64, error) {
+	if step.fn != nil {
+		stx, err := databaseSQLTx(tx)
+		if err != nil {
+			return 0, err
+		}
+		return 0, step.fn(stx)
+	} else if split {
+		return execStatements(ctx, tx, step.sql)
+	}
+	res, err := tx.ExecContext(ctx, step.sql)
+	if err != nil {
+		return 0, err
+	}
+	// not all drivers support RowsAffected, so ignore the error
+	rows, _ := res.RowsAffected()
+	return rows, nil
+}
@@ -0,0 +1,121 @@
+package emigrate
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Verify that the SQL and Go steps of a migration run in order in the
+// transaction of the migration.
+func TestStepMigration(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	var called bool
+	m.migrations = []Migration{NewStepMigration(1, []Step{
+		SQLStep(TestQueryCreateInvoiceTable),
+		FuncStep(func(tx *sql.Tx) error {
+			called = true
+			_, err := tx.Exec(TestQueryInsertInvoices)
+			return err
+		}),
+	}, nil)}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryInsertInvoices)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if !called {
+		t.Errorf("Expected the function step to be called")
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that a failing step rolls back the steps before it.
+func TestStepMigrationFails(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	expected := errors.New("transform failed")
+	m.migrations = []Migration{NewStepMigration(1, []Step{
+		SQLStep(TestQueryCreateInvoiceTable),
+		FuncStep(func(tx *sql.Tx) error { return expected }),
+		SQLStep(TestQueryInsertInvoices),
+	}, nil)}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	expectInsertHistory(mock)
+
+	if _, err := m.UpgradeToVersion(1); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	mock.CloseTest(t)
+}
+
+func TestStepMigrationSQL(t *testing.T) {
+	m := NewStepMigration(1,
+		[]Step{SQLStep(TestQueryCreateInvoiceTable), FuncStep(func(tx *sql.Tx) error { return nil })},
+		[]Step{SQLStep(TestQueryDropInvoiceTable)})
+	if sql := m.(SQLer).SQL("up"); sql != TestQueryCreateInvoiceTable {
+		t.Errorf("Expected %q, got %q", TestQueryCreateInvoiceTable, sql)
+	}
+	if !canDowngrade(m) {
+		t.Errorf("Expected the migration to have a downgrade")
+	}
+	if canDowngrade(NewStepMigration(2, nil, nil)) {
+		t.Errorf("Expected a migration without down steps to have no downgrade")
+	}
+}
+
+// Verify that the completion of each step of a composite migration is
+// reported to OnStep.
+func TestCompositeMigrationProgress(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{NewCompositeMigration(1, WithName("split_invoices")).
+		AddStep("create table", SQLStep(TestQueryCreateInvoiceTable)).
+		AddStep("backfill", FuncStep(func(tx *sql.Tx) error { return nil })).
+		AddDownStep("drop table", SQLStep(TestQueryDropInvoiceTable))}
+	var progress []StepProgress
+	m.OnStep = func(p StepProgress) {
+		progress = append(progress, p)
+	}
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	if _, err := m.UpgradeToVersion(1); err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if len(progress) != 2 {
+		t.Fatalf("Expected %d steps reported, got %d", 2, len(progress))
+	}
+	if p := progress[1]; p.Version != 1 || p.Direction != "up" || p.Step != "backfill" || p.Index != 2 || p.Total != 2 {
+		t.Errorf("Expected the second step to be reported as backfill, got %#v", p)
+	}
+	if !canDowngrade(m.migrations[0]) || migrationName(m.migrations[0]) != "split_invoices" {
+		t.Errorf("Expected a named migration with a downgrade")
+	}
+	mock.CloseTest(t)
+}
@@ -11,21 +11,66 @@ type stringMigration struct {
 	version int64  // the version number for this migration
 	up      string // the string to run when upgrading
 	down    string // the string to run when downgrading
+	migrationOptions
 }
 
-func NewStringMigration(version int64, up, down string) Migration {
-	return &stringMigration{version, up, down}
+func NewStringMigration(version int64, up, down string, opts ...MigrationOption) Migration {
+	m := &stringMigration{version: version, up: up, down: down}
+	m.set(opts)
+	return m
+}
+
+// NewNoTxStringMigration returns a string migration that runs outside of a
+// transaction, for statements that cannot run in one, such as CREATE INDEX
+// CONCURRENTLY. Its statements are run one at a time directly on the
+// database, and the version is updated in a transaction of its own
+// afterwards, as described by Transactional.
+func NewNoTxStringMigration(version int64, up, down string, opts ...MigrationOption) Migration {
+	m := &stringMigration{version: version, up: up, down: down}
+	m.set(opts)
+	m.noTransaction = true
+	return m
 }
 
 func (m stringMigration) Version() int64 {
 	return m.version
 }
 
+// Checksum returns the declared checksum of the migration or, failing that,
+// the checksum of the upgrade SQL
+func (m stringMigration) Checksum() string {
+	if m.checksum != "" {
+		return m.checksum
+	}
+	return checksumString(m.up)
+}
+
+// DirectionChecksum returns the checksum of the migration in the given
+// direction, which for an upgrade is its Checksum, and for a downgrade is the
+// checksum of the downgrade SQL, or "" if it has none
+func (m stringMigration) DirectionChecksum(direction string) string {
+	if direction == "down" {
+		if m.down == "" {
+			return ""
+		}
+		return checksumString(m.down)
+	}
+	return m.Checksum()
+}
+
 func (m stringMigration) Upgrade(tx *sql.Tx) error {
 	_, err := tx.Exec(m.up)
 	return err
 }
 
+// SQL returns the upgrade or downgrade script of the migration
+func (m stringMigration) SQL(direction string) string {
+	if direction == "down" {
+		return m.down
+	}
+	return m.up
+}
+
 func (m stringMigration) Downgrade(tx *sql.Tx) error {
 	if m.down == "" {
 		return fmt.Errorf("emigrate: No downgrade defined for migration %d", m.version)
@@ -7,11 +7,12 @@ import "regexp"
 var (
 	TestQueryCreateInvoiceTable = `CREATE TABLE "invoice" (id INTEGER, sold BOOLEAN)`
 	TestQueryDropInvoiceTable   = `DROP TABLE "invoice"`
+	TestQueryInsertInvoices     = `INSERT INTO "invoice" VALUES (1, true), (2, false), (3, true)`
 )
 
 func TestVersionStringMigration(t *testing.T) {
 	var expected int64 = 1
-	m := stringMigration{expected, "", ""}
+	m := stringMigration{version: expected, up: "", down: ""}
 
 	result := m.Version()
 	if result != expected {
@@ -23,15 +24,17 @@ func TestVersionStringMigration(t *testing.T) {
 // is applied.
 func TestUpgradeStringMigration(t *testing.T) {
 	mock, m := setupVersioned(t, 0)
-	v1 := stringMigration{1, TestQueryCreateInvoiceTable, TestQueryDropInvoiceTable}
+	v1 := stringMigration{version: 1, up: TestQueryCreateInvoiceTable, down: TestQueryDropInvoiceTable}
 	m.migrations = append(m.migrations, v1)
 
 	mock.ExpectBegin()
 	expectVersionQuery(mock, 0)
 	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
 		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec(QuerySetVersion(1)).
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
 	mock.ExpectCommit()
 
 	_, err := m.UpgradeToVersion(1)
@@ -40,3 +43,70 @@ func TestUpgradeStringMigration(t *testing.T) {
 	}
 	mock.CloseTest(t)
 }
+
+// Verify that the rows affected by a string migration are reported in the
+// upgrade result.
+func TestStringMigrationRowsAffected(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = append(m.migrations, stringMigration{version: 1, up: TestQueryInsertInvoices, down: ""})
+
+	mock.ExpectBegin()
+	expectVersionQuery(mock, 0)
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryInsertInvoices)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion(testTable))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectInsertApplied(mock, 1)
+	expectInsertHistory(mock)
+	mock.ExpectCommit()
+
+	result, err := m.UpgradeToVersion(1)
+	if err != nil {
+		t.Fatalf("Error during migration: %s", err)
+	}
+	if rows := result.Migrations[0].RowsAffected; rows != 3 {
+		t.Errorf("Expected %d rows affected, got %d", 3, rows)
+	}
+	mock.CloseTest(t)
+}
+
+func TestNamedStringMigration(t *testing.T) {
+	m := NewStringMigration(42, "CREATE INDEX ...", "", WithName("add_invoice_indexes"))
+	if name := migrationName(m); name != "add_invoice_indexes" {
+		t.Errorf("Expected %s, got %s", "add_invoice_indexes", name)
+	}
+
+	mr := MigrationResult{Version: 42, Name: migrationName(m), Status: StatusApplied}
+	expected := "emigrate: upgraded to version 42 add_invoice_indexes"
+	if result := mr.String(); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+func TestLabeledStringMigration(t *testing.T) {
+	m := NewStringMigration(42, "CREATE INDEX ...", "",
+		WithName("add_invoice_indexes"), WithLabel("2024-Q3-invoice-split"))
+	if label := migrationLabel(m); label != "2024-Q3-invoice-split" {
+		t.Errorf("Expected %s, got %s", "2024-Q3-invoice-split", label)
+	}
+
+	mr := MigrationResult{Version: 42, Name: migrationName(m), Label: migrationLabel(m), Status: StatusApplied}
+	expected := "emigrate: upgraded to version 42 add_invoice_indexes (2024-Q3-invoice-split)"
+	if result := mr.String(); result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+func TestNoTxStringMigration(t *testing.T) {
+	m := NewNoTxStringMigration(42, "CREATE INDEX CONCURRENTLY i ON invoices (id)", "DROP INDEX CONCURRENTLY i",
+		WithName("add_invoice_index"))
+	if transactional(m) {
+		t.Errorf("Expected the migration to run outside a transaction")
+	}
+	if name := migrationName(m); name != "add_invoice_index" {
+		t.Errorf("Expected %s, got %s", "add_invoice_index", name)
+	}
+	if !canDowngrade(m) {
+		t.Errorf("Expected the migration to have a downgrade")
+	}
+}
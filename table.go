@@ -0,0 +1,60 @@
+package emigrate
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TableSource returns a MigrationSource that reads migrations from table in
+// db, which has the columns version, up_sql and down_sql, so that a central
+// database can distribute migrations to the databases of its tenants. The
+// table is written into the query as it is given, so may be qualified by a
+// schema, and must not come from untrusted input. A NULL or empty down_sql
+// means the migration cannot be downgraded.
+func TableSource(db *sql.DB, table string) MigrationSource {
+	return &tableSource{db: db, table: table}
+}
+
+// tableSource is a MigrationSource that reads every migration in its table
+// when the versions are listed
+type tableSource struct {
+	db    *sql.DB
+	table string
+	sql   map[int64]map[string]string // by version and direction
+}
+
+// QueryGetTableMigrations reads the migrations of a TableSource
+var QueryGetTableMigrations = func(table string) string {
+	return fmt.Sprintf(`SELECT version, up_sql, down_sql FROM %s ORDER BY version`, table)
+}
+
+func (s *tableSource) List() ([]int64, error) {
+	rows, err := s.db.Query(QueryGetTableMigrations(s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s.sql = make(map[int64]map[string]string)
+	var versions []int64
+	for rows.Next() {
+		var version int64
+		var up string
+		var down sql.NullString
+		if err := rows.Scan(&version, &up, &down); err != nil {
+			return nil, err
+		}
+		s.sql[version] = map[string]string{"up": up, "down": down.String}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (s *tableSource) Read(version int64, direction string) (string, error) {
+	return s.sql[version][direction], nil
+}
+
+// Location returns the table and version of a migration
+func (s *tableSource) Location(version int64) string {
+	return fmt.Sprintf("%s version %d", s.table, version)
+}
@@ -0,0 +1,34 @@
+package emigrate
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTableSource(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := sqlmock.NewRows([]string{"version", "up_sql", "down_sql"}).
+		AddRow(int64(1), TestQueryCreateInvoiceTable, TestQueryDropInvoiceTable).
+		AddRow(int64(2), TestQueryInsertInvoices, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(QueryGetTableMigrations("control.migrations"))).WillReturnRows(rows)
+
+	ms, err := SourceMigrations(TableSource(db, "control.migrations"))
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("Expected %d migrations, got %d", 2, len(ms))
+	}
+	if m := ms[0].(stringMigration); m.up != TestQueryCreateInvoiceTable || m.down != TestQueryDropInvoiceTable {
+		t.Errorf("Unexpected migration %#v", m)
+	}
+	if canDowngrade(ms[1]) {
+		t.Errorf("Expected version 2 not to be able to downgrade")
+	}
+	mock.CloseTest(t)
+}
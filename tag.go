@@ -0,0 +1,75 @@
+package emigrate
+
+import "fmt"
+
+// The tags with a meaning shared by migrations, although any tag may be used
+const (
+	TagDestructive = "destructive" // drops or rewrites schema or data
+	TagData        = "data"        // changes data rather than schema
+	TagIndex       = "index"       // adds or removes indexes
+	TagSeed        = "seed"        // inserts seed data
+)
+
+// Tagged is implemented by migrations that are tagged with what they do,
+// such as TagDestructive, so that they can be selected with the Tags filter
+// or refused by the RefuseTags policy of a Migrator.
+type Tagged interface {
+	Tags() []string
+}
+
+// migrationTags returns the tags of a migration, if any
+func migrationTags(m Migration) []string {
+	if t, ok := m.(Tagged); ok {
+		return t.Tags()
+	}
+	return nil
+}
+
+// hasTag reports whether a migration is tagged with any of tags, returning
+// the first one found
+func hasTag(m Migration, tags []string) (string, bool) {
+	for _, tag := range migrationTags(m) {
+		for _, t := range tags {
+			if tag == t {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Tags selects the migrations tagged with any of tags.
+func Tags(tags ...string) Filter {
+	return func(m Migration) bool {
+		_, ok := hasTag(m, tags)
+		return ok
+	}
+}
+
+// RefusedTagError indicates that a migration to be applied has a tag refused
+// by the RefuseTags policy of the Migrator
+type RefusedTagError struct {
+	version int64  // the version of the migration
+	tag     string // the refused tag
+}
+
+func (e RefusedTagError) Error() string {
+	return fmt.Sprintf("emigrate: Migration %d is tagged %q, which is refused", e.version, e.tag)
+}
+
+// checkTags returns a RefusedTagError for the first of migrations with a tag
+// refused by the Migrator. Migrations that read their header when they are
+// run read it first, so that the tags it declares are checked.
+func (m *Migrator) checkTags(migrations []Migration) error {
+	if len(m.RefuseTags) == 0 {
+		return nil
+	}
+	for _, migration := range migrations {
+		if err := prepare(migration); err != nil {
+			return err
+		} else if tag, ok := hasTag(migration, m.RefuseTags); ok {
+			return RefusedTagError{migration.Version(), tag}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package emigrate
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTagsFilter(t *testing.T) {
+	ms := []Migration{
+		NewStringMigration(1, "up", "down"),
+		NewStringMigration(2, "up", "down", WithTags(TagData)),
+		NewStringMigration(3, "up", "down", WithTags(TagIndex, TagDestructive)),
+	}
+	selected := FilterMigrations(ms, Tags(TagDestructive, TagData))
+	if len(selected) != 2 || selected[0].Version() != 2 || selected[1].Version() != 3 {
+		t.Errorf("Expected migrations 2 and 3, got %v", selected)
+	}
+}
+
+func TestParseHeaderTags(t *testing.T) {
+	var o migrationOptions
+	if err := o.parseHeader("001.sql", "-- emigrate:tags=destructive, data\nDROP TABLE invoices;\n"); err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if expected := []string{TagDestructive, TagData}; !reflect.DeepEqual(o.Tags(), expected) {
+		t.Errorf("Expected %v, got %v", expected, o.Tags())
+	}
+	if err := o.parseHeader("001.sql", "-- emigrate:tags\n"); err == nil {
+		t.Errorf("Expected an error for tags without a value")
+	}
+}
+
+// Verify that nothing is applied if a pending migration has a refused tag.
+func TestRefuseTags(t *testing.T) {
+	mock, m := setupVersioned(t, 0)
+	m.migrations = []Migration{
+		NewStringMigration(1, TestQueryCreateInvoiceTable, ""),
+		NewStringMigration(2, TestQueryDropInvoiceTable, "", WithTags(TagDestructive)),
+	}
+	m.RefuseTags = []string{TagDestructive}
+
+	expected := RefusedTagError{2, TagDestructive}
+	if _, err := m.UpgradeToVersion(2); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the tags declared in the headers of lazy migrations are seen
+// by the Tags filter and RefuseTags before the migrations are run.
+func TestLazyTags(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_up.sql": {Data: []byte(TestQueryCreateInvoiceTable)},
+		"migrations/002_up.sql": {Data: []byte("-- emigrate:tags=destructive\n" + TestQueryDropInvoiceTable)},
+	}
+	ms, err := FSMigrations(fsys, "migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	if selected := FilterMigrations(ms, Tags(TagDestructive)); len(selected) != 1 || selected[0].Version() != 2 {
+		t.Errorf("Expected migration 2, got %v", selected)
+	}
+
+	ms, err = FSMigrations(fsys, "migrations", Lazy())
+	if err != nil {
+		t.Fatalf("Got unexpected error %#v", err)
+	}
+	mock, m := setupVersioned(t, 0)
+	m.migrations = ms
+	m.RefuseTags = []string{TagDestructive}
+
+	expected := RefusedTagError{2, TagDestructive}
+	if _, err := m.UpgradeToVersion(2); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	mock.CloseTest(t)
+}
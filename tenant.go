@@ -0,0 +1,123 @@
+package emigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SchemaMigrator applies the same migrations to each of several schemas in
+// the same database, such as one schema per tenant. Each schema has a
+// Migrator of its own, with the schema as its TargetSchema, so its tracking
+// tables are kept in the schema and its migrations run with the schema as
+// that of the session. The migrations must therefore leave their tables
+// unqualified.
+type SchemaMigrator struct {
+	schemas   []string
+	migrators []*Migrator
+
+	// Parallel is how many schemas are upgraded at once. If zero, they are
+	// upgraded one at a time, in the order they were given.
+	Parallel int
+}
+
+// NewSchemaMigrator returns a SchemaMigrator applying migrations to schemas,
+// which must have unique, non-empty names. The Migrator of each schema can be
+// configured through Migrator, and the dialect must support setting the
+// TargetSchema.
+func NewSchemaMigrator(db DB, schemas []string, migrations []Migration) (*SchemaMigrator, error) {
+	s := &SchemaMigrator{schemas: schemas}
+	seen := make(map[string]bool, len(schemas))
+	for _, schema := range schemas {
+		if schema == "" {
+			return nil, fmt.Errorf("emigrate: Schema has no name.")
+		} else if seen[schema] {
+			return nil, fmt.Errorf("emigrate: Duplicate schema %q.", schema)
+		}
+		seen[schema] = true
+
+		m := NewMigrator(db, migrations)
+		m.TargetSchema = schema
+		s.migrators = append(s.migrators, m)
+	}
+	return s, nil
+}
+
+// Migrator returns the Migrator of the named schema, or nil if there is none.
+func (s *SchemaMigrator) Migrator(schema string) *Migrator {
+	for idx, name := range s.schemas {
+		if name == schema {
+			return s.migrators[idx]
+		}
+	}
+	return nil
+}
+
+// SchemaResult describes the upgrade of a schema.
+type SchemaResult struct {
+	Schema string // the name of the schema
+	*Result
+	Err error // the error upgrading the schema, if it failed
+}
+
+// SchemaError indicates that one or more schemas failed to upgrade, and
+// describes each of them.
+type SchemaError struct {
+	Failed []SchemaResult
+}
+
+func (e SchemaError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for idx, sr := range e.Failed {
+		msgs[idx] = fmt.Sprintf("schema %q: %s", sr.Schema, sr.Err)
+	}
+	return fmt.Sprintf("emigrate: %d schemas failed to upgrade:\n\t%s", len(e.Failed), strings.Join(msgs, "\n\t"))
+}
+
+// Unwrap allows errors.Is and errors.As to inspect the individual errors
+func (e SchemaError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for idx, sr := range e.Failed {
+		errs[idx] = sr.Err
+	}
+	return errs
+}
+
+// Init initializes the tracking tables of every schema, which must exist.
+func (s *SchemaMigrator) Init() error {
+	_, err := s.each(func(m *Migrator) (*Result, error) {
+		return nil, m.Init()
+	})
+	return err
+}
+
+// Upgrade upgrades every schema, carrying on with the others when one
+// fails, and returns their results in the order the schemas were given. If
+// any failed, a SchemaError describes them.
+func (s *SchemaMigrator) Upgrade() ([]SchemaResult, error) {
+	return s.each(func(m *Migrator) (*Result, error) {
+		return m.Upgrade()
+	})
+}
+
+// each runs fn with the Migrator of every schema, up to Parallel at once,
+// returning the results in the order of the schemas and a SchemaError if
+// any failed
+func (s *SchemaMigrator) each(fn func(m *Migrator) (*Result, error)) ([]SchemaResult, error) {
+	results := make([]SchemaResult, len(s.schemas))
+	forEach(len(s.schemas), s.Parallel, false, func(idx int) error {
+		result, err := fn(s.migrators[idx])
+		results[idx] = SchemaResult{s.schemas[idx], result, err}
+		return err
+	})
+
+	var failed []SchemaResult
+	for _, sr := range results {
+		if sr.Err != nil {
+			failed = append(failed, sr)
+		}
+	}
+	if len(failed) > 0 {
+		return results, SchemaError{failed}
+	}
+	return results, nil
+}
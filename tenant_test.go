@@ -0,0 +1,60 @@
+package emigrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNewSchemaMigratorInvalid(t *testing.T) {
+	for _, schemas := range [][]string{{"tenant_1", ""}, {"tenant_1", "tenant_1"}} {
+		if _, err := NewSchemaMigrator(nil, schemas, nil); err == nil {
+			t.Errorf("%q: expected an error", schemas)
+		}
+	}
+}
+
+// Verify that each schema is upgraded with its own tracking tables, and that
+// a failing schema does not stop the others.
+func TestSchemaMigratorUpgrade(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	s, err := NewSchemaMigrator(db, []string{"tenant_1", "tenant_2"},
+		[]Migration{NewStringMigration(1, TestQueryCreateInvoiceTable, "")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	failure := errors.New("schema tenant_1 does not exist")
+
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("tenant_1.emigrate"))).
+		WillReturnError(failure)
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion("tenant_2.emigrate"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectBegin()
+	mock.ExpectExec("SET SCHEMA tenant_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.LockCurrentVersion("tenant_2.emigrate"))).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	mock.ExpectExec(regexp.QuoteMeta(TestQueryCreateInvoiceTable)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.SetVersion("tenant_2.emigrate"))).WithArgs(int64(1), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(testQueries.InsertAppliedVersion("tenant_2.emigrate_applied"))).WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tenant_2.emigrate_history")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	results, err := s.Upgrade()
+	se, ok := err.(SchemaError)
+	if !ok || len(se.Failed) != 1 || se.Failed[0].Schema != "tenant_1" || !errors.Is(err, failure) {
+		t.Errorf("Expected tenant_1 to fail, got %v", err)
+	}
+	if len(results) != 2 || len(results[1].Applied()) != 1 {
+		t.Errorf("Expected tenant_2 to be upgraded, got %v", results)
+	}
+	mock.CloseTest(t)
+}
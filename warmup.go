@@ -0,0 +1,66 @@
+package emigrate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
+
+// coldStartMessages are parts of the errors returned while a database is
+// paused, resuming or starting, by drivers that give no SQLSTATE for them
+var coldStartMessages = []string{
+	"connection refused",
+	"connection reset",
+	"i/o timeout",
+	"the database system is starting up",  // PostgreSQL
+	"Communications link failure",         // Aurora Serverless MySQL
+	"is resuming after being auto-paused", // Aurora Serverless
+	"Couldn't connect to compute node",    // Neon
+	"compute is starting",                 // Neon
+}
+
+// IsColdStartError reports whether err indicates that the database cannot be
+// reached yet, as while a serverless database such as Aurora Serverless or
+// Neon resumes from being paused, or a database server starts. It recognizes
+// broken connections, connection exceptions (SQLSTATE class 08), the
+// cannot_connect_now error of PostgreSQL and the messages of the common
+// drivers and proxies.
+func IsColdStartError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	if state := sqlState(err); state != "" {
+		return state == "57P03" || strings.HasPrefix(state, "08")
+	}
+	msg := err.Error()
+	for _, m := range coldStartMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// warmUp reads the current version until the database can be reached, for up
+// to WarmUp, backing off between attempts as Open does. Any error other than
+// that of a cold start is left for the upgrade or downgrade to return.
+func (m *Migrator) warmUp() error {
+	if m.WarmUp <= 0 {
+		return nil
+	}
+	deadline := time.Now().Add(m.WarmUp)
+	delay := openMinBackoff
+	for {
+		_, err := m.CurrentVersion()
+		if err == nil || !IsColdStartError(err) {
+			return nil
+		} else if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > openMaxBackoff {
+			delay = openMaxBackoff
+		}
+	}
+}
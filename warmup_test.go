@@ -0,0 +1,69 @@
+package emigrate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIsColdStartError(t *testing.T) {
+	var cases = []struct {
+		err       error
+		coldStart bool
+	}{
+		{sqlStateError("57P03"), true},
+		{sqlStateError("08001"), true},
+		{sqlStateError("40001"), false},
+		{fmt.Errorf("ping: %w", driver.ErrBadConn), true},
+		{errors.New("dial tcp 10.0.0.1:5432: connect: connection refused"), true},
+		{errors.New("Couldn't connect to compute node"), true},
+		{errors.New("syntax error"), false},
+	}
+	for _, c := range cases {
+		if IsColdStartError(c.err) != c.coldStart {
+			t.Errorf("Expected %v for %v", c.coldStart, c.err)
+		}
+	}
+}
+
+// Verify that an upgrade waits for a database resuming from a cold start.
+func TestWarmUp(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), WarmUp: time.Minute}
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+		WillReturnError(errors.New("dial tcp: connect: connection refused"))
+	for idx := 0; idx < 2; idx++ {
+		mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).FromCSVString("0"))
+	}
+	expectSetVersions(0, mock, 1)
+
+	if _, err := m.Upgrade(); err != nil {
+		t.Errorf("Unexpected error upgrading: %s", err)
+	}
+	mock.CloseTest(t)
+}
+
+// Verify that the warm up gives up once WarmUp has passed.
+func TestWarmUpTimeout(t *testing.T) {
+	mock, db, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock: %s", err)
+	}
+	m := Migrator{db: db, migrations: migrationRange(1), WarmUp: time.Millisecond}
+	refused := errors.New("dial tcp: connect: connection refused")
+	mock.ExpectQuery(regexp.QuoteMeta(testQueries.GetCurrentVersion(testTable))).WillReturnError(refused)
+
+	if _, err := m.Upgrade(); err != refused {
+		t.Errorf("Expected the connection to be refused, got %v", err)
+	}
+	mock.CloseTest(t)
+}